@@ -0,0 +1,11 @@
+// Package blob stores binary objects such as images under string keys, on the local filesystem or in an S3-compatible
+// object storage, so several replicas of the API can share one store
+package blob
+
+import "fmt"
+
+// ErrNotFound means that no object is stored under the key
+var ErrNotFound = fmt.Errorf("blob not found")
+
+// ErrInvalidKey means that the key is empty or escapes the store
+var ErrInvalidKey = fmt.Errorf("blob key is invalid")
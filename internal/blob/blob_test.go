@@ -0,0 +1,141 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+var testCtx = context.Background()
+
+// store is the interface every implementation is tested against
+type store interface {
+	Put(ctx context.Context, key string, src io.Reader, size int64) error
+	Get(ctx context.Context, key string) (*model.Blob, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// testStores returns constructors of the tested stores, the ones that need docker are skipped where it isn't available
+func testStores() map[string]func(t *testing.T) store {
+	return map[string]func(t *testing.T) store{
+		"local":   func(t *testing.T) store { return NewLocal(t.TempDir()) },
+		"fakeS3":  func(t *testing.T) store { return newFakeS3(t) },
+		"minioS3": func(t *testing.T) store { return testMinIO(t) },
+	}
+}
+
+// put stores the content under the key
+func put(t *testing.T, s store, key string, content []byte) {
+	require.NoError(t, s.Put(testCtx, key, bytes.NewReader(content), int64(len(content))))
+}
+
+// read returns the whole content of the blob and closes it
+func read(t *testing.T, blob *model.Blob) []byte {
+	defer func() { require.NoError(t, blob.Content.Close()) }()
+	content, err := io.ReadAll(blob.Content)
+	require.NoError(t, err)
+	return content
+}
+
+func TestStorePutGet(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			put(t, s, "images/first", []byte("first image"))
+			put(t, s, "second", []byte("second image"))
+
+			blob, err := s.Get(testCtx, "images/first")
+			require.NoError(t, err)
+			require.Equal(t, int64(11), blob.Size)
+			require.False(t, blob.ModTime.IsZero())
+			require.Equal(t, []byte("first image"), read(t, blob))
+
+			put(t, s, "second", []byte("replaced"))
+			blob, err = s.Get(testCtx, "second")
+			require.NoError(t, err)
+			require.Equal(t, []byte("replaced"), read(t, blob))
+		})
+	}
+}
+
+func TestStoreSeek(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			put(t, s, "blob", []byte("0123456789"))
+			blob, err := s.Get(testCtx, "blob")
+			require.NoError(t, err)
+			defer func() { require.NoError(t, blob.Content.Close()) }()
+
+			head := make([]byte, 3)
+			_, err = io.ReadFull(blob.Content, head)
+			require.NoError(t, err)
+			require.Equal(t, "012", string(head))
+			_, err = blob.Content.Seek(-4, io.SeekEnd)
+			require.NoError(t, err)
+			tail, err := io.ReadAll(blob.Content)
+			require.NoError(t, err)
+			require.Equal(t, "6789", string(tail))
+			_, err = blob.Content.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			all, err := io.ReadAll(blob.Content)
+			require.NoError(t, err)
+			require.Equal(t, "0123456789", string(all))
+		})
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			put(t, s, "blob", []byte("content"))
+			require.NoError(t, s.Delete(testCtx, "blob"))
+			_, err := s.Get(testCtx, "blob")
+			require.True(t, errors.Is(err, ErrNotFound), err)
+			require.NoError(t, s.Delete(testCtx, "blob"))
+			_, err = s.Get(testCtx, "never-stored")
+			require.True(t, errors.Is(err, ErrNotFound), err)
+		})
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			keys := []string{"derivatives/a/64x64.png", "derivatives/a/128x128.png", "derivatives/a/256x256.jpg", "derivatives/ab/64x64.png", "a"}
+			for _, key := range keys {
+				put(t, s, key, []byte(key))
+			}
+			listed, err := s.List(testCtx, "derivatives/a/")
+			require.NoError(t, err)
+			require.ElementsMatch(t, keys[:3], listed)
+			listed, err = s.List(testCtx, "derivatives/a")
+			require.NoError(t, err)
+			require.ElementsMatch(t, keys[:4], listed)
+			listed, err = s.List(testCtx, "missing/")
+			require.NoError(t, err)
+			require.Empty(t, listed)
+		})
+	}
+}
+
+func TestStoreRejectsEscapingKeys(t *testing.T) {
+	for name, newStore := range testStores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			for _, key := range []string{"", "../outside", "images/../../outside", "/etc/passwd"} {
+				require.True(t, errors.Is(s.Put(testCtx, key, bytes.NewReader(nil), 0), ErrInvalidKey), key)
+				_, err := s.Get(testCtx, key)
+				require.True(t, errors.Is(err, ErrInvalidKey), key)
+			}
+		})
+	}
+}
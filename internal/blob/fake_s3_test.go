@@ -0,0 +1,242 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeObject is an object kept by fakeS3Server
+type fakeObject struct {
+	content []byte
+	modTime time.Time
+	etag    string
+}
+
+// fakeS3Server is an in-process S3 of one path-style bucket. It checks signatures of requests by signing them again,
+// so a request changed after it was signed is rejected like by the real S3
+type fakeS3Server struct {
+	signer  *S3
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	version int
+}
+
+// newFakeS3 returns S3 store backed by fakeS3Server
+func newFakeS3(t *testing.T) *S3 {
+	opts := S3Options{Region: "us-east-1", Bucket: "images", AccessKey: "access", SecretKey: "secret", PathStyle: true}
+	fake := &fakeS3Server{objects: make(map[string]fakeObject)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	opts.Endpoint = server.URL
+	var err error
+	fake.signer, err = NewS3(opts, server.Client())
+	require.NoError(t, err)
+	store, err := NewS3(opts, server.Client())
+	require.NoError(t, err)
+	return store
+}
+
+func (f *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.validSignature(r) {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/images/")
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query())
+		return
+	case key == "":
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var body bytes.Buffer
+		if _, err := body.ReadFrom(r.Body); err != nil || int64(body.Len()) != r.ContentLength {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.version++
+		f.objects[key] = fakeObject{content: body.Bytes(), modTime: time.Now(), etag: fmt.Sprintf(`"%d"`, f.version)}
+	case http.MethodGet, http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", object.etag)
+		http.ServeContent(w, r, "", object.modTime, bytes.NewReader(object.content))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// fakeListPage is how many keys fakeS3Server lists at once, it is small so tests read several pages
+const fakeListPage = 2
+
+// list writes a page of ListObjectsV2 response, the continuation token is the last listed key
+func (f *fakeS3Server) list(w http.ResponseWriter, query url.Values) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var result listResult
+	if len(keys) > fakeListPage {
+		keys = keys[:fakeListPage]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	result.Keys = keys
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listResult
+	}{listResult: result})
+}
+
+// validSignature signs the received request again with the headers the client signed and compares signatures
+func (f *fakeS3Server) validSignature(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	_, signed, ok := strings.Cut(auth, "SignedHeaders=")
+	if !ok {
+		return false
+	}
+	signed, _, _ = strings.Cut(signed, ",")
+	date, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequestWithContext(context.Background(), r.Method, "http://"+r.Host+r.URL.RequestURI(), http.NoBody)
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.Split(signed, ";") {
+		if name != "host" {
+			req.Header.Set(name, r.Header.Get(name))
+		}
+	}
+	f.signer.sign(req, r.Header.Get("X-Amz-Content-Sha256"), date)
+	return req.Header.Get("Authorization") == auth
+}
+
+// writeS3Error writes S3 error document with the code
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>fake S3 error</Message></Error>", code)
+}
+
+func TestS3RejectedRequest(t *testing.T) {
+	s3 := newFakeS3(t)
+	s3.opts.SecretKey = "wrong"
+	err := s3.Put(testCtx, "blob", strings.NewReader("content"), 7)
+	require.ErrorContains(t, err, "403 Forbidden: SignatureDoesNotMatch")
+}
+
+func TestS3ReadFailsIfObjectIsReplaced(t *testing.T) {
+	s3 := newFakeS3(t)
+	put(t, s3, "blob", []byte("first"))
+	blob, err := s3.Get(testCtx, "blob")
+	require.NoError(t, err)
+	put(t, s3, "blob", []byte("second"))
+	_, err = blob.Content.Read(make([]byte, 5))
+	require.ErrorContains(t, err, "412 Precondition Failed")
+}
+
+var (
+	minioOnce    sync.Once
+	minioOptions S3Options
+	minioCleanup func()
+	errNoMinIO   error
+)
+
+// testMinIO returns S3 store backed by MinIO started once for the package, it is skipped where docker isn't available
+func testMinIO(t *testing.T) *S3 {
+	minioOnce.Do(func() {
+		pool, err := dockertest.NewPool("")
+		if err == nil {
+			err = pool.Client.Ping()
+		}
+		if err != nil {
+			errNoMinIO = fmt.Errorf("docker isn't available: %w", err)
+			return
+		}
+		resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+			Repository: "minio/minio",
+			Tag:        "latest",
+			Cmd:        []string{"server", "/data"},
+			Env:        []string{"MINIO_ROOT_USER=minioadmin", "MINIO_ROOT_PASSWORD=minioadmin"},
+		})
+		if err != nil {
+			errNoMinIO = fmt.Errorf("could not start minio: %w", err)
+			return
+		}
+		minioCleanup = func() { _ = pool.Purge(resource) }
+		minioOptions = S3Options{
+			Endpoint:  "http://localhost:" + resource.GetPort("9000/tcp"),
+			Region:    "us-east-1",
+			Bucket:    "images",
+			AccessKey: "minioadmin",
+			SecretKey: "minioadmin",
+			PathStyle: true,
+		}
+		s3, err := NewS3(minioOptions, http.DefaultClient)
+		if err != nil {
+			errNoMinIO = err
+			return
+		}
+		errNoMinIO = pool.Retry(func() error {
+			req, err := s3.newRequest(testCtx, http.MethodPut, "", http.NoBody)
+			if err != nil {
+				return err
+			}
+			resp, err := s3.do(req, emptyPayload)
+			if err != nil {
+				return err
+			}
+			closeBody(resp)
+			return nil
+		})
+	})
+	if errNoMinIO != nil {
+		t.Skip(errNoMinIO)
+	}
+	s3, err := NewS3(minioOptions, http.DefaultClient)
+	require.NoError(t, err)
+	return s3
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if minioCleanup != nil {
+		minioCleanup()
+	}
+	os.Exit(code)
+}
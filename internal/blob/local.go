@@ -0,0 +1,135 @@
+// Package blob stores binary objects on the local filesystem or in an S3-compatible object storage
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distuurbia/firstTask/internal/model"
+)
+
+// Local stores objects as files in a directory, keys with slashes are stored in subdirectories
+type Local struct {
+	dir string
+}
+
+// NewLocal accepts the directory to store objects in and returns an object of type *Local
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Put stores the object read from src under the key, the object is visible to readers only when it is complete
+func (l *Local) Put(_ context.Context, key string, src io.Reader, _ int64) error {
+	path, err := l.path(key)
+	if err != nil {
+		return fmt.Errorf("Local -> Put -> error: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("Local -> Put -> os.MkdirAll -> error: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return fmt.Errorf("Local -> Put -> os.CreateTemp -> error: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = io.Copy(tmp, src); err != nil {
+		return fmt.Errorf("Local -> Put -> io.Copy -> error: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Local -> Put -> tmp.Close -> error: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Local -> Put -> os.Rename -> error: %w", err)
+	}
+	return nil
+}
+
+// Get opens the object stored under the key, it returns ErrNotFound if there is no such object
+func (l *Local) Get(_ context.Context, key string) (*model.Blob, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, fmt.Errorf("Local -> Get -> error: %w", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("Local -> Get -> %s -> error: %w", key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("Local -> Get -> os.Open -> error: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("Local -> Get -> file.Stat -> error: %w", err)
+	}
+	if info.IsDir() {
+		_ = file.Close()
+		return nil, fmt.Errorf("Local -> Get -> %s -> error: %w", key, ErrNotFound)
+	}
+	return &model.Blob{Content: file, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete deletes the object stored under the key, deleting a missing object isn't an error
+func (l *Local) Delete(_ context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return fmt.Errorf("Local -> Delete -> error: %w", err)
+	}
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Local -> Delete -> os.Remove -> error: %w", err)
+	}
+	return nil
+}
+
+// List returns keys of all objects whose keys start with the prefix
+func (l *Local) List(_ context.Context, prefix string) ([]string, error) {
+	// only the directory that the prefix points into is walked
+	root := l.dir
+	if dir, _ := filepath.Split(filepath.FromSlash(prefix)); dir != "" {
+		if !filepath.IsLocal(dir) {
+			return nil, fmt.Errorf("Local -> List -> error: %q: %w", prefix, ErrInvalidKey)
+		}
+		root = filepath.Join(l.dir, dir)
+	}
+	var keys []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Local -> List -> filepath.WalkDir -> error: %w", err)
+	}
+	return keys, nil
+}
+
+// path returns path of the object, keys that would escape the directory are rejected
+func (l *Local) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("%q: %w", key, ErrInvalidKey)
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
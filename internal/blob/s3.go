@@ -0,0 +1,306 @@
+// Package blob stores binary objects on the local filesystem or in an S3-compatible object storage
+package blob
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/model"
+)
+
+// maxErrorBody limits how much of an error response is read
+const maxErrorBody = 4 << 10
+
+// Timeouts of the client returned by NewHTTPClient
+const (
+	dialTimeout           = 10 * time.Second
+	tlsHandshakeTimeout   = 10 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+	idleConnTimeout       = 90 * time.Second
+)
+
+// S3Options contains the endpoint, the bucket and credentials of an S3-compatible storage
+type S3Options struct {
+	// Endpoint is URL of the storage, e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000 for MinIO
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as the first segment of the path instead of a subdomain, MinIO needs it
+	PathStyle bool
+}
+
+// S3 stores objects in a bucket of an S3-compatible storage, requests are signed with AWS Signature Version 4.
+// The service needs only PUT, GET with ranges, HEAD, DELETE and listing of single objects, so S3 speaks the REST API
+// itself instead of pulling the AWS SDK and its dozens of modules into the build; the signer is checked against
+// the examples of the AWS documentation in sigv4_test.go
+type S3 struct {
+	endpoint *url.URL
+	opts     S3Options
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewHTTPClient returns a client for S3 that limits connecting and waiting for response headers but not reading
+// the body, so downloads of large objects aren't cut off, they end with the context of the request
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		IdleConnTimeout:       idleConnTimeout,
+		MaxIdleConnsPerHost:   16,
+	}}
+}
+
+// NewS3 accepts options of the storage and an object of *http.Client and returns an object of type *S3
+func NewS3(opts S3Options, client *http.Client) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("NewS3 -> url.Parse -> error: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("NewS3 -> error: endpoint %q must be an absolute URL", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("NewS3 -> error: bucket is empty")
+	}
+	return &S3{endpoint: endpoint, opts: opts, client: client, now: time.Now}, nil
+}
+
+// Put stores the object of size bytes read from src under the key
+func (s *S3) Put(ctx context.Context, key string, src io.Reader, size int64) error {
+	if err := checkKey(key); err != nil {
+		return fmt.Errorf("S3 -> Put -> error: %w", err)
+	}
+	req, err := s.newRequest(ctx, http.MethodPut, key, io.NopCloser(src))
+	if err != nil {
+		return fmt.Errorf("S3 -> Put -> error: %w", err)
+	}
+	req.ContentLength = size
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return fmt.Errorf("S3 -> Put -> error: %w", err)
+	}
+	closeBody(resp)
+	return nil
+}
+
+// Get returns the object stored under the key, it returns ErrNotFound if there is no such object.
+// Content is downloaded lazily from the position it is read at, so seeking to a range doesn't download the rest
+func (s *S3) Get(ctx context.Context, key string) (*model.Blob, error) {
+	if err := checkKey(key); err != nil {
+		return nil, fmt.Errorf("S3 -> Get -> error: %w", err)
+	}
+	req, err := s.newRequest(ctx, http.MethodHead, key, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("S3 -> Get -> error: %w", err)
+	}
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		return nil, fmt.Errorf("S3 -> Get -> error: %w", err)
+	}
+	closeBody(resp)
+	modTime, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return nil, fmt.Errorf("S3 -> Get -> http.ParseTime -> error: %w", err)
+	}
+	reader := &s3Reader{s3: s, ctx: ctx, key: key, size: resp.ContentLength, etag: resp.Header.Get("ETag")}
+	return &model.Blob{Content: reader, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Delete deletes the object stored under the key, deleting a missing object isn't an error
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return fmt.Errorf("S3 -> Delete -> error: %w", err)
+	}
+	req, err := s.newRequest(ctx, http.MethodDelete, key, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("S3 -> Delete -> error: %w", err)
+	}
+	resp, err := s.do(req, emptyPayload)
+	if err != nil {
+		return fmt.Errorf("S3 -> Delete -> error: %w", err)
+	}
+	closeBody(resp)
+	return nil
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Keys                  []string `xml:"Contents>Key"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// List returns keys of all objects whose keys start with the prefix, pages of ListObjectsV2 are read until the last one
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		req, err := s.newRequest(ctx, http.MethodGet, "", http.NoBody)
+		if err != nil {
+			return nil, fmt.Errorf("S3 -> List -> error: %w", err)
+		}
+		req.URL.RawQuery = query.Encode()
+		resp, err := s.do(req, emptyPayload)
+		if err != nil {
+			return nil, fmt.Errorf("S3 -> List -> error: %w", err)
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		closeBody(resp)
+		if err != nil {
+			return nil, fmt.Errorf("S3 -> List -> Decode -> error: %w", err)
+		}
+		keys = append(keys, page.Keys...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// newRequest returns request to the object, key is empty for requests to the bucket itself
+func (s *S3) newRequest(ctx context.Context, method, key string, body io.ReadCloser) (*http.Request, error) {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/") + "/"
+	if s.opts.PathStyle {
+		path += s.opts.Bucket + "/"
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+	}
+	u.Path = path + key
+	u.RawPath = uriEncode(path+key, false)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext -> error: %w", err)
+	}
+	return req, nil
+}
+
+// checkKey rejects keys that are empty, absolute or have ".." segments, so keys mean the same in every store
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains("/"+key+"/", "/../") {
+		return fmt.Errorf("%q: %w", key, ErrInvalidKey)
+	}
+	return nil
+}
+
+// do signs and sends the request, responses with a status other than 2xx are closed and returned as errors
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client.Do -> error: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer closeBody(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %s -> error: %w", req.Method, req.URL.Path, ErrNotFound)
+	}
+	return nil, fmt.Errorf("%s %s -> error: %w", req.Method, req.URL.Path, responseError(resp))
+}
+
+// s3Error is the error document of S3
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// responseError returns error with the status and the code and the message of S3 error document if the response has one
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var doc s3Error
+	if err := xml.Unmarshal(body, &doc); err != nil || doc.Code == "" {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return fmt.Errorf("unexpected status %s: %s: %s", resp.Status, doc.Code, doc.Message)
+}
+
+// closeBody drains and closes body of the response, so the connection can be reused
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	_ = resp.Body.Close()
+}
+
+// s3Reader reads an object with ranged GET requests. A request is made on the first read after a seek,
+// and every request requires the ETag seen by Get, so a replaced object fails the read instead of mixing two versions
+type s3Reader struct {
+	s3     *S3
+	ctx    context.Context
+	key    string
+	size   int64
+	etag   string
+	offset int64
+	body   io.ReadCloser
+}
+
+// Read reads the object from the current position
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		req, err := r.s3.newRequest(r.ctx, http.MethodGet, r.key, http.NoBody)
+		if err != nil {
+			return 0, fmt.Errorf("s3Reader -> Read -> error: %w", err)
+		}
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+		if r.etag != "" {
+			req.Header.Set("If-Match", r.etag)
+		}
+		resp, err := r.s3.do(req, emptyPayload)
+		if err != nil {
+			return 0, fmt.Errorf("s3Reader -> Read -> error: %w", err)
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Seek moves the current position, the open response is dropped only if the position changes
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("s3Reader -> Seek -> error: negative position %d", offset)
+	}
+	if offset != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close closes the open response
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
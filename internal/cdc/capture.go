@@ -0,0 +1,158 @@
+// Package cdc captures changes of persons and users from Postgres logical replication, so writes that bypass the service
+// produce the same domain events and cache invalidations as the writes made through it
+package cdc
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+)
+
+// Tables of the publication
+const (
+	personTable = "persondb"
+	userTable   = "users"
+	outboxTable = "outbox"
+)
+
+// refreshTokenColumn is the column of users that login and refresh rewrite, its changes aren't user updates
+const refreshTokenColumn = "refreshtoken"
+
+// eventNamespace derives IDs of captured events from their WAL position, so an event captured twice keeps its ID
+var eventNamespace = uuid.MustParse("6f0b7f5c-2f55-4b8e-9d3c-1f0c2b5a7e11")
+
+// rowChange is one captured change of a row
+type rowChange struct {
+	table string
+	kind  byte
+	old   Tuple
+	new   Tuple
+}
+
+// transaction collects changes between Begin and Commit, they are handled only after the commit
+type transaction struct {
+	changes []rowChange
+	// viaService is set when the transaction wrote to the outbox, such transactions were made by the service
+	// and their person events are already published by the outbox relay
+	viaService bool
+	truncated  bool
+}
+
+// add records the change of the relation, changes of the outbox only mark the transaction
+func (tx *transaction) add(relation *Relation, msg *Message) {
+	switch relation.Name {
+	case outboxTable:
+		tx.viaService = true
+	case personTable, userTable:
+		if msg.Kind == KindTruncate {
+			tx.truncated = tx.truncated || relation.Name == personTable
+			return
+		}
+		tx.changes = append(tx.changes, rowChange{table: relation.Name, kind: msg.Kind, old: msg.Old, new: msg.New})
+	}
+}
+
+// Captured is a domain event made from a captured change, Invalidate tells if the cached person must be dropped
+type Captured struct {
+	Event      *events.Event
+	Invalidate bool
+}
+
+// toEvents turns changes of the committed transaction into domain events, person changes of the service are skipped
+func (tx *transaction) toEvents(commit *Message) ([]Captured, error) {
+	var captured []Captured
+	for i := range tx.changes {
+		change := &tx.changes[i]
+		if (change.table == personTable && tx.viaService) || change.onlyRefreshToken() {
+			continue
+		}
+		event, err := change.toEvent()
+		if err != nil {
+			return nil, fmt.Errorf("toEvents -> %s -> error: %w", change.table, err)
+		}
+		event.ID = uuid.NewSHA1(eventNamespace, []byte(commit.EndLSN.String()+"/"+strconv.Itoa(i)))
+		event.OccurredAt = commit.CommitTime
+		captured = append(captured, Captured{Event: event, Invalidate: change.table == personTable})
+	}
+	return captured, nil
+}
+
+// onlyRefreshToken tells if the change is an update of users that changed nothing but the refresh token. The old row
+// comes with replica identity full, a value missing from the new row is an unchanged TOASTed one
+func (change *rowChange) onlyRefreshToken() bool {
+	if change.table != userTable || change.kind != KindUpdate || len(change.old) == 0 {
+		return false
+	}
+	for column, value := range change.new {
+		if column != refreshTokenColumn && change.old[column] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// toEvent makes event with the new state of the row or, for deletions, with its ID
+func (change *rowChange) toEvent() (*events.Event, error) {
+	row := change.new
+	if change.kind == KindDelete {
+		row = change.old
+	}
+	tenantID, err := uuid.Parse(row["tenant_id"])
+	if err != nil {
+		return nil, fmt.Errorf("tenant_id -> error: %w", err)
+	}
+	id, err := uuid.Parse(row["id"])
+	if err != nil {
+		return nil, fmt.Errorf("id -> error: %w", err)
+	}
+	var eventType string
+	var payload interface{}
+	if change.table == personTable {
+		eventType, payload, err = personEvent(change.kind, id, row)
+	} else {
+		eventType, payload = userEvent(change.kind, id, row)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return events.NewCaptured(eventType, tenantID, id, payload)
+}
+
+// personEvent returns type and payload of the person event
+func personEvent(kind byte, id uuid.UUID, row Tuple) (eventType string, payload interface{}, err error) {
+	if kind == KindDelete {
+		return events.PersonDeleted, events.PersonDeletedPayload{ID: id}, nil
+	}
+	pers := model.Person{ID: id, Profession: row["profession"], Married: row["married"] == "t"}
+	if salary, ok := row["salary"]; ok {
+		if pers.Salary, err = strconv.Atoi(salary); err != nil {
+			return "", nil, fmt.Errorf("salary -> error: %w", err)
+		}
+	}
+	if avatar := row["avatar_image_id"]; avatar != "" {
+		avatarID, err := uuid.Parse(avatar)
+		if err != nil {
+			return "", nil, fmt.Errorf("avatar_image_id -> error: %w", err)
+		}
+		pers.AvatarImageID = &avatarID
+	}
+	if kind == KindInsert {
+		return events.PersonCreated, pers, nil
+	}
+	return events.PersonUpdated, pers, nil
+}
+
+// userEvent returns type and payload of the user event
+func userEvent(kind byte, id uuid.UUID, row Tuple) (eventType string, payload interface{}) {
+	switch kind {
+	case KindInsert:
+		return events.UserCreated, events.UserPayload{ID: id, Username: row["username"]}
+	case KindUpdate:
+		return events.UserUpdated, events.UserPayload{ID: id, Username: row["username"]}
+	default:
+		return events.UserDeleted, events.UserPayload{ID: id}
+	}
+}
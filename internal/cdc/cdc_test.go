@@ -0,0 +1,154 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// message builds pgoutput messages
+type message []byte
+
+func (m message) byte(b byte) message { return append(m, b) }
+
+func (m message) uint16(v uint16) message { return binary.BigEndian.AppendUint16(m, v) }
+
+func (m message) uint32(v uint32) message { return binary.BigEndian.AppendUint32(m, v) }
+
+func (m message) uint64(v uint64) message { return binary.BigEndian.AppendUint64(m, v) }
+
+func (m message) cstring(s string) message { return append(append(m, s...), 0) }
+
+func (m message) tuple(values ...string) message {
+	m = m.uint16(uint16(len(values)))
+	for _, value := range values {
+		if value == "" {
+			m = m.byte('n')
+			continue
+		}
+		m = m.byte('t').uint32(uint32(len(value)))
+		m = append(m, value...)
+	}
+	return m
+}
+
+func relationMessage(id uint32, name string, columns ...string) message {
+	m := message{}.byte(KindRelation).uint32(id).cstring("public").cstring(name).byte('f').uint16(uint16(len(columns)))
+	for _, column := range columns {
+		m = m.byte(0).cstring(column).uint32(25).uint32(0)
+	}
+	return m
+}
+
+var personColumns = []string{"id", "salary", "married", "profession", "tenant_id"}
+
+func parse(t *testing.T, m message, relations map[uint32]*Relation) *Message {
+	msg, err := ParseMessage(m, relations)
+	require.NoError(t, err)
+	return msg
+}
+
+func TestLSN(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	require.NoError(t, err)
+	require.Equal(t, LSN(0x16B374D848), lsn)
+	require.Equal(t, "16/B374D848", lsn.String())
+	_, err = ParseLSN("16B374D848")
+	require.Error(t, err)
+}
+
+func TestParseMessages(t *testing.T) {
+	relations := map[uint32]*Relation{}
+	relation := parse(t, relationMessage(16385, personTable, personColumns...), relations).Relation
+	require.Equal(t, personTable, relation.Name)
+	require.Len(t, relation.Columns, 5)
+	relations[relation.ID] = relation
+
+	id, tenantID := uuid.New().String(), uuid.New().String()
+	insert := parse(t, message{}.byte(KindInsert).uint32(16385).byte('N').tuple(id, "500", "t", "", tenantID), relations)
+	require.Equal(t, Tuple{"id": id, "salary": "500", "married": "t", "tenant_id": tenantID}, insert.New)
+
+	update := parse(t, message{}.byte(KindUpdate).uint32(16385).
+		byte('O').tuple(id, "500", "t", "baker", tenantID).
+		byte('N').tuple(id, "700", "f", "baker", tenantID), relations)
+	require.Equal(t, "500", update.Old["salary"])
+	require.Equal(t, "700", update.New["salary"])
+
+	deletion := parse(t, message{}.byte(KindDelete).uint32(16385).byte('O').tuple(id, "700", "f", "baker", tenantID), relations)
+	require.Equal(t, tenantID, deletion.Old["tenant_id"])
+
+	commitTime := postgresEpoch.Add(time.Hour)
+	commit := parse(t, message{}.byte(KindCommit).byte(0).uint64(10).uint64(20).uint64(uint64(time.Hour.Microseconds())), relations)
+	require.Equal(t, LSN(20), commit.EndLSN)
+	require.True(t, commitTime.Equal(commit.CommitTime))
+
+	_, err := ParseMessage(message{}.byte(KindInsert).uint32(1).byte('N').tuple(id), relations)
+	require.Error(t, err, "tuple of unknown relation must fail")
+	_, err = ParseMessage(message{}.byte(KindCommit).byte(0).uint64(10), relations)
+	require.Error(t, err)
+}
+
+func TestTransactionToEvents(t *testing.T) {
+	persons := &Relation{ID: 1, Name: personTable}
+	users := &Relation{ID: 2, Name: userTable}
+	outbox := &Relation{ID: 3, Name: outboxTable}
+	tenantID, personID, userID, avatarID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	personRow := Tuple{"id": personID.String(), "salary": "500", "married": "t", "profession": "baker",
+		"avatar_image_id": avatarID.String(), "tenant_id": tenantID.String()}
+	userRow := Tuple{"id": userID.String(), "username": "vladimir", "password": "secret", "tenant_id": tenantID.String()}
+	commit := &Message{Kind: KindCommit, EndLSN: 42, CommitTime: time.Now().UTC()}
+
+	tx := &transaction{}
+	tx.add(persons, &Message{Kind: KindUpdate, New: personRow})
+	tx.add(users, &Message{Kind: KindDelete, Old: userRow})
+	captured, err := tx.toEvents(commit)
+	require.NoError(t, err)
+	require.Len(t, captured, 2)
+	require.Equal(t, events.PersonUpdated, captured[0].Event.Type)
+	require.True(t, captured[0].Invalidate)
+	require.Equal(t, tenantID, captured[0].Event.TenantID)
+	var pers model.Person
+	require.NoError(t, json.Unmarshal(captured[0].Event.Payload, &pers))
+	require.Equal(t, model.Person{ID: personID, Salary: 500, Married: true, Profession: "baker", AvatarImageID: &avatarID}, pers)
+	require.Equal(t, events.UserDeleted, captured[1].Event.Type)
+	require.False(t, captured[1].Invalidate)
+	require.NotContains(t, string(captured[1].Event.Payload), "secret")
+
+	again, err := tx.toEvents(commit)
+	require.NoError(t, err)
+	require.Equal(t, captured[0].Event.ID, again[0].Event.ID, "captured twice the change must keep its event ID")
+
+	viaService := &transaction{}
+	viaService.add(persons, &Message{Kind: KindInsert, New: personRow})
+	viaService.add(outbox, &Message{Kind: KindInsert})
+	captured, err = viaService.toEvents(commit)
+	require.NoError(t, err)
+	require.Empty(t, captured, "person changes of the service are published by the outbox relay")
+}
+
+func TestTransactionSkipsRefreshTokenUpdates(t *testing.T) {
+	users := &Relation{ID: 2, Name: userTable}
+	tenantID, userID := uuid.New(), uuid.New()
+	oldRow := Tuple{"id": userID.String(), "username": "vladimir", "password": "secret", "refreshtoken": "old",
+		"tenant_id": tenantID.String()}
+	loggedIn := Tuple{"id": userID.String(), "username": "vladimir", "password": "secret", "refreshtoken": "new",
+		"tenant_id": tenantID.String()}
+	renamed := Tuple{"id": userID.String(), "username": "vova", "password": "secret", "refreshtoken": "new",
+		"tenant_id": tenantID.String()}
+	commit := &Message{Kind: KindCommit, EndLSN: 42, CommitTime: time.Now().UTC()}
+
+	tx := &transaction{}
+	tx.add(users, &Message{Kind: KindUpdate, Old: oldRow, New: loggedIn})
+	tx.add(users, &Message{Kind: KindUpdate, Old: loggedIn, New: renamed})
+	captured, err := tx.toEvents(commit)
+	require.NoError(t, err)
+	require.Len(t, captured, 1, "a login must not publish UserUpdated")
+	require.Equal(t, events.UserUpdated, captured[0].Event.Type)
+	require.Contains(t, string(captured[0].Event.Payload), "vova")
+}
@@ -0,0 +1,276 @@
+// Package cdc captures changes of persons and users from Postgres logical replication, so writes that bypass the service
+// produce the same domain events and cache invalidations as the writes made through it
+package cdc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LSN is a position in the write-ahead log of Postgres
+type LSN uint64
+
+// String returns LSN in the X/X form used by Postgres
+func (lsn LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
+
+// ParseLSN parses LSN in the X/X form
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("ParseLSN -> %q has no slash", s)
+	}
+	hiValue, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("ParseLSN -> %q -> error: %w", s, err)
+	}
+	loValue, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("ParseLSN -> %q -> error: %w", s, err)
+	}
+	return LSN(hiValue<<32 | loValue), nil
+}
+
+// postgresEpoch is the start of the time values of the replication protocol
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// errShortMessage means that the message ended before all its fields were read
+var errShortMessage = errors.New("message is too short")
+
+// Column is a column of the replicated table
+type Column struct {
+	Name string
+	Key  bool
+}
+
+// Relation describes the replicated table, pgoutput sends it before the first change of the table in the session
+type Relation struct {
+	ID        uint32
+	Namespace string
+	Name      string
+	Columns   []Column
+}
+
+// Tuple contains text values of the row by column names, columns with NULL or unchanged TOAST values are absent
+type Tuple map[string]string
+
+// Message kinds of pgoutput that the reader handles
+const (
+	KindBegin    = 'B'
+	KindCommit   = 'C'
+	KindRelation = 'R'
+	KindInsert   = 'I'
+	KindUpdate   = 'U'
+	KindDelete   = 'D'
+	KindTruncate = 'T'
+)
+
+// Message is a decoded pgoutput message, fields are filled according to Kind
+type Message struct {
+	Kind        byte
+	CommitLSN   LSN
+	EndLSN      LSN
+	CommitTime  time.Time
+	Relation    *Relation
+	RelationID  uint32
+	RelationIDs []uint32
+	Old         Tuple
+	New         Tuple
+}
+
+// reader reads big-endian fields of the message
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.data) < 1 {
+		r.err = errShortMessage
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.data) < 2 {
+		r.err = errShortMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return v
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errShortMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if r.err != nil || len(r.data) < 8 {
+		r.err = errShortMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data) < n {
+		r.err = errShortMessage
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, b := range r.data {
+		if b == 0 {
+			s := string(r.data[:i])
+			r.data = r.data[i+1:]
+			return s
+		}
+	}
+	r.err = errShortMessage
+	return ""
+}
+
+// ParseMessage decodes pgoutput message of protocol version 1, relations are needed to name the columns of tuples.
+// Kinds the reader doesn't handle, such as origin or type messages, are returned with only Kind set
+func ParseMessage(data []byte, relations map[uint32]*Relation) (*Message, error) {
+	r := &reader{data: data}
+	msg := &Message{Kind: r.byte()}
+	switch msg.Kind {
+	case KindBegin:
+		msg.EndLSN = LSN(r.uint64())
+	case KindCommit:
+		r.byte()
+		msg.CommitLSN = LSN(r.uint64())
+		msg.EndLSN = LSN(r.uint64())
+		msg.CommitTime = postgresEpoch.Add(time.Duration(int64(r.uint64())) * time.Microsecond)
+	case KindRelation:
+		relation := &Relation{ID: r.uint32(), Namespace: r.cstring(), Name: r.cstring()}
+		r.byte()
+		columns := int(r.uint16())
+		for i := 0; i < columns && r.err == nil; i++ {
+			flags := r.byte()
+			relation.Columns = append(relation.Columns, Column{Name: r.cstring(), Key: flags&1 == 1})
+			r.uint32()
+			r.uint32()
+		}
+		msg.Relation = relation
+	case KindInsert:
+		msg.RelationID = r.uint32()
+		if r.byte() != 'N' && r.err == nil {
+			return nil, fmt.Errorf("ParseMessage -> insert has no new tuple")
+		}
+		msg.New = r.tuple(relations[msg.RelationID])
+	case KindUpdate:
+		msg.RelationID = r.uint32()
+		marker := r.byte()
+		if marker == 'K' || marker == 'O' {
+			msg.Old = r.tuple(relations[msg.RelationID])
+			marker = r.byte()
+		}
+		if marker != 'N' && r.err == nil {
+			return nil, fmt.Errorf("ParseMessage -> update has no new tuple")
+		}
+		msg.New = r.tuple(relations[msg.RelationID])
+	case KindDelete:
+		msg.RelationID = r.uint32()
+		r.byte()
+		msg.Old = r.tuple(relations[msg.RelationID])
+	case KindTruncate:
+		count := int(r.uint32())
+		r.byte()
+		for i := 0; i < count && r.err == nil; i++ {
+			msg.RelationIDs = append(msg.RelationIDs, r.uint32())
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("ParseMessage -> %c -> error: %w", msg.Kind, r.err)
+	}
+	return msg, nil
+}
+
+// tuple reads values of the row, a relation that wasn't announced leaves the tuple without names
+func (r *reader) tuple(relation *Relation) Tuple {
+	if relation == nil {
+		r.err = fmt.Errorf("tuple of unknown relation")
+		return nil
+	}
+	tuple := Tuple{}
+	columns := int(r.uint16())
+	for i := 0; i < columns && r.err == nil; i++ {
+		kind := r.byte()
+		if kind != 't' {
+			continue
+		}
+		value := r.bytes(int(r.uint32()))
+		if i < len(relation.Columns) {
+			tuple[relation.Columns[i].Name] = string(value)
+		}
+	}
+	return tuple
+}
+
+// xLogData is the header of WAL data sent by the server
+type xLogData struct {
+	WALStart LSN
+	Data     []byte
+}
+
+// parseXLogData decodes 'w' message of the replication stream
+func parseXLogData(data []byte) (xLogData, error) {
+	r := &reader{data: data}
+	msg := xLogData{WALStart: LSN(r.uint64())}
+	r.uint64()
+	r.uint64()
+	msg.Data = r.data
+	if r.err != nil {
+		return msg, fmt.Errorf("parseXLogData -> error: %w", r.err)
+	}
+	return msg, nil
+}
+
+// parseKeepalive decodes 'k' message of the replication stream and returns if the server asks for a reply
+func parseKeepalive(data []byte) (walEnd LSN, replyRequested bool, err error) {
+	r := &reader{data: data}
+	walEnd = LSN(r.uint64())
+	r.uint64()
+	replyRequested = r.byte() == 1
+	if r.err != nil {
+		return 0, false, fmt.Errorf("parseKeepalive -> error: %w", r.err)
+	}
+	return walEnd, replyRequested, nil
+}
+
+// standbyStatus encodes 'r' message that reports how far the WAL was processed, the server keeps WAL after flushed
+func standbyStatus(flushed LSN, now time.Time) []byte {
+	msg := make([]byte, 1+8+8+8+8+1)
+	msg[0] = 'r'
+	binary.BigEndian.PutUint64(msg[1:], uint64(flushed))
+	binary.BigEndian.PutUint64(msg[9:], uint64(flushed))
+	binary.BigEndian.PutUint64(msg[17:], uint64(flushed))
+	binary.BigEndian.PutUint64(msg[25:], uint64(now.Sub(postgresEpoch).Microseconds()))
+	return msg
+}
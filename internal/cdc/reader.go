@@ -0,0 +1,249 @@
+// Package cdc captures changes of persons and users from Postgres logical replication, so writes that bypass the service
+// produce the same domain events and cache invalidations as the writes made through it
+package cdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/sirupsen/logrus"
+)
+
+// Publication is the publication created by the migrations, it contains persondb, users and outbox tables
+const Publication = "person_cdc"
+
+const (
+	// statusInterval is how often the processed position is reported to the server
+	statusInterval = 10 * time.Second
+	// reconnectDelay is a pause after the replication connection fails
+	reconnectDelay = 5 * time.Second
+	// duplicateObject is the code of the error returned when the slot already exists
+	duplicateObject = "42710"
+)
+
+// slotName restricts names of the slot, the name is put into replication commands as is
+var slotName = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+// Cache is an interface that contains methods of the person cache
+type Cache interface {
+	Delete(ctx context.Context, id uuid.UUID) error
+	Flush(ctx context.Context) error
+}
+
+// Reader streams changes of the publication from the replication slot. The slot keeps its position on the server,
+// the position is moved only after the changes of a transaction are published and invalidated,
+// so after a restart the reader continues from the first transaction it hasn't finished
+type Reader struct {
+	connString string
+	slot       string
+	publisher  events.Publisher
+	cache      Cache
+	relations  map[uint32]*Relation
+	tx         *transaction
+	flushed    LSN
+}
+
+// NewReader accepts connection string of Postgres, name of the replication slot, events.Publisher and Cache
+// and returns an object of type *Reader
+func NewReader(connString, slot string, publisher events.Publisher, cache Cache) (*Reader, error) {
+	if !slotName.MatchString(slot) {
+		return nil, fmt.Errorf("NewReader -> invalid slot name %q", slot)
+	}
+	return &Reader{connString: connString, slot: slot, publisher: publisher, cache: cache}, nil
+}
+
+// Run streams changes until ctx is done and reconnects after failures
+func (r *Reader) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := r.stream(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.WithField("Slot", r.slot).Errorf("Reader -> Run -> stream -> error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// stream opens the replication connection, creates the slot if it doesn't exist and handles messages until a failure
+func (r *Reader) stream(ctx context.Context) error {
+	config, err := pgconn.ParseConfig(r.connString)
+	if err != nil {
+		return fmt.Errorf("pgconn.ParseConfig -> error: %w", err)
+	}
+	config.RuntimeParams["replication"] = "database"
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	if err != nil {
+		return fmt.Errorf("pgconn.ConnectConfig -> error: %w", err)
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+	_, err = conn.Exec(ctx, fmt.Sprintf("CREATE_REPLICATION_SLOT %s LOGICAL pgoutput NOEXPORT_SNAPSHOT", r.slot)).ReadAll()
+	var pgErr *pgconn.PgError
+	if err != nil && !(errors.As(err, &pgErr) && pgErr.Code == duplicateObject) {
+		return fmt.Errorf("CREATE_REPLICATION_SLOT -> error: %w", err)
+	}
+	if err = r.start(ctx, conn); err != nil {
+		return err
+	}
+	r.relations = map[uint32]*Relation{}
+	r.tx = nil
+	nextStatus := time.Now().Add(statusInterval)
+	for {
+		if time.Now().After(nextStatus) {
+			if err = r.sendStatus(conn); err != nil {
+				return err
+			}
+			nextStatus = time.Now().Add(statusInterval)
+		}
+		receiveCtx, cancel := context.WithDeadline(ctx, nextStatus)
+		msg, err := conn.ReceiveMessage(receiveCtx)
+		cancel()
+		if err != nil {
+			if pgconn.Timeout(err) && ctx.Err() == nil {
+				continue
+			}
+			return fmt.Errorf("ReceiveMessage -> error: %w", err)
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			if err = r.handleCopyData(ctx, conn, msg.Data); err != nil {
+				return err
+			}
+		case *pgproto3.ErrorResponse:
+			return fmt.Errorf("replication -> error: %w", pgconn.ErrorResponseToPgError(msg))
+		}
+	}
+}
+
+// start sends START_REPLICATION and waits until the server switches to the copy mode
+func (r *Reader) start(ctx context.Context, conn *pgconn.PgConn) error {
+	conn.Frontend().Send(&pgproto3.Query{String: fmt.Sprintf(
+		"START_REPLICATION SLOT %s LOGICAL 0/0 (proto_version '1', publication_names '%s')", r.slot, Publication)})
+	if err := conn.Frontend().Flush(); err != nil {
+		return fmt.Errorf("START_REPLICATION -> Flush -> error: %w", err)
+	}
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("START_REPLICATION -> ReceiveMessage -> error: %w", err)
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			logrus.WithField("Slot", r.slot).Info("change data capture started")
+			return nil
+		case *pgproto3.ErrorResponse:
+			return fmt.Errorf("START_REPLICATION -> error: %w", pgconn.ErrorResponseToPgError(msg))
+		}
+	}
+}
+
+// handleCopyData handles WAL data and keepalive messages of the server
+func (r *Reader) handleCopyData(ctx context.Context, conn *pgconn.PgConn, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	switch data[0] {
+	case 'k':
+		walEnd, replyRequested, err := parseKeepalive(data[1:])
+		if err != nil {
+			return err
+		}
+		// without an open transaction everything up to the end of WAL is handled
+		if r.tx == nil && walEnd > r.flushed {
+			r.flushed = walEnd
+		}
+		if replyRequested {
+			return r.sendStatus(conn)
+		}
+	case 'w':
+		xLog, err := parseXLogData(data[1:])
+		if err != nil {
+			return err
+		}
+		msg, err := ParseMessage(xLog.Data, r.relations)
+		if err != nil {
+			return err
+		}
+		return r.handleMessage(ctx, conn, msg)
+	}
+	return nil
+}
+
+// handleMessage collects changes of the transaction and applies them on commit
+func (r *Reader) handleMessage(ctx context.Context, conn *pgconn.PgConn, msg *Message) error {
+	switch msg.Kind {
+	case KindRelation:
+		r.relations[msg.Relation.ID] = msg.Relation
+	case KindBegin:
+		r.tx = &transaction{}
+	case KindInsert, KindUpdate, KindDelete:
+		if relation, ok := r.relations[msg.RelationID]; ok && r.tx != nil {
+			r.tx.add(relation, msg)
+		}
+	case KindTruncate:
+		for _, id := range msg.RelationIDs {
+			if relation, ok := r.relations[id]; ok && r.tx != nil {
+				r.tx.add(relation, msg)
+			}
+		}
+	case KindCommit:
+		if r.tx == nil {
+			return nil
+		}
+		if err := r.apply(ctx, r.tx, msg); err != nil {
+			return fmt.Errorf("apply %s -> error: %w", msg.EndLSN, err)
+		}
+		r.tx = nil
+		r.flushed = msg.EndLSN
+		return r.sendStatus(conn)
+	}
+	return nil
+}
+
+// apply publishes events of the committed transaction and invalidates cached persons
+func (r *Reader) apply(ctx context.Context, tx *transaction, commit *Message) error {
+	captured, err := tx.toEvents(commit)
+	if err != nil {
+		return err
+	}
+	for _, c := range captured {
+		if err = r.publisher.Publish(ctx, c.Event); err != nil {
+			return fmt.Errorf("publisher.Publish -> error: %w", err)
+		}
+		if !c.Invalidate {
+			continue
+		}
+		err = r.cache.Delete(identity.WithTenant(ctx, c.Event.TenantID), c.Event.AggregateID)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("cache.Delete -> error: %w", err)
+		}
+	}
+	if tx.truncated {
+		logrus.WithField("Slot", r.slot).Warn("persondb is truncated, whole cache is flushed")
+		if err = r.cache.Flush(ctx); err != nil {
+			return fmt.Errorf("cache.Flush -> error: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendStatus reports the position up to which all changes are handled, the slot keeps it across restarts
+func (r *Reader) sendStatus(conn *pgconn.PgConn) error {
+	conn.Frontend().Send(&pgproto3.CopyData{Data: standbyStatus(r.flushed, time.Now())})
+	if err := conn.Frontend().Flush(); err != nil {
+		return fmt.Errorf("sendStatus -> Flush -> error: %w", err)
+	}
+	return nil
+}
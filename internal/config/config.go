@@ -1,11 +1,66 @@
 // Package config contains all system variables
 package config
 
+import "time"
+
 // Config contains system variables
 type Config struct {
-	SecretKey             string `env:"SECRET_KEY"`
-	MongoConnectionString string `env:"MONGO_CONN_STRING"`
-	PgxConnectionString   string `env:"PGX_CONN_STRING"`
-	RedisAddress          string `env:"REDIS_CONN_STRING"`
-	RedisPassword         string `env:"REDIS_PASSWORD"`
+	SecretKey             string        `env:"SECRET_KEY"`
+	MongoConnectionString string        `env:"MONGO_CONN_STRING"`
+	PgxConnectionString   string        `env:"PGX_CONN_STRING"`
+	RedisAddress          string        `env:"REDIS_CONN_STRING"`
+	RedisPassword         string        `env:"REDIS_PASSWORD"`
+	AdminKey              string        `env:"ADMIN_KEY"`
+	AutoMigrate           bool          `env:"AUTO_MIGRATE" envDefault:"true"`
+	OutboxBatchSize       int           `env:"OUTBOX_BATCH_SIZE" envDefault:"100"`
+	OutboxPollInterval    time.Duration `env:"OUTBOX_POLL_INTERVAL" envDefault:"1s"`
+	OutboxMaxAttempts     int           `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"10"`
+	OutboxRetention       time.Duration `env:"OUTBOX_RETENTION" envDefault:"168h"`
+	EventsGroup           string        `env:"EVENTS_GROUP" envDefault:"firstTask"`
+	EventsConsumer        string        `env:"EVENTS_CONSUMER"`
+	EventsMinIdle         time.Duration `env:"EVENTS_MIN_IDLE" envDefault:"1m"`
+	EventsMaxDeliveries   int64         `env:"EVENTS_MAX_DELIVERIES" envDefault:"5"`
+	EventsStreamMaxLen    int64         `env:"EVENTS_STREAM_MAXLEN" envDefault:"100000"`
+	FeedAllowedOrigins    []string      `env:"FEED_ALLOWED_ORIGINS" envSeparator:","`
+	WebhookMaxAttempts    int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"5"`
+	WebhookDisableAfter   int           `env:"WEBHOOK_DISABLE_AFTER" envDefault:"10"`
+	WebhookTimeout        time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	WebhookRetryInterval  time.Duration `env:"WEBHOOK_RETRY_INTERVAL" envDefault:"1s"`
+	WebhookDeliveriesKept int           `env:"WEBHOOK_DELIVERIES_KEPT" envDefault:"100"`
+	CDCEnabled            bool          `env:"CDC_ENABLED" envDefault:"false"`
+	CDCSlot               string        `env:"CDC_SLOT" envDefault:"person_cdc"`
+	CacheTTL              time.Duration `env:"CACHE_TTL" envDefault:"10m"`
+	CacheTTLJitter        time.Duration `env:"CACHE_TTL_JITTER" envDefault:"1m"`
+	CacheMaxEntries       int64         `env:"CACHE_MAX_ENTRIES" envDefault:"10000"`
+	CacheMissingTTL       time.Duration `env:"CACHE_MISSING_TTL" envDefault:"30s"`
+	CacheEarlyRefresh     time.Duration `env:"CACHE_EARLY_REFRESH" envDefault:"0s"`
+	CacheLocalSize        int           `env:"CACHE_LOCAL_SIZE" envDefault:"0"`
+	CacheLocalTTL         time.Duration `env:"CACHE_LOCAL_TTL" envDefault:"30s"`
+	CacheStrategy         string        `env:"CACHE_STRATEGY" envDefault:"write-around"`
+	CacheFlushInterval    time.Duration `env:"CACHE_FLUSH_INTERVAL" envDefault:"1s"`
+	CacheFlushBatch       int           `env:"CACHE_FLUSH_BATCH" envDefault:"100"`
+	CacheMaxQueued        int           `env:"CACHE_MAX_QUEUED" envDefault:"10000"`
+	ShutdownTimeout       time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	ImageDir              string        `env:"IMAGE_DIR" envDefault:"images/upload"`
+	BlobStore             string        `env:"BLOB_STORE" envDefault:"local"`
+	S3Endpoint            string        `env:"S3_ENDPOINT"`
+	S3Region              string        `env:"S3_REGION" envDefault:"us-east-1"`
+	S3Bucket              string        `env:"S3_BUCKET"`
+	S3AccessKey           string        `env:"S3_ACCESS_KEY"`
+	S3SecretKey           string        `env:"S3_SECRET_KEY"`
+	S3PathStyle           bool          `env:"S3_PATH_STYLE" envDefault:"true"`
+	ImageMaxBytes         int64         `env:"IMAGE_MAX_BYTES" envDefault:"10485760"`
+	ImageMaxDimension     int           `env:"IMAGE_MAX_DIMENSION" envDefault:"8192"`
+	ImageMaxPixels        int           `env:"IMAGE_MAX_PIXELS" envDefault:"40000000"`
+	ImageVariantSizes     []int         `env:"IMAGE_VARIANT_SIZES" envDefault:"32,64,128,256,512,1024" envSeparator:","`
+	ImageMaxResizes       int           `env:"IMAGE_MAX_RESIZES" envDefault:"4"`
+	ImageQuotaBytes       int64         `env:"IMAGE_QUOTA_BYTES" envDefault:"1073741824"`
+	ImageCanonicalFormat  string        `env:"IMAGE_CANONICAL_FORMAT"`
+	ImageURLKey           string        `env:"IMAGE_URL_KEY"`
+	ImageURLMaxTTL        time.Duration `env:"IMAGE_URL_MAX_TTL" envDefault:"24h"`
+	ImageCollectInterval  time.Duration `env:"IMAGE_COLLECT_INTERVAL" envDefault:"1h"`
+	ImageCollectGrace     time.Duration `env:"IMAGE_COLLECT_GRACE" envDefault:"1h"`
+	UploadTTL             time.Duration `env:"UPLOAD_TTL" envDefault:"24h"`
+	UploadCleanupInterval time.Duration `env:"UPLOAD_CLEANUP_INTERVAL" envDefault:"1h"`
+	UploadMinChunk        int64         `env:"UPLOAD_MIN_CHUNK" envDefault:"1048576"`
 }
@@ -0,0 +1,117 @@
+// Package events contains domain events of persons, their versioned JSON schema and the interfaces to publish and subscribe to them
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrDeadLetterNotFound means that the dead-letter stream has no message with such id
+var ErrDeadLetterNotFound = fmt.Errorf("dead letter not found")
+
+// ErrDeadLetterInvalid means that the dead letter has no event that can be decoded or no group, so it can only be discarded
+var ErrDeadLetterInvalid = fmt.Errorf("dead letter can't be replayed")
+
+// DeadLetter contains a message moved to the dead-letter stream and the reason why
+type DeadLetter struct {
+	ID         string          `json:"id"`
+	MessageID  string          `json:"messageId"`
+	Group      string          `json:"group"`
+	Reason     string          `json:"reason"`
+	Deliveries int64           `json:"deliveries"`
+	FailedAt   string          `json:"failedAt"`
+	Event      json.RawMessage `json:"event,omitempty"`
+}
+
+// RedisDeadLetters lets to inspect, replay and discard messages of the dead-letter stream
+type RedisDeadLetters struct {
+	client *redis.Client
+}
+
+// NewRedisDeadLetters accepts an object of *redis.Client and returns an object of type *RedisDeadLetters
+func NewRedisDeadLetters(client *redis.Client) *RedisDeadLetters {
+	return &RedisDeadLetters{client: client}
+}
+
+// List returns up to count oldest dead letters
+func (d *RedisDeadLetters) List(ctx context.Context, count int64) ([]DeadLetter, error) {
+	msgs, err := d.client.XRangeN(ctx, DeadLetterStream, "-", "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("RedisDeadLetters -> List -> XRangeN -> error: %w", err)
+	}
+	deadLetters := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		deadLetters = append(deadLetters, toDeadLetter(msg))
+	}
+	return deadLetters, nil
+}
+
+// Replay adds event of the dead letter to the retry stream of the group that dead-lettered it and removes the dead letter
+// in one transaction, so only that group handles the event again, see RetryStream
+func (d *RedisDeadLetters) Replay(ctx context.Context, id string) error {
+	msgs, err := d.client.XRangeN(ctx, DeadLetterStream, id, id, 1).Result()
+	if err != nil {
+		return fmt.Errorf("RedisDeadLetters -> Replay -> XRangeN -> error: %w", err)
+	}
+	if len(msgs) == 0 {
+		return ErrDeadLetterNotFound
+	}
+	event, err := decodeMessage(msgs[0])
+	if err != nil {
+		return fmt.Errorf("RedisDeadLetters -> Replay -> decodeMessage -> %v: %w", err, ErrDeadLetterInvalid)
+	}
+	group := toDeadLetter(msgs[0]).Group
+	if group == "" {
+		return fmt.Errorf("RedisDeadLetters -> Replay -> no group: %w", ErrDeadLetterInvalid)
+	}
+	values, err := messageValues(event)
+	if err != nil {
+		return fmt.Errorf("RedisDeadLetters -> Replay -> messageValues -> error: %w", err)
+	}
+	_, err = d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: RetryStream(group), Values: values})
+		pipe.XDel(ctx, DeadLetterStream, id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("RedisDeadLetters -> Replay -> TxPipelined -> error: %w", err)
+	}
+	return nil
+}
+
+// Discard removes the dead letter without replaying it, it is the only way to get rid of a letter that can't be replayed
+func (d *RedisDeadLetters) Discard(ctx context.Context, id string) error {
+	deleted, err := d.client.XDel(ctx, DeadLetterStream, id).Result()
+	if err != nil {
+		return fmt.Errorf("RedisDeadLetters -> Discard -> XDel -> error: %w", err)
+	}
+	if deleted == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// toDeadLetter converts message of the dead-letter stream into DeadLetter
+func toDeadLetter(msg redis.XMessage) DeadLetter {
+	str := func(key string) string {
+		value, _ := msg.Values[key].(string)
+		return value
+	}
+	deliveries, _ := strconv.ParseInt(str("deliveries"), 10, 64)
+	deadLetter := DeadLetter{
+		ID:         msg.ID,
+		MessageID:  str("messageId"),
+		Group:      str("group"),
+		Reason:     str("reason"),
+		Deliveries: deliveries,
+		FailedAt:   str("failedAt"),
+	}
+	if event := str("event"); json.Valid([]byte(event)) {
+		deadLetter.Event = json.RawMessage(event)
+	}
+	return deadLetter
+}
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/ory/dockertest"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	redisOnce    sync.Once
+	redisClient  *redis.Client
+	redisCleanup func()
+	errNoRedis   error
+)
+
+// testRedis starts redis for the tests of the package once, tests that need it are skipped where docker isn't available
+func testRedis(t *testing.T) *redis.Client {
+	redisOnce.Do(func() {
+		pool, err := dockertest.NewPool("")
+		if err == nil {
+			err = pool.Client.Ping()
+		}
+		if err != nil {
+			errNoRedis = fmt.Errorf("docker isn't available: %w", err)
+			return
+		}
+		resource, err := pool.Run("redis", "latest", nil)
+		if err != nil {
+			errNoRedis = fmt.Errorf("could not start redis: %w", err)
+			return
+		}
+		redisCleanup = func() { _ = pool.Purge(resource) }
+		redisClient = redis.NewClient(&redis.Options{Addr: "localhost:" + resource.GetPort("6379/tcp")})
+		if err = pool.Retry(func() error { return redisClient.Ping(context.Background()).Err() }); err != nil {
+			errNoRedis = fmt.Errorf("redis isn't ready: %w", err)
+		}
+	})
+	if errNoRedis != nil {
+		t.Skip(errNoRedis)
+	}
+	require.NoError(t, redisClient.FlushAll(context.Background()).Err())
+	return redisClient
+}
+
+func TestMain(m *testing.M) {
+	exitVal := m.Run()
+	if redisCleanup != nil {
+		redisCleanup()
+	}
+	os.Exit(exitVal)
+}
+
+// addDeadLetter adds a dead letter of the group with the event field as the subscriber does and returns its id
+func addDeadLetter(t *testing.T, client *redis.Client, group, event string) string {
+	id, err := client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: DeadLetterStream,
+		Values: map[string]interface{}{
+			"messageId":  "1-0",
+			"group":      group,
+			"reason":     "too many deliveries",
+			"deliveries": 6,
+			"failedAt":   time.Now().UTC().Format(time.RFC3339),
+			"event":      event,
+		},
+	}).Result()
+	require.NoError(t, err)
+	return id
+}
+
+func TestDeadLetterReplayOnlyToItsGroup(t *testing.T) {
+	client := testRedis(t)
+	event, err := New(identity.WithTenant(context.Background(), uuid.New()), PersonDeleted, uuid.New(), PersonDeletedPayload{})
+	require.NoError(t, err)
+	eventJSON, err := Encode(event)
+	require.NoError(t, err)
+	id := addDeadLetter(t, client, "failed", string(eventJSON))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := make(map[string]chan *Event)
+	var wg sync.WaitGroup
+	for _, group := range []string{"failed", "other"} {
+		received := make(chan *Event, 1)
+		handled[group] = received
+		subscriber := NewRedisSubscriber(client, group, "consumer", time.Minute, 5)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = subscriber.Subscribe(ctx, func(_ context.Context, event *Event) error {
+				received <- event
+				return nil
+			})
+		}()
+	}
+	// both groups exist before the replay, so the other group would receive an event published to the stream
+	require.Eventually(t, func() bool {
+		groups, err := client.XInfoGroups(context.Background(), Stream).Result()
+		return err == nil && len(groups) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	deadLetters := NewRedisDeadLetters(client)
+	require.NoError(t, deadLetters.Replay(context.Background(), id))
+	select {
+	case replayed := <-handled["failed"]:
+		require.Equal(t, event.ID, replayed.ID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the group that dead-lettered the event didn't receive it")
+	}
+	require.Eventually(t, func() bool {
+		return client.XLen(context.Background(), RetryStream("failed")).Val() == 0
+	}, 10*time.Second, 10*time.Millisecond, "handled retries are deleted")
+	require.Zero(t, client.XLen(context.Background(), Stream).Val(), "the event isn't published to all groups")
+	require.Zero(t, client.XLen(context.Background(), DeadLetterStream).Val())
+	cancel()
+	wg.Wait()
+	require.Empty(t, handled["other"])
+	require.True(t, errors.Is(deadLetters.Replay(context.Background(), id), ErrDeadLetterNotFound))
+}
+
+func TestDeadLetterDiscard(t *testing.T) {
+	client := testRedis(t)
+	id := addDeadLetter(t, client, "failed", "not json")
+	deadLetters := NewRedisDeadLetters(client)
+
+	err := deadLetters.Replay(context.Background(), id)
+	require.True(t, errors.Is(err, ErrDeadLetterInvalid), err)
+	require.NoError(t, deadLetters.Discard(context.Background(), id))
+	list, err := deadLetters.List(context.Background(), 10)
+	require.NoError(t, err)
+	require.Empty(t, list)
+	require.True(t, errors.Is(deadLetters.Discard(context.Background(), id), ErrDeadLetterNotFound))
+}
@@ -0,0 +1,136 @@
+// Package events contains domain events of persons, their versioned JSON schema and the interfaces to publish and subscribe to them
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is a version of the event JSON schema, it is incremented on every incompatible change
+const SchemaVersion = 1
+
+// Types of person events
+const (
+	PersonCreated = "PersonCreated"
+	PersonUpdated = "PersonUpdated"
+	PersonDeleted = "PersonDeleted"
+)
+
+// Types of user events, they are captured from the database and carry UserPayload
+const (
+	UserCreated = "UserCreated"
+	UserUpdated = "UserUpdated"
+	UserDeleted = "UserDeleted"
+)
+
+// IsPersonEvent checks if the type is one of the person event types
+func IsPersonEvent(eventType string) bool {
+	return eventType == PersonCreated || eventType == PersonUpdated || eventType == PersonDeleted
+}
+
+// ErrUnsupportedVersion means that the event was written with a schema version this binary doesn't know
+var ErrUnsupportedVersion = fmt.Errorf("unsupported event schema version")
+
+// Event contains a domain event, Payload is the person for PersonCreated and PersonUpdated and PersonDeletedPayload for PersonDeleted
+type Event struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	ID            uuid.UUID       `json:"id"`
+	Type          string          `json:"type"`
+	OccurredAt    time.Time       `json:"occurredAt"`
+	TenantID      uuid.UUID       `json:"tenantId"`
+	AggregateID   uuid.UUID       `json:"aggregateId"`
+	Actor         *uuid.UUID      `json:"actor,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// PersonDeletedPayload contains payload of PersonDeleted event
+type PersonDeletedPayload struct {
+	ID uuid.UUID `json:"id"`
+}
+
+// UserPayload contains payload of user events, credentials are never published
+type UserPayload struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username,omitempty"`
+}
+
+// Publisher is an interface that publishes events
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+}
+
+// Handler handles one event, the same event may be handled twice and must be deduplicated by its ID
+type Handler func(ctx context.Context, event *Event) error
+
+// Subscriber is an interface that delivers events to the handler until ctx is done
+type Subscriber interface {
+	Subscribe(ctx context.Context, handler Handler) error
+}
+
+// New returns event about the aggregate, tenant and actor are taken from ctx
+func New(ctx context.Context, eventType string, aggregateID uuid.UUID, payload interface{}) (*Event, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("New -> identity.TenantFromContext -> error: %w", err)
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("New -> json.Marshal -> error: %w", err)
+	}
+	event := &Event{
+		SchemaVersion: SchemaVersion,
+		ID:            uuid.New(),
+		Type:          eventType,
+		OccurredAt:    time.Now().UTC(),
+		TenantID:      tenantID,
+		AggregateID:   aggregateID,
+		Payload:       payloadJSON,
+	}
+	if actor, ok := identity.UserFromContext(ctx); ok {
+		event.Actor = &actor
+	}
+	return event, nil
+}
+
+// NewCaptured returns event about a change that was made outside of the service, so it has neither request context nor actor
+func NewCaptured(eventType string, tenantID, aggregateID uuid.UUID, payload interface{}) (*Event, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("NewCaptured -> json.Marshal -> error: %w", err)
+	}
+	return &Event{
+		SchemaVersion: SchemaVersion,
+		ID:            uuid.New(),
+		Type:          eventType,
+		OccurredAt:    time.Now().UTC(),
+		TenantID:      tenantID,
+		AggregateID:   aggregateID,
+		Payload:       payloadJSON,
+	}, nil
+}
+
+// Encode returns JSON of the event
+func Encode(event *Event) ([]byte, error) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("Encode -> json.Marshal -> error: %w", err)
+	}
+	return eventJSON, nil
+}
+
+// Decode parses JSON of the event and checks its schema version
+func Decode(data []byte) (*Event, error) {
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("Decode -> json.Unmarshal -> error: %w", err)
+	}
+	if event.SchemaVersion != SchemaVersion {
+		return nil, fmt.Errorf("Decode -> version %d: %w", event.SchemaVersion, ErrUnsupportedVersion)
+	}
+	return &event, nil
+}
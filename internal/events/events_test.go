@@ -0,0 +1,57 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTakesTenantAndActorFromContext(t *testing.T) {
+	tenantID, userID := uuid.New(), uuid.New()
+	ctx := identity.WithUser(identity.WithTenant(context.Background(), tenantID), userID)
+	pers := model.Person{ID: uuid.New(), Salary: 300, Profession: "teacher"}
+
+	event, err := New(ctx, PersonCreated, pers.ID, pers)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion, event.SchemaVersion)
+	require.Equal(t, tenantID, event.TenantID)
+	require.Equal(t, userID, *event.Actor)
+	require.Equal(t, pers.ID, event.AggregateID)
+	require.NotEqual(t, uuid.Nil, event.ID)
+	require.False(t, event.OccurredAt.IsZero())
+}
+
+func TestNewWithoutTenant(t *testing.T) {
+	_, err := New(context.Background(), PersonDeleted, uuid.New(), PersonDeletedPayload{})
+	require.True(t, errors.Is(err, identity.ErrNoTenant))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	ctx := identity.WithTenant(context.Background(), uuid.New())
+	id := uuid.New()
+	event, err := New(ctx, PersonDeleted, id, PersonDeletedPayload{ID: id})
+	require.NoError(t, err)
+
+	eventJSON, err := Encode(event)
+	require.NoError(t, err)
+	decoded, err := Decode(eventJSON)
+	require.NoError(t, err)
+	require.Equal(t, event.ID, decoded.ID)
+	require.Equal(t, event.Type, decoded.Type)
+	require.Nil(t, decoded.Actor)
+	require.True(t, event.OccurredAt.Equal(decoded.OccurredAt))
+	var payload PersonDeletedPayload
+	require.NoError(t, json.Unmarshal(decoded.Payload, &payload))
+	require.Equal(t, id, payload.ID)
+}
+
+func TestDecodeUnsupportedVersion(t *testing.T) {
+	_, err := Decode([]byte(`{"schemaVersion":2,"type":"PersonCreated"}`))
+	require.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
@@ -0,0 +1,261 @@
+// Package events contains domain events of persons, their versioned JSON schema and the interfaces to publish and subscribe to them
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// feedBuffer is how many changes may wait for a slow client before it is disconnected to resume later
+	feedBuffer = 256
+	// feedBacklog is how many missed changes are replayed to a client that resumes, a client that missed more gets a reset
+	feedBacklog = 1000
+)
+
+// ErrInvalidStreamID means that the resume position is not an ID of the redis stream
+var ErrInvalidStreamID = errors.New("invalid stream ID")
+
+// Change is an event together with its ID in the redis stream, the ID is the position clients resume from.
+// A change with Reset and no event tells the client that the changes it missed can't be replayed,
+// so it must reload the persons and resume from StreamID
+type Change struct {
+	StreamID string
+	Event    *Event
+	Reset    bool
+}
+
+// feedClient is one subscriber of the feed
+type feedClient struct {
+	tenantID uuid.UUID
+	changes  chan Change
+}
+
+// RedisFeed reads the stream once and fans the changes out to all connected clients of their tenants
+type RedisFeed struct {
+	client  *redis.Client
+	mu      sync.Mutex
+	clients map[*feedClient]struct{}
+}
+
+// NewRedisFeed accepts an object of *redis.Client and returns an object of type *RedisFeed
+func NewRedisFeed(client *redis.Client) *RedisFeed {
+	return &RedisFeed{client: client, clients: map[*feedClient]struct{}{}}
+}
+
+// Run reads new messages of the stream and broadcasts them until ctx is done
+func (f *RedisFeed) Run(ctx context.Context) {
+	lastID := "$"
+	latest, err := f.client.XRevRangeN(ctx, Stream, "+", "-", 1).Result()
+	if err == nil && len(latest) != 0 {
+		lastID = latest[0].ID
+	}
+	for ctx.Err() == nil {
+		results, err := f.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{Stream, lastID},
+			Count:   readCount,
+			Block:   readBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("RedisFeed -> Run -> XRead -> error: %v", err)
+			sleep(ctx, readRetry)
+			continue
+		}
+		for _, result := range results {
+			for _, msg := range result.Messages {
+				lastID = msg.ID
+				f.broadcast(msg)
+			}
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for client := range f.clients {
+		close(client.changes)
+		delete(f.clients, client)
+	}
+}
+
+// Subscribe returns changes of the tenant that are published after lastEventID, or from now on when it is empty.
+// The channel is closed when ctx is done or the client falls too far behind, cancel must be called when the client leaves
+func (f *RedisFeed) Subscribe(ctx context.Context, tenantID uuid.UUID, lastEventID string) (changes <-chan Change, cancel func(), err error) {
+	if lastEventID != "" && !ValidStreamID(lastEventID) {
+		return nil, nil, fmt.Errorf("RedisFeed -> Subscribe -> %q: %w", lastEventID, ErrInvalidStreamID)
+	}
+	live := &feedClient{tenantID: tenantID, changes: make(chan Change, feedBuffer)}
+	f.mu.Lock()
+	f.clients[live] = struct{}{}
+	f.mu.Unlock()
+	cancel = func() { f.remove(live) }
+	if lastEventID == "" {
+		return live.changes, cancel, nil
+	}
+	// the client is registered before the backlog is read, so changes published meanwhile are
+	// buffered in live and the ones already sent from the backlog are skipped by their IDs
+	backlog, reset, err := f.backlog(ctx, lastEventID)
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("RedisFeed -> Subscribe -> error: %w", err)
+	}
+	out := make(chan Change, feedBuffer)
+	go func() {
+		defer close(out)
+		sent := lastEventID
+		if reset != "" {
+			if !send(ctx, out, Change{StreamID: reset, Reset: true}) {
+				return
+			}
+			sent = reset
+		}
+		for _, msg := range backlog {
+			change, ok := toChange(msg)
+			if !ok || change.Event.TenantID != tenantID {
+				continue
+			}
+			if !send(ctx, out, change) {
+				return
+			}
+			sent = change.StreamID
+		}
+		for change := range live.changes {
+			if CompareStreamIDs(change.StreamID, sent) <= 0 {
+				continue
+			}
+			if !send(ctx, out, change) {
+				return
+			}
+		}
+	}()
+	return out, cancel, nil
+}
+
+// backlog returns up to feedBacklog messages published after lastEventID. If more were published or the ones right after
+// lastEventID were already trimmed from the stream, it returns no messages and the ID of the last message to resume from
+func (f *RedisFeed) backlog(ctx context.Context, lastEventID string) (backlog []redis.XMessage, reset string, err error) {
+	first, err := f.client.XRangeN(ctx, Stream, "-", "+", 1).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("XRangeN -> error: %w", err)
+	}
+	if len(first) == 0 {
+		return nil, "", nil
+	}
+	backlog, err = f.client.XRangeN(ctx, Stream, "("+lastEventID, "+", feedBacklog+1).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("XRangeN -> error: %w", err)
+	}
+	if !tooFarBehind(lastEventID, first[0].ID, len(backlog)) {
+		return backlog, "", nil
+	}
+	last, err := f.client.XRevRangeN(ctx, Stream, "+", "-", 1).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("XRevRangeN -> error: %w", err)
+	}
+	if len(last) == 0 {
+		return nil, "", nil
+	}
+	return nil, last[0].ID, nil
+}
+
+// tooFarBehind tells if the client can't be caught up from the backlog: it missed more than feedBacklog messages
+// or resumes from a message older than the first one kept in the stream, so the messages between were trimmed
+func tooFarBehind(lastEventID, firstID string, missed int) bool {
+	return missed > feedBacklog || CompareStreamIDs(lastEventID, firstID) < 0
+}
+
+// broadcast passes the message to clients of its tenant, a client whose buffer is full is disconnected
+func (f *RedisFeed) broadcast(msg redis.XMessage) {
+	change, ok := toChange(msg)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for client := range f.clients {
+		if client.tenantID != change.Event.TenantID {
+			continue
+		}
+		select {
+		case client.changes <- change:
+		default:
+			close(client.changes)
+			delete(f.clients, client)
+		}
+	}
+}
+
+// remove unregisters the client, removing it twice is a no-op
+func (f *RedisFeed) remove(client *feedClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.clients[client]; ok {
+		close(client.changes)
+		delete(f.clients, client)
+	}
+}
+
+// toChange decodes the message, messages that can't be decoded are left to the dead-letter stream of the consumers
+func toChange(msg redis.XMessage) (Change, bool) {
+	event, err := decodeMessage(msg)
+	if err != nil {
+		return Change{}, false
+	}
+	return Change{StreamID: msg.ID, Event: event}, true
+}
+
+// send passes the change to out unless ctx is done first
+func send(ctx context.Context, out chan<- Change, change Change) bool {
+	select {
+	case out <- change:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ValidStreamID checks if id has the <milliseconds>-<sequence> form of the redis stream IDs
+func ValidStreamID(id string) bool {
+	_, _, ok := parseStreamID(id)
+	return ok
+}
+
+// CompareStreamIDs returns -1, 0 or 1 when ID a is before, equal to or after ID b
+func CompareStreamIDs(a, b string) int {
+	aMs, aSeq, _ := parseStreamID(a)
+	bMs, bSeq, _ := parseStreamID(b)
+	switch {
+	case aMs < bMs || (aMs == bMs && aSeq < bSeq):
+		return -1
+	case aMs == bMs && aSeq == bSeq:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// parseStreamID splits the stream ID into its milliseconds and sequence parts
+func parseStreamID(id string) (ms, seq uint64, ok bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err = strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
@@ -0,0 +1,74 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newFeedMessage(t *testing.T, streamID string, tenantID uuid.UUID) redis.XMessage {
+	event, err := New(identity.WithTenant(context.Background(), tenantID), PersonDeleted, uuid.New(), PersonDeletedPayload{})
+	require.NoError(t, err)
+	eventJSON, err := Encode(event)
+	require.NoError(t, err)
+	return redis.XMessage{ID: streamID, Values: map[string]interface{}{"event": string(eventJSON)}}
+}
+
+func TestCompareStreamIDs(t *testing.T) {
+	require.Equal(t, -1, CompareStreamIDs("1-5", "2-0"))
+	require.Equal(t, -1, CompareStreamIDs("2-1", "2-10"))
+	require.Equal(t, 0, CompareStreamIDs("3-0", "3-0"))
+	require.Equal(t, 1, CompareStreamIDs("10-0", "9-99"))
+	require.True(t, ValidStreamID("1700000000000-0"))
+	require.False(t, ValidStreamID("1700000000000"))
+	require.False(t, ValidStreamID("abc-0"))
+}
+
+func TestFeedBroadcastsToTenant(t *testing.T) {
+	feed := NewRedisFeed(nil)
+	tenantID, otherTenantID := uuid.New(), uuid.New()
+	changes, cancel, err := feed.Subscribe(context.Background(), tenantID, "")
+	require.NoError(t, err)
+	defer cancel()
+
+	feed.broadcast(newFeedMessage(t, "1-0", otherTenantID))
+	feed.broadcast(newFeedMessage(t, "2-0", tenantID))
+	change := <-changes
+	require.Equal(t, "2-0", change.StreamID)
+	require.Equal(t, tenantID, change.Event.TenantID)
+	require.Empty(t, changes)
+}
+
+func TestFeedDisconnectsSlowClient(t *testing.T) {
+	feed := NewRedisFeed(nil)
+	tenantID := uuid.New()
+	changes, cancel, err := feed.Subscribe(context.Background(), tenantID, "")
+	require.NoError(t, err)
+	defer cancel()
+
+	for i := 0; i <= feedBuffer; i++ {
+		feed.broadcast(newFeedMessage(t, "1-0", tenantID))
+	}
+	received := 0
+	for range changes {
+		received++
+	}
+	require.Equal(t, feedBuffer, received, "channel of the slow client must be closed after its buffer")
+}
+
+func TestFeedSubscribeInvalidLastEventID(t *testing.T) {
+	_, _, err := NewRedisFeed(nil).Subscribe(context.Background(), uuid.New(), "yesterday")
+	require.True(t, errors.Is(err, ErrInvalidStreamID))
+}
+
+func TestFeedTooFarBehind(t *testing.T) {
+	require.False(t, tooFarBehind("5-0", "3-0", 10))
+	require.False(t, tooFarBehind("3-0", "3-0", feedBacklog))
+	require.True(t, tooFarBehind("5-0", "3-0", feedBacklog+1), "more missed changes than the backlog must reset")
+	require.True(t, tooFarBehind("2-0", "3-0", 1), "changes trimmed from the stream must reset")
+}
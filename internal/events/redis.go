@@ -0,0 +1,333 @@
+// Package events contains domain events of persons, their versioned JSON schema and the interfaces to publish and subscribe to them
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// Stream is a redis stream where person events are published
+const Stream = "person_stream"
+
+const (
+	// readBlock is how long XReadGroup waits for new messages before it is called again
+	readBlock = 5 * time.Second
+	// readRetry is a pause after a failed redis call
+	readRetry = time.Second
+)
+
+// RedisPublisher publishes events to the redis stream
+type RedisPublisher struct {
+	client *redis.Client
+	maxLen int64
+}
+
+// NewRedisPublisher accepts an object of *redis.Client and the length the stream is trimmed to, approximately,
+// on every publish, 0 keeps the stream untrimmed. It returns an object of type *RedisPublisher
+func NewRedisPublisher(client *redis.Client, maxLen int64) *RedisPublisher {
+	return &RedisPublisher{client: client, maxLen: maxLen}
+}
+
+// Publish adds event to the stream, the type and the ID are duplicated as fields so consumers can skip events without decoding them
+func (p *RedisPublisher) Publish(ctx context.Context, event *Event) error {
+	values, err := messageValues(event)
+	if err != nil {
+		return fmt.Errorf("RedisPublisher -> Publish -> error: %w", err)
+	}
+	_, err = p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: Stream,
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: values,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("RedisPublisher -> Publish -> XAdd -> error: %w", err)
+	}
+	return nil
+}
+
+// messageValues returns fields of the stream message that keeps the event
+func messageValues(event *Event) (map[string]interface{}, error) {
+	eventJSON, err := Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"id":    event.ID.String(),
+		"type":  event.Type,
+		"event": string(eventJSON),
+	}, nil
+}
+
+// DeadLetterStream is a redis stream where messages that failed too many times or can't be decoded are moved
+const DeadLetterStream = Stream + ":dead"
+
+// readCount is the maximum number of messages read or reclaimed at once
+const readCount = 100
+
+// RetryStream returns the stream where dead letters of the group are replayed. Only the group reads it, so a replayed
+// event isn't handled again by the other groups that already handled it
+func RetryStream(group string) string {
+	return Stream + ":retry:" + group
+}
+
+// RedisSubscriber consumes events from the redis stream as a member of the consumer group,
+// so every event is handled by one consumer of the group and is acknowledged only after the handler succeeds
+type RedisSubscriber struct {
+	client        *redis.Client
+	group         string
+	consumer      string
+	minIdle       time.Duration
+	maxDeliveries int64
+}
+
+// NewRedisSubscriber accepts an object of *redis.Client, names of the group and the consumer, the idle time after which
+// pending messages of a stalled consumer are reclaimed and the number of deliveries after which a message is dead-lettered
+func NewRedisSubscriber(client *redis.Client, group, consumer string, minIdle time.Duration, maxDeliveries int64) *RedisSubscriber {
+	return &RedisSubscriber{client: client, group: group, consumer: consumer, minIdle: minIdle, maxDeliveries: maxDeliveries}
+}
+
+// Subscribe delivers events to the handler until ctx is done. Messages that the handler failed stay pending and are retried
+// after minIdle, messages delivered maxDeliveries times or that can't be decoded are moved to the dead-letter stream.
+// Events of the retry stream of the group, see RetryStream, are delivered too
+func (s *RedisSubscriber) Subscribe(ctx context.Context, handler Handler) error {
+	retryStream := RetryStream(s.group)
+	// the retry stream is read from its start, so events replayed before the group was created aren't skipped
+	for stream, start := range map[string]string{Stream: "$", retryStream: "0"} {
+		err := s.client.XGroupCreateMkStream(ctx, stream, s.group, start).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("RedisSubscriber -> Subscribe -> XGroupCreateMkStream -> error: %w", err)
+		}
+	}
+	for ctx.Err() == nil {
+		if err := s.reclaim(ctx, handler, Stream, retryStream); err != nil {
+			s.pause(ctx, "autoClaim", err)
+			continue
+		}
+		results, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{Stream, retryStream, ">", ">"},
+			Count:    readCount,
+			Block:    readBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			s.pause(ctx, "XReadGroup", err)
+			continue
+		}
+		for _, result := range results {
+			for _, msg := range result.Messages {
+				s.handle(ctx, result.Stream, msg, handler, false)
+			}
+		}
+	}
+	return nil
+}
+
+// reclaim handles messages of the streams that stayed pending in the group longer than minIdle
+func (s *RedisSubscriber) reclaim(ctx context.Context, handler Handler, streams ...string) error {
+	for _, stream := range streams {
+		reclaimed, err := s.autoClaim(ctx, stream)
+		if err != nil {
+			return err
+		}
+		for _, msg := range reclaimed {
+			s.handle(ctx, stream, msg, handler, true)
+		}
+	}
+	return nil
+}
+
+// handle passes the message to the handler and acknowledges it, reclaimed messages are checked for the delivery limit first
+func (s *RedisSubscriber) handle(ctx context.Context, stream string, msg redis.XMessage, handler Handler, reclaimed bool) {
+	log := logrus.WithFields(logrus.Fields{"MessageID": msg.ID, "Group": s.group, "Stream": stream})
+	event, err := decodeMessage(msg)
+	if err != nil {
+		s.deadLetter(ctx, stream, msg, fmt.Sprintf("decodeMessage: %v", err), 1)
+		return
+	}
+	if reclaimed {
+		deliveries, err := s.deliveries(ctx, stream, msg.ID)
+		if err != nil {
+			log.Errorf("RedisSubscriber -> handle -> deliveries -> error: %v", err)
+			return
+		}
+		if deliveries > s.maxDeliveries {
+			s.deadLetter(ctx, stream, msg, "too many deliveries", deliveries)
+			return
+		}
+	}
+	if err = handler(ctx, event); err != nil {
+		log.WithFields(logrus.Fields{"EventID": event.ID, "Type": event.Type}).
+			Errorf("RedisSubscriber -> handle -> handler -> error: %v", err)
+		return
+	}
+	if err = s.ack(ctx, stream, msg.ID); err != nil {
+		log.Errorf("RedisSubscriber -> handle -> ack -> error: %v", err)
+	}
+}
+
+// ack acknowledges the message in the group, messages of the retry stream are read by the group only, so they are deleted
+func (s *RedisSubscriber) ack(ctx context.Context, stream, id string) error {
+	if err := s.client.XAck(ctx, stream, s.group, id).Err(); err != nil {
+		return fmt.Errorf("XAck -> error: %w", err)
+	}
+	if stream == Stream {
+		return nil
+	}
+	if err := s.client.XDel(ctx, stream, id).Err(); err != nil {
+		return fmt.Errorf("XDel -> error: %w", err)
+	}
+	return nil
+}
+
+// deadLetter moves the message to the dead-letter stream and acknowledges it in the group
+func (s *RedisSubscriber) deadLetter(ctx context.Context, stream string, msg redis.XMessage, reason string, deliveries int64) {
+	log := logrus.WithFields(logrus.Fields{"MessageID": msg.ID, "Group": s.group, "Stream": stream, "Reason": reason})
+	event, _ := msg.Values["event"].(string)
+	err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: DeadLetterStream,
+		Values: map[string]interface{}{
+			"messageId":  msg.ID,
+			"group":      s.group,
+			"reason":     reason,
+			"deliveries": deliveries,
+			"failedAt":   time.Now().UTC().Format(time.RFC3339),
+			"event":      event,
+		},
+	}).Err()
+	if err != nil {
+		log.Errorf("RedisSubscriber -> deadLetter -> XAdd -> error: %v", err)
+		return
+	}
+	if err = s.ack(ctx, stream, msg.ID); err != nil {
+		log.Errorf("RedisSubscriber -> deadLetter -> ack -> error: %v", err)
+		return
+	}
+	log.Warn("message moved to the dead-letter stream")
+}
+
+// deliveries returns how many times the pending message of the stream was delivered to the group
+func (s *RedisSubscriber) deliveries(ctx context.Context, stream, id string) (int64, error) {
+	pending, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  s.group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	return pending[0].RetryCount, nil
+}
+
+// autoClaim takes over messages of the stream that stayed pending in the group longer than minIdle. XAUTOCLAIM is sent
+// as a raw command, because since redis 7 its reply has a third element that the typed command of the client can't parse
+func (s *RedisSubscriber) autoClaim(ctx context.Context, stream string) ([]redis.XMessage, error) {
+	var claimed []redis.XMessage
+	start := "0-0"
+	for {
+		reply, err := s.client.Do(ctx, "xautoclaim", stream, s.group, s.consumer,
+			s.minIdle.Milliseconds(), start, "count", readCount).Slice()
+		if err != nil {
+			return claimed, err
+		}
+		if len(reply) < 2 {
+			return claimed, fmt.Errorf("unexpected xautoclaim reply of %d elements", len(reply))
+		}
+		start, _ = reply[0].(string)
+		entries, _ := reply[1].([]interface{})
+		for _, entry := range entries {
+			if msg, ok := parseMessage(entry); ok {
+				claimed = append(claimed, msg)
+			}
+		}
+		if start == "0-0" || start == "" || len(claimed) >= readCount {
+			return claimed, nil
+		}
+	}
+}
+
+// parseMessage converts raw [id, [field, value, ...]] reply into the message, deleted entries are reported as not ok
+func parseMessage(entry interface{}) (redis.XMessage, bool) {
+	parts, ok := entry.([]interface{})
+	if !ok || len(parts) != 2 {
+		return redis.XMessage{}, false
+	}
+	id, _ := parts[0].(string)
+	fields, ok := parts[1].([]interface{})
+	if id == "" || !ok {
+		return redis.XMessage{}, false
+	}
+	values := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		key, _ := fields[i].(string)
+		values[key] = fields[i+1]
+	}
+	return redis.XMessage{ID: id, Values: values}, true
+}
+
+// pause logs the error of redis and waits before the next attempt
+func (s *RedisSubscriber) pause(ctx context.Context, op string, err error) {
+	logrus.WithField("Group", s.group).Errorf("RedisSubscriber -> Subscribe -> %s -> error: %v", op, err)
+	sleep(ctx, readRetry)
+}
+
+// sleep waits for the given time or until ctx is done
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// decodeMessage returns event kept in the message of the stream
+func decodeMessage(msg redis.XMessage) (*Event, error) {
+	eventJSON, ok := msg.Values["event"].(string)
+	if !ok {
+		return nil, fmt.Errorf("message has no event field")
+	}
+	return Decode([]byte(eventJSON))
+}
+
+// RedisReader reads ranges of the redis stream, it is used to replay events that were already consumed
+type RedisReader struct {
+	client *redis.Client
+}
+
+// NewRedisReader accepts an object of *redis.Client and returns an object of type *RedisReader
+func NewRedisReader(client *redis.Client) *RedisReader {
+	return &RedisReader{client: client}
+}
+
+// Range returns up to count changes with IDs from start to end inclusive and the ID of the last read message.
+// Messages that can't be decoded are skipped, so the returned ID is the one to continue from even if no change is returned
+func (r *RedisReader) Range(ctx context.Context, start, end string, count int64) (changes []Change, lastID string, err error) {
+	msgs, err := r.client.XRangeN(ctx, Stream, start, end, count).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("RedisReader -> Range -> XRangeN -> error: %w", err)
+	}
+	for _, msg := range msgs {
+		lastID = msg.ID
+		change, ok := toChange(msg)
+		if !ok {
+			logrus.WithField("MessageID", msg.ID).Warn("RedisReader -> Range -> message can't be decoded, skipped")
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, lastID, nil
+}
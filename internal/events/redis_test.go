@@ -0,0 +1,42 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessage(t *testing.T) {
+	msg, ok := parseMessage([]interface{}{"1-0", []interface{}{"id", "42", "event", "{}"}})
+	require.True(t, ok)
+	require.Equal(t, "1-0", msg.ID)
+	require.Equal(t, "{}", msg.Values["event"])
+	require.Equal(t, "42", msg.Values["id"])
+}
+
+func TestParseMessageDeleted(t *testing.T) {
+	_, ok := parseMessage(nil)
+	require.False(t, ok)
+	_, ok = parseMessage([]interface{}{"1-0", nil})
+	require.False(t, ok)
+}
+
+func TestToDeadLetter(t *testing.T) {
+	deadLetter := toDeadLetter(redis.XMessage{ID: "2-0", Values: map[string]interface{}{
+		"messageId":  "1-0",
+		"group":      "firstTask",
+		"reason":     "too many deliveries",
+		"deliveries": "6",
+		"event":      `{"schemaVersion":1}`,
+	}})
+	require.Equal(t, "2-0", deadLetter.ID)
+	require.Equal(t, "1-0", deadLetter.MessageID)
+	require.Equal(t, int64(6), deadLetter.Deliveries)
+	require.JSONEq(t, `{"schemaVersion":1}`, string(deadLetter.Event))
+}
+
+func TestToDeadLetterBrokenEvent(t *testing.T) {
+	deadLetter := toDeadLetter(redis.XMessage{ID: "2-0", Values: map[string]interface{}{"event": "not json"}})
+	require.Nil(t, deadLetter.Event)
+}
@@ -3,10 +3,8 @@ package handler
 
 import (
 	"context"
-	"io"
+	"errors"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/distuurbia/firstTask/internal/service"
@@ -78,7 +76,7 @@ func (handl *EntityHandler) Create(c echo.Context) error {
 		}).Errorf("EntityHandler -> Create -> srvcPers.Create -> error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to create check if id is UUID format")
 	}
-	return c.JSON(http.StatusCreated, createdPerson)
+	return c.JSON(http.StatusCreated, withAvatarURL(&createdPerson))
 }
 
 // ReadRow calls ReadRow method of Service by handler
@@ -109,7 +107,7 @@ func (handl *EntityHandler) ReadRow(c echo.Context) error {
 		logrus.WithField("ID", uuidID).Errorf("EntityHandler -> ReadRow -> srvcPers.ReadRow -> error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to read check if id is UUID format or that such person exist")
 	}
-	return c.JSON(http.StatusOK, readPerson)
+	return c.JSON(http.StatusOK, withAvatarURL(readPerson))
 }
 
 // GetAll calls GetAll method of Service by handler
@@ -128,9 +126,21 @@ func (handl *EntityHandler) GetAll(c echo.Context) error {
 		logrus.Errorf("EntityHandler -> GetAll -> srvcPers.GetAll -> error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to get all persons")
 	}
+	for i := range persAll {
+		withAvatarURL(&persAll[i])
+	}
 	return c.JSON(http.StatusOK, persAll)
 }
 
+// withAvatarURL fills the URL the avatar of the person is downloaded from and returns the person
+func withAvatarURL(pers *model.Person) *model.Person {
+	pers.AvatarURL = ""
+	if pers.AvatarImageID != nil {
+		pers.AvatarURL = "/persons/" + pers.ID.String() + "/avatar"
+	}
+	return pers
+}
+
 // Update calls Update method of Service by handler
 // @Summary Update a person by ID
 // @Security ApiKeyAuth
@@ -177,7 +187,7 @@ func (handl *EntityHandler) Update(c echo.Context) error {
 		}).Errorf("EntityHandler -> Update -> srvcPers.Update -> error: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to update check if id is UUID format or that such person exist")
 	}
-	return c.JSON(http.StatusOK, updatedPerson)
+	return c.JSON(http.StatusOK, withAvatarURL(&updatedPerson))
 }
 
 // Delete calls Delete method of Service by handler
@@ -220,6 +230,7 @@ func (handl *EntityHandler) Delete(c echo.Context) error {
 // @Param user body model.UserRequest true "user value (model.UserRequest)"
 // @Success 201 {string} string
 // @Failure 400 {object} error
+// @Failure 409 {object} error
 // @Router /signUp [post]
 func (handl *EntityHandler) SignUp(c echo.Context) error {
 	bindInfo := struct {
@@ -241,6 +252,10 @@ func (handl *EntityHandler) SignUp(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to validate")
 	}
 	err = handl.srvcUser.SignUp(c.Request().Context(), &createdUser)
+	if errors.Is(err, service.ErrUsernameExist) {
+		logrus.WithField("Username", createdUser.Username).Errorf("EntityHandler -> SignUp -> srvcUser.SignUp -> error: %v", err)
+		return echo.NewHTTPError(http.StatusConflict, "such username already exist")
+	}
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"ID":           createdUser.ID,
@@ -334,93 +349,3 @@ func (handl *EntityHandler) Refresh(c echo.Context) error {
 		"refresh token": tokenPair.RefreshToken,
 	})
 }
-
-// DownloadImage downloads image from server
-// @Summary Download an image
-// @Description Downloads the specified image from the server
-// @Tags Images
-// @Accept json
-// @Produce octet-stream
-// @Param imageName path string true "Image filename"
-// @Success 200 {file} octet-stream "Image file"
-// @Failure 404 {object} error
-// @Router /downloadImage/{imageName} [get]
-func (handl *EntityHandler) DownloadImage(c echo.Context) error {
-	imgName := c.Param("imageName")
-	imgPath := "images/download/" + imgName
-	if _, err := os.Stat(imgPath); os.IsNotExist(err) {
-		logrus.Errorf("EntityHandler -> DownloadImage -> os.Stat -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "image not found")
-	}
-	imgPath = filepath.Clean(imgPath)
-	img, err := os.Open(imgPath)
-	if err != nil {
-		logrus.Errorf("EntityHandler -> DownloadImage -> os.Open -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "image not found")
-	}
-	defer func() {
-		if err = img.Close(); err != nil {
-			logrus.Errorf("EntityHandler -> DownloadImage -> img.Close -> error: %v", err)
-		}
-	}()
-	c.Response().Header().Set("Content-Type", "image/png")
-	c.Response().Header().Set("Content-Disposition", "attachment; filename="+imgName)
-	_, err = io.Copy(c.Response(), img)
-	if err != nil {
-		logrus.Errorf("EntityHandler -> DownloadImage -> io.copy -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "image not found")
-	}
-	return nil
-}
-
-// UploadImage uploads image to server
-// @Summary Upload an image
-// @Description Uploads an image to the server
-// @Tags Images
-// @Accept multipart/form-data
-// @Produce json
-// @Param image formData file true "Image file"
-// @Success 200 {string} string "OK"
-// @Failure 404 {object} error
-// @Router /uploadImage [post]
-func (handl *EntityHandler) UploadImage(c echo.Context) error {
-	image, err := c.FormFile("image")
-	if err != nil {
-		logrus.Errorf("EntityHandler -> UploadImage -> c.FormFile -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "image not found")
-	}
-	src, err := image.Open()
-	if err != nil {
-		logrus.Errorf("EntityHandler -> UploadImage -> image.Open -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "image not found")
-	}
-	defer func() {
-		if err = src.Close(); err != nil {
-			logrus.Errorf("EntityHandler -> uploadImage -> src.Close -> error: %v", err)
-		}
-	}()
-	dstPath := "images/upload/" + image.Filename
-	dstPath = filepath.Clean(dstPath)
-	dst, err := os.Create(dstPath)
-	if err != nil {
-		logrus.Errorf("EntityHandler -> UploadImage -> os.Create -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "failed to create")
-	}
-	defer func() {
-		if err = dst.Close(); err != nil {
-			logrus.Errorf("EntityHandler -> uploadImage -> dst.Close -> error: %v", err)
-		}
-	}()
-	if _, err = io.Copy(dst, src); err != nil {
-		logrus.Errorf("EntityHandler -> UploadImage -> io.Copy -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "failed to copy")
-	}
-	c.Response().Header().Set("Content-Type", "image/png")
-	c.Response().Header().Set("Content-Disposition", "attachment; filename="+dst.Name())
-	_, err = io.Copy(c.Response(), dst)
-	if err != nil {
-		logrus.Errorf("EntityHandler -> UploadImage -> io.Copy -> error: %v", err)
-		return echo.NewHTTPError(http.StatusNotFound, "failed to copy")
-	}
-	return nil
-}
@@ -0,0 +1,144 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// AvatarService is an interface that contains methods of service for avatars of persons
+type AvatarService interface {
+	SetAvatar(ctx context.Context, personID, imageID uuid.UUID) (*model.Person, error)
+	UploadAvatar(ctx context.Context, personID uuid.UUID, filename string, src io.Reader) (*model.Person, error)
+	Avatar(ctx context.Context, personID uuid.UUID, variant model.ImageVariant) (*model.ImageFile, error)
+}
+
+// AvatarHandler contains AvatarService interface and the size limit of uploaded images
+type AvatarHandler struct {
+	srvcAvatar AvatarService
+	maxBytes   int64
+}
+
+// NewAvatarHandler accepts AvatarService interface and the maximum size of an uploaded image and returns an object of *AvatarHandler
+func NewAvatarHandler(srvcAvatar AvatarService, maxBytes int64) *AvatarHandler {
+	return &AvatarHandler{srvcAvatar: srvcAvatar, maxBytes: maxBytes}
+}
+
+// SetAvatar sets avatar of the person
+// @Summary Set an avatar of a person
+// @Security ApiKeyAuth
+// @Description Uploads a new image as the avatar of the person from multipart form, or makes an already uploaded image of
+// @Description the user the avatar, then the body is JSON {"imageId": "..."}. The previous avatar is deleted unless another
+// @Description person has it. Returns the changed person
+// @Tags Person
+// @Accept multipart/form-data,json
+// @Produce json
+// @Param id path string true "Person ID"
+// @Param image formData file false "Image file"
+// @Success 200 {object} model.Person
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Failure 413 {object} error
+// @Failure 415 {object} error
+// @Failure 422 {object} error
+// @Failure 507 {object} error
+// @Router /persons/{id}/avatar [put]
+func (handl *AvatarHandler) SetAvatar(c echo.Context) error {
+	personID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("AvatarHandler -> SetAvatar -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	ctx := c.Request().Context()
+	var pers *model.Person
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, err := openFormImage(c, handl.maxBytes)
+		if err != nil {
+			logrus.Errorf("AvatarHandler -> SetAvatar -> openFormImage -> error: %v", err)
+			return err
+		}
+		defer func() {
+			if err = file.Close(); err != nil {
+				logrus.Errorf("AvatarHandler -> SetAvatar -> file.Close -> error: %v", err)
+			}
+		}()
+		pers, err = handl.srvcAvatar.UploadAvatar(ctx, personID, file.filename, file)
+		if err != nil {
+			logrus.WithField("ID", personID).Errorf("AvatarHandler -> SetAvatar -> srvcAvatar.UploadAvatar -> error: %v", err)
+			return avatarError(err)
+		}
+	} else {
+		var body struct {
+			ImageID uuid.UUID `json:"imageId"`
+		}
+		if err = c.Bind(&body); err != nil || body.ImageID == uuid.Nil {
+			logrus.Errorf("AvatarHandler -> SetAvatar -> c.Bind -> error: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "image form file or imageId is required")
+		}
+		pers, err = handl.srvcAvatar.SetAvatar(ctx, personID, body.ImageID)
+		if err != nil {
+			logrus.WithField("ID", personID).Errorf("AvatarHandler -> SetAvatar -> srvcAvatar.SetAvatar -> error: %v", err)
+			return avatarError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, withAvatarURL(pers))
+}
+
+// Avatar downloads avatar of the person
+// @Summary Download an avatar of a person
+// @Security ApiKeyAuth
+// @Description Downloads the avatar of the person or its variant resized to width and height, like /images/{id}
+// @Tags Person
+// @Produce image/png,image/jpeg,image/gif
+// @Param id path string true "Person ID"
+// @Param width query int false "width of the variant, one of the allowed sizes"
+// @Param height query int false "height of the variant, one of the allowed sizes"
+// @Param fit query string false "contain (default), cover or fill"
+// @Param format query string false "png or jpeg, the format of the original by default"
+// @Success 200 {file} binary "Image file"
+// @Success 206 {file} binary "Requested range of the image file"
+// @Success 304 "Image isn't modified"
+// @Failure 400 {object} error
+// @Failure 404 {object} error
+// @Router /persons/{id}/avatar [get]
+func (handl *AvatarHandler) Avatar(c echo.Context) error {
+	personID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("AvatarHandler -> Avatar -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	variant, err := bindImageVariant(c)
+	if err != nil {
+		logrus.Errorf("AvatarHandler -> Avatar -> bindImageVariant -> error: %v", err)
+		return err
+	}
+	img, err := handl.srvcAvatar.Avatar(c.Request().Context(), personID, variant)
+	if err != nil {
+		logrus.WithField("ID", personID).Errorf("AvatarHandler -> Avatar -> srvcAvatar.Avatar -> error: %v", err)
+		return avatarError(err)
+	}
+	serveImage(c, img, "avatar-"+personID.String(), "")
+	return nil
+}
+
+// avatarError returns HTTP error that tells the client why the avatar can't be set or read
+func avatarError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrPersonNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "person not found")
+	case errors.Is(err, service.ErrAvatarNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "person has no avatar")
+	default:
+		return imageError(err)
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/handler/mocks"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newAvatarPerson returns a person with an avatar as the service returns it
+func newAvatarPerson() *model.Person {
+	avatarID := uuid.New()
+	return &model.Person{ID: uuid.New(), Salary: 300, Profession: "baker", AvatarImageID: &avatarID}
+}
+
+// requireAvatarPerson checks that the response is the person with the URL of its avatar
+func requireAvatarPerson(t *testing.T, rec *httptest.ResponseRecorder, pers *model.Person) {
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp model.Person
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(t, *pers.AvatarImageID, *resp.AvatarImageID)
+	require.Equal(t, "/persons/"+pers.ID.String()+"/avatar", resp.AvatarURL)
+}
+
+func TestSetAvatarUpload(t *testing.T) {
+	pers := newAvatarPerson()
+	srvcAvatar := mocks.NewAvatarService(t)
+	srvcAvatar.On("UploadAvatar", mock.Anything, pers.ID, "passwd.png", mock.Anything).Return(pers, nil).Once()
+	req := newUploadRequest(t, "image", []byte("image"))
+	req.Method = http.MethodPut
+	c, rec := newAvatarContext(req, pers.ID.String())
+	require.NoError(t, NewAvatarHandler(srvcAvatar, 1024).SetAvatar(c))
+	requireAvatarPerson(t, rec, pers)
+}
+
+func TestSetAvatarImageID(t *testing.T) {
+	pers := newAvatarPerson()
+	srvcAvatar := mocks.NewAvatarService(t)
+	srvcAvatar.On("SetAvatar", mock.Anything, pers.ID, *pers.AvatarImageID).Return(pers, nil).Once()
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(fmt.Sprintf(`{"imageId": %q}`, pers.AvatarImageID)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c, rec := newAvatarContext(req, pers.ID.String())
+	require.NoError(t, NewAvatarHandler(srvcAvatar, 1024).SetAvatar(c))
+	requireAvatarPerson(t, rec, pers)
+}
+
+func TestSetAvatarRejected(t *testing.T) {
+	personID := uuid.New()
+	tests := map[string]struct {
+		body string
+		err  error
+		code int
+	}{
+		"missingImage":  {body: `{}`, code: http.StatusBadRequest},
+		"personMissing": {body: fmt.Sprintf(`{"imageId": %q}`, uuid.New()), err: service.ErrPersonNotFound, code: http.StatusNotFound},
+		"imageMissing":  {body: fmt.Sprintf(`{"imageId": %q}`, uuid.New()), err: service.ErrImageNotFound, code: http.StatusNotFound},
+		"notOwned":      {body: fmt.Sprintf(`{"imageId": %q}`, uuid.New()), err: service.ErrImageForbidden, code: http.StatusForbidden},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srvcAvatar := mocks.NewAvatarService(t)
+			if test.err != nil {
+				srvcAvatar.On("SetAvatar", mock.Anything, personID, mock.Anything).
+					Return(nil, fmt.Errorf("AvatarService -> SetAvatar -> error: %w", test.err)).Once()
+			}
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			c, _ := newAvatarContext(req, personID.String())
+			err := NewAvatarHandler(srvcAvatar, 1024).SetAvatar(c)
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			require.Equal(t, test.code, httpErr.Code)
+		})
+	}
+}
+
+func TestAvatarDownload(t *testing.T) {
+	personID := uuid.New()
+	file, content := newImageFile(uuid.New(), []byte("\x89PNG image"))
+	srvcAvatar := mocks.NewAvatarService(t)
+	srvcAvatar.On("Avatar", mock.Anything, personID, model.ImageVariant{Width: 64, Height: 64}).Return(file, nil).Once()
+	srvcAvatar.On("Avatar", mock.Anything, mock.Anything, model.ImageVariant{}).
+		Return(nil, fmt.Errorf("AvatarService -> Avatar -> error: %w", service.ErrAvatarNotFound)).Once()
+	handl := NewAvatarHandler(srvcAvatar, 1024)
+
+	c, rec := newAvatarContext(httptest.NewRequest(http.MethodGet, "/?width=64&height=64", http.NoBody), personID.String())
+	require.NoError(t, handl.Avatar(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "\x89PNG image", rec.Body.String())
+	require.Equal(t, file.ETag, rec.Header().Get("ETag"))
+	require.Equal(t, `attachment; filename=avatar-`+personID.String()+`.png`, rec.Header().Get(echo.HeaderContentDisposition))
+	require.Equal(t, 1, content.closed)
+
+	c, _ = newAvatarContext(httptest.NewRequest(http.MethodGet, "/", http.NoBody), uuid.New().String())
+	var httpErr *echo.HTTPError
+	require.ErrorAs(t, handl.Avatar(c), &httpErr)
+	require.Equal(t, http.StatusNotFound, httpErr.Code)
+}
+
+// newAvatarContext returns context of the request to the avatar of the person
+func newAvatarContext(req *http.Request, personID string) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(personID)
+	return c, rec
+}
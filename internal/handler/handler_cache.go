@@ -0,0 +1,39 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"net/http"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/labstack/echo/v4"
+)
+
+// CacheTier is an interface that returns counters of one tier of the person cache
+type CacheTier interface {
+	Stats() model.CacheTierStats
+}
+
+// CacheHandler contains tiers of the person cache by their names
+type CacheHandler struct {
+	tiers map[string]CacheTier
+}
+
+// NewCacheHandler accepts tiers of the person cache by their names and returns an object of *CacheHandler
+func NewCacheHandler(tiers map[string]CacheTier) *CacheHandler {
+	return &CacheHandler{tiers: tiers}
+}
+
+// Stats returns hit and miss counters of every tier of the person cache
+// @Summary Person cache counters
+// @Description Returns hit and miss counters of every tier of the person cache of this replica, requires X-Admin-Key header
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} map[string]model.CacheTierStats
+// @Router /admin/cache/stats [get]
+func (handl *CacheHandler) Stats(c echo.Context) error {
+	stats := make(map[string]model.CacheTierStats, len(handl.tiers))
+	for name, tier := range handl.tiers {
+		stats[name] = tier.Stats()
+	}
+	return c.JSON(http.StatusOK, stats)
+}
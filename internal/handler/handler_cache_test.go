@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/handler/mocks"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheStats(t *testing.T) {
+	local, rds := mocks.NewCacheTier(t), mocks.NewCacheTier(t)
+	local.On("Stats").Return(model.CacheTierStats{Hits: 7, Misses: 3, Entries: 2}).Once()
+	rds.On("Stats").Return(model.CacheTierStats{Hits: 2, Misses: 1}).Once()
+	handl := NewCacheHandler(map[string]CacheTier{"local": local, "redis": rds})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/admin/cache/stats", http.NoBody)
+	rec := httptest.NewRecorder()
+	err := handl.Stats(e.NewContext(req, rec))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"local":{"hits":7,"misses":3,"entries":2},"redis":{"hits":2,"misses":1}}`, rec.Body.String())
+}
@@ -0,0 +1,109 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDeadLettersCount is how many dead letters are listed when count isn't given
+const defaultDeadLettersCount = 100
+
+// DeadLetterService is an interface that contains methods to inspect, replay and discard dead letters
+type DeadLetterService interface {
+	List(ctx context.Context, count int64) ([]events.DeadLetter, error)
+	Replay(ctx context.Context, id string) error
+	Discard(ctx context.Context, id string) error
+}
+
+// DeadLetterHandler contains DeadLetterService interface
+type DeadLetterHandler struct {
+	srvcDeadLetter DeadLetterService
+}
+
+// NewDeadLetterHandler accepts DeadLetterService interface and returns an object of *DeadLetterHandler
+func NewDeadLetterHandler(srvcDeadLetter DeadLetterService) *DeadLetterHandler {
+	return &DeadLetterHandler{srvcDeadLetter: srvcDeadLetter}
+}
+
+// List returns the oldest dead letters
+// @Summary List dead letters
+// @Description Lists messages of the dead-letter stream, requires X-Admin-Key header
+// @Tags Admin
+// @Produce json
+// @Param count query int false "maximum number of dead letters"
+// @Success 200 {array} events.DeadLetter
+// @Failure 400 {object} error
+// @Router /admin/deadletters [get]
+func (handl *DeadLetterHandler) List(c echo.Context) error {
+	count := int64(defaultDeadLettersCount)
+	if param := c.QueryParam("count"); param != "" {
+		parsed, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || parsed <= 0 {
+			logrus.Errorf("DeadLetterHandler -> List -> strconv.ParseInt -> error: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "count must be a positive number")
+		}
+		count = parsed
+	}
+	deadLetters, err := handl.srvcDeadLetter.List(c.Request().Context(), count)
+	if err != nil {
+		logrus.Errorf("DeadLetterHandler -> List -> srvcDeadLetter.List -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to list dead letters")
+	}
+	return c.JSON(http.StatusOK, deadLetters)
+}
+
+// Replay passes the event of the dead letter again to the group that dead-lettered it and removes the dead letter
+// @Summary Replay a dead letter
+// @Description Adds the event of the dead letter to the retry stream of its group, so only that group handles it again, requires X-Admin-Key header
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Dead letter ID"
+// @Success 200 {string} string
+// @Failure 404 {object} error
+// @Failure 422 {object} error
+// @Router /admin/deadletters/{id}/replay [post]
+func (handl *DeadLetterHandler) Replay(c echo.Context) error {
+	id := c.Param("id")
+	err := handl.srvcDeadLetter.Replay(c.Request().Context(), id)
+	if errors.Is(err, events.ErrDeadLetterNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "dead letter not found")
+	}
+	if errors.Is(err, events.ErrDeadLetterInvalid) {
+		logrus.WithField("ID", id).Errorf("DeadLetterHandler -> Replay -> srvcDeadLetter.Replay -> error: %v", err)
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "dead letter can't be replayed, discard it")
+	}
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("DeadLetterHandler -> Replay -> srvcDeadLetter.Replay -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to replay dead letter")
+	}
+	return c.JSON(http.StatusOK, "Replayed: "+id)
+}
+
+// Discard removes the dead letter without replaying it
+// @Summary Discard a dead letter
+// @Description Removes the dead letter without replaying it, letters that can't be decoded can only be discarded, requires X-Admin-Key header
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Dead letter ID"
+// @Success 200 {string} string
+// @Failure 404 {object} error
+// @Router /admin/deadletters/{id} [delete]
+func (handl *DeadLetterHandler) Discard(c echo.Context) error {
+	id := c.Param("id")
+	err := handl.srvcDeadLetter.Discard(c.Request().Context(), id)
+	if errors.Is(err, events.ErrDeadLetterNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "dead letter not found")
+	}
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("DeadLetterHandler -> Discard -> srvcDeadLetter.Discard -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to discard dead letter")
+	}
+	return c.JSON(http.StatusOK, "Discarded: "+id)
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/handler/mocks"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterList(t *testing.T) {
+	srvcDeadLetter := mocks.NewDeadLetterService(t)
+	srvcDeadLetter.On("List", mock.Anything, int64(10)).Return([]events.DeadLetter{{ID: "2-0", Reason: "too many deliveries"}}, nil).Once()
+	handl := NewDeadLetterHandler(srvcDeadLetter)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/admin/deadletters?count=10", http.NoBody)
+	rec := httptest.NewRecorder()
+	err := handl.List(e.NewContext(req, rec))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"reason":"too many deliveries"`)
+}
+
+func TestDeadLetterListInvalidCount(t *testing.T) {
+	handl := NewDeadLetterHandler(mocks.NewDeadLetterService(t))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/admin/deadletters?count=-1", http.NoBody)
+	err := handl.List(e.NewContext(req, httptest.NewRecorder()))
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+}
+
+func TestDeadLetterReplayNotFound(t *testing.T) {
+	srvcDeadLetter := mocks.NewDeadLetterService(t)
+	srvcDeadLetter.On("Replay", mock.Anything, "2-0").Return(events.ErrDeadLetterNotFound).Once()
+	handl := NewDeadLetterHandler(srvcDeadLetter)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/admin/deadletters/2-0/replay", http.NoBody)
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues("2-0")
+	err := handl.Replay(c)
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusNotFound, httpErr.Code)
+}
+
+func TestDeadLetterReplayInvalid(t *testing.T) {
+	srvcDeadLetter := mocks.NewDeadLetterService(t)
+	srvcDeadLetter.On("Replay", mock.Anything, "2-0").Return(events.ErrDeadLetterInvalid).Once()
+	handl := NewDeadLetterHandler(srvcDeadLetter)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/admin/deadletters/2-0/replay", http.NoBody)
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues("2-0")
+	err := handl.Replay(c)
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusUnprocessableEntity, httpErr.Code)
+}
+
+func TestDeadLetterDiscard(t *testing.T) {
+	srvcDeadLetter := mocks.NewDeadLetterService(t)
+	srvcDeadLetter.On("Discard", mock.Anything, "2-0").Return(nil).Once()
+	srvcDeadLetter.On("Discard", mock.Anything, "3-0").Return(events.ErrDeadLetterNotFound).Once()
+	handl := NewDeadLetterHandler(srvcDeadLetter)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/admin/deadletters/2-0", http.NoBody)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("2-0")
+	require.NoError(t, handl.Discard(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/admin/deadletters/3-0", http.NoBody)
+	c = e.NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues("3-0")
+	err := handl.Discard(c)
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusNotFound, httpErr.Code)
+}
@@ -0,0 +1,240 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// heartbeatInterval keeps idle connections open through proxies
+	heartbeatInterval = 15 * time.Second
+	// sseRetry is how many milliseconds EventSource waits before it reconnects
+	sseRetry = 3000
+)
+
+// ChangeFeed is an interface that streams person changes of the tenant
+type ChangeFeed interface {
+	Subscribe(ctx context.Context, tenantID uuid.UUID, lastEventID string) (<-chan events.Change, func(), error)
+}
+
+// FeedHandler contains ChangeFeed interface and origins allowed to open the websocket
+type FeedHandler struct {
+	feed    ChangeFeed
+	origins map[string]bool
+}
+
+// NewFeedHandler accepts ChangeFeed interface with origins of web pages allowed to open the websocket
+// and returns an object of *FeedHandler
+func NewFeedHandler(feed ChangeFeed, allowedOrigins []string) *FeedHandler {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	return &FeedHandler{feed: feed, origins: origins}
+}
+
+// changeFilter selects event types the client asked for, no types means all of them
+type changeFilter struct {
+	types map[string]bool
+}
+
+// matches checks if the change passes the filter, a reset always passes
+func (f *changeFilter) matches(change *events.Change) bool {
+	if change.Reset {
+		return true
+	}
+	if !events.IsPersonEvent(change.Event.Type) {
+		return false
+	}
+	return len(f.types) == 0 || f.types[change.Event.Type]
+}
+
+// subscribe parses filter and resume position of the request and subscribes to the feed
+func (handl *FeedHandler) subscribe(c echo.Context) (changes <-chan events.Change, cancel func(), filter *changeFilter, err error) {
+	filter = &changeFilter{types: map[string]bool{}}
+	for _, eventType := range c.QueryParams()["type"] {
+		if eventType != events.PersonCreated && eventType != events.PersonUpdated && eventType != events.PersonDeleted {
+			return nil, nil, nil, echo.NewHTTPError(http.StatusBadRequest, "unknown event type "+eventType)
+		}
+		filter.types[eventType] = true
+	}
+	tenantID, err := identity.TenantFromContext(c.Request().Context())
+	if err != nil {
+		return nil, nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "missing tenant")
+	}
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("lastEventId")
+	}
+	changes, cancel, err = handl.feed.Subscribe(c.Request().Context(), tenantID, lastEventID)
+	if errors.Is(err, events.ErrInvalidStreamID) {
+		return nil, nil, nil, echo.NewHTTPError(http.StatusBadRequest, "invalid Last-Event-ID")
+	}
+	if err != nil {
+		logrus.WithField("TenantID", tenantID).Errorf("FeedHandler -> subscribe -> feed.Subscribe -> error: %v", err)
+		return nil, nil, nil, echo.NewHTTPError(http.StatusServiceUnavailable, "failed to subscribe to changes")
+	}
+	return changes, cancel, filter, nil
+}
+
+// Events streams person changes as Server-Sent Events
+// @Summary Stream person changes over SSE
+// @Security ApiKeyAuth
+// @Description Pushes create, update and delete notifications of persons. Every event has the ID of the redis stream,
+// @Description send it back in Last-Event-ID header to resume after a reconnect. A "reset" event means that the missed
+// @Description changes can't be replayed, the client must reload the persons and resume from the ID of the reset
+// @Tags Person
+// @Produce text/event-stream
+// @Param type query []string false "event types" collectionFormat(multi)
+// @Param Last-Event-ID header string false "stream ID to resume after"
+// @Success 200 {string} string
+// @Failure 400 {object} error
+// @Router /persons/events [get]
+func (handl *FeedHandler) Events(c echo.Context) error {
+	changes, cancel, filter, err := handl.subscribe(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	if _, err = fmt.Fprintf(resp, "retry: %d\n\n", sseRetry); err != nil {
+		return nil
+	}
+	resp.Flush()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(resp, ": ping\n\n")
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			if !filter.matches(&change) {
+				continue
+			}
+			if change.Reset {
+				_, err = fmt.Fprintf(resp, "id: %s\nevent: reset\ndata: {}\n\n", change.StreamID)
+				break
+			}
+			var eventJSON []byte
+			eventJSON, err = events.Encode(change.Event)
+			if err != nil {
+				logrus.WithField("ID", change.Event.ID).Errorf("FeedHandler -> Events -> events.Encode -> error: %v", err)
+				continue
+			}
+			_, err = fmt.Fprintf(resp, "id: %s\nevent: %s\ndata: %s\n\n", change.StreamID, change.Event.Type, eventJSON)
+		}
+		if err != nil {
+			return nil
+		}
+		resp.Flush()
+	}
+}
+
+// wsMessage is a change sent over the websocket, a reset has no event
+type wsMessage struct {
+	ID    string        `json:"id"`
+	Event *events.Event `json:"event,omitempty"`
+	Reset bool          `json:"reset,omitempty"`
+}
+
+// WebSocket streams person changes over a websocket, every message is JSON with the stream ID and the event
+// @Summary Stream person changes over WebSocket
+// @Security ApiKeyAuth
+// @Description Pushes create, update and delete notifications of persons as JSON messages {"id": streamID, "event": event}.
+// @Description Filters are the same as of SSE, pass ID of the last message in lastEventId to resume after a reconnect.
+// @Description A message {"id": streamID, "reset": true} means that the client must reload the persons and resume from its ID.
+// @Description Browsers may connect only from the configured origins
+// @Tags Person
+// @Param type query []string false "event types" collectionFormat(multi)
+// @Param lastEventId query string false "stream ID to resume after"
+// @Success 101 {string} string
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Router /persons/ws [get]
+func (handl *FeedHandler) WebSocket(c echo.Context) error {
+	changes, cancel, filter, err := handl.subscribe(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	server := websocket.Server{
+		Handshake: handl.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+			handl.pushWebSocket(c.Request().Context(), ws, changes, filter, closed)
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// checkOrigin rejects the handshake of a web page that is served neither by this host nor from an allowed origin,
+// so a page of another site can't read the feed with a token it got hold of. Clients that aren't browsers send no Origin
+func (handl *FeedHandler) checkOrigin(_ *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" || handl.origins[strings.TrimSuffix(origin, "/")] {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == req.Host {
+		return nil
+	}
+	return fmt.Errorf("origin %q is not allowed", origin)
+}
+
+// pushWebSocket sends matching changes to the websocket until the client or the feed goes away
+func (handl *FeedHandler) pushWebSocket(ctx context.Context, ws *websocket.Conn, changes <-chan events.Change, filter *changeFilter, closed <-chan struct{}) {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	var err error
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			ws.PayloadType = websocket.PingFrame
+			_, err = ws.Write(nil)
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if !filter.matches(&change) {
+				continue
+			}
+			err = websocket.JSON.Send(ws, wsMessage{ID: change.StreamID, Event: change.Event, Reset: change.Reset})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
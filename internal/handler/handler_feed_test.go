@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// fakeFeed replays the given changes and records the resume position it was asked for
+type fakeFeed struct {
+	changes     []events.Change
+	lastEventID string
+}
+
+func (f *fakeFeed) Subscribe(_ context.Context, _ uuid.UUID, lastEventID string) (<-chan events.Change, func(), error) {
+	f.lastEventID = lastEventID
+	changes := make(chan events.Change, len(f.changes))
+	for _, change := range f.changes {
+		changes <- change
+	}
+	close(changes)
+	return changes, func() {}, nil
+}
+
+func newTestChange(t *testing.T, ctx context.Context, streamID, eventType string, pers model.Person) events.Change {
+	event, err := events.New(ctx, eventType, pers.ID, pers)
+	require.NoError(t, err)
+	return events.Change{StreamID: streamID, Event: event}
+}
+
+func TestFeedEvents(t *testing.T) {
+	ctx := identity.WithTenant(context.Background(), uuid.New())
+	feed := &fakeFeed{changes: []events.Change{
+		newTestChange(t, ctx, "1-0", events.PersonCreated, model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}),
+		newTestChange(t, ctx, "2-0", events.PersonUpdated, model.Person{ID: uuid.New(), Salary: 900, Profession: "lawyer"}),
+		newTestChange(t, ctx, "3-0", events.PersonDeleted, model.Person{ID: uuid.New()}),
+	}}
+	handl := NewFeedHandler(feed, nil)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/persons/events?type=PersonCreated&type=PersonDeleted", http.NoBody).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "0-1")
+	rec := httptest.NewRecorder()
+	err := handl.Events(e.NewContext(req, rec))
+	require.NoError(t, err)
+	require.Equal(t, "0-1", feed.lastEventID)
+	require.Equal(t, "text/event-stream", rec.Header().Get(echo.HeaderContentType))
+	body := rec.Body.String()
+	require.Contains(t, body, "id: 1-0\nevent: PersonCreated\n")
+	require.NotContains(t, body, "id: 2-0", "types that weren't asked for must be skipped")
+	require.Contains(t, body, "id: 3-0\nevent: PersonDeleted\n")
+}
+
+func TestFeedEventsInvalidFilter(t *testing.T) {
+	ctx := identity.WithTenant(context.Background(), uuid.New())
+	handl := NewFeedHandler(&fakeFeed{}, nil)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/persons/events?type=PersonHired", http.NoBody).WithContext(ctx)
+	err := handl.Events(e.NewContext(req, httptest.NewRecorder()))
+	var httpErr *echo.HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+}
+
+func TestFeedWebSocket(t *testing.T) {
+	ctx := identity.WithTenant(context.Background(), uuid.New())
+	created := newTestChange(t, ctx, "1-0", events.PersonCreated, model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"})
+	feed := &fakeFeed{changes: []events.Change{
+		newTestChange(t, ctx, "2-0", events.PersonDeleted, model.Person{ID: uuid.New()}),
+		created,
+	}}
+	handl := NewFeedHandler(feed, nil)
+	e := echo.New()
+	e.GET("/persons/ws", handl.WebSocket, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(identity.WithTenant(c.Request().Context(), created.Event.TenantID)))
+			return next(c)
+		}
+	})
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/persons/ws?type=PersonCreated&lastEventId=0-5"
+	ws, err := websocket.Dial(wsURL, "", server.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+	var raw []byte
+	require.NoError(t, websocket.Message.Receive(ws, &raw))
+	var msg struct {
+		ID    string          `json:"id"`
+		Event json.RawMessage `json:"event"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &msg))
+	require.Equal(t, "1-0", msg.ID)
+	event, err := events.Decode(msg.Event)
+	require.NoError(t, err)
+	require.Equal(t, created.Event.ID, event.ID)
+	require.Equal(t, "0-5", feed.lastEventID)
+}
+
+func TestFeedEventsReset(t *testing.T) {
+	ctx := identity.WithTenant(context.Background(), uuid.New())
+	feed := &fakeFeed{changes: []events.Change{
+		{StreamID: "9-0", Reset: true},
+		newTestChange(t, ctx, "10-0", events.PersonCreated, model.Person{ID: uuid.New(), Salary: 500}),
+	}}
+	handl := NewFeedHandler(feed, nil)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/persons/events?type=PersonDeleted", http.NoBody).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "0-1")
+	rec := httptest.NewRecorder()
+	err := handl.Events(e.NewContext(req, rec))
+	require.NoError(t, err)
+	body := rec.Body.String()
+	require.Contains(t, body, "id: 9-0\nevent: reset\n", "reset must pass any filter")
+	require.NotContains(t, body, "id: 10-0")
+}
+
+func TestFeedWebSocketForbiddenOrigin(t *testing.T) {
+	tenantID := uuid.New()
+	handl := NewFeedHandler(&fakeFeed{}, []string{"https://app.example.com"})
+	e := echo.New()
+	e.GET("/persons/ws", handl.WebSocket, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(identity.WithTenant(c.Request().Context(), tenantID)))
+			return next(c)
+		}
+	})
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/persons/ws"
+	_, err := websocket.Dial(wsURL, "", "https://evil.example.com")
+	require.Error(t, err)
+	ws, err := websocket.Dial(wsURL, "", "https://app.example.com")
+	require.NoError(t, err)
+	require.NoError(t, ws.Close())
+}
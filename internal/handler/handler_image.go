@@ -0,0 +1,429 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// multipartOverhead is how many bytes of the request body beside the image itself are allowed for multipart headers and boundaries
+const multipartOverhead = 64 << 10
+
+// Bounds of the limit query param of the image list
+const (
+	defaultImagesLimit = 50
+	maxImagesLimit     = 500
+)
+
+// ImageService is an interface that contains methods of service for images
+type ImageService interface {
+	Upload(ctx context.Context, filename string, src io.Reader) (*model.Image, error)
+	Open(ctx context.Context, id uuid.UUID, variant model.ImageVariant) (*model.ImageFile, error)
+	Meta(ctx context.Context, id uuid.UUID) (*model.Image, error)
+	List(ctx context.Context, limit, offset int) ([]model.Image, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Usage(ctx context.Context) (*model.ImageUsage, error)
+	UsageReport(ctx context.Context) (*model.ImageUsageReport, error)
+}
+
+// ImageURLService is an interface that contains methods of service for signed download URLs of images
+type ImageURLService interface {
+	Sign(ctx context.Context, id uuid.UUID, ttl time.Duration, disposition string) (*model.SignedURL, error)
+	Verify(tenantID, id uuid.UUID, expires int64, disposition, signature string) error
+}
+
+// imageExtensions are file extensions of allowed image types, they name downloaded files
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// ImageHandler contains ImageService and ImageURLService interfaces and the size limit of uploaded images
+type ImageHandler struct {
+	srvcImage ImageService
+	srvcURL   ImageURLService
+	maxBytes  int64
+}
+
+// NewImageHandler accepts ImageService and ImageURLService interfaces and the maximum size of an uploaded image
+// and returns an object of *ImageHandler
+func NewImageHandler(srvcImage ImageService, srvcURL ImageURLService, maxBytes int64) *ImageHandler {
+	return &ImageHandler{srvcImage: srvcImage, srvcURL: srvcURL, maxBytes: maxBytes}
+}
+
+// Upload uploads image to server
+// @Summary Upload an image
+// @Security ApiKeyAuth
+// @Description Uploads a PNG, JPEG or GIF image, the type is detected from the content. Returns metadata of the stored image
+// @Tags Images
+// @Accept multipart/form-data
+// @Produce json
+// @Param image formData file true "Image file"
+// @Success 201 {object} model.Image
+// @Failure 400 {object} error
+// @Failure 413 {object} error
+// @Failure 415 {object} error
+// @Failure 422 {object} error
+// @Failure 507 {object} error
+// @Router /images [post]
+func (handl *ImageHandler) Upload(c echo.Context) error {
+	file, err := openFormImage(c, handl.maxBytes)
+	if err != nil {
+		logrus.Errorf("ImageHandler -> Upload -> openFormImage -> error: %v", err)
+		return err
+	}
+	defer func() {
+		if err = file.Close(); err != nil {
+			logrus.Errorf("ImageHandler -> Upload -> file.Close -> error: %v", err)
+		}
+	}()
+	img, err := handl.srvcImage.Upload(c.Request().Context(), file.filename, file)
+	if err != nil {
+		logrus.Errorf("ImageHandler -> Upload -> srvcImage.Upload -> error: %v", err)
+		return imageError(err)
+	}
+	return c.JSON(http.StatusCreated, img)
+}
+
+// formImage is the image file of a multipart form
+type formImage struct {
+	multipart.File
+	filename string
+}
+
+// openFormImage opens the image form file of the request, the body is limited to maxBytes of the image and
+// multipartOverhead. The returned error is an HTTP error that can be returned to the client
+func openFormImage(c echo.Context, maxBytes int64) (*formImage, error) {
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxBytes+multipartOverhead)
+	header, err := c.FormFile("image")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "image is too large").SetInternal(err)
+		}
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "image form file is missing").SetInternal(err)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "failed to read image").SetInternal(err)
+	}
+	return &formImage{File: file, filename: header.Filename}, nil
+}
+
+// Download downloads image or its resized variant from server
+// @Summary Download an image
+// @Security ApiKeyAuth
+// @Description Downloads the image stored under the ID or its variant resized to width and height. Variants are generated
+// @Description on the first request and stored. Supports conditional requests with ETag or Last-Modified and Range requests.
+// @Description Requires access token of the owner of the image or a signed URL, see POST /images/{id}/url
+// @Tags Images
+// @Produce image/png,image/jpeg,image/gif
+// @Param id path string true "Image ID"
+// @Param tenant query string false "tenant of the signed URL"
+// @Param expires query int false "expiry of the signed URL"
+// @Param disposition query string false "inline or attachment, signed with the URL"
+// @Param signature query string false "signature of the URL"
+// @Param width query int false "width of the variant, one of the allowed sizes"
+// @Param height query int false "height of the variant, one of the allowed sizes"
+// @Param fit query string false "contain (default), cover or fill"
+// @Param format query string false "png or jpeg, the format of the original by default"
+// @Success 200 {file} binary "Image file"
+// @Success 206 {file} binary "Requested range of the image file"
+// @Success 304 "Image isn't modified"
+// @Failure 400 {object} error
+// @Failure 401 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Failure 416 {object} error
+// @Router /images/{id} [get]
+func (handl *ImageHandler) Download(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.WithField("ID", c.Param("id")).Errorf("ImageHandler -> Download -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusNotFound, "image not found")
+	}
+	if err = handl.authorizeDownload(c, id); err != nil {
+		logrus.WithField("ID", id).Errorf("ImageHandler -> Download -> authorizeDownload -> error: %v", err)
+		return err
+	}
+	variant, err := bindImageVariant(c)
+	if err != nil {
+		logrus.Errorf("ImageHandler -> Download -> bindImageVariant -> error: %v", err)
+		return err
+	}
+	img, err := handl.srvcImage.Open(c.Request().Context(), id, variant)
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("ImageHandler -> Download -> srvcImage.Open -> error: %v", err)
+		return imageError(err)
+	}
+	serveImage(c, img, id.String(), c.QueryParam("disposition"))
+	return nil
+}
+
+// authorizeDownload lets the download through if the URL is signed for the image, or if the request has an access token,
+// checked by the middleware, of the user that owns the image, like Meta. Only a valid signature skips the ownership
+// check, a signed URL puts its tenant into request context. The returned error is an HTTP error
+func (handl *ImageHandler) authorizeDownload(c echo.Context, id uuid.UUID) error {
+	signature := c.QueryParam("signature")
+	if signature == "" {
+		if _, ok := identity.UserFromContext(c.Request().Context()); !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "access token or signed URL is required")
+		}
+		if _, err := handl.srvcImage.Meta(c.Request().Context(), id); err != nil {
+			return imageError(err)
+		}
+		return nil
+	}
+	tenantID, err := uuid.Parse(c.QueryParam("tenant"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "signed URL is invalid or expired").SetInternal(err)
+	}
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "signed URL is invalid or expired").SetInternal(err)
+	}
+	if err = handl.srvcURL.Verify(tenantID, id, expires, c.QueryParam("disposition"), signature); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "signed URL is invalid or expired").SetInternal(err)
+	}
+	c.SetRequest(c.Request().WithContext(identity.WithTenant(c.Request().Context(), tenantID)))
+	return nil
+}
+
+// SignURL returns a signed URL of the image
+// @Summary Get a signed URL of an image
+// @Security ApiKeyAuth
+// @Description Returns a URL that downloads the image of the user without access token until it expires, so it can be
+// @Description embedded in an <img> tag. Variant params may be added to the URL
+// @Tags Images
+// @Produce json
+// @Param id path string true "Image ID"
+// @Param ttl query string false "how long the URL is valid, e.g. 10m, 15m by default"
+// @Param disposition query string false "inline or attachment (default)"
+// @Success 200 {object} model.SignedURL
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Router /images/{id}/url [post]
+func (handl *ImageHandler) SignURL(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("ImageHandler -> SignURL -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	var ttl time.Duration
+	if param := c.QueryParam("ttl"); param != "" {
+		if ttl, err = time.ParseDuration(param); err != nil {
+			logrus.Errorf("ImageHandler -> SignURL -> time.ParseDuration -> error: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "ttl must be a duration like 10m")
+		}
+	}
+	signed, err := handl.srvcURL.Sign(c.Request().Context(), id, ttl, c.QueryParam("disposition"))
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("ImageHandler -> SignURL -> srvcURL.Sign -> error: %v", err)
+		if errors.Is(err, service.ErrImageURLOptions) {
+			return echo.NewHTTPError(http.StatusBadRequest, "ttl must be positive and within the limit, disposition must be inline or attachment")
+		}
+		return imageError(err)
+	}
+	return c.JSON(http.StatusOK, signed)
+}
+
+// bindImageVariant reads the variant of the downloaded image from query params, the returned error is an HTTP error
+func bindImageVariant(c echo.Context) (model.ImageVariant, error) {
+	variant := model.ImageVariant{Fit: c.QueryParam("fit"), Format: c.QueryParam("format")}
+	for param, size := range map[string]*int{"width": &variant.Width, "height": &variant.Height} {
+		if value := c.QueryParam(param); value != "" {
+			var err error
+			if *size, err = strconv.Atoi(value); err != nil {
+				return variant, echo.NewHTTPError(http.StatusBadRequest, param+" must be a number").SetInternal(err)
+			}
+		}
+	}
+	return variant, nil
+}
+
+// serveImage writes the opened image named name and closes it, the image is an attachment unless the disposition is inline.
+// Conditional and Range requests are answered by http.ServeContent
+func serveImage(c echo.Context, img *model.ImageFile, name, disposition string) {
+	if disposition != "inline" {
+		disposition = "attachment"
+	}
+	defer func() {
+		if err := img.Content.Close(); err != nil {
+			logrus.Errorf("serveImage -> img.Content.Close -> error: %v", err)
+		}
+	}()
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, img.ContentType)
+	header.Set("ETag", img.ETag)
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType(disposition,
+		map[string]string{"filename": name + imageExtensions[img.ContentType]}))
+	http.ServeContent(c.Response(), c.Request(), "", img.ModTime, img.Content)
+}
+
+// List returns images uploaded by the user
+// @Summary Get images of the user
+// @Security ApiKeyAuth
+// @Description Returns a page of metadata of images uploaded by the user, newest first
+// @Tags Images
+// @Produce json
+// @Param limit query int false "maximum number of images, 50 by default"
+// @Param offset query int false "number of images to skip"
+// @Success 200 {array} model.Image
+// @Failure 400 {object} error
+// @Router /images [get]
+func (handl *ImageHandler) List(c echo.Context) error {
+	limit := defaultImagesLimit
+	var err error
+	if param := c.QueryParam("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit <= 0 || limit > maxImagesLimit {
+			logrus.Errorf("ImageHandler -> List -> strconv.Atoi -> error: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 500")
+		}
+	}
+	offset := 0
+	if param := c.QueryParam("offset"); param != "" {
+		offset, err = strconv.Atoi(param)
+		if err != nil || offset < 0 {
+			logrus.Errorf("ImageHandler -> List -> strconv.Atoi -> error: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "offset must be a non-negative number")
+		}
+	}
+	images, err := handl.srvcImage.List(c.Request().Context(), limit, offset)
+	if err != nil {
+		logrus.Errorf("ImageHandler -> List -> srvcImage.List -> error: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get images")
+	}
+	return c.JSON(http.StatusOK, images)
+}
+
+// Meta returns metadata of the image
+// @Summary Get metadata of an image
+// @Security ApiKeyAuth
+// @Description Returns metadata of the image, only the user who uploaded it can read it
+// @Tags Images
+// @Produce json
+// @Param id path string true "Image ID"
+// @Success 200 {object} model.Image
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Router /images/{id}/meta [get]
+func (handl *ImageHandler) Meta(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("ImageHandler -> Meta -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	img, err := handl.srvcImage.Meta(c.Request().Context(), id)
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("ImageHandler -> Meta -> srvcImage.Meta -> error: %v", err)
+		return imageError(err)
+	}
+	return c.JSON(http.StatusOK, img)
+}
+
+// Delete deletes the image
+// @Summary Delete an image by ID
+// @Security ApiKeyAuth
+// @Description Deletes the image with its metadata, only the user who uploaded it can delete it
+// @Tags Images
+// @Produce json
+// @Param id path string true "Image ID"
+// @Success 200 {string} string
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Router /images/{id} [delete]
+func (handl *ImageHandler) Delete(c echo.Context) error {
+	id := c.Param("id")
+	uuidID, err := uuid.Parse(id)
+	if err != nil {
+		logrus.Errorf("ImageHandler -> Delete -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	if err = handl.srvcImage.Delete(c.Request().Context(), uuidID); err != nil {
+		logrus.WithField("ID", uuidID).Errorf("ImageHandler -> Delete -> srvcImage.Delete -> error: %v", err)
+		return imageError(err)
+	}
+	return c.JSON(http.StatusOK, "Deleted: "+id)
+}
+
+// Usage returns storage used by images of the user
+// @Summary Get storage usage of the user
+// @Security ApiKeyAuth
+// @Description Returns the number and the size of images of the user and the quota of the user, 0 means no quota
+// @Tags Images
+// @Produce json
+// @Success 200 {object} model.ImageUsage
+// @Failure 500 {object} error
+// @Router /images/usage [get]
+func (handl *ImageHandler) Usage(c echo.Context) error {
+	usage, err := handl.srvcImage.Usage(c.Request().Context())
+	if err != nil {
+		logrus.Errorf("ImageHandler -> Usage -> srvcImage.Usage -> error: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get storage usage")
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+// UsageReport returns storage used by images of every user of the tenant
+// @Summary Get storage usage report of the tenant
+// @Description Returns storage used by images of every user of the tenant from X-Tenant-ID header, the largest first,
+// @Description the size of all images and the size actually stored after duplicates are shared. Requires X-Admin-Key header
+// @Tags Admin
+// @Produce json
+// @Param X-Admin-Key header string true "Admin key"
+// @Param X-Tenant-ID header string true "Tenant ID"
+// @Success 200 {object} model.ImageUsageReport
+// @Failure 400 {object} error
+// @Failure 401 {object} error
+// @Router /admin/images/usage [get]
+func (handl *ImageHandler) UsageReport(c echo.Context) error {
+	report, err := handl.srvcImage.UsageReport(c.Request().Context())
+	if err != nil {
+		logrus.Errorf("ImageHandler -> UsageReport -> srvcImage.UsageReport -> error: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get storage usage report")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// imageError returns HTTP error that tells the client why the image was rejected or can't be accessed
+func imageError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrImageTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "image is too large")
+	case errors.Is(err, service.ErrImageType):
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "only PNG, JPEG and GIF images are allowed")
+	case errors.Is(err, service.ErrImageQuota):
+		return echo.NewHTTPError(http.StatusInsufficientStorage, "storage quota of the user is exceeded, see GET /images/usage")
+	case errors.Is(err, service.ErrImageDimensions):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "image dimensions exceed the limit")
+	case errors.Is(err, service.ErrInvalidImage):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "image can't be decoded")
+	case errors.Is(err, service.ErrImageVariant):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrImageNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "image not found")
+	case errors.Is(err, service.ErrImageForbidden):
+		return echo.NewHTTPError(http.StatusForbidden, "image belongs to another user")
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to process image")
+	}
+}
@@ -0,0 +1,415 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/handler/mocks"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newUploadRequest returns multipart request with the content as the image form file
+func newUploadRequest(t *testing.T, field string, content []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(field, "../../etc/passwd.png")
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, "/images", &body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return req
+}
+
+func TestImageUpload(t *testing.T) {
+	img := &model.Image{
+		ID:          uuid.New(),
+		OwnerID:     uuid.New(),
+		Filename:    "passwd.png",
+		ContentType: "image/png",
+		Size:        5,
+		Width:       1,
+		Height:      1,
+		SHA256:      "abc",
+		Origin:      model.ImageOriginUpload,
+		CreatedAt:   time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC),
+	}
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("Upload", mock.Anything, "passwd.png", mock.Anything).Return(img, nil).Once()
+	handl := NewImageHandler(srvcImage, nil, 1024)
+
+	rec := httptest.NewRecorder()
+	err := handl.Upload(echo.New().NewContext(newUploadRequest(t, "image", []byte("image")), rec))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.JSONEq(t, fmt.Sprintf(`{"id":%q,"ownerId":%q,"filename":"passwd.png","contentType":"image/png","size":5,"width":1,`+
+		`"height":1,"sha256":"abc","origin":"upload","createdAt":"2023-07-01T12:00:00Z"}`, img.ID, img.OwnerID), rec.Body.String())
+}
+
+func TestImageUploadMissingFile(t *testing.T) {
+	handl := NewImageHandler(mocks.NewImageService(t), nil, 1024)
+	err := handl.Upload(echo.New().NewContext(newUploadRequest(t, "file", []byte("image")), httptest.NewRecorder()))
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+}
+
+func TestImageUploadBodyTooLarge(t *testing.T) {
+	handl := NewImageHandler(mocks.NewImageService(t), nil, 1024)
+	req := newUploadRequest(t, "image", make([]byte, 1024+multipartOverhead))
+	err := handl.Upload(echo.New().NewContext(req, httptest.NewRecorder()))
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	require.Equal(t, http.StatusRequestEntityTooLarge, httpErr.Code)
+}
+
+func TestImageUploadRejected(t *testing.T) {
+	tests := map[error]int{
+		service.ErrImageTooLarge:   http.StatusRequestEntityTooLarge,
+		service.ErrImageType:       http.StatusUnsupportedMediaType,
+		service.ErrImageQuota:      http.StatusInsufficientStorage,
+		service.ErrImageDimensions: http.StatusUnprocessableEntity,
+		service.ErrInvalidImage:    http.StatusUnprocessableEntity,
+		errors.New("disk is full"): http.StatusInternalServerError,
+	}
+	for serviceErr, code := range tests {
+		srvcImage := mocks.NewImageService(t)
+		srvcImage.On("Upload", mock.Anything, "passwd.png", mock.Anything).Return(nil, fmt.Errorf("ImageService -> Upload -> error: %w", serviceErr)).Once()
+		handl := NewImageHandler(srvcImage, nil, 1024)
+		err := handl.Upload(echo.New().NewContext(newUploadRequest(t, "image", []byte("image")), httptest.NewRecorder()))
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(err, &httpErr))
+		require.Equal(t, code, httpErr.Code, serviceErr.Error())
+	}
+}
+
+// imageContent is content of a stored image that counts how many times it was closed
+type imageContent struct {
+	*bytes.Reader
+	closed int
+}
+
+func (c *imageContent) Close() error {
+	c.closed++
+	return nil
+}
+
+// newImageFile returns the stored image the mock opens
+func newImageFile(id uuid.UUID, content []byte) (*model.ImageFile, *imageContent) {
+	reader := &imageContent{Reader: bytes.NewReader(content)}
+	return &model.ImageFile{
+		Content:     reader,
+		ContentType: "image/png",
+		Size:        int64(len(content)),
+		ModTime:     time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC),
+		ETag:        fmt.Sprintf("%q", id),
+	}, reader
+}
+
+// download sends the download request of the signed in owner of the image with the headers and returns the response
+func download(t *testing.T, srvcImage *mocks.ImageService, id uuid.UUID, header http.Header) *httptest.ResponseRecorder {
+	srvcImage.On("Meta", mock.Anything, id).Return(&model.Image{ID: id}, nil).Once()
+	c, rec := newImageContext(http.MethodGet, "/images/"+id.String(), id.String())
+	for key := range header {
+		c.Request().Header.Set(key, header.Get(key))
+	}
+	require.NoError(t, NewImageHandler(srvcImage, nil, 1024).Download(c))
+	return rec
+}
+
+func TestImageDownload(t *testing.T) {
+	id := uuid.New()
+	file, content := newImageFile(id, []byte("\x89PNG image"))
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("Open", mock.Anything, id, model.ImageVariant{}).Return(file, nil).Once()
+
+	rec := download(t, srvcImage, id, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "\x89PNG image", rec.Body.String())
+	require.Equal(t, "image/png", rec.Header().Get(echo.HeaderContentType))
+	require.Equal(t, file.ETag, rec.Header().Get("ETag"))
+	require.Equal(t, "Sat, 01 Jul 2023 12:00:00 GMT", rec.Header().Get(echo.HeaderLastModified))
+	require.Equal(t, "nosniff", rec.Header().Get(echo.HeaderXContentTypeOptions))
+	require.Equal(t, `attachment; filename=`+id.String()+`.png`, rec.Header().Get(echo.HeaderContentDisposition))
+	require.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	require.Equal(t, 1, content.closed)
+}
+
+func TestImageDownloadConditional(t *testing.T) {
+	id := uuid.New()
+	tests := map[string]http.Header{
+		"ifNoneMatch":     {"If-None-Match": []string{fmt.Sprintf("%q", id)}},
+		"ifModifiedSince": {"If-Modified-Since": []string{"Sat, 01 Jul 2023 12:00:00 GMT"}},
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			file, _ := newImageFile(id, []byte("\x89PNG image"))
+			srvcImage := mocks.NewImageService(t)
+			srvcImage.On("Open", mock.Anything, id, model.ImageVariant{}).Return(file, nil).Once()
+			rec := download(t, srvcImage, id, header)
+			require.Equal(t, http.StatusNotModified, rec.Code)
+			require.Empty(t, rec.Body.String())
+		})
+	}
+}
+
+func TestImageDownloadRange(t *testing.T) {
+	id := uuid.New()
+	file, _ := newImageFile(id, []byte("\x89PNG image"))
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("Open", mock.Anything, id, model.ImageVariant{}).Return(file, nil).Once()
+	rec := download(t, srvcImage, id, http.Header{"Range": []string{"bytes=5-"}})
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, "image", rec.Body.String())
+	require.Equal(t, "bytes 5-9/10", rec.Header().Get("Content-Range"))
+
+	file, _ = newImageFile(id, []byte("\x89PNG image"))
+	srvcImage.On("Open", mock.Anything, id, model.ImageVariant{}).Return(file, nil).Once()
+	rec = download(t, srvcImage, id, http.Header{"Range": []string{"bytes=100-"}})
+	require.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+}
+
+func TestImageDownloadNotFound(t *testing.T) {
+	srvcImage := mocks.NewImageService(t)
+	for _, name := range []string{"../../etc/passwd", "..%2F..%2Fetc%2Fpasswd", "andy.png"} {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/images/x", http.NoBody), httptest.NewRecorder())
+		c.SetParamNames("id")
+		c.SetParamValues(name)
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(NewImageHandler(srvcImage, nil, 1024).Download(c), &httpErr))
+		require.Equal(t, http.StatusNotFound, httpErr.Code)
+	}
+
+	id := uuid.New()
+	srvcImage.On("Meta", mock.Anything, id).Return(&model.Image{ID: id}, nil).Once()
+	srvcImage.On("Open", mock.Anything, id, model.ImageVariant{}).Return(nil, fmt.Errorf("ImageService -> Open -> error: %w", service.ErrImageNotFound)).Once()
+	c, _ := newImageContext(http.MethodGet, "/images/x", id.String())
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(NewImageHandler(srvcImage, nil, 1024).Download(c), &httpErr))
+	require.Equal(t, http.StatusNotFound, httpErr.Code)
+}
+
+func TestImageDownloadOtherUser(t *testing.T) {
+	tests := map[error]int{
+		service.ErrImageForbidden: http.StatusForbidden,
+		service.ErrImageNotFound:  http.StatusNotFound,
+	}
+	for serviceErr, code := range tests {
+		id := uuid.New()
+		srvcImage := mocks.NewImageService(t)
+		srvcImage.On("Meta", mock.Anything, id).Return(nil, fmt.Errorf("ImageService -> Meta -> error: %w", serviceErr)).Once()
+		c, _ := newImageContext(http.MethodGet, "/images/x", id.String())
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(NewImageHandler(srvcImage, nil, 1024).Download(c), &httpErr), "an access token doesn't reach images of other users")
+		require.Equal(t, code, httpErr.Code)
+	}
+}
+
+// newImageContext returns context of the request of a signed in user to the image with the id param
+func newImageContext(method, target, id string) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, http.NoBody)
+	c := echo.New().NewContext(req.WithContext(identity.WithUser(req.Context(), uuid.New())), rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return c, rec
+}
+
+func TestImageList(t *testing.T) {
+	images := []model.Image{{ID: uuid.New()}, {ID: uuid.New()}}
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("List", mock.Anything, defaultImagesLimit, 0).Return(images, nil).Once()
+	srvcImage.On("List", mock.Anything, 2, 4).Return(images[:1], nil).Once()
+	handl := NewImageHandler(srvcImage, nil, 1024)
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, handl.List(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/images", http.NoBody), rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), images[1].ID.String())
+
+	rec = httptest.NewRecorder()
+	require.NoError(t, handl.List(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/images?limit=2&offset=4", http.NoBody), rec)))
+	require.NotContains(t, rec.Body.String(), images[1].ID.String())
+
+	for _, query := range []string{"limit=0", "limit=501", "limit=x", "offset=-1"} {
+		err := handl.List(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/images?"+query, http.NoBody), httptest.NewRecorder()))
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(err, &httpErr), query)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code, query)
+	}
+}
+
+func TestImageMeta(t *testing.T) {
+	img := &model.Image{ID: uuid.New(), Filename: "andy.png"}
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("Meta", mock.Anything, img.ID).Return(img, nil).Once()
+	c, rec := newImageContext(http.MethodGet, "/images/x/meta", img.ID.String())
+	require.NoError(t, NewImageHandler(srvcImage, nil, 1024).Meta(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"filename":"andy.png"`)
+}
+
+func TestImageAccessDenied(t *testing.T) {
+	tests := map[error]int{
+		service.ErrImageNotFound:  http.StatusNotFound,
+		service.ErrImageForbidden: http.StatusForbidden,
+	}
+	for serviceErr, code := range tests {
+		id := uuid.New()
+		srvcImage := mocks.NewImageService(t)
+		srvcImage.On("Meta", mock.Anything, id).Return(nil, fmt.Errorf("ImageService -> Meta -> error: %w", serviceErr)).Once()
+		srvcImage.On("Delete", mock.Anything, id).Return(fmt.Errorf("ImageService -> Delete -> error: %w", serviceErr)).Once()
+		handl := NewImageHandler(srvcImage, nil, 1024)
+
+		c, _ := newImageContext(http.MethodGet, "/images/x/meta", id.String())
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(handl.Meta(c), &httpErr))
+		require.Equal(t, code, httpErr.Code)
+		c, _ = newImageContext(http.MethodDelete, "/images/x", id.String())
+		require.True(t, errors.As(handl.Delete(c), &httpErr))
+		require.Equal(t, code, httpErr.Code)
+	}
+}
+
+func TestImageDelete(t *testing.T) {
+	id := uuid.New()
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("Delete", mock.Anything, id).Return(nil).Once()
+	c, rec := newImageContext(http.MethodDelete, "/images/x", id.String())
+	require.NoError(t, NewImageHandler(srvcImage, nil, 1024).Delete(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	c, _ = newImageContext(http.MethodDelete, "/images/x", "andy.png")
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(NewImageHandler(srvcImage, nil, 1024).Delete(c), &httpErr))
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+}
+
+func TestImageDownloadVariant(t *testing.T) {
+	id := uuid.New()
+	file, _ := newImageFile(id, []byte("\x89PNG thumbnail"))
+	variant := model.ImageVariant{Width: 64, Height: 32, Fit: "cover", Format: "png"}
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("Meta", mock.Anything, id).Return(&model.Image{ID: id}, nil).Times(3)
+	srvcImage.On("Open", mock.Anything, id, variant).Return(file, nil).Once()
+	srvcImage.On("Open", mock.Anything, id, model.ImageVariant{Width: 64, Format: "webp"}).
+		Return(nil, fmt.Errorf("ImageService -> Open -> error: %w", service.ErrImageVariant)).Once()
+	handl := NewImageHandler(srvcImage, nil, 1024)
+
+	c, rec := newImageContext(http.MethodGet, "/images/x?width=64&height=32&fit=cover&format=png", id.String())
+	require.NoError(t, handl.Download(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "\x89PNG thumbnail", rec.Body.String())
+
+	for _, query := range []string{"width=64&format=webp", "width=big"} {
+		c, _ = newImageContext(http.MethodGet, "/images/x?"+query, id.String())
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(handl.Download(c), &httpErr), query)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code, query)
+	}
+}
+
+func TestImageUsage(t *testing.T) {
+	usage := &model.ImageUsage{OwnerID: uuid.New(), Images: 2, Bytes: 300, QuotaBytes: 1000}
+	report := &model.ImageUsageReport{QuotaBytes: 1000, Bytes: 300, StoredBytes: 150, Users: []model.ImageUsage{*usage}}
+	srvcImage := mocks.NewImageService(t)
+	srvcImage.On("Usage", mock.Anything).Return(usage, nil).Once()
+	srvcImage.On("UsageReport", mock.Anything).Return(report, nil).Once()
+	handl := NewImageHandler(srvcImage, nil, 1024)
+
+	c, rec := newImageContext(http.MethodGet, "/images/usage", "")
+	require.NoError(t, handl.Usage(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"quotaBytes":1000`)
+
+	c, rec = newImageContext(http.MethodGet, "/admin/images/usage", "")
+	require.NoError(t, handl.UsageReport(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"storedBytes":150`)
+	require.Contains(t, rec.Body.String(), usage.OwnerID.String())
+}
+
+func TestImageDownloadSigned(t *testing.T) {
+	id := uuid.New()
+	file, _ := newImageFile(id, []byte("\x89PNG image"))
+	srvcImage := mocks.NewImageService(t)
+	tenantID := uuid.New()
+	srvcImage.On("Open", mock.MatchedBy(func(ctx context.Context) bool {
+		tenant, err := identity.TenantFromContext(ctx)
+		return err == nil && tenant == tenantID
+	}), id, model.ImageVariant{Width: 64}).Return(file, nil).Once()
+	srvcURL := mocks.NewImageURLService(t)
+	srvcURL.On("Verify", tenantID, id, int64(1700000000), "inline", "good").Return(nil).Once()
+	srvcURL.On("Verify", tenantID, id, int64(1700000000), "", "forged").
+		Return(fmt.Errorf("ImageURLService -> Verify -> error: %w", service.ErrImageURLInvalid)).Once()
+	handl := NewImageHandler(srvcImage, srvcURL, 1024)
+
+	// newAnonymousContext returns context of the request without access token
+	newAnonymousContext := func(target string) (echo.Context, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, target, http.NoBody), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id.String())
+		return c, rec
+	}
+	c, rec := newAnonymousContext("/images/x?width=64&tenant=" + tenantID.String() + "&expires=1700000000&disposition=inline&signature=good")
+	require.NoError(t, handl.Download(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `inline; filename=`+id.String()+`.png`, rec.Header().Get(echo.HeaderContentDisposition))
+
+	tests := map[string]int{
+		"/images/x": http.StatusUnauthorized,
+		"/images/x?tenant=" + tenantID.String() + "&expires=1700000000&signature=forged": http.StatusForbidden,
+		"/images/x?tenant=" + tenantID.String() + "&expires=soon&signature=good":         http.StatusForbidden,
+		"/images/x?expires=1700000000&signature=good":                                    http.StatusForbidden,
+	}
+	for target, code := range tests {
+		c, _ = newAnonymousContext(target)
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(handl.Download(c), &httpErr), target)
+		require.Equal(t, code, httpErr.Code, target)
+	}
+}
+
+func TestImageSignURL(t *testing.T) {
+	id := uuid.New()
+	signed := &model.SignedURL{URL: "/images/" + id.String() + "?expires=1700000000&signature=good", ExpiresAt: time.Unix(1700000000, 0)}
+	srvcURL := mocks.NewImageURLService(t)
+	srvcURL.On("Sign", mock.Anything, id, 10*time.Minute, "inline").Return(signed, nil).Once()
+	srvcURL.On("Sign", mock.Anything, id, time.Duration(0), "form-data").
+		Return(nil, fmt.Errorf("ImageURLService -> Sign -> error: %w", service.ErrImageURLOptions)).Once()
+	srvcURL.On("Sign", mock.Anything, id, time.Duration(0), "").
+		Return(nil, fmt.Errorf("ImageURLService -> Sign -> error: %w", service.ErrImageForbidden)).Once()
+	handl := NewImageHandler(mocks.NewImageService(t), srvcURL, 1024)
+
+	c, rec := newImageContext(http.MethodPost, "/images/x/url?ttl=10m&disposition=inline", id.String())
+	require.NoError(t, handl.SignURL(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "signature=good")
+
+	tests := map[string]int{
+		"/images/x/url?ttl=soon":              http.StatusBadRequest,
+		"/images/x/url?disposition=form-data": http.StatusBadRequest,
+		"/images/x/url":                       http.StatusForbidden,
+	}
+	for target, code := range tests {
+		c, _ = newImageContext(http.MethodPost, target, id.String())
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(handl.SignURL(c), &httpErr), target)
+		require.Equal(t, code, httpErr.Code, target)
+	}
+}
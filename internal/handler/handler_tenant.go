@@ -0,0 +1,95 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// TenantService is an interface that contains methods of service for tenants
+type TenantService interface {
+	Create(ctx context.Context, tenant *model.Tenant) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// TenantHandler contains TenantService interface
+type TenantHandler struct {
+	srvcTenant TenantService
+	validate   *validator.Validate
+}
+
+// NewTenantHandler accepts TenantService interface and returns an object of *TenantHandler
+func NewTenantHandler(srvcTenant TenantService, validate *validator.Validate) *TenantHandler {
+	return &TenantHandler{srvcTenant: srvcTenant, validate: validate}
+}
+
+// Create provisions a new tenant
+// @Summary Create a new tenant
+// @Description Provisions a new tenant, requires X-Admin-Key header
+// @Tags Tenant
+// @Accept json
+// @Produce json
+// @Param tenant body model.Tenant true "tenant value (model.Tenant)"
+// @Success 201 {object} model.Tenant
+// @Failure 400 {object} error
+// @Router /tenants [post]
+func (handl *TenantHandler) Create(c echo.Context) error {
+	var createdTenant model.Tenant
+	err := c.Bind(&createdTenant)
+	if err != nil {
+		logrus.Errorf("TenantHandler -> Create -> c.Bind -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to bind")
+	}
+	createdTenant.ID = uuid.New()
+	err = handl.validate.StructCtx(c.Request().Context(), createdTenant)
+	if err != nil {
+		logrus.Errorf("TenantHandler -> Create -> validate -> StructCtx -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to validate")
+	}
+	err = handl.srvcTenant.Create(c.Request().Context(), &createdTenant)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"ID":   createdTenant.ID,
+			"Name": createdTenant.Name,
+		}).Errorf("TenantHandler -> Create -> srvcTenant.Create -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to create tenant")
+	}
+	return c.JSON(http.StatusCreated, createdTenant)
+}
+
+// Delete deletes tenant with all its persons, users and cache
+// @Summary Delete a tenant by ID
+// @Description Deletes a tenant with all its data, requires X-Admin-Key header
+// @Tags Tenant
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {string} string
+// @Failure 400 {object} error
+// @Failure 404 {object} error
+// @Router /tenants/{id} [delete]
+func (handl *TenantHandler) Delete(c echo.Context) error {
+	id := c.Param("id")
+	uuidID, err := uuid.Parse(id)
+	if err != nil {
+		logrus.Errorf("TenantHandler -> Delete -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	err = handl.srvcTenant.Delete(c.Request().Context(), uuidID)
+	if err != nil {
+		logrus.WithField("ID", uuidID).Errorf("TenantHandler -> Delete -> srvcTenant.Delete -> error: %v", err)
+		if errors.Is(err, service.ErrTenantNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "tenant not found")
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to delete tenant")
+	}
+	return c.JSON(http.StatusOK, "Deleted: "+id)
+}
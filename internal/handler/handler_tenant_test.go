@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/handler/mocks"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantCreate(t *testing.T) {
+	srvcTenant := mocks.NewTenantService(t)
+	srvcTenant.On("Create", mock.Anything, mock.AnythingOfType("*model.Tenant")).Return(nil).Once()
+	handl := NewTenantHandler(srvcTenant, validator.New())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/tenants", strings.NewReader(`{"name":"acme"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	err := handl.Create(e.NewContext(req, rec))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Contains(t, rec.Body.String(), `"name":"acme"`)
+}
+
+func TestTenantCreateInvalidName(t *testing.T) {
+	srvcTenant := mocks.NewTenantService(t)
+	handl := NewTenantHandler(srvcTenant, validator.New())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/tenants", strings.NewReader(`{"name":"a"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	err := handl.Create(e.NewContext(req, rec))
+	require.Error(t, err)
+	srvcTenant.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestTenantDelete(t *testing.T) {
+	srvcTenant := mocks.NewTenantService(t)
+	id := uuid.New()
+	srvcTenant.On("Delete", mock.Anything, id).Return(nil).Once()
+	handl := NewTenantHandler(srvcTenant, validator.New())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/tenants/"+id.String(), http.NoBody)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id.String())
+	err := handl.Delete(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestTenantDeleteNotFound(t *testing.T) {
+	srvcTenant := mocks.NewTenantService(t)
+	id := uuid.New()
+	srvcTenant.On("Delete", mock.Anything, id).Return(fmt.Errorf("TenantService -> Delete -> %w", service.ErrTenantNotFound)).Once()
+	handl := NewTenantHandler(srvcTenant, validator.New())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/tenants/"+id.String(), http.NoBody)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id.String())
+	err := handl.Delete(c)
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(err, &httpErr), err)
+	require.Equal(t, http.StatusNotFound, httpErr.Code)
+}
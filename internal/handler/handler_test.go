@@ -3,13 +3,20 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/distuurbia/firstTask/internal/handler/mocks"
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -56,7 +63,7 @@ func TestReadRow(t *testing.T) {
 
 func TestGetAll(t *testing.T) {
 	srvc.On("GetAll", mock.Anything).Return([]model.Person{vladimir}, nil)
-	handle := service.NewPersonService(srvc, nil)
+	handle := service.NewPersonService(srvc, nil, nil, nil, service.CacheOptions{})
 	allPers, err := handle.GetAll(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, len(allPers), len([]model.Person{vladimir}))
@@ -88,3 +95,20 @@ func TestDelete(t *testing.T) {
 
 	srvc.AssertExpectations(t)
 }
+
+// TestSignUpConflict checks that taken username is answered with 409
+func TestSignUpConflict(t *testing.T) {
+	srvcUser := mocks.NewUserService(t)
+	srvcUser.On("SignUp", mock.Anything, mock.AnythingOfType("*model.User")).
+		Return(fmt.Errorf("ServiceUser -> SignUp -> error: %w", service.ErrUsernameExist)).Once()
+	handl := NewHandler(srvc, srvcUser, validator.New())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/signUp", strings.NewReader(`{"username":"Vladimir","password":"secret"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	err := handl.SignUp(e.NewContext(req, rec))
+	var httpErr *echo.HTTPError
+	assert.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusConflict, httpErr.Code)
+}
@@ -0,0 +1,252 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers of the tus resumable upload protocol, see https://tus.io/protocols/resumable-upload
+const (
+	headerTusResumable   = "Tus-Resumable"
+	headerUploadLength   = "Upload-Length"
+	headerUploadOffset   = "Upload-Offset"
+	headerUploadMetadata = "Upload-Metadata"
+	headerUploadExpires  = "Upload-Expires"
+	// tusVersion is the version of the protocol the uploads follow
+	tusVersion = "1.0.0"
+	// mimeOffsetOctetStream is the content type of chunks
+	mimeOffsetOctetStream = "application/offset+octet-stream"
+)
+
+// UploadService is an interface that contains methods of service for resumable uploads of images
+type UploadService interface {
+	Create(ctx context.Context, filename string, length int64) (*model.Upload, error)
+	Progress(ctx context.Context, id uuid.UUID) (*model.Upload, error)
+	Append(ctx context.Context, id uuid.UUID, offset int64, src io.Reader) (*model.Upload, error)
+	Finish(ctx context.Context, id uuid.UUID) (*model.Image, error)
+	Cancel(ctx context.Context, id uuid.UUID) error
+}
+
+// UploadHandler contains UploadService interface
+type UploadHandler struct {
+	srvcUpload UploadService
+}
+
+// NewUploadHandler accepts UploadService interface and returns an object of *UploadHandler
+func NewUploadHandler(srvcUpload UploadService) *UploadHandler {
+	return &UploadHandler{srvcUpload: srvcUpload}
+}
+
+// Create starts a resumable upload
+// @Summary Start a resumable upload of an image
+// @Security ApiKeyAuth
+// @Description Creates an upload session following tus.io. Upload-Length is the size of the file, Upload-Metadata may
+// @Description carry its base64 encoded "filename". The size is checked against the limit and the quota of the user at once.
+// @Description The session expires unless a chunk is sent within the TTL
+// @Tags Uploads
+// @Produce json
+// @Param Upload-Length header int true "Size of the file"
+// @Param Upload-Metadata header string false "filename <base64>"
+// @Success 201 {object} model.Upload
+// @Failure 400 {object} error
+// @Failure 413 {object} error
+// @Failure 507 {object} error
+// @Router /uploads [post]
+func (handl *UploadHandler) Create(c echo.Context) error {
+	length, err := strconv.ParseInt(c.Request().Header.Get(headerUploadLength), 10, 64)
+	if err != nil {
+		logrus.Errorf("UploadHandler -> Create -> strconv.ParseInt -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload-Length header must be the size of the file")
+	}
+	upload, err := handl.srvcUpload.Create(c.Request().Context(), uploadFilename(c.Request().Header.Get(headerUploadMetadata)), length)
+	if err != nil {
+		logrus.Errorf("UploadHandler -> Create -> srvcUpload.Create -> error: %v", err)
+		return uploadError(err)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, "/uploads/"+upload.ID.String())
+	setUploadHeaders(c, upload)
+	return c.JSON(http.StatusCreated, upload)
+}
+
+// Progress returns how much of the upload is received
+// @Summary Get progress of a resumable upload
+// @Security ApiKeyAuth
+// @Description Returns the upload session, Upload-Offset header and the offset field tell how many bytes are received,
+// @Description the next chunk must start there. HEAD returns the headers only, as tus.io clients expect
+// @Tags Uploads
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 200 {object} model.Upload
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Router /uploads/{id} [get]
+func (handl *UploadHandler) Progress(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("UploadHandler -> Progress -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	upload, err := handl.srvcUpload.Progress(c.Request().Context(), id)
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("UploadHandler -> Progress -> srvcUpload.Progress -> error: %v", err)
+		return uploadError(err)
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	setUploadHeaders(c, upload)
+	return c.JSON(http.StatusOK, upload)
+}
+
+// Append receives a chunk of the upload
+// @Summary Send a chunk of a resumable upload
+// @Security ApiKeyAuth
+// @Description Appends the body to the upload, Upload-Offset header must be the offset returned by the previous chunk
+// @Description or by progress. Every chunk but the last one must have at least UPLOAD_MIN_CHUNK bytes. If the connection
+// @Description drops, the received bytes are kept and the upload is resumed from the offset returned by progress
+// @Tags Uploads
+// @Accept application/offset+octet-stream
+// @Param id path string true "Upload ID"
+// @Param Upload-Offset header int true "Offset of the chunk"
+// @Success 204 "Chunk is received, Upload-Offset header is the new offset"
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Failure 409 {object} error
+// @Failure 413 {object} error
+// @Failure 415 {object} error
+// @Router /uploads/{id} [patch]
+func (handl *UploadHandler) Append(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("UploadHandler -> Append -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	if c.Request().Header.Get(echo.HeaderContentType) != mimeOffsetOctetStream {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "chunk must be sent as "+mimeOffsetOctetStream)
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get(headerUploadOffset), 10, 64)
+	if err != nil {
+		logrus.Errorf("UploadHandler -> Append -> strconv.ParseInt -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload-Offset header must be the offset of the chunk")
+	}
+	upload, err := handl.srvcUpload.Append(c.Request().Context(), id, offset, c.Request().Body)
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("UploadHandler -> Append -> srvcUpload.Append -> error: %v", err)
+		return uploadError(err)
+	}
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Finish stores the received upload as an image
+// @Summary Finish a resumable upload
+// @Security ApiKeyAuth
+// @Description Checks the completely received file like POST /images, stores it and deletes the upload session.
+// @Description Returns metadata of the stored image
+// @Tags Uploads
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 201 {object} model.Image
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Failure 409 {object} error
+// @Failure 415 {object} error
+// @Failure 422 {object} error
+// @Failure 507 {object} error
+// @Router /uploads/{id}/finish [post]
+func (handl *UploadHandler) Finish(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("UploadHandler -> Finish -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	img, err := handl.srvcUpload.Finish(c.Request().Context(), id)
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("UploadHandler -> Finish -> srvcUpload.Finish -> error: %v", err)
+		return uploadError(err)
+	}
+	return c.JSON(http.StatusCreated, img)
+}
+
+// Cancel deletes the upload
+// @Summary Cancel a resumable upload
+// @Security ApiKeyAuth
+// @Description Deletes the upload session and the received chunks
+// @Tags Uploads
+// @Param id path string true "Upload ID"
+// @Success 204 "Upload is deleted"
+// @Failure 400 {object} error
+// @Failure 403 {object} error
+// @Failure 404 {object} error
+// @Router /uploads/{id} [delete]
+func (handl *UploadHandler) Cancel(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.Errorf("UploadHandler -> Cancel -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	if err = handl.srvcUpload.Cancel(c.Request().Context(), id); err != nil {
+		logrus.WithField("ID", id).Errorf("UploadHandler -> Cancel -> srvcUpload.Cancel -> error: %v", err)
+		return uploadError(err)
+	}
+	c.Response().Header().Set(headerTusResumable, tusVersion)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// setUploadHeaders sets tus headers that tell the client the state of the upload
+func setUploadHeaders(c echo.Context, upload *model.Upload) {
+	header := c.Response().Header()
+	header.Set(headerTusResumable, tusVersion)
+	header.Set(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	header.Set(headerUploadLength, strconv.FormatInt(upload.Length, 10))
+	header.Set(headerUploadExpires, upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// uploadFilename returns the filename from Upload-Metadata header, comma separated pairs of a key and a base64 value
+func uploadFilename(metadata string) string {
+	for _, pair := range strings.Split(metadata, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key != "filename" {
+			continue
+		}
+		filename, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ""
+		}
+		return string(filename)
+	}
+	return ""
+}
+
+// uploadError returns HTTP error that tells the client why the upload can't be continued
+func uploadError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "upload not found or expired")
+	case errors.Is(err, service.ErrUploadForbidden):
+		return echo.NewHTTPError(http.StatusForbidden, "upload belongs to another user")
+	case errors.Is(err, service.ErrUploadLength):
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload-Length must be positive")
+	case errors.Is(err, service.ErrUploadChunkSize):
+		return echo.NewHTTPError(http.StatusBadRequest, "chunk is smaller than the minimal size and isn't the last one")
+	case errors.Is(err, service.ErrUploadOffset):
+		return echo.NewHTTPError(http.StatusConflict, "Upload-Offset doesn't match the received bytes, see GET /uploads/{id}")
+	case errors.Is(err, service.ErrUploadIncomplete):
+		return echo.NewHTTPError(http.StatusConflict, "upload is incomplete, see GET /uploads/{id}")
+	default:
+		return imageError(err)
+	}
+}
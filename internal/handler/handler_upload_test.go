@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/handler/mocks"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUpload returns an upload session as the service returns it
+func newTestUpload(offset int64) *model.Upload {
+	return &model.Upload{ID: uuid.New(), Filename: "andy.png", Length: 100, Offset: offset, ExpiresAt: time.Now().Add(time.Hour)}
+}
+
+func TestUploadCreate(t *testing.T) {
+	upload := newTestUpload(0)
+	srvcUpload := mocks.NewUploadService(t)
+	srvcUpload.On("Create", mock.Anything, "andy.png", int64(100)).Return(upload, nil).Once()
+	req := httptest.NewRequest(http.MethodPost, "/uploads", http.NoBody)
+	req.Header.Set(headerUploadLength, "100")
+	req.Header.Set(headerUploadMetadata, "filetype aW1hZ2UvcG5n,filename "+base64.StdEncoding.EncodeToString([]byte("andy.png")))
+	rec := httptest.NewRecorder()
+	require.NoError(t, NewUploadHandler(srvcUpload).Create(echo.New().NewContext(req, rec)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, "/uploads/"+upload.ID.String(), rec.Header().Get(echo.HeaderLocation))
+	require.Equal(t, tusVersion, rec.Header().Get(headerTusResumable))
+	require.Equal(t, "0", rec.Header().Get(headerUploadOffset))
+
+	req = httptest.NewRequest(http.MethodPost, "/uploads", http.NoBody)
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(NewUploadHandler(srvcUpload).Create(echo.New().NewContext(req, httptest.NewRecorder())), &httpErr))
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+}
+
+// newChunkRequest returns PATCH request with the chunk at the offset
+func newChunkRequest(offset, chunk string) *http.Request {
+	req := httptest.NewRequest(http.MethodPatch, "/uploads/x", strings.NewReader(chunk))
+	req.Header.Set(echo.HeaderContentType, mimeOffsetOctetStream)
+	req.Header.Set(headerUploadOffset, offset)
+	return req
+}
+
+func TestUploadAppend(t *testing.T) {
+	upload := newTestUpload(40)
+	srvcUpload := mocks.NewUploadService(t)
+	srvcUpload.On("Append", mock.Anything, upload.ID, int64(30), mock.Anything).Return(upload, nil).Once()
+	handl := NewUploadHandler(srvcUpload)
+
+	c, rec := newUploadContext(newChunkRequest("30", "0123456789"), upload.ID.String())
+	require.NoError(t, handl.Append(c))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "40", rec.Header().Get(headerUploadOffset))
+
+	tests := map[string]struct {
+		req  *http.Request
+		code int
+	}{
+		"missingOffset": {req: newChunkRequest("", "0123456789"), code: http.StatusBadRequest},
+		"wrongType":     {req: httptest.NewRequest(http.MethodPatch, "/uploads/x", strings.NewReader("0123456789")), code: http.StatusUnsupportedMediaType},
+	}
+	for name, test := range tests {
+		c, _ = newUploadContext(test.req, upload.ID.String())
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(handl.Append(c), &httpErr), name)
+		require.Equal(t, test.code, httpErr.Code, name)
+	}
+}
+
+func TestUploadRejected(t *testing.T) {
+	tests := map[error]int{
+		service.ErrUploadNotFound:   http.StatusNotFound,
+		service.ErrUploadForbidden:  http.StatusForbidden,
+		service.ErrUploadOffset:     http.StatusConflict,
+		service.ErrUploadChunkSize:  http.StatusBadRequest,
+		service.ErrUploadIncomplete: http.StatusConflict,
+		service.ErrImageTooLarge:    http.StatusRequestEntityTooLarge,
+		service.ErrImageType:        http.StatusUnsupportedMediaType,
+	}
+	for serviceErr, code := range tests {
+		id := uuid.New()
+		srvcUpload := mocks.NewUploadService(t)
+		srvcUpload.On("Append", mock.Anything, id, int64(0), mock.Anything).Return(nil, fmt.Errorf("UploadService -> Append -> error: %w", serviceErr)).Once()
+		srvcUpload.On("Finish", mock.Anything, id).Return(nil, fmt.Errorf("UploadService -> Finish -> error: %w", serviceErr)).Once()
+		handl := NewUploadHandler(srvcUpload)
+
+		c, _ := newUploadContext(newChunkRequest("0", "chunk"), id.String())
+		var httpErr *echo.HTTPError
+		require.True(t, errors.As(handl.Append(c), &httpErr))
+		require.Equal(t, code, httpErr.Code, serviceErr.Error())
+		c, _ = newUploadContext(httptest.NewRequest(http.MethodPost, "/uploads/x/finish", http.NoBody), id.String())
+		require.True(t, errors.As(handl.Finish(c), &httpErr))
+		require.Equal(t, code, httpErr.Code, serviceErr.Error())
+	}
+}
+
+func TestUploadProgressFinishCancel(t *testing.T) {
+	upload := newTestUpload(100)
+	img := &model.Image{ID: uuid.New(), Filename: "andy.png"}
+	srvcUpload := mocks.NewUploadService(t)
+	srvcUpload.On("Progress", mock.Anything, upload.ID).Return(upload, nil).Once()
+	srvcUpload.On("Finish", mock.Anything, upload.ID).Return(img, nil).Once()
+	srvcUpload.On("Cancel", mock.Anything, upload.ID).Return(nil).Once()
+	handl := NewUploadHandler(srvcUpload)
+
+	c, rec := newUploadContext(httptest.NewRequest(http.MethodHead, "/uploads/x", http.NoBody), upload.ID.String())
+	require.NoError(t, handl.Progress(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "100", rec.Header().Get(headerUploadOffset))
+	require.Equal(t, "no-store", rec.Header().Get(echo.HeaderCacheControl))
+
+	c, rec = newUploadContext(httptest.NewRequest(http.MethodPost, "/uploads/x/finish", http.NoBody), upload.ID.String())
+	require.NoError(t, handl.Finish(c))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Contains(t, rec.Body.String(), img.ID.String())
+
+	c, rec = newUploadContext(httptest.NewRequest(http.MethodDelete, "/uploads/x", http.NoBody), upload.ID.String())
+	require.NoError(t, handl.Cancel(c))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	c, _ = newUploadContext(httptest.NewRequest(http.MethodDelete, "/uploads/x", http.NoBody), "andy.png")
+	var httpErr *echo.HTTPError
+	require.True(t, errors.As(handl.Cancel(c), &httpErr))
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+}
+
+func TestUploadFilename(t *testing.T) {
+	tests := map[string]string{
+		"filename " + base64.StdEncoding.EncodeToString([]byte("andy.png")):                       "andy.png",
+		"filetype aW1hZ2UvcG5n, filename " + base64.StdEncoding.EncodeToString([]byte("a b.png")): "a b.png",
+		"filename !!!":          "",
+		"filetype aW1hZ2UvcG5n": "",
+		"":                      "",
+	}
+	for metadata, expected := range tests {
+		require.Equal(t, expected, uploadFilename(metadata), metadata)
+	}
+}
+
+// newUploadContext returns context of the request to the upload with the id param
+func newUploadContext(req *http.Request, id string) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return c, rec
+}
@@ -0,0 +1,186 @@
+// Package handler contains handler methods and handler tests
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Bounds of the limit query param of the delivery log
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+// WebhookService is an interface that contains methods of service for webhooks
+type WebhookService interface {
+	Create(ctx context.Context, webhook *model.Webhook) error
+	GetAll(ctx context.Context) ([]model.Webhook, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Enable(ctx context.Context, id uuid.UUID) error
+	Deliveries(ctx context.Context, id uuid.UUID, limit int) ([]model.WebhookDelivery, error)
+}
+
+// WebhookHandler contains WebhookService interface
+type WebhookHandler struct {
+	srvcWebhook WebhookService
+	validate    *validator.Validate
+}
+
+// NewWebhookHandler accepts WebhookService interface and returns an object of *WebhookHandler
+func NewWebhookHandler(srvcWebhook WebhookService, validate *validator.Validate) *WebhookHandler {
+	return &WebhookHandler{srvcWebhook: srvcWebhook, validate: validate}
+}
+
+// Create subscribes a new webhook to person events
+// @Summary Create a new webhook
+// @Security ApiKeyAuth
+// @Description Subscribes URL to person events, secret is generated if it is empty and is returned only once.
+// @Description URL must be http or https and must not point to localhost or a private, link-local or internal address
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param webhook body model.Webhook true "webhook value (model.Webhook)"
+// @Success 201 {object} model.Webhook
+// @Failure 400 {object} error
+// @Router /webhooks [post]
+func (handl *WebhookHandler) Create(c echo.Context) error {
+	var createdWebhook model.Webhook
+	err := c.Bind(&createdWebhook)
+	if err != nil {
+		logrus.Errorf("WebhookHandler -> Create -> c.Bind -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to bind")
+	}
+	createdWebhook.ID = uuid.New()
+	createdWebhook.Disabled = false
+	createdWebhook.Failures = 0
+	err = handl.validate.StructCtx(c.Request().Context(), createdWebhook)
+	if err != nil {
+		logrus.Errorf("WebhookHandler -> Create -> validate -> StructCtx -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to validate")
+	}
+	err = handl.srvcWebhook.Create(c.Request().Context(), &createdWebhook)
+	if errors.Is(err, service.ErrWebhookURL) {
+		logrus.WithField("URL", createdWebhook.URL).Errorf("WebhookHandler -> Create -> srvcWebhook.Create -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "url must be a public http or https URL")
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"ID":  createdWebhook.ID,
+			"URL": createdWebhook.URL,
+		}).Errorf("WebhookHandler -> Create -> srvcWebhook.Create -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to create webhook")
+	}
+	return c.JSON(http.StatusCreated, createdWebhook)
+}
+
+// GetAll returns webhooks of the tenant
+// @Summary Get all webhooks
+// @Security ApiKeyAuth
+// @Description Returns webhooks of the tenant without their secrets
+// @Tags Webhook
+// @Produce json
+// @Success 200 {array} model.Webhook
+// @Failure 400 {object} error
+// @Router /webhooks [get]
+func (handl *WebhookHandler) GetAll(c echo.Context) error {
+	webhooks, err := handl.srvcWebhook.GetAll(c.Request().Context())
+	if err != nil {
+		logrus.Errorf("WebhookHandler -> GetAll -> srvcWebhook.GetAll -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to get webhooks")
+	}
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// Delete deletes webhook with its delivery log
+// @Summary Delete a webhook by ID
+// @Security ApiKeyAuth
+// @Description Deletes a webhook with its delivery log
+// @Tags Webhook
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Success 200 {string} string
+// @Failure 400 {object} error
+// @Router /webhooks/{id} [delete]
+func (handl *WebhookHandler) Delete(c echo.Context) error {
+	id := c.Param("id")
+	uuidID, err := uuid.Parse(id)
+	if err != nil {
+		logrus.Errorf("WebhookHandler -> Delete -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	err = handl.srvcWebhook.Delete(c.Request().Context(), uuidID)
+	if err != nil {
+		logrus.WithField("ID", uuidID).Errorf("WebhookHandler -> Delete -> srvcWebhook.Delete -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to delete check that such webhook exist")
+	}
+	return c.JSON(http.StatusOK, "Deleted: "+id)
+}
+
+// Enable turns on webhook that was disabled after too many failed deliveries
+// @Summary Enable a webhook by ID
+// @Security ApiKeyAuth
+// @Description Enables a webhook and resets its counter of consecutive failures
+// @Tags Webhook
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Success 200 {string} string
+// @Failure 400 {object} error
+// @Router /webhooks/{id}/enable [post]
+func (handl *WebhookHandler) Enable(c echo.Context) error {
+	id := c.Param("id")
+	uuidID, err := uuid.Parse(id)
+	if err != nil {
+		logrus.Errorf("WebhookHandler -> Enable -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	err = handl.srvcWebhook.Enable(c.Request().Context(), uuidID)
+	if err != nil {
+		logrus.WithField("ID", uuidID).Errorf("WebhookHandler -> Enable -> srvcWebhook.Enable -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to enable check that such webhook exist")
+	}
+	return c.JSON(http.StatusOK, "Enabled: "+id)
+}
+
+// Deliveries returns the latest delivery attempts of the webhook
+// @Summary Get delivery log of a webhook
+// @Security ApiKeyAuth
+// @Description Returns the latest delivery attempts of the webhook, newest first
+// @Tags Webhook
+// @Produce json
+// @Param id path string true "Webhook ID"
+// @Param limit query int false "maximum number of deliveries"
+// @Success 200 {array} model.WebhookDelivery
+// @Failure 400 {object} error
+// @Router /webhooks/{id}/deliveries [get]
+func (handl *WebhookHandler) Deliveries(c echo.Context) error {
+	id := c.Param("id")
+	uuidID, err := uuid.Parse(id)
+	if err != nil {
+		logrus.Errorf("WebhookHandler -> Deliveries -> uuid.Parse -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id")
+	}
+	limit := defaultDeliveriesLimit
+	if param := c.QueryParam("limit"); param != "" {
+		limit, err = strconv.Atoi(param)
+		if err != nil || limit <= 0 || limit > maxDeliveriesLimit {
+			logrus.Errorf("WebhookHandler -> Deliveries -> strconv.Atoi -> error: %v", err)
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 500")
+		}
+	}
+	deliveries, err := handl.srvcWebhook.Deliveries(c.Request().Context(), uuidID, limit)
+	if err != nil {
+		logrus.WithField("ID", uuidID).Errorf("WebhookHandler -> Deliveries -> srvcWebhook.Deliveries -> error: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to get deliveries")
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/handler/mocks"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookCreate(t *testing.T) {
+	srvcWebhook := mocks.NewWebhookService(t)
+	srvcWebhook.On("Create", mock.Anything, mock.AnythingOfType("*model.Webhook")).Return(nil).Once()
+	handl := NewWebhookHandler(srvcWebhook, validator.New())
+
+	e := echo.New()
+	body := `{"url":"https://payroll.example.com/hooks","eventTypes":["PersonCreated"]}`
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	err := handl.Create(e.NewContext(req, rec))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Contains(t, rec.Body.String(), `"url":"https://payroll.example.com/hooks"`)
+}
+
+func TestWebhookCreateInvalid(t *testing.T) {
+	testTable := []struct {
+		name string
+		body string
+	}{
+		{name: "not url", body: `{"url":"payroll"}`},
+		{name: "unknown event type", body: `{"url":"https://payroll.example.com","eventTypes":["PersonHired"]}`},
+		{name: "short secret", body: `{"url":"https://payroll.example.com","secret":"short"}`},
+	}
+	for _, tc := range testTable {
+		t.Run(tc.name, func(t *testing.T) {
+			srvcWebhook := mocks.NewWebhookService(t)
+			handl := NewWebhookHandler(srvcWebhook, validator.New())
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			err := handl.Create(e.NewContext(req, httptest.NewRecorder()))
+			require.Error(t, err)
+			srvcWebhook.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestWebhookCreateForbiddenURL(t *testing.T) {
+	srvcWebhook := mocks.NewWebhookService(t)
+	srvcWebhook.On("Create", mock.Anything, mock.AnythingOfType("*model.Webhook")).
+		Return(fmt.Errorf("WebhookService -> Create -> %w", service.ErrWebhookURL)).Once()
+	handl := NewWebhookHandler(srvcWebhook, validator.New())
+
+	e := echo.New()
+	body := `{"url":"http://169.254.169.254/latest/meta-data"}`
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	err := handl.Create(e.NewContext(req, httptest.NewRecorder()))
+	var httpErr *echo.HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	require.Contains(t, httpErr.Message, "public")
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	srvcWebhook := mocks.NewWebhookService(t)
+	id := uuid.New()
+	srvcWebhook.On("Deliveries", mock.Anything, id, 10).
+		Return([]model.WebhookDelivery{{ID: uuid.New(), WebhookID: id, Attempt: 1, StatusCode: http.StatusOK}}, nil).Once()
+	handl := NewWebhookHandler(srvcWebhook, validator.New())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/webhooks/"+id.String()+"/deliveries?limit=10", http.NoBody)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id.String())
+	err := handl.Deliveries(c)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"statusCode":200`)
+}
@@ -45,6 +45,32 @@ func (_m *PersonService) Delete(ctx context.Context, id uuid.UUID) error {
 	return r0
 }
 
+// GetByAvatarForUpdate provides a mock function with given fields: ctx, imageID
+func (_m *PersonService) GetByAvatarForUpdate(ctx context.Context, imageID uuid.UUID) ([]model.Person, error) {
+	ret := _m.Called(ctx, imageID)
+
+	var r0 []model.Person
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) ([]model.Person, error)); ok {
+		return rf(ctx, imageID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) []model.Person); ok {
+		r0 = rf(ctx, imageID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Person)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, imageID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetAll provides a mock function with given fields: ctx
 func (_m *PersonService) GetAll(ctx context.Context) ([]model.Person, error) {
 	ret := _m.Called(ctx)
@@ -97,6 +123,32 @@ func (_m *PersonService) ReadRow(ctx context.Context, id uuid.UUID) (*model.Pers
 	return r0, r1
 }
 
+// ReadRowForUpdate provides a mock function with given fields: ctx, id
+func (_m *PersonService) ReadRowForUpdate(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.Person
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) (*model.Person, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID) *model.Person); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Person)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Update provides a mock function with given fields: ctx, pers
 func (_m *PersonService) Update(ctx context.Context, pers *model.Person) error {
 	ret := _m.Called(ctx, pers)
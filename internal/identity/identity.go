@@ -0,0 +1,44 @@
+// Package identity carries the tenant and the user of a request through context
+package identity
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ErrNoTenant means that context doesn't contain a tenant ID
+var ErrNoTenant = fmt.Errorf("tenant is missing in context")
+
+type ctxKey int
+
+const (
+	tenantKey ctxKey = iota
+	userKey
+)
+
+// WithTenant returns a copy of ctx that carries the given tenant ID
+func WithTenant(ctx context.Context, tenantID uuid.UUID) context.Context {
+	return context.WithValue(ctx, tenantKey, tenantID)
+}
+
+// TenantFromContext returns tenant ID from ctx or ErrNoTenant if there is no one
+func TenantFromContext(ctx context.Context) (uuid.UUID, error) {
+	tenantID, ok := ctx.Value(tenantKey).(uuid.UUID)
+	if !ok || tenantID == uuid.Nil {
+		return uuid.Nil, ErrNoTenant
+	}
+	return tenantID, nil
+}
+
+// WithUser returns a copy of ctx that carries the given user ID
+func WithUser(ctx context.Context, userID uuid.UUID) context.Context {
+	return context.WithValue(ctx, userKey, userID)
+}
+
+// UserFromContext returns user ID from ctx and reports if it was there
+func UserFromContext(ctx context.Context) (uuid.UUID, bool) {
+	userID, ok := ctx.Value(userKey).(uuid.UUID)
+	return userID, ok && userID != uuid.Nil
+}
@@ -2,17 +2,20 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/distuurbia/firstTask/internal/config"
+	"github.com/distuurbia/firstTask/internal/identity"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
-// JWTMiddleware makes an authorization through access token
+// JWTMiddleware makes an authorization through access token and puts user and tenant from it into request context
 func JWTMiddleware(cfg *config.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -29,17 +32,118 @@ func JWTMiddleware(cfg *config.Config) echo.MiddlewareFunc {
 			if err != nil || !token.Valid {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 			}
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-				exp := claims["exp"].(float64)
-				if exp < float64(time.Now().Unix()) {
-					return echo.NewHTTPError(http.StatusUnauthorized, "Token is expired")
-				}
+			claims, ok := token.Claims.(jwt.MapClaims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			}
+			if err = CheckExpiration(claims); err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Token is expired or has no expiration")
+			}
+			userID, tenantID, err := ParseIdentityClaims(claims)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Token has no valid user or tenant")
+			}
+			ctx := identity.WithTenant(c.Request().Context(), tenantID)
+			ctx = identity.WithUser(ctx, userID)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// TenantMiddleware puts tenant from X-Tenant-ID header into request context, it is used by routes without access token
+func TenantMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tenantID, err := uuid.Parse(c.Request().Header.Get("X-Tenant-ID"))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Missing or invalid X-Tenant-ID header")
+			}
+			c.SetRequest(c.Request().WithContext(identity.WithTenant(c.Request().Context(), tenantID)))
+			return next(c)
+		}
+	}
+}
+
+// QueryTokenMiddleware moves access token from access_token query param to Authorization header, it is used before
+// JWTMiddleware by streaming routes because browsers can't set headers of EventSource and WebSocket requests
+func QueryTokenMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := c.QueryParam("access_token")
+			if token != "" && c.Request().Header.Get("Authorization") == "" {
+				c.Request().Header.Set("Authorization", "Bearer "+token)
+			}
+			return next(c)
+		}
+	}
+}
+
+// SignedURLMiddleware checks access token like JWTMiddleware unless the request has signature query param,
+// then the handler verifies the signature of the URL instead. It is used by image downloads, so images can be
+// embedded in pages by signed URLs
+func SignedURLMiddleware(cfg *config.Config) echo.MiddlewareFunc {
+	jwtMiddleware := JWTMiddleware(cfg)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withToken := jwtMiddleware(next)
+		return func(c echo.Context) error {
+			if c.QueryParam("signature") != "" {
+				return next(c)
+			}
+			return withToken(c)
+		}
+	}
+}
+
+// AdminMiddleware lets through only requests with X-Admin-Key header equal to the configured admin key
+func AdminMiddleware(cfg *config.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			adminKey := c.Request().Header.Get("X-Admin-Key")
+			if cfg.AdminKey == "" || subtle.ConstantTimeCompare([]byte(adminKey), []byte(cfg.AdminKey)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid admin key")
 			}
 			return next(c)
 		}
 	}
 }
 
+// ParseIdentityClaims returns user ID and tenant ID from claims of the token
+func ParseIdentityClaims(claims jwt.MapClaims) (userID, tenantID uuid.UUID, err error) {
+	id, ok := claims["id"].(string)
+	if !ok {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("claim id is missing")
+	}
+	userID, err = uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("claim id -> uuid.Parse -> error: %w", err)
+	}
+	tenant, ok := claims["tenant"].(string)
+	if !ok {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("claim tenant is missing")
+	}
+	tenantID, err = uuid.Parse(tenant)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("claim tenant -> uuid.Parse -> error: %w", err)
+	}
+	return userID, tenantID, nil
+}
+
+// CheckExpiration returns an error if claims of the token have no valid exp claim or the token is expired
+func CheckExpiration(claims jwt.MapClaims) error {
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return fmt.Errorf("claims.GetExpirationTime -> error: %w", err)
+	}
+	if exp == nil {
+		return fmt.Errorf("claim exp is missing")
+	}
+	if exp.Before(time.Now()) {
+		return fmt.Errorf("token expired at %s", exp.Time)
+	}
+	return nil
+}
+
 // extractTokenFromHeader extractes token from authHeader
 func extractTokenFromHeader(authHeader string) string {
 	parts := strings.Split(authHeader, " ")
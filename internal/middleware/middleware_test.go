@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// signedToken returns the claims signed with the key
+func signedToken(t *testing.T, claims jwt.MapClaims, key string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	require.NoError(t, err)
+	return token
+}
+
+func TestJWTMiddlewareExpiration(t *testing.T) {
+	cfg := &config.Config{SecretKey: "secret"}
+	identityClaims := func(exp interface{}) jwt.MapClaims {
+		claims := jwt.MapClaims{"id": uuid.New().String(), "tenant": uuid.New().String()}
+		if exp != nil {
+			claims["exp"] = exp
+		}
+		return claims
+	}
+	testCases := []struct {
+		name   string
+		claims jwt.MapClaims
+		status int
+	}{
+		{name: "valid", claims: identityClaims(time.Now().Add(time.Minute).Unix()), status: http.StatusOK},
+		{name: "missing exp", claims: identityClaims(nil), status: http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+signedToken(t, tc.claims, cfg.SecretKey))
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			err := JWTMiddleware(cfg)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})(c)
+			if tc.status == http.StatusOK {
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, rec.Code)
+				return
+			}
+			var httpErr *echo.HTTPError
+			require.ErrorAs(t, err, &httpErr)
+			require.Equal(t, tc.status, httpErr.Code)
+		})
+	}
+}
+
+func TestCheckExpiration(t *testing.T) {
+	require.NoError(t, CheckExpiration(jwt.MapClaims{"exp": float64(time.Now().Add(time.Minute).Unix())}))
+	require.Error(t, CheckExpiration(jwt.MapClaims{}))
+	require.Error(t, CheckExpiration(jwt.MapClaims{"exp": "tomorrow"}))
+	require.Error(t, CheckExpiration(jwt.MapClaims{"exp": float64(time.Now().Add(-time.Minute).Unix())}))
+}
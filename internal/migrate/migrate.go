@@ -0,0 +1,255 @@
+// Package migrate applies embedded versioned migrations to postgreSQL and keeps track of them in schema_migrations table
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lockID is a key of the advisory lock that keeps several replicas from migrating at the same time
+const lockID = 8_271_934
+
+// ErrNoDown means that the migration has no U<version>__<description>.sql file and can't be reverted
+var ErrNoDown = fmt.Errorf("migration has no down script")
+
+// fileName matches V1__create_tables.sql and U1__create_tables.sql
+var fileName = regexp.MustCompile(`^([VU])(\d+)__(\w+)\.sql$`)
+
+// Migration contains up and down scripts of one schema version
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+}
+
+// Status contains an info about the migration and if it was applied
+type Status struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// Load reads migrations from fsys and returns them sorted by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("Load -> fs.ReadDir -> error: %w", err)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		parts := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || parts == nil {
+			continue
+		}
+		version, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("Load -> strconv.Atoi -> error: %w", err)
+		}
+		script, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("Load -> fs.ReadFile -> error: %w", err)
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Description: parts[3]}
+			byVersion[version] = migration
+		}
+		if parts[1] == "V" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("Load -> error: version %d has no up script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Postgres applies migrations to postgreSQL
+type Postgres struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+}
+
+// NewPostgres accepts an object of *pgxpool.Pool with migration files and returns an object of type *Postgres
+func NewPostgres(db *pgxpool.Pool, fsys fs.FS) (*Postgres, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgres -> error: %w", err)
+	}
+	return &Postgres{db: db, migrations: migrations}, nil
+}
+
+// Up applies all pending migrations and returns their versions
+func (m *Postgres) Up(ctx context.Context) ([]int, error) {
+	var applied []int
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "INSERT INTO schema_migrations(version, description) VALUES($1, $2)", migration.Version, migration.Description)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("version %d: %w", migration.Version, err)
+			}
+			applied = append(applied, migration.Version)
+		}
+		return nil
+	})
+	if err != nil {
+		return applied, fmt.Errorf("Postgres -> Up -> error: %w", err)
+	}
+	return applied, nil
+}
+
+// Down reverts the last applied migration and returns its version, 0 means that there was nothing to revert
+func (m *Postgres) Down(ctx context.Context) (int, error) {
+	var reverted int
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		var version int
+		err := conn.QueryRow(ctx, "SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1").Scan(&version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var migration *Migration
+		for i := range m.migrations {
+			if m.migrations[i].Version == version {
+				migration = &m.migrations[i]
+			}
+		}
+		if migration == nil || migration.Down == "" {
+			return fmt.Errorf("version %d: %w", version, ErrNoDown)
+		}
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, migration.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("version %d: %w", version, err)
+		}
+		reverted = version
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Postgres -> Down -> error: %w", err)
+	}
+	return reverted, nil
+}
+
+// Status returns all known migrations and marks the applied ones
+func (m *Postgres) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(conn *pgxpool.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			appliedAt, ok := done[migration.Version]
+			statuses = append(statuses, Status{
+				Version:     migration.Version,
+				Description: migration.Description,
+				Applied:     ok,
+				AppliedAt:   appliedAt,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Postgres -> Status -> error: %w", err)
+	}
+	return statuses, nil
+}
+
+// locked runs fn on one connection that holds the advisory lock and makes sure schema_migrations table exists
+func (m *Postgres) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("Acquire -> error: %w", err)
+	}
+	defer conn.Release()
+	if _, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("pg_advisory_lock -> error: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+	}()
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description VARCHAR NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations -> error: %w", err)
+	}
+	if err = importFlywayHistory(ctx, conn); err != nil {
+		return fmt.Errorf("importFlywayHistory -> error: %w", err)
+	}
+	return fn(conn)
+}
+
+// importFlywayHistory marks versions that were applied by the flyway CLI as applied, so databases migrated before stay untouched
+func importFlywayHistory(ctx context.Context, conn *pgxpool.Conn) error {
+	var exists bool
+	err := conn.QueryRow(ctx, "SELECT to_regclass('flyway_schema_history') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = conn.Exec(ctx, `INSERT INTO schema_migrations(version, description, applied_at)
+		SELECT version::INTEGER, replace(description, ' ', '_'), installed_on FROM flyway_schema_history
+		WHERE success AND version IS NOT NULL
+		ON CONFLICT (version) DO NOTHING`)
+	return err
+}
+
+// appliedVersions returns applied versions with the time they were applied
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("appliedVersions -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	done := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("appliedVersions -> Scan -> error: %w", err)
+		}
+		done[version] = appliedAt
+	}
+	return done, rows.Err()
+}
@@ -0,0 +1,43 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/distuurbia/firstTask/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"V2__second.sql": {Data: []byte("create table b ();")},
+		"V1__first.sql":  {Data: []byte("create table a ();")},
+		"U1__first.sql":  {Data: []byte("drop table a;")},
+		"README.md":      {Data: []byte("not a migration")},
+	}
+	loaded, err := Load(fsys)
+	require.NoError(t, err)
+	require.Len(t, loaded, 2)
+	require.Equal(t, 1, loaded[0].Version)
+	require.Equal(t, "first", loaded[0].Description)
+	require.Equal(t, "drop table a;", loaded[0].Down)
+	require.Equal(t, 2, loaded[1].Version)
+	require.Empty(t, loaded[1].Down)
+}
+
+func TestLoadDownWithoutUp(t *testing.T) {
+	fsys := fstest.MapFS{
+		"U1__first.sql": {Data: []byte("drop table a;")},
+	}
+	_, err := Load(fsys)
+	require.Error(t, err)
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	require.NoError(t, err)
+	for i, migration := range loaded {
+		require.Equal(t, i+1, migration.Version)
+		require.NotEmpty(t, migration.Down)
+	}
+}
@@ -0,0 +1,14 @@
+// Package model contains structs that we will use as a records in our dbs
+package model
+
+import "fmt"
+
+// ErrExist means that the entity violates uniqueness in the storage, repositories wrap it,
+// so services recognise it without depending on a storage
+var ErrExist = fmt.Errorf("entity already exist")
+
+// ErrNotFound means that the storage has no such entity, repositories wrap it instead of errors of their drivers
+var ErrNotFound = fmt.Errorf("entity not found")
+
+// ErrQuotaExceeded means that a conditional increment of a counter would exceed its limit, so nothing was changed
+var ErrQuotaExceeded = fmt.Errorf("quota exceeded")
@@ -1,7 +1,12 @@
 // Package model contains structs that we will use as a records in our dbs
 package model
 
-import "github.com/google/uuid"
+import (
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // Person contains an info about the person and will be written in a personsdb table
 type Person struct {
@@ -9,6 +14,23 @@ type Person struct {
 	Salary     int       `json:"salary" bson:"salary" validate:"required,numeric,min=100,max=100000"`
 	Married    bool      `json:"married" bson:"married"`
 	Profession string    `json:"profession" bson:"profession" validate:"required,min=3,max=30"`
+	// AvatarImageID is the image shown as avatar of the person, it is set only through the avatar endpoint
+	AvatarImageID *uuid.UUID `json:"avatarImageId,omitempty" bson:"avatarImageId"`
+	// AvatarURL is where the avatar is downloaded from, it is filled in responses and never stored
+	AvatarURL string `json:"avatarUrl,omitempty" bson:"-"`
+}
+
+// Copy returns a copy of the person that shares nothing with it, copy of nil is nil
+func (p *Person) Copy() *Person {
+	if p == nil {
+		return nil
+	}
+	copied := *p
+	if p.AvatarImageID != nil {
+		avatarID := *p.AvatarImageID
+		copied.AvatarImageID = &avatarID
+	}
+	return &copied
 }
 
 // User contains an info about the user and will be written in a users table
@@ -30,3 +52,151 @@ type RefreshRequest struct {
 	AccessToken  string `json:"accessToken" bson:"accessToken"`
 	RefreshToken string `json:"refreshToken" bson:"refreshToken"`
 }
+
+// Tenant contains an info about the customer organisation and will be written in a tenants table
+type Tenant struct {
+	ID   uuid.UUID `json:"id" bson:"_id"`
+	Name string    `json:"name" bson:"name" validate:"required,min=3,max=30"`
+}
+
+// OutboxEvent contains a domain event that is written in the outbox in the same transaction as the change it describes
+type OutboxEvent struct {
+	ID          uuid.UUID `json:"id" bson:"_id"`
+	TenantID    uuid.UUID `json:"tenantId" bson:"tenantId"`
+	AggregateID uuid.UUID `json:"aggregateId" bson:"aggregateId"`
+	Type        string    `json:"type" bson:"type"`
+	Payload     []byte    `json:"payload" bson:"payload"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	Attempts    int       `json:"attempts" bson:"attempts"`
+}
+
+// Webhook contains a subscription of the tenant to person events, empty EventTypes means all types
+type Webhook struct {
+	ID         uuid.UUID `json:"id" bson:"_id"`
+	URL        string    `json:"url" bson:"url" validate:"required,url,max=2048"`
+	EventTypes []string  `json:"eventTypes" bson:"eventTypes" validate:"dive,oneof=PersonCreated PersonUpdated PersonDeleted"`
+	Secret     string    `json:"secret,omitempty" bson:"secret" validate:"omitempty,min=16,max=128"`
+	Disabled   bool      `json:"disabled" bson:"disabled"`
+	Failures   int       `json:"failures" bson:"failures"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// WebhookDelivery contains a result of one attempt to deliver an event to the webhook
+type WebhookDelivery struct {
+	ID         uuid.UUID `json:"id" bson:"_id"`
+	WebhookID  uuid.UUID `json:"webhookId" bson:"webhookId"`
+	EventID    uuid.UUID `json:"eventId" bson:"eventId"`
+	EventType  string    `json:"eventType" bson:"eventType"`
+	Attempt    int       `json:"attempt" bson:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode"`
+	Error      string    `json:"error,omitempty" bson:"error"`
+	Duration   int64     `json:"durationMs" bson:"durationMs"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Succeeded reports whether the receiver accepted the delivery
+func (d *WebhookDelivery) Succeeded() bool {
+	return d.Error == "" && d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// CacheTierStats contains counters of one tier of the person cache
+type CacheTierStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries,omitempty"`
+}
+
+// Image contains metadata of an uploaded image, Filename is the name given by the uploader and is never used as a path
+type Image struct {
+	ID          uuid.UUID `json:"id" bson:"_id"`
+	OwnerID     uuid.UUID `json:"ownerId" bson:"ownerId"`
+	Filename    string    `json:"filename" bson:"filename"`
+	ContentType string    `json:"contentType" bson:"contentType"`
+	Size        int64     `json:"size" bson:"size"`
+	Width       int       `json:"width" bson:"width"`
+	Height      int       `json:"height" bson:"height"`
+	SHA256      string    `json:"sha256" bson:"sha256"`
+	// Origin tells how the image was uploaded, ImageOriginUpload or ImageOriginAvatar
+	Origin    string    `json:"origin" bson:"origin"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Origins of images. An image uploaded as an avatar belongs to the avatar and is deleted when no person has it
+// as avatar anymore, other images are only unlinked
+const (
+	ImageOriginUpload = "upload"
+	ImageOriginAvatar = "avatar"
+)
+
+// SignedURL is a URL that downloads an image without access token until ExpiresAt
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Upload is a session of a resumable upload of an image, the content is sent in chunks and becomes an image when
+// all Length bytes are received
+type Upload struct {
+	ID        uuid.UUID `json:"id"`
+	OwnerID   uuid.UUID `json:"ownerId"`
+	Filename  string    `json:"filename"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ImageUsage is storage used by images of one user
+type ImageUsage struct {
+	OwnerID uuid.UUID `json:"ownerId" bson:"_id"`
+	Images  int       `json:"images" bson:"images"`
+	// Bytes is the size of all images of the user, an image counts even if its content is shared with another one
+	Bytes int64 `json:"bytes" bson:"bytes"`
+	// QuotaBytes is how many bytes of images the user may keep, 0 means no quota
+	QuotaBytes int64 `json:"quotaBytes" bson:"-"`
+}
+
+// ImageUsageReport is storage used by images of the tenant, users are sorted by used bytes, the largest first
+type ImageUsageReport struct {
+	QuotaBytes int64 `json:"quotaBytes"`
+	// Bytes is the size of all images, StoredBytes is the size of their distinct contents that is actually stored
+	Bytes       int64        `json:"bytes"`
+	StoredBytes int64        `json:"storedBytes"`
+	Users       []ImageUsage `json:"users"`
+}
+
+// ImageContent is a content stored once per tenant under its SHA-256 with the number of images that refer to it.
+// Empty Key means the key the content had before keys had a generation, see ImageService.save
+type ImageContent struct {
+	TenantID uuid.UUID `bson:"-"`
+	SHA256   string    `bson:"_id"`
+	Key      string    `bson:"key"`
+	Refs     int       `bson:"refs"`
+	// UnreferencedAt is when the last image referring to the content was deleted, the content is collected later
+	UnreferencedAt *time.Time `bson:"unreferencedAt,omitempty"`
+}
+
+// ImageVariant describes a resized copy of an image, zero value means the original.
+// Zero Width or Height doesn't bound that side, empty Fit means contain and empty Format keeps the format of the original
+type ImageVariant struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+}
+
+// ImageFile is a stored image opened for reading, Content must be closed by the reader
+type ImageFile struct {
+	Content     io.ReadSeekCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+}
+
+// Blob is a stored object opened for reading, Content must be closed by the reader
+type Blob struct {
+	Content io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
@@ -0,0 +1,163 @@
+// Package outbox relays events written in the outbox to the event publisher and applies them to the cache
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// lease is how long a claimed event is hidden from relays of the other replicas
+	lease = 30 * time.Second
+	// retryBase and retryMax bound exponential backoff between attempts of a failed event
+	retryBase = time.Second
+	retryMax  = 5 * time.Minute
+	// pruneInterval is how often published events older than the retention are deleted
+	pruneInterval = time.Hour
+)
+
+// errPoison means that the event can never be relayed, e.g. its payload can't be decoded, so it isn't retried
+var errPoison = fmt.Errorf("event can't be relayed")
+
+// Store is an interface that contains outbox methods of the repository
+type Store interface {
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error)
+	MarkOutboxPublished(ctx context.Context, id uuid.UUID) error
+	MarkOutboxFailed(ctx context.Context, id uuid.UUID, reason string, retryAfter time.Duration) error
+	MarkOutboxDead(ctx context.Context, id uuid.UUID, reason string) error
+	PruneOutbox(ctx context.Context, publishedBefore time.Time) (int64, error)
+}
+
+// Cache is an interface that contains methods of the person cache
+type Cache interface {
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// Relay moves events from the outbox to the publisher and the cache, every event is delivered at least once.
+// An event that fails maxAttempts times or can never be relayed is dead-lettered: it stays in the outbox with its
+// last error for inspection and isn't claimed again. Published events are deleted once they are older than retention
+type Relay struct {
+	store       Store
+	publisher   events.Publisher
+	cache       Cache
+	batchSize   int
+	maxAttempts int
+	interval    time.Duration
+	retention   time.Duration
+}
+
+// NewRelay accepts Store, events.Publisher and Cache objects, size of a batch, number of attempts per event,
+// poll interval and how long published events are kept, 0 keeps them forever, and returns an object of type *Relay
+func NewRelay(store Store, publisher events.Publisher, cache Cache, batchSize, maxAttempts int, interval, retention time.Duration) *Relay {
+	return &Relay{
+		store:       store,
+		publisher:   publisher,
+		cache:       cache,
+		batchSize:   batchSize,
+		maxAttempts: maxAttempts,
+		interval:    interval,
+		retention:   retention,
+	}
+}
+
+// Run processes the outbox until ctx is done, a full batch is followed by the next one without waiting
+func (r *Relay) Run(ctx context.Context) {
+	var pruned time.Time
+	for {
+		if r.retention > 0 && time.Since(pruned) >= pruneInterval {
+			if _, err := r.Prune(ctx); err != nil {
+				logrus.Errorf("Relay -> Run -> Prune -> error: %v", err)
+			}
+			pruned = time.Now()
+		}
+		processed, err := r.Process(ctx)
+		if err != nil {
+			logrus.Errorf("Relay -> Run -> Process -> error: %v", err)
+		}
+		if processed == r.batchSize && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// Process relays one batch of events and returns how many events were claimed
+func (r *Relay) Process(ctx context.Context) (int, error) {
+	events, err := r.store.ClaimOutbox(ctx, r.batchSize, lease)
+	if err != nil {
+		return 0, fmt.Errorf("Relay -> Process -> store.ClaimOutbox -> error: %w", err)
+	}
+	for i := range events {
+		event := &events[i]
+		if err = r.apply(ctx, event); err != nil {
+			fields := logrus.Fields{"ID": event.ID, "Type": event.Type, "Attempts": event.Attempts + 1}
+			if errors.Is(err, errPoison) || event.Attempts+1 >= r.maxAttempts {
+				logrus.WithFields(fields).Errorf("Relay -> Process -> apply -> event is dead-lettered, error: %v", err)
+				if err = r.store.MarkOutboxDead(ctx, event.ID, err.Error()); err != nil {
+					return len(events), fmt.Errorf("Relay -> Process -> store.MarkOutboxDead -> error: %w", err)
+				}
+				continue
+			}
+			logrus.WithFields(fields).Errorf("Relay -> Process -> apply -> error: %v", err)
+			if err = r.store.MarkOutboxFailed(ctx, event.ID, err.Error(), Backoff(event.Attempts)); err != nil {
+				return len(events), fmt.Errorf("Relay -> Process -> store.MarkOutboxFailed -> error: %w", err)
+			}
+			continue
+		}
+		if err = r.store.MarkOutboxPublished(ctx, event.ID); err != nil {
+			return len(events), fmt.Errorf("Relay -> Process -> store.MarkOutboxPublished -> error: %w", err)
+		}
+	}
+	return len(events), nil
+}
+
+// Prune deletes events published before the retention and returns how many were deleted
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	deleted, err := r.store.PruneOutbox(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		return 0, fmt.Errorf("Relay -> Prune -> store.PruneOutbox -> error: %w", err)
+	}
+	return deleted, nil
+}
+
+// apply publishes event and invalidates cached person. Invalidation doesn't depend on the order
+// of events, so events relayed out of order or twice never leave a stale entry in the cache
+func (r *Relay) apply(ctx context.Context, event *model.OutboxEvent) error {
+	domainEvent, err := events.Decode(event.Payload)
+	if err != nil {
+		return fmt.Errorf("events.Decode -> %v: %w", err, errPoison)
+	}
+	if err = r.publisher.Publish(ctx, domainEvent); err != nil {
+		return fmt.Errorf("publisher.Publish -> error: %w", err)
+	}
+	err = r.cache.Delete(identity.WithTenant(ctx, event.TenantID), event.AggregateID)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("cache.Delete -> error: %w", err)
+	}
+	return nil
+}
+
+// Backoff returns delay before the next attempt of an event that has already failed the given number of times
+func Backoff(attempts int) time.Duration {
+	delay := retryBase
+	for i := 0; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	if delay > retryMax {
+		return retryMax
+	}
+	return delay
+}
@@ -0,0 +1,210 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore keeps outbox events in memory
+type fakeStore struct {
+	mu        sync.Mutex
+	events    []model.OutboxEvent
+	published map[uuid.UUID]bool
+	failed    map[uuid.UUID]time.Duration
+	dead      map[uuid.UUID]string
+	prunedAt  time.Time
+}
+
+func newFakeStore(events ...model.OutboxEvent) *fakeStore {
+	return &fakeStore{
+		events:    events,
+		published: map[uuid.UUID]bool{},
+		failed:    map[uuid.UUID]time.Duration{},
+		dead:      map[uuid.UUID]string{},
+	}
+}
+
+func (s *fakeStore) ClaimOutbox(_ context.Context, limit int, _ time.Duration) ([]model.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []model.OutboxEvent
+	for _, event := range s.events {
+		if len(claimed) == limit {
+			break
+		}
+		if _, ok := s.failed[event.ID]; ok || s.published[event.ID] || s.dead[event.ID] != "" {
+			continue
+		}
+		claimed = append(claimed, event)
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) MarkOutboxPublished(_ context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published[id] = true
+	return nil
+}
+
+func (s *fakeStore) MarkOutboxFailed(_ context.Context, id uuid.UUID, _ string, retryAfter time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed[id] = retryAfter
+	return nil
+}
+
+func (s *fakeStore) MarkOutboxDead(_ context.Context, id uuid.UUID, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dead[id] = reason
+	return nil
+}
+
+func (s *fakeStore) PruneOutbox(_ context.Context, publishedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prunedAt = publishedBefore
+	return int64(len(s.published)), nil
+}
+
+// fakePublisher records published events and fails the ones listed in failFor
+type fakePublisher struct {
+	published []uuid.UUID
+	failFor   map[uuid.UUID]bool
+}
+
+func (p *fakePublisher) Publish(_ context.Context, event *events.Event) error {
+	if p.failFor[event.ID] {
+		return fmt.Errorf("stream is down")
+	}
+	p.published = append(p.published, event.ID)
+	return nil
+}
+
+// fakeCache records invalidated persons with the tenant taken from context
+type fakeCache struct {
+	deleted map[uuid.UUID]uuid.UUID
+}
+
+func (c *fakeCache) Delete(ctx context.Context, id uuid.UUID) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	c.deleted[id] = tenantID
+	return nil
+}
+
+func newEvent() model.OutboxEvent {
+	tenantID, aggregateID := uuid.New(), uuid.New()
+	event, err := events.New(identity.WithTenant(context.Background(), tenantID), events.PersonUpdated, aggregateID, model.Person{ID: aggregateID})
+	if err != nil {
+		panic(err)
+	}
+	eventJSON, err := events.Encode(event)
+	if err != nil {
+		panic(err)
+	}
+	return model.OutboxEvent{ID: event.ID, TenantID: tenantID, AggregateID: aggregateID, Type: event.Type, Payload: eventJSON}
+}
+
+func TestProcessPublishesAndInvalidates(t *testing.T) {
+	first, second := newEvent(), newEvent()
+	store := newFakeStore(first, second)
+	publisher := &fakePublisher{}
+	cache := &fakeCache{deleted: map[uuid.UUID]uuid.UUID{}}
+	relay := NewRelay(store, publisher, cache, 10, 5, time.Second, time.Hour)
+
+	processed, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, processed)
+	require.Equal(t, []uuid.UUID{first.ID, second.ID}, publisher.published)
+	require.True(t, store.published[first.ID])
+	require.True(t, store.published[second.ID])
+	require.Equal(t, first.TenantID, cache.deleted[first.AggregateID])
+	require.Equal(t, second.TenantID, cache.deleted[second.AggregateID])
+}
+
+func TestProcessRetriesFailedEvent(t *testing.T) {
+	failing, ok := newEvent(), newEvent()
+	failing.Attempts = 3
+	store := newFakeStore(failing, ok)
+	publisher := &fakePublisher{failFor: map[uuid.UUID]bool{failing.ID: true}}
+	cache := &fakeCache{deleted: map[uuid.UUID]uuid.UUID{}}
+	relay := NewRelay(store, publisher, cache, 10, 5, time.Second, time.Hour)
+
+	processed, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, processed)
+	require.False(t, store.published[failing.ID])
+	require.Equal(t, Backoff(3), store.failed[failing.ID])
+	require.True(t, store.published[ok.ID])
+}
+
+func TestProcessRespectsBatchSize(t *testing.T) {
+	store := newFakeStore(newEvent(), newEvent(), newEvent())
+	relay := NewRelay(store, &fakePublisher{}, &fakeCache{deleted: map[uuid.UUID]uuid.UUID{}}, 2, 5, time.Second, time.Hour)
+
+	processed, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, processed)
+	processed, err = relay.Process(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, processed)
+}
+
+func TestBackoff(t *testing.T) {
+	require.Equal(t, time.Second, Backoff(0))
+	require.Equal(t, 2*time.Second, Backoff(1))
+	require.Equal(t, 8*time.Second, Backoff(3))
+	require.Equal(t, retryMax, Backoff(100))
+}
+
+func TestProcessFailsUndecodableEvent(t *testing.T) {
+	broken := newEvent()
+	broken.Payload = []byte(`{"schemaVersion":99}`)
+	store := newFakeStore(broken)
+	publisher := &fakePublisher{}
+	relay := NewRelay(store, publisher, &fakeCache{deleted: map[uuid.UUID]uuid.UUID{}}, 10, 5, time.Second, time.Hour)
+
+	_, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, publisher.published)
+	require.NotContains(t, store.failed, broken.ID)
+	require.Contains(t, store.dead, broken.ID)
+}
+
+func TestProcessDeadLettersAfterMaxAttempts(t *testing.T) {
+	failing := newEvent()
+	failing.Attempts = 4
+	store := newFakeStore(failing)
+	publisher := &fakePublisher{failFor: map[uuid.UUID]bool{failing.ID: true}}
+	relay := NewRelay(store, publisher, &fakeCache{deleted: map[uuid.UUID]uuid.UUID{}}, 10, 5, time.Second, time.Hour)
+
+	_, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	require.NotContains(t, store.failed, failing.ID)
+	require.Contains(t, store.dead[failing.ID], "stream is down")
+	processed, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, processed)
+}
+
+func TestPruneDeletesEventsOlderThanRetention(t *testing.T) {
+	store := newFakeStore()
+	relay := NewRelay(store, &fakePublisher{}, &fakeCache{deleted: map[uuid.UUID]uuid.UUID{}}, 10, 5, time.Second, time.Hour)
+
+	_, err := relay.Prune(context.Background())
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(-time.Hour), store.prunedAt, time.Second)
+}
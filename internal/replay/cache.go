@@ -0,0 +1,53 @@
+// Package replay re-applies person events kept in the stream to projections, so read models can be rebuilt from the history
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Cache is an interface that contains methods of the person cache
+type Cache interface {
+	Delete(ctx context.Context, id uuid.UUID) error
+	Flush(ctx context.Context) error
+}
+
+// CacheProjection rebuilds the redis cache of persons. A replayed event may be older than a write made after the
+// replayed range or not yet relayed from the outbox, so the projection never caches the state of an event, it only
+// invalidates the person and the next read loads the current state from the database
+type CacheProjection struct {
+	cache Cache
+}
+
+// NewCacheProjection accepts Cache and returns an object of type *CacheProjection
+func NewCacheProjection(cache Cache) *CacheProjection {
+	return &CacheProjection{cache: cache}
+}
+
+// Name returns name of the projection used by the CLI
+func (p *CacheProjection) Name() string {
+	return "cache"
+}
+
+// Reset deletes cached persons of all tenants
+func (p *CacheProjection) Reset(ctx context.Context) error {
+	return p.cache.Flush(ctx)
+}
+
+// Apply deletes the cached person the event is about, so loads that started before it can't cache an older state
+// either. Applying the same event twice gives the same cache
+func (p *CacheProjection) Apply(ctx context.Context, event *events.Event) error {
+	if !events.IsPersonEvent(event.Type) {
+		return nil
+	}
+	err := p.cache.Delete(ctx, event.AggregateID)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("CacheProjection -> Apply -> cache.Delete -> error: %w", err)
+	}
+	return nil
+}
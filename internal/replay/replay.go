@@ -0,0 +1,138 @@
+// Package replay re-applies person events kept in the stream to projections, so read models can be rebuilt from the history
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/sirupsen/logrus"
+)
+
+// batchSize is how many messages are read from the stream at once
+const batchSize = 500
+
+// Open ends of the stream range
+const (
+	First = "-"
+	Last  = "+"
+)
+
+// ErrUnknownProjection means that no projection is registered under the given name
+var ErrUnknownProjection = errors.New("unknown projection")
+
+// Projection is a read model built from person events. Apply must be idempotent,
+// because a replay may apply events the projection has already seen
+type Projection interface {
+	Name() string
+	Reset(ctx context.Context) error
+	Apply(ctx context.Context, event *events.Event) error
+}
+
+// Reader is an interface that reads a range of the event stream
+type Reader interface {
+	Range(ctx context.Context, start, end string, count int64) ([]events.Change, string, error)
+}
+
+// Result contains outcome of the replay, LastID is the stream ID of the last applied event
+type Result struct {
+	Applied int
+	LastID  string
+}
+
+// Service replays ranges of the stream to the registered projections
+type Service struct {
+	reader      Reader
+	projections map[string]Projection
+}
+
+// NewService accepts Reader and projections and returns an object of type *Service
+func NewService(reader Reader, projections ...Projection) *Service {
+	srv := &Service{reader: reader, projections: map[string]Projection{}}
+	for _, projection := range projections {
+		srv.projections[projection.Name()] = projection
+	}
+	return srv
+}
+
+// Projections returns sorted names of the registered projections
+func (srv *Service) Projections() []string {
+	names := make([]string, 0, len(srv.projections))
+	for name := range srv.projections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Replay applies events with stream IDs from start to end inclusive to the projection in the order of the stream.
+// Reading goes on until the stream is exhausted, so events published during the replay are applied too when end is Last.
+// On failure Result tells the last applied event, the replay can be repeated from it
+func (srv *Service) Replay(ctx context.Context, name, start, end string) (Result, error) {
+	var result Result
+	projection, ok := srv.projections[name]
+	if !ok {
+		return result, fmt.Errorf("Service -> Replay -> %q: %w", name, ErrUnknownProjection)
+	}
+	for {
+		changes, lastID, err := srv.reader.Range(ctx, start, end, batchSize)
+		if err != nil {
+			return result, fmt.Errorf("Service -> Replay -> reader.Range -> error: %w", err)
+		}
+		if lastID == "" {
+			return result, nil
+		}
+		for i := range changes {
+			change := &changes[i]
+			err = projection.Apply(identity.WithTenant(ctx, change.Event.TenantID), change.Event)
+			if err != nil {
+				return result, fmt.Errorf("Service -> Replay -> %s.Apply %s -> error: %w", name, change.StreamID, err)
+			}
+			result.Applied++
+			result.LastID = change.StreamID
+		}
+		start = "(" + lastID
+		logrus.WithFields(logrus.Fields{"Projection": name, "Applied": result.Applied, "Position": lastID}).Info("replay progress")
+	}
+}
+
+// Rebuild resets the projection and replays the whole stream to it
+func (srv *Service) Rebuild(ctx context.Context, name string) (Result, error) {
+	projection, ok := srv.projections[name]
+	if !ok {
+		return Result{}, fmt.Errorf("Service -> Rebuild -> %q: %w", name, ErrUnknownProjection)
+	}
+	if err := projection.Reset(ctx); err != nil {
+		return Result{}, fmt.Errorf("Service -> Rebuild -> %s.Reset -> error: %w", name, err)
+	}
+	return srv.Replay(ctx, name, First, Last)
+}
+
+// ParsePosition converts bound of the range given as a stream ID or an RFC3339 timestamp to a stream ID.
+// Timestamp of the start covers all events of its millisecond, timestamp of the end does the same
+func ParsePosition(value string, end bool) (string, error) {
+	if value == "" || value == First || value == Last || events.ValidStreamID(value) {
+		return value, nil
+	}
+	if ms, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return streamIDAt(ms, end), nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return "", fmt.Errorf("ParsePosition -> %q is neither stream ID nor RFC3339 time", value)
+	}
+	return streamIDAt(uint64(at.UnixMilli()), end), nil
+}
+
+// streamIDAt returns the first or the last possible stream ID of the millisecond
+func streamIDAt(ms uint64, end bool) string {
+	if end {
+		return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(^uint64(0), 10)
+	}
+	return strconv.FormatUint(ms, 10) + "-0"
+}
@@ -0,0 +1,165 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReader serves a stream kept in memory
+type fakeReader struct {
+	changes []events.Change
+}
+
+func (r *fakeReader) Range(_ context.Context, start, end string, count int64) ([]events.Change, string, error) {
+	var changes []events.Change
+	lastID := ""
+	for _, change := range r.changes {
+		if int64(len(changes)) == count {
+			break
+		}
+		if !inRange(change.StreamID, start, end) {
+			continue
+		}
+		changes = append(changes, change)
+		lastID = change.StreamID
+	}
+	return changes, lastID, nil
+}
+
+func inRange(id, start, end string) bool {
+	switch {
+	case start == First:
+	case start[0] == '(':
+		if events.CompareStreamIDs(id, start[1:]) <= 0 {
+			return false
+		}
+	case events.CompareStreamIDs(id, start) < 0:
+		return false
+	}
+	return end == Last || events.CompareStreamIDs(id, end) <= 0
+}
+
+// fakeCache keeps persons of all tenants in memory
+type fakeCache struct {
+	persons map[uuid.UUID]model.Person
+	failOn  uuid.UUID
+}
+
+func (c *fakeCache) Delete(_ context.Context, id uuid.UUID) error {
+	if id == c.failOn {
+		return errors.New("redis is down")
+	}
+	delete(c.persons, id)
+	return nil
+}
+
+func (c *fakeCache) Flush(_ context.Context) error {
+	c.persons = map[uuid.UUID]model.Person{}
+	return nil
+}
+
+func newChange(t *testing.T, streamID, eventType string, pers model.Person) events.Change {
+	ctx := identity.WithTenant(context.Background(), uuid.New())
+	var payload interface{} = pers
+	if eventType == events.PersonDeleted {
+		payload = events.PersonDeletedPayload{ID: pers.ID}
+	}
+	event, err := events.New(ctx, eventType, pers.ID, payload)
+	require.NoError(t, err)
+	return events.Change{StreamID: streamID, Event: event}
+}
+
+func TestRebuildCache(t *testing.T) {
+	kept := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	gone := model.Person{ID: uuid.New(), Salary: 700, Profession: "lawyer"}
+	updated := kept
+	updated.Salary = 900
+	reader := &fakeReader{changes: []events.Change{
+		newChange(t, "1-0", events.PersonCreated, kept),
+		newChange(t, "2-0", events.PersonCreated, gone),
+		newChange(t, "3-0", events.PersonUpdated, updated),
+		newChange(t, "4-0", events.PersonDeleted, gone),
+	}}
+	stale := model.Person{ID: uuid.New(), Salary: 100, Profession: "ghost"}
+	cache := &fakeCache{persons: map[uuid.UUID]model.Person{stale.ID: stale, kept.ID: kept}}
+	srv := NewService(reader, NewCacheProjection(cache))
+
+	result, err := srv.Rebuild(context.Background(), "cache")
+	require.NoError(t, err)
+	require.Equal(t, Result{Applied: 4, LastID: "4-0"}, result)
+	require.Empty(t, cache.persons, "persons are loaded from the database by the next read")
+
+	cache.persons[kept.ID] = updated
+	result, err = srv.Replay(context.Background(), "cache", "2-0", Last)
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Applied)
+	require.Empty(t, cache.persons, "replay must be idempotent")
+}
+
+func TestReplayDoesntCacheOlderStates(t *testing.T) {
+	pers := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	older := pers
+	older.Salary = 600
+	newer := pers
+	newer.Salary = 700
+	reader := &fakeReader{changes: []events.Change{
+		newChange(t, "1-0", events.PersonCreated, pers),
+		newChange(t, "2-0", events.PersonUpdated, older),
+		newChange(t, "3-0", events.PersonUpdated, newer),
+	}}
+	// the newer write is after the replayed range and is cached already
+	cache := &fakeCache{persons: map[uuid.UUID]model.Person{pers.ID: newer}}
+
+	result, err := NewService(reader, NewCacheProjection(cache)).Replay(context.Background(), "cache", First, "2-0")
+	require.NoError(t, err)
+	require.Equal(t, Result{Applied: 2, LastID: "2-0"}, result)
+	cached, ok := cache.persons[pers.ID]
+	require.False(t, ok, "the replayed state %+v must not replace the newer one", cached)
+}
+
+func TestReplayStopsOnFailure(t *testing.T) {
+	first := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	broken := model.Person{ID: uuid.New(), Salary: 700, Profession: "lawyer"}
+	reader := &fakeReader{changes: []events.Change{
+		newChange(t, "1-0", events.PersonCreated, first),
+		newChange(t, "2-0", events.PersonCreated, broken),
+	}}
+	cache := &fakeCache{persons: map[uuid.UUID]model.Person{}, failOn: broken.ID}
+
+	result, err := NewService(reader, NewCacheProjection(cache)).Replay(context.Background(), "cache", First, Last)
+	require.Error(t, err)
+	require.Equal(t, Result{Applied: 1, LastID: "1-0"}, result)
+}
+
+func TestReplayUnknownProjection(t *testing.T) {
+	_, err := NewService(&fakeReader{}).Rebuild(context.Background(), "search")
+	require.True(t, errors.Is(err, ErrUnknownProjection))
+}
+
+func TestParsePosition(t *testing.T) {
+	testTable := []struct {
+		value    string
+		end      bool
+		expected string
+	}{
+		{value: "1700000000000-3", expected: "1700000000000-3"},
+		{value: "-", expected: "-"},
+		{value: "1700000000000", expected: "1700000000000-0"},
+		{value: "2023-11-14T22:13:20Z", expected: "1700000000000-0"},
+		{value: "2023-11-14T22:13:20Z", end: true, expected: "1700000000000-18446744073709551615"},
+	}
+	for _, tc := range testTable {
+		position, err := ParsePosition(tc.value, tc.end)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, position)
+	}
+	_, err := ParsePosition("yesterday", false)
+	require.Error(t, err)
+}
@@ -1,10 +1,32 @@
 // Package repository error.go contains errors
 package repository
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/distuurbia/firstTask/internal/model"
+)
 
 // ErrNil means that u've given nil entity for a create method
 var ErrNil = fmt.Errorf("entity that u've given is nil")
 
 // ErrExist means that u've given username that already exist
-var ErrExist = fmt.Errorf("such username already exist")
+var ErrExist = fmt.Errorf("such username already exist: %w", model.ErrExist)
+
+// ErrPersonExist means that a person with such ID already exist
+var ErrPersonExist = fmt.Errorf("such person already exist: %w", model.ErrExist)
+
+// ErrPersonNotFound means that there is no person with such ID
+var ErrPersonNotFound = fmt.Errorf("such person doesn't exist: %w", model.ErrNotFound)
+
+// ErrImageNotFound means that there is no image with such ID
+var ErrImageNotFound = fmt.Errorf("such image doesn't exist: %w", model.ErrNotFound)
+
+// ErrImageContentNotFound means that the tenant has no content with such SHA-256
+var ErrImageContentNotFound = fmt.Errorf("such image content doesn't exist: %w", model.ErrNotFound)
+
+// ErrTenantNotFound means that u've given tenant that isn't registered
+var ErrTenantNotFound = fmt.Errorf("such tenant doesn't exist: %w", model.ErrNotFound)
+
+// ErrTxRollbackOnly means that the transaction is rolled back because a nested WithTx failed
+var ErrTxRollbackOnly = fmt.Errorf("transaction is rolled back because a nested transaction failed")
@@ -0,0 +1,273 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// resubscribeDelay is how long LRU waits before it subscribes to invalidations again after a failure
+	resubscribeDelay = time.Second
+	// lruStripes is how many generations LRU keeps, an invalidation of one person only spoils reads of its stripe
+	lruStripes = 256
+)
+
+// PersonCache is an interface of the cache tier that LRU keeps persons of
+type PersonCache interface {
+	Set(ctx context.Context, pers *model.Person) error
+	SetMissing(ctx context.Context, id uuid.UUID) error
+	Version(ctx context.Context, id uuid.UUID) (int64, error)
+	Fill(ctx context.Context, id uuid.UUID, pers *model.Person, version int64) error
+	Get(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// lruEntry is a person kept in memory, nil person means that the person is cached as missing
+type lruEntry struct {
+	key       string
+	pers      *model.Person
+	expiresAt time.Time
+	// nextExpiresAt is when the entry expires in the next tier, it is reported by Get so early refresh works as without LRU
+	nextExpiresAt time.Time
+}
+
+// LRU keeps the most recently read persons in memory in front of the next cache tier.
+// Changes made by any replica are announced through InvalidationChannel, Run must be running to receive them
+type LRU struct {
+	next    PersonCache
+	client  *redis.Client
+	size    int
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	// generations are increased by invalidations of persons of their stripe, an entry read from the next tier is kept
+	// only if its stripe wasn't invalidated while it was read, otherwise it may be older than the invalidation.
+	// Invalidations of a tenant or of all persons increase all of them
+	generations [lruStripes]uint64
+	hits        atomic.Int64
+	misses      atomic.Int64
+}
+
+// NewLRU accepts the next cache tier, the client to receive invalidations with, maximum number of kept persons and
+// how long a person is kept and returns an object of type *LRU
+func NewLRU(next PersonCache, client *redis.Client, size int, ttl time.Duration) *LRU {
+	return &LRU{next: next, client: client, size: size, ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Set sets cache of person in the next tier and drops the kept copy. The person isn't kept in memory until it is read,
+// because only Get knows when the entry of the next tier expires
+func (l *LRU) Set(ctx context.Context, pers *model.Person) error {
+	if err := l.invalidate(ctx, pers.ID); err != nil {
+		return fmt.Errorf("LRU -> Set -> error: %w", err)
+	}
+	if err := l.next.Set(ctx, pers); err != nil {
+		return fmt.Errorf("LRU -> Set -> error: %w", err)
+	}
+	return nil
+}
+
+// SetMissing caches that person with the id doesn't exist in the next tier and drops the kept copy
+func (l *LRU) SetMissing(ctx context.Context, id uuid.UUID) error {
+	if err := l.invalidate(ctx, id); err != nil {
+		return fmt.Errorf("LRU -> SetMissing -> error: %w", err)
+	}
+	if err := l.next.SetMissing(ctx, id); err != nil {
+		return fmt.Errorf("LRU -> SetMissing -> error: %w", err)
+	}
+	return nil
+}
+
+// Version returns the number of writes of the person kept by the next tier
+func (l *LRU) Version(ctx context.Context, id uuid.UUID) (int64, error) {
+	version, err := l.next.Version(ctx, id)
+	if err != nil {
+		return 0, fmt.Errorf("LRU -> Version -> error: %w", err)
+	}
+	return version, nil
+}
+
+// Fill caches the loaded person in the next tier unless it was written since version, see Redis.Fill.
+// The person is kept in memory when it is read, like after Set
+func (l *LRU) Fill(ctx context.Context, id uuid.UUID, pers *model.Person, version int64) error {
+	if err := l.next.Fill(ctx, id, pers, version); err != nil {
+		return fmt.Errorf("LRU -> Fill -> error: %w", err)
+	}
+	return nil
+}
+
+// Get gets cache of person from memory or from the next tier. It returns redis.Nil if the person isn't cached
+// and nil person if the person is cached as missing
+func (l *LRU) Get(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("LRU -> Get -> error: %w", err)
+	}
+	key := invalidation(tenantID, id)
+	now := time.Now()
+	l.mu.Lock()
+	if element, ok := l.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		if now.Before(entry.expiresAt) {
+			l.order.MoveToFront(element)
+			l.mu.Unlock()
+			l.hits.Add(1)
+			return entry.pers.Copy(), entry.nextExpiresAt.Sub(now), nil
+		}
+		l.remove(element)
+	}
+	generation := l.generations[stripe(key)]
+	l.mu.Unlock()
+	l.misses.Add(1)
+
+	pers, ttl, err := l.next.Get(ctx, id)
+	if err != nil {
+		if err == redis.Nil {
+			return nil, 0, err
+		}
+		return nil, 0, fmt.Errorf("LRU -> Get -> error: %w", err)
+	}
+	localTTL := l.ttl
+	if ttl < localTTL {
+		localTTL = ttl
+	}
+	l.store(generation, &lruEntry{key: key, pers: pers, expiresAt: now.Add(localTTL), nextExpiresAt: now.Add(ttl)})
+	return pers.Copy(), ttl, nil
+}
+
+// Delete deletes cache of person from memory and from the next tier, the next tier announces it to other replicas
+func (l *LRU) Delete(ctx context.Context, id uuid.UUID) error {
+	if err := l.invalidate(ctx, id); err != nil {
+		return fmt.Errorf("LRU -> Delete -> error: %w", err)
+	}
+	if err := l.next.Delete(ctx, id); err != nil {
+		return fmt.Errorf("LRU -> Delete -> error: %w", err)
+	}
+	return nil
+}
+
+// invalidate drops the kept copy of the person of the tenant from context
+func (l *LRU) invalidate(ctx context.Context, id uuid.UUID) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	l.Invalidate(invalidation(tenantID, id))
+	return nil
+}
+
+// Stats returns hit and miss counters of the memory tier and the number of kept persons
+func (l *LRU) Stats() model.CacheTierStats {
+	l.mu.Lock()
+	entries := l.order.Len()
+	l.mu.Unlock()
+	return model.CacheTierStats{Hits: l.hits.Load(), Misses: l.misses.Load(), Entries: entries}
+}
+
+// Invalidate drops kept persons named by the invalidation message, see InvalidationChannel
+func (l *LRU) Invalidate(message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case message == flushAll:
+		l.entries = make(map[string]*list.Element)
+		l.order.Init()
+		l.nextGenerations()
+	case !strings.Contains(message, ":"):
+		for key, element := range l.entries {
+			if strings.HasPrefix(key, message+":") {
+				l.remove(element)
+			}
+		}
+		l.nextGenerations()
+	default:
+		if element, ok := l.entries[message]; ok {
+			l.remove(element)
+		}
+		l.generations[stripe(message)]++
+	}
+}
+
+// Run receives invalidations of other replicas until ctx is canceled. Invalidations sent while LRU wasn't subscribed are lost,
+// so all kept persons are dropped on every subscription
+func (l *LRU) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := l.receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Errorf("LRU -> Run -> receive -> error: %v", err)
+		l.Invalidate(flushAll)
+		select {
+		case <-ctx.Done():
+		case <-time.After(resubscribeDelay):
+		}
+	}
+}
+
+// receive applies invalidations until receiving fails
+func (l *LRU) receive(ctx context.Context) error {
+	pubsub := l.client.Subscribe(ctx, InvalidationChannel)
+	defer pubsub.Close()
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			return fmt.Errorf("pubsub.Receive -> error: %w", err)
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			l.Invalidate(flushAll)
+		case *redis.Message:
+			l.Invalidate(msg.Payload)
+		}
+	}
+}
+
+// store keeps the entry unless an invalidation happened since generation, the least recently read entry is dropped when LRU is full
+func (l *LRU) store(generation uint64, entry *lruEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if generation != l.generations[stripe(entry.key)] || l.size <= 0 {
+		return
+	}
+	if element, ok := l.entries[entry.key]; ok {
+		l.remove(element)
+	}
+	l.entries[entry.key] = l.order.PushFront(entry)
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+}
+
+// nextGenerations increases generations of all stripes, it must be called with mu locked
+func (l *LRU) nextGenerations() {
+	for i := range l.generations {
+		l.generations[i]++
+	}
+}
+
+// stripe returns the stripe of the kept person
+func stripe(key string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % lruStripes)
+}
+
+// remove drops the element, it must be called with mu locked
+func (l *LRU) remove(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.entries, element.Value.(*lruEntry).key)
+}
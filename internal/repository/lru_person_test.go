@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// countingCache counts reads of the wrapped tier
+type countingCache struct {
+	PersonCache
+	gets atomic.Int64
+}
+
+func (c *countingCache) Get(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	c.gets.Add(1)
+	return c.PersonCache.Get(ctx, id)
+}
+
+func newTestLRU(size int) (*LRU, *countingCache) {
+	next := &countingCache{PersonCache: NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 0)}
+	return NewLRU(next, rdsClient, size, time.Minute), next
+}
+
+func Test_LRUGetKeepsPerson(t *testing.T) {
+	lru, next := newTestLRU(10)
+	pers := newTestPerson()
+	require.NoError(t, lru.Set(testCtx, pers))
+	for i := 0; i < 3; i++ {
+		cached, ttl, err := lru.Get(testCtx, pers.ID)
+		require.NoError(t, err)
+		require.Equal(t, pers, cached)
+		require.True(t, ttl > 0 && ttl <= time.Minute)
+	}
+	require.Equal(t, int64(1), next.gets.Load())
+	require.Equal(t, model.CacheTierStats{Hits: 2, Misses: 1, Entries: 1}, lru.Stats())
+}
+
+func Test_LRUGetNotCached(t *testing.T) {
+	lru, _ := newTestLRU(10)
+	_, _, err := lru.Get(testCtx, uuid.New())
+	require.Equal(t, redis.Nil, err)
+}
+
+func Test_LRUEvictsLeastRecentlyRead(t *testing.T) {
+	lru, next := newTestLRU(2)
+	first, second, third := newTestPerson(), newTestPerson(), newTestPerson()
+	for _, pers := range []*model.Person{first, second} {
+		require.NoError(t, lru.Set(testCtx, pers))
+		_, _, err := lru.Get(testCtx, pers.ID)
+		require.NoError(t, err)
+	}
+	_, _, err := lru.Get(testCtx, first.ID)
+	require.NoError(t, err)
+	require.NoError(t, lru.Set(testCtx, third))
+	_, _, err = lru.Get(testCtx, third.ID)
+	require.NoError(t, err)
+	reads := next.gets.Load()
+	_, _, err = lru.Get(testCtx, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, reads, next.gets.Load())
+	_, _, err = lru.Get(testCtx, second.ID)
+	require.NoError(t, err)
+	require.Equal(t, reads+1, next.gets.Load())
+}
+
+func Test_LRUInvalidate(t *testing.T) {
+	lru, _ := newTestLRU(10)
+	pers, other := newTestPerson(), newTestPerson()
+	for _, p := range []*model.Person{pers, other} {
+		require.NoError(t, lru.Set(testCtx, p))
+		_, _, err := lru.Get(testCtx, p.ID)
+		require.NoError(t, err)
+	}
+	lru.Invalidate(invalidation(testTenant.ID, pers.ID))
+	require.Equal(t, 1, lru.Stats().Entries)
+	lru.Invalidate(testTenant.ID.String())
+	require.Zero(t, lru.Stats().Entries)
+}
+
+func Test_LRUReceivesInvalidationsOfOtherReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+	lru, _ := newTestLRU(10)
+	go lru.Run(ctx)
+	other, _ := newTestLRU(10)
+	pers := newTestPerson()
+	require.NoError(t, other.Set(testCtx, pers))
+	require.Eventually(t, func() bool {
+		_, _, err := lru.Get(testCtx, pers.ID)
+		return err == nil && lru.Stats().Entries == 1
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, other.Delete(testCtx, pers.ID))
+	require.Eventually(t, func() bool { return lru.Stats().Entries == 0 }, time.Second, 10*time.Millisecond)
+	_, _, err := lru.Get(testCtx, pers.ID)
+	require.Equal(t, redis.Nil, err)
+}
+
+func Test_LRUInvalidationSpoilsOnlyItsStripe(t *testing.T) {
+	lru, _ := newTestLRU(10)
+	pers := newTestPerson()
+	key := invalidation(testTenant.ID, pers.ID)
+	other := newTestPerson()
+	for stripe(invalidation(testTenant.ID, other.ID)) == stripe(key) {
+		other = newTestPerson()
+	}
+	generation := lru.generations[stripe(key)]
+	lru.Invalidate(invalidation(testTenant.ID, other.ID))
+	lru.store(generation, &lruEntry{key: key, pers: pers, expiresAt: time.Now().Add(time.Minute)})
+	require.Equal(t, 1, lru.Stats().Entries, "an invalidation of another person must not drop the read")
+
+	generation = lru.generations[stripe(key)]
+	lru.Invalidate(key)
+	lru.store(generation, &lruEntry{key: key, pers: pers, expiresAt: time.Now().Add(time.Minute)})
+	require.Zero(t, lru.Stats().Entries, "a read that raced the invalidation of the person must not be kept")
+}
@@ -0,0 +1,302 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateImage creates a document in images collection of the tenant
+func (rpsMongo *Mongo) CreateImage(ctx context.Context, img *model.Image) error {
+	if img == nil {
+		return ErrNil
+	}
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> CreateImage -> error: %w", err)
+	}
+	img.CreatedAt = time.Now().UTC()
+	_, err = db.Collection("images").InsertOne(ctx, img)
+	if err != nil {
+		return fmt.Errorf("Mongo -> CreateImage -> InsertOne -> error: %w", err)
+	}
+	return nil
+}
+
+// GetImage reads metadata of the image of the tenant, it returns ErrImageNotFound if there is no such image
+func (rpsMongo *Mongo) GetImage(ctx context.Context, id uuid.UUID) (*model.Image, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetImage -> error: %w", err)
+	}
+	var img model.Image
+	err = db.Collection("images").FindOne(ctx, bson.M{"_id": id}).Decode(&img)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("Mongo -> GetImage -> error: %w", ErrImageNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetImage -> FindOne -> error: %w", err)
+	}
+	return &img, nil
+}
+
+// GetImages reads a page of images of the owner, newest first
+func (rpsMongo *Mongo) GetImages(ctx context.Context, ownerID uuid.UUID, limit, offset int) ([]model.Image, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetImages -> error: %w", err)
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)).SetSkip(int64(offset))
+	cursor, err := db.Collection("images").Find(ctx, bson.M{"ownerId": ownerID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetImages -> Find -> error: %w", err)
+	}
+	images := []model.Image{}
+	if err = cursor.All(ctx, &images); err != nil {
+		return nil, fmt.Errorf("Mongo -> GetImages -> All -> error: %w", err)
+	}
+	return images, nil
+}
+
+// DeleteImage deletes metadata of the image of the tenant and unsets the avatar of persons that have it in one
+// transaction, like the foreign key of postgreSQL does. It returns ErrImageNotFound if there is no such image
+func (rpsMongo *Mongo) DeleteImage(ctx context.Context, id uuid.UUID) error {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> DeleteImage -> error: %w", err)
+	}
+	err = rpsMongo.WithTx(ctx, func(ctx context.Context) error {
+		res, err := db.Collection("images").DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			return fmt.Errorf("DeleteOne -> error: %w", err)
+		}
+		if res.DeletedCount == 0 {
+			return ErrImageNotFound
+		}
+		_, err = db.Collection("persons").UpdateMany(ctx, bson.M{"avatarImageId": id}, bson.M{"$unset": bson.M{"avatarImageId": ""}})
+		if err != nil {
+			return fmt.Errorf("UpdateMany -> error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Mongo -> DeleteImage -> error: %w", err)
+	}
+	return nil
+}
+
+// ImageContentKey returns the key of the content with the SHA-256 of the tenant, it returns ErrImageContentNotFound
+// if the tenant has no such content
+func (rpsMongo *Mongo) ImageContentKey(ctx context.Context, sha256 string) (string, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Mongo -> ImageContentKey -> error: %w", err)
+	}
+	var content model.ImageContent
+	err = db.Collection("imageContents").FindOne(ctx, bson.M{"_id": sha256}).Decode(&content)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("Mongo -> ImageContentKey -> error: %w", ErrImageContentNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Mongo -> ImageContentKey -> FindOne -> error: %w", err)
+	}
+	return content.Key, nil
+}
+
+// AddImageRef counts one more image that refers to the content with the SHA-256 of the tenant and returns the key
+// of the content. If the tenant has no such content, it is recorded under the key, or ErrImageContentNotFound is
+// returned if the key is empty
+func (rpsMongo *Mongo) AddImageRef(ctx context.Context, sha256, key string) (string, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Mongo -> AddImageRef -> error: %w", err)
+	}
+	update := bson.M{"$inc": bson.M{"refs": 1}, "$unset": bson.M{"unreferencedAt": ""}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if key != "" {
+		update["$setOnInsert"] = bson.M{"key": key}
+		opts.SetUpsert(true)
+	}
+	var content model.ImageContent
+	err = db.Collection("imageContents").FindOneAndUpdate(ctx, bson.M{"_id": sha256}, update, opts).Decode(&content)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", fmt.Errorf("Mongo -> AddImageRef -> error: %w", ErrImageContentNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Mongo -> AddImageRef -> FindOneAndUpdate -> error: %w", err)
+	}
+	return content.Key, nil
+}
+
+// ReleaseImageRef counts one image less that refers to the content with the SHA-256 of the tenant. The content that
+// loses its last reference is kept and marked as unreferenced, see UnreferencedImageContents
+func (rpsMongo *Mongo) ReleaseImageRef(ctx context.Context, sha256 string) error {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> ReleaseImageRef -> error: %w", err)
+	}
+	_, err = db.Collection("imageContents").UpdateOne(ctx, bson.M{"_id": sha256, "refs": bson.M{"$gt": 0}}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"refs":           bson.M{"$subtract": bson.A{"$refs", 1}},
+			"unreferencedAt": bson.M{"$cond": bson.A{bson.M{"$lte": bson.A{"$refs", 1}}, "$$NOW", "$unreferencedAt"}},
+		}}},
+	})
+	if err != nil {
+		return fmt.Errorf("Mongo -> ReleaseImageRef -> UpdateOne -> error: %w", err)
+	}
+	return nil
+}
+
+// UnreferencedImageContents returns at most limit contents of all tenants that have had no references since before
+func (rpsMongo *Mongo) UnreferencedImageContents(ctx context.Context, before time.Time, limit int) ([]model.ImageContent, error) {
+	cursor, err := rpsMongo.client.Database(mongoRegistryDB).Collection("tenants").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> UnreferencedImageContents -> Find -> error: %w", err)
+	}
+	var tenants []model.Tenant
+	if err = cursor.All(ctx, &tenants); err != nil {
+		return nil, fmt.Errorf("Mongo -> UnreferencedImageContents -> All -> error: %w", err)
+	}
+	contents := []model.ImageContent{}
+	for _, tenant := range tenants {
+		if len(contents) >= limit {
+			break
+		}
+		coll := rpsMongo.client.Database(tenantDBName(tenant.ID)).Collection("imageContents")
+		opts := options.Find().SetSort(bson.D{{Key: "unreferencedAt", Value: 1}}).SetLimit(int64(limit - len(contents)))
+		cursor, err = coll.Find(ctx, bson.M{"refs": 0, "unreferencedAt": bson.M{"$lte": before}}, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Mongo -> UnreferencedImageContents -> Find -> error: %w", err)
+		}
+		var found []model.ImageContent
+		if err = cursor.All(ctx, &found); err != nil {
+			return nil, fmt.Errorf("Mongo -> UnreferencedImageContents -> All -> error: %w", err)
+		}
+		for i := range found {
+			found[i].TenantID = tenant.ID
+		}
+		contents = append(contents, found...)
+	}
+	return contents, nil
+}
+
+// DeleteImageContent deletes the record of the content if it still has no references and the same key,
+// and tells if it was deleted
+func (rpsMongo *Mongo) DeleteImageContent(ctx context.Context, content *model.ImageContent) (bool, error) {
+	coll := rpsMongo.client.Database(tenantDBName(content.TenantID)).Collection("imageContents")
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": content.SHA256, "key": content.Key, "refs": 0})
+	if err != nil {
+		return false, fmt.Errorf("Mongo -> DeleteImageContent -> DeleteOne -> error: %w", err)
+	}
+	return res.DeletedCount != 0, nil
+}
+
+// ReserveImageUsage adds size bytes to the usage of the owner unless it would exceed the quota, then it returns
+// model.ErrQuotaExceeded. The usage is matched only if the size fits, so the upsert of a full usage fails with
+// a duplicate key instead of incrementing it. quota 0 means no quota
+func (rpsMongo *Mongo) ReserveImageUsage(ctx context.Context, ownerID uuid.UUID, size, quota int64) error {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> ReserveImageUsage -> error: %w", err)
+	}
+	filter := bson.M{"_id": ownerID}
+	if quota > 0 {
+		if size > quota {
+			return fmt.Errorf("Mongo -> ReserveImageUsage -> error: %w", model.ErrQuotaExceeded)
+		}
+		filter["bytes"] = bson.M{"$lte": quota - size}
+	}
+	_, err = db.Collection("imageUsage").UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"bytes": size}}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("Mongo -> ReserveImageUsage -> error: %w", model.ErrQuotaExceeded)
+	}
+	if err != nil {
+		return fmt.Errorf("Mongo -> ReserveImageUsage -> UpdateOne -> error: %w", err)
+	}
+	return nil
+}
+
+// ReleaseImageUsage subtracts size bytes from the usage of the owner
+func (rpsMongo *Mongo) ReleaseImageUsage(ctx context.Context, ownerID uuid.UUID, size int64) error {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> ReleaseImageUsage -> error: %w", err)
+	}
+	_, err = db.Collection("imageUsage").UpdateOne(ctx, bson.M{"_id": ownerID}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"bytes": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{"$bytes", size}}}}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("Mongo -> ReleaseImageUsage -> UpdateOne -> error: %w", err)
+	}
+	return nil
+}
+
+// ImageUsage returns the number and the size of images of the owner
+func (rpsMongo *Mongo) ImageUsage(ctx context.Context, ownerID uuid.UUID) (*model.ImageUsage, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsage -> error: %w", err)
+	}
+	cursor, err := db.Collection("images").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ownerId": ownerID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$ownerId", "images": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": "$size"}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsage -> Aggregate -> error: %w", err)
+	}
+	var usages []model.ImageUsage
+	if err = cursor.All(ctx, &usages); err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsage -> All -> error: %w", err)
+	}
+	if len(usages) == 0 {
+		return &model.ImageUsage{OwnerID: ownerID}, nil
+	}
+	return &usages[0], nil
+}
+
+// ImageUsageReport returns usage of every owner of images of the tenant, the largest first, and the size of all images
+// and of their distinct contents
+func (rpsMongo *Mongo) ImageUsageReport(ctx context.Context) (*model.ImageUsageReport, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsageReport -> error: %w", err)
+	}
+	report := model.ImageUsageReport{Users: []model.ImageUsage{}}
+	cursor, err := db.Collection("images").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$ownerId", "images": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": "$size"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "bytes", Value: -1}, {Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsageReport -> Aggregate -> error: %w", err)
+	}
+	if err = cursor.All(ctx, &report.Users); err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsageReport -> All -> error: %w", err)
+	}
+	cursor, err = db.Collection("images").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$sha256", "size": bson.M{"$max": "$size"}, "bytes": bson.M{"$sum": "$size"}}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "stored": bson.M{"$sum": "$size"}, "bytes": bson.M{"$sum": "$bytes"}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsageReport -> Aggregate -> error: %w", err)
+	}
+	var totals []struct {
+		Stored int64 `bson:"stored"`
+		Bytes  int64 `bson:"bytes"`
+	}
+	if err = cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("Mongo -> ImageUsageReport -> All -> error: %w", err)
+	}
+	if len(totals) != 0 {
+		report.Bytes, report.StoredBytes = totals[0].Bytes, totals[0].Stored
+	}
+	return &report, nil
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MongoImageLifecycle(t *testing.T) {
+	img := newTestImage(uuid.New())
+	require.NoError(t, rpsMongo.CreateImage(testCtx, &img))
+
+	stored, err := rpsMongo.GetImage(testCtx, img.ID)
+	require.NoError(t, err)
+	require.Equal(t, img.OwnerID, stored.OwnerID)
+	require.Equal(t, img.Filename, stored.Filename)
+
+	require.NoError(t, rpsMongo.DeleteImage(testCtx, img.ID))
+	_, err = rpsMongo.GetImage(testCtx, img.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+	err = rpsMongo.DeleteImage(testCtx, img.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_MongoGetImagesPages(t *testing.T) {
+	ownerID := uuid.New()
+	var created []uuid.UUID
+	for i := 0; i < 3; i++ {
+		img := newTestImage(ownerID)
+		require.NoError(t, rpsMongo.CreateImage(testCtx, &img))
+		created = append(created, img.ID)
+	}
+	other := newTestImage(uuid.New())
+	require.NoError(t, rpsMongo.CreateImage(testCtx, &other))
+
+	first, err := rpsMongo.GetImages(testCtx, ownerID, 2, 0)
+	require.NoError(t, err)
+	second, err := rpsMongo.GetImages(testCtx, ownerID, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	require.Len(t, second, 1)
+	require.ElementsMatch(t, created, append(imageIDs(first), imageIDs(second)...))
+}
+
+func Test_MongoImageUsage(t *testing.T) {
+	ownerID := uuid.New()
+	sha := uuid.NewString()
+	for i := 0; i < 2; i++ {
+		img := newTestImage(ownerID)
+		img.SHA256 = sha
+		require.NoError(t, rpsMongo.CreateImage(testCtx, &img))
+	}
+	usage, err := rpsMongo.ImageUsage(testCtx, ownerID)
+	require.NoError(t, err)
+	require.Equal(t, model.ImageUsage{OwnerID: ownerID, Images: 2, Bytes: 2048}, *usage)
+
+	report, err := rpsMongo.ImageUsageReport(testCtx)
+	require.NoError(t, err)
+	require.Contains(t, report.Users, model.ImageUsage{OwnerID: ownerID, Images: 2, Bytes: 2048})
+	require.Less(t, report.StoredBytes, report.Bytes)
+}
+
+func Test_MongoImageContentRefs(t *testing.T) {
+	sha := newTestSHA256()
+	_, err := rpsMongo.ImageContentKey(testCtx, sha)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+	_, err = rpsMongo.AddImageRef(testCtx, sha, "")
+	require.True(t, errors.Is(err, model.ErrNotFound), "a content that isn't recorded isn't created without a key")
+
+	key, err := rpsMongo.AddImageRef(testCtx, sha, "first")
+	require.NoError(t, err)
+	require.Equal(t, "first", key)
+	key, err = rpsMongo.AddImageRef(testCtx, sha, "second")
+	require.NoError(t, err)
+	require.Equal(t, "first", key, "the content recorded first is shared")
+	key, err = rpsMongo.ImageContentKey(testCtx, sha)
+	require.NoError(t, err)
+	require.Equal(t, "first", key)
+
+	require.NoError(t, rpsMongo.ReleaseImageRef(testCtx, sha))
+	require.NoError(t, rpsMongo.ReleaseImageRef(testCtx, sha))
+	contents, err := rpsMongo.UnreferencedImageContents(context.Background(), time.Now().Add(time.Minute), 1000)
+	require.NoError(t, err)
+	var found *model.ImageContent
+	for i := range contents {
+		if contents[i].SHA256 == sha {
+			found = &contents[i]
+		}
+	}
+	require.NotNil(t, found)
+	require.Equal(t, testTenant.ID, found.TenantID)
+	require.Equal(t, "first", found.Key)
+
+	_, err = rpsMongo.AddImageRef(testCtx, sha, "")
+	require.NoError(t, err)
+	deleted, err := rpsMongo.DeleteImageContent(context.Background(), found)
+	require.NoError(t, err)
+	require.False(t, deleted, "a content referred to again is kept")
+	require.NoError(t, rpsMongo.ReleaseImageRef(testCtx, sha))
+	deleted, err = rpsMongo.DeleteImageContent(context.Background(), found)
+	require.NoError(t, err)
+	require.True(t, deleted)
+	_, err = rpsMongo.ImageContentKey(testCtx, sha)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_MongoReserveImageUsage(t *testing.T) {
+	ownerID := uuid.New()
+	require.NoError(t, rpsMongo.ReserveImageUsage(testCtx, ownerID, 600, 1000))
+	err := rpsMongo.ReserveImageUsage(testCtx, ownerID, 600, 1000)
+	require.True(t, errors.Is(err, model.ErrQuotaExceeded))
+	require.NoError(t, rpsMongo.ReserveImageUsage(testCtx, ownerID, 400, 1000))
+	require.NoError(t, rpsMongo.ReleaseImageUsage(testCtx, ownerID, 600))
+	require.NoError(t, rpsMongo.ReserveImageUsage(testCtx, ownerID, 600, 1000))
+	require.NoError(t, rpsMongo.ReserveImageUsage(testCtx, ownerID, 5000, 0), "0 means no quota")
+	err = rpsMongo.ReserveImageUsage(testCtx, uuid.New(), 1001, 1000)
+	require.True(t, errors.Is(err, model.ErrQuotaExceeded))
+}
@@ -0,0 +1,166 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// usernameCollationStrength makes comparison of usernames ignore case
+const usernameCollationStrength = 2
+
+// EnsureIndexes creates collections and indexes of every registered tenant database, it is the mongoDB counterpart of sql migrations
+func (rpsMongo *Mongo) EnsureIndexes(ctx context.Context) error {
+	coll := rpsMongo.client.Database(mongoRegistryDB).Collection("tenants")
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return fmt.Errorf("Mongo -> EnsureIndexes -> Find -> error: %w", err)
+	}
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+	for cursor.Next(ctx) {
+		var tenant model.Tenant
+		if err = cursor.Decode(&tenant); err != nil {
+			return fmt.Errorf("Mongo -> EnsureIndexes -> Decode -> error: %w", err)
+		}
+		if err = rpsMongo.ensureTenantIndexes(ctx, tenant.ID); err != nil {
+			return fmt.Errorf("Mongo -> EnsureIndexes -> error: %w", err)
+		}
+	}
+	return cursor.Err()
+}
+
+// ensureTenantIndexes creates indexes of the tenant database, creating an index that already exists is a no-op
+func (rpsMongo *Mongo) ensureTenantIndexes(ctx context.Context, tenantID uuid.UUID) error {
+	db := rpsMongo.client.Database(tenantDBName(tenantID))
+	// username_unique was case-sensitive, it is replaced by username_unique_ci
+	_, err := db.Collection("users").Indexes().DropOne(ctx, "username_unique")
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound")) {
+		return fmt.Errorf("ensureTenantIndexes -> users -> DropOne -> error: %w", err)
+	}
+	if err = checkUsernameDuplicates(ctx, db.Collection("users")); err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> error: %w", err)
+	}
+	_, err = db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetName("username_unique_ci").SetUnique(true).
+			SetCollation(&options.Collation{Locale: "en", Strength: usernameCollationStrength}),
+	})
+	if err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> users -> CreateOne -> error: %w", err)
+	}
+	// usernames are stored lower-cased like in postgres, users created before normalization are lower-cased here
+	_, err = db.Collection("users").UpdateMany(ctx,
+		bson.M{"$expr": bson.M{"$ne": bson.A{"$username", bson.M{"$toLower": "$username"}}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"username": bson.M{"$toLower": "$username"}}}}})
+	if err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> users -> UpdateMany -> error: %w", err)
+	}
+	_, err = db.Collection("webhookDeliveries").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "webhookId", Value: 1}, {Key: "createdAt", Value: -1}},
+		Options: options.Index().SetName("webhook_deliveries_webhook"),
+	})
+	if err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> webhookDeliveries -> CreateOne -> error: %w", err)
+	}
+	_, err = db.Collection("images").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "ownerId", Value: 1}, {Key: "createdAt", Value: -1}},
+		Options: options.Index().SetName("images_owner"),
+	})
+	if err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> images -> CreateOne -> error: %w", err)
+	}
+	_, err = db.Collection("images").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "sha256", Value: 1}},
+		Options: options.Index().SetName("images_sha256"),
+	})
+	if err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> images -> CreateOne -> error: %w", err)
+	}
+	_, err = db.Collection("imageContents").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "unreferencedAt", Value: 1}},
+		Options: options.Index().SetName("image_contents_unreferenced").SetPartialFilterExpression(bson.M{"refs": 0}),
+	})
+	if err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> imageContents -> CreateOne -> error: %w", err)
+	}
+	if err = backfillImageLedgers(ctx, db); err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> error: %w", err)
+	}
+	_, err = db.Collection("persons").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "avatarImageId", Value: 1}},
+		Options: options.Index().SetName("persons_avatar").SetSparse(true),
+	})
+	if err != nil {
+		return fmt.Errorf("ensureTenantIndexes -> persons -> CreateOne -> error: %w", err)
+	}
+	return nil
+}
+
+// backfillImageLedgers counts references of contents and usage of owners of images uploaded before they were counted.
+// Uploads and deletions change the counters in their transaction, so only images without counters are added
+// and counters that exist are kept
+func backfillImageLedgers(ctx context.Context, db *mongo.Database) error {
+	pipelines := map[string]mongo.Pipeline{
+		"imageContents": {
+			{{Key: "$group", Value: bson.M{"_id": "$sha256", "refs": bson.M{"$sum": 1}}}},
+			// contents uploaded before have no generation in their key, empty key means that key
+			{{Key: "$set", Value: bson.M{"key": ""}}},
+			{{Key: "$merge", Value: bson.M{"into": "imageContents", "whenMatched": "keepExisting"}}},
+		},
+		"imageUsage": {
+			{{Key: "$group", Value: bson.M{"_id": "$ownerId", "bytes": bson.M{"$sum": "$size"}}}},
+			{{Key: "$merge", Value: bson.M{"into": "imageUsage", "whenMatched": "keepExisting"}}},
+		},
+	}
+	for name, pipeline := range pipelines {
+		cursor, err := db.Collection("images").Aggregate(ctx, pipeline)
+		if err != nil {
+			return fmt.Errorf("backfillImageLedgers -> %s -> Aggregate -> error: %w", name, err)
+		}
+		if err = cursor.Close(ctx); err != nil {
+			return fmt.Errorf("backfillImageLedgers -> %s -> Close -> error: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkUsernameDuplicates returns an error that lists usernames which differ only by case, they can't be lower-cased
+// and must be renamed or deleted before the case-insensitive index is created
+func checkUsernameDuplicates(ctx context.Context, coll *mongo.Collection) error {
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":       bson.M{"$toLower": "$username"},
+			"usernames": bson.M{"$push": "$username"},
+			"count":     bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("checkUsernameDuplicates -> Aggregate -> error: %w", err)
+	}
+	var duplicates []struct {
+		Usernames []string `bson:"usernames"`
+	}
+	if err = cursor.All(ctx, &duplicates); err != nil {
+		return fmt.Errorf("checkUsernameDuplicates -> All -> error: %w", err)
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+	groups := make([]string, 0, len(duplicates))
+	for _, duplicate := range duplicates {
+		groups = append(groups, strings.Join(duplicate.Usernames, ", "))
+	}
+	return fmt.Errorf("checkUsernameDuplicates -> usernames differ only by case, rename or delete them: %s", strings.Join(groups, "; "))
+}
@@ -0,0 +1,113 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// outboxColl returns outbox collection, it is shared by all tenants so one relay serves all of them
+func (rpsMongo *Mongo) outboxColl() *mongo.Collection {
+	return rpsMongo.client.Database(mongoRegistryDB).Collection("outbox")
+}
+
+// AddToOutbox writes event to outbox collection, called inside of WithTx it becomes a part of the transaction
+func (rpsMongo *Mongo) AddToOutbox(ctx context.Context, event *model.OutboxEvent) error {
+	if event == nil {
+		return ErrNil
+	}
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> AddToOutbox -> error: %w", err)
+	}
+	event.TenantID = tenantID
+	event.CreatedAt = time.Now().UTC()
+	_, err = rpsMongo.outboxColl().InsertOne(ctx, event)
+	if err != nil {
+		return fmt.Errorf("Mongo -> AddToOutbox -> InsertOne -> error: %w", err)
+	}
+	return nil
+}
+
+// ClaimOutbox leases up to limit unpublished events for the given time, so relays of the other replicas skip them
+func (rpsMongo *Mongo) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error) {
+	var events []model.OutboxEvent
+	for len(events) < limit {
+		now := time.Now().UTC()
+		filter := bson.M{
+			"publishedAt": bson.M{"$exists": false},
+			"deadAt":      bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"claimedUntil": bson.M{"$exists": false}},
+				bson.M{"claimedUntil": bson.M{"$lt": now}},
+			},
+		}
+		update := bson.M{"$set": bson.M{"claimedUntil": now.Add(lease)}}
+		opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetReturnDocument(options.After)
+		var event model.OutboxEvent
+		err := rpsMongo.outboxColl().FindOneAndUpdate(ctx, filter, update, opts).Decode(&event)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		}
+		if err != nil {
+			return events, fmt.Errorf("Mongo -> ClaimOutbox -> FindOneAndUpdate -> error: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// MarkOutboxPublished marks event as published
+func (rpsMongo *Mongo) MarkOutboxPublished(ctx context.Context, id uuid.UUID) error {
+	update := bson.M{"$set": bson.M{"publishedAt": time.Now().UTC()}, "$unset": bson.M{"claimedUntil": ""}}
+	_, err := rpsMongo.outboxColl().UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("Mongo -> MarkOutboxPublished -> UpdateOne -> error: %w", err)
+	}
+	return nil
+}
+
+// MarkOutboxFailed records failed attempt of the event and postpones the next one for retryAfter
+func (rpsMongo *Mongo) MarkOutboxFailed(ctx context.Context, id uuid.UUID, reason string, retryAfter time.Duration) error {
+	update := bson.M{
+		"$inc": bson.M{"attempts": 1},
+		"$set": bson.M{"lastError": reason, "claimedUntil": time.Now().UTC().Add(retryAfter)},
+	}
+	_, err := rpsMongo.outboxColl().UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("Mongo -> MarkOutboxFailed -> UpdateOne -> error: %w", err)
+	}
+	return nil
+}
+
+// MarkOutboxDead records the last failed attempt of the event and dead-letters it, so it is never claimed again
+func (rpsMongo *Mongo) MarkOutboxDead(ctx context.Context, id uuid.UUID, reason string) error {
+	update := bson.M{
+		"$inc":   bson.M{"attempts": 1},
+		"$set":   bson.M{"lastError": reason, "deadAt": time.Now().UTC()},
+		"$unset": bson.M{"claimedUntil": ""},
+	}
+	_, err := rpsMongo.outboxColl().UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("Mongo -> MarkOutboxDead -> UpdateOne -> error: %w", err)
+	}
+	return nil
+}
+
+// PruneOutbox deletes events published before the given time and returns how many were deleted
+func (rpsMongo *Mongo) PruneOutbox(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	res, err := rpsMongo.outboxColl().DeleteMany(ctx, bson.M{"publishedAt": bson.M{"$lt": publishedBefore.UTC()}})
+	if err != nil {
+		return 0, fmt.Errorf("Mongo -> PruneOutbox -> DeleteMany -> error: %w", err)
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MongoOutboxRolledBackWithTx(t *testing.T) {
+	event := newOutboxEvent()
+	err := rpsMongo.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rpsMongo.AddToOutbox(ctx, &event); err != nil {
+			return err
+		}
+		return errTxTest
+	})
+	require.Error(t, err)
+	_, ok := claimAll(t, rpsMongo.ClaimOutbox)[event.ID]
+	require.False(t, ok)
+}
+
+func Test_MongoOutboxClaimAndPublish(t *testing.T) {
+	event := newOutboxEvent()
+	err := rpsMongo.AddToOutbox(testCtx, &event)
+	require.NoError(t, err)
+
+	claimed, ok := claimAll(t, rpsMongo.ClaimOutbox)[event.ID]
+	require.True(t, ok)
+	require.Equal(t, testTenant.ID, claimed.TenantID)
+	_, ok = claimAll(t, rpsMongo.ClaimOutbox)[event.ID]
+	require.False(t, ok, "claimed event must be hidden until its lease expires")
+
+	err = rpsMongo.MarkOutboxPublished(context.Background(), event.ID)
+	require.NoError(t, err)
+}
+
+func Test_MongoOutboxMarkFailed(t *testing.T) {
+	event := newOutboxEvent()
+	err := rpsMongo.AddToOutbox(testCtx, &event)
+	require.NoError(t, err)
+	claimAll(t, rpsMongo.ClaimOutbox)
+
+	err = rpsMongo.MarkOutboxFailed(context.Background(), event.ID, "stream is down", -time.Second)
+	require.NoError(t, err)
+	claimed, ok := claimAll(t, rpsMongo.ClaimOutbox)[event.ID]
+	require.True(t, ok, "failed event must be claimed again after its retry delay")
+	require.Equal(t, 1, claimed.Attempts)
+}
+
+func Test_MongoOutboxDeadAndPrune(t *testing.T) {
+	dead, published := newOutboxEvent(), newOutboxEvent()
+	require.NoError(t, rpsMongo.AddToOutbox(testCtx, &dead))
+	require.NoError(t, rpsMongo.AddToOutbox(testCtx, &published))
+
+	err := rpsMongo.MarkOutboxDead(context.Background(), dead.ID, "poison")
+	require.NoError(t, err)
+	_, ok := claimAll(t, rpsMongo.ClaimOutbox)[dead.ID]
+	require.False(t, ok, "dead-lettered event must never be claimed")
+
+	err = rpsMongo.MarkOutboxPublished(context.Background(), published.ID)
+	require.NoError(t, err)
+	deleted, err := rpsMongo.PruneOutbox(context.Background(), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, deleted, int64(1))
+}
@@ -3,22 +3,28 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Mongo contains object of type *mongo.Client
+// Mongo contains object of type *mongo.Client and the tenants that were lately found in the registry
 type Mongo struct {
-	client *mongo.Client
+	client    *mongo.Client
+	tenantsMu sync.Mutex
+	tenants   map[uuid.UUID]time.Time
 }
 
 // NewRepositoryMongo accepts object of type *mongo.Client and returns an object of type *PersonMongo
 func NewRepositoryMongo(client *mongo.Client) *Mongo {
-	return &Mongo{client: client}
+	return &Mongo{client: client, tenants: make(map[uuid.UUID]time.Time)}
 }
 
 // Create creates document in mongoDB collection
@@ -26,8 +32,15 @@ func (rpsMongo *Mongo) Create(ctx context.Context, pers *model.Person) error {
 	if pers == nil {
 		return ErrNil
 	}
-	coll := rpsMongo.client.Database("personMongoDB").Collection("persons")
-	_, err := coll.InsertOne(ctx, pers)
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("PersonMongo -> Create -> error: %w", err)
+	}
+	coll := db.Collection("persons")
+	_, err = coll.InsertOne(ctx, pers)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPersonExist
+	}
 	if err != nil {
 		return fmt.Errorf("PersonMongo -> Create -> error: %w", err)
 	}
@@ -36,27 +49,49 @@ func (rpsMongo *Mongo) Create(ctx context.Context, pers *model.Person) error {
 
 // ReadRow reads document from mongoDB collection
 func (rpsMongo *Mongo) ReadRow(ctx context.Context, id uuid.UUID) (*model.Person, error) {
-	coll := rpsMongo.client.Database("personMongoDB").Collection("persons")
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("PersonMongo -> ReadRow -> error: %w", err)
+	}
+	coll := db.Collection("persons")
 	filter := bson.M{"_id": id}
 	var pers model.Person
-	err := coll.FindOne(ctx, filter).Decode(&pers)
+	err = coll.FindOne(ctx, filter).Decode(&pers)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &pers, fmt.Errorf("PersonMongo -> ReadRow -> error: %w", ErrPersonNotFound)
+	}
 	if err != nil {
 		return &pers, fmt.Errorf("PersonMongo -> ReadRow -> error: %w", err)
 	}
 	return &pers, nil
 }
 
+// ReadRowForUpdate reads document from mongoDB collection, it must be called inside of WithTx. MongoDB has no row locks,
+// but a transaction that writes the document after another one changed it since the read fails with a write conflict
+// and is retried by WithTx, so the change read here can't be lost
+func (rpsMongo *Mongo) ReadRowForUpdate(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	pers, err := rpsMongo.ReadRow(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("PersonMongo -> ReadRowForUpdate -> error: %w", err)
+	}
+	return pers, nil
+}
+
 // GetAll reads all documents from mongoDB collection
 func (rpsMongo *Mongo) GetAll(ctx context.Context) ([]model.Person, error) {
-	coll := rpsMongo.client.Database("personMongoDB").Collection("persons")
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("PersonMongo -> GetAll -> error: %w", err)
+	}
+	coll := db.Collection("persons")
 	filter := bson.M{}
 	var allPers []model.Person
 	cursor, err := coll.Find(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("PersonMongo -> GetAll -> Find -> error: %w", err)
 	}
-	var pers model.Person
 	for cursor.Next(ctx) {
+		var pers model.Person
 		err = cursor.Decode(&pers)
 		if err != nil {
 			return allPers, fmt.Errorf("PersonMongo -> GetAll -> Decode -> error: %w", err)
@@ -66,9 +101,31 @@ func (rpsMongo *Mongo) GetAll(ctx context.Context) ([]model.Person, error) {
 	return allPers, nil
 }
 
+// GetByAvatarForUpdate reads documents of persons that have the image as avatar, it must be called inside of WithTx,
+// see ReadRowForUpdate
+func (rpsMongo *Mongo) GetByAvatarForUpdate(ctx context.Context, imageID uuid.UUID) ([]model.Person, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("PersonMongo -> GetByAvatarForUpdate -> error: %w", err)
+	}
+	var persons []model.Person
+	cursor, err := db.Collection("persons").Find(ctx, bson.M{"avatarImageId": imageID})
+	if err != nil {
+		return nil, fmt.Errorf("PersonMongo -> GetByAvatarForUpdate -> Find -> error: %w", err)
+	}
+	if err = cursor.All(ctx, &persons); err != nil {
+		return nil, fmt.Errorf("PersonMongo -> GetByAvatarForUpdate -> All -> error: %w", err)
+	}
+	return persons, nil
+}
+
 // Update update the document of mongoDB collection
 func (rpsMongo *Mongo) Update(ctx context.Context, pers *model.Person) error {
-	coll := rpsMongo.client.Database("personMongoDB").Collection("persons")
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("PersonMongo -> Update -> error: %w", err)
+	}
+	coll := db.Collection("persons")
 	filter := bson.M{"_id": pers.ID}
 	update := bson.M{"$set": pers}
 	res, err := coll.UpdateOne(ctx, filter, update)
@@ -76,21 +133,38 @@ func (rpsMongo *Mongo) Update(ctx context.Context, pers *model.Person) error {
 		return fmt.Errorf("PersonMongo -> Update -> error: %w", err)
 	}
 	if res.ModifiedCount == 0 {
-		return mongo.ErrNoDocuments
+		return fmt.Errorf("PersonMongo -> Update -> error: %w", ErrPersonNotFound)
 	}
 	return nil
 }
 
 // Delete deletes the document of mongoDB collection
 func (rpsMongo *Mongo) Delete(ctx context.Context, id uuid.UUID) error {
-	coll := rpsMongo.client.Database("personMongoDB").Collection("persons")
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("PersonMongo -> Delete -> error: %w", err)
+	}
+	coll := db.Collection("persons")
 	filter := bson.M{"_id": id}
 	res, err := coll.DeleteOne(ctx, filter)
 	if err != nil {
 		return fmt.Errorf("PersonMongo -> Delete -> error: %w", err)
 	}
 	if res.DeletedCount == 0 {
-		return mongo.ErrNoDocuments
+		return fmt.Errorf("PersonMongo -> Delete -> error: %w", ErrPersonNotFound)
 	}
 	return nil
 }
+
+// AvatarInUse tells if any person of the tenant has the image as avatar
+func (rpsMongo *Mongo) AvatarInUse(ctx context.Context, imageID uuid.UUID) (bool, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return false, fmt.Errorf("PersonMongo -> AvatarInUse -> error: %w", err)
+	}
+	count, err := db.Collection("persons").CountDocuments(ctx, bson.M{"avatarImageId": imageID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("PersonMongo -> AvatarInUse -> CountDocuments -> error: %w", err)
+	}
+	return count != 0, nil
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var rpsMongo *Mongo
@@ -23,9 +22,9 @@ var mongoVladimir = model.Person{
 }
 
 func Test_MongoCreate(t *testing.T) {
-	err := rpsMongo.Create(context.Background(), &mongoVladimir)
+	err := rpsMongo.Create(testCtx, &mongoVladimir)
 	require.NoError(t, err)
-	testMongoVladimir, err := rpsMongo.ReadRow(context.Background(), mongoVladimir.ID)
+	testMongoVladimir, err := rpsMongo.ReadRow(testCtx, mongoVladimir.ID)
 	require.NoError(t, err)
 	require.Equal(t, mongoVladimir.ID, testMongoVladimir.ID)
 	require.Equal(t, mongoVladimir.Salary, testMongoVladimir.Salary)
@@ -34,17 +33,17 @@ func Test_MongoCreate(t *testing.T) {
 }
 
 func Test_MongoCreateNil(t *testing.T) {
-	err := rpsMongo.Create(context.Background(), nil)
+	err := rpsMongo.Create(testCtx, nil)
 	require.True(t, errors.Is(err, ErrNil))
 }
 
 func Test_MongoCreateDuplicate(t *testing.T) {
-	err := rpsMongo.Create(context.Background(), &mongoVladimir)
+	err := rpsMongo.Create(testCtx, &mongoVladimir)
 	require.Error(t, err)
 }
 
 func Test_MongoCreateContextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	err := rpsMongo.Create(ctx, &mongoVladimir)
@@ -52,7 +51,7 @@ func Test_MongoCreateContextTimeout(t *testing.T) {
 }
 
 func Test_MongoReadRow(t *testing.T) {
-	testMongoVladimir, err := rpsMongo.ReadRow(context.Background(), mongoVladimir.ID)
+	testMongoVladimir, err := rpsMongo.ReadRow(testCtx, mongoVladimir.ID)
 	require.NoError(t, err)
 	require.Equal(t, mongoVladimir.ID, testMongoVladimir.ID)
 	require.Equal(t, mongoVladimir.Salary, testMongoVladimir.Salary)
@@ -62,12 +61,12 @@ func Test_MongoReadRow(t *testing.T) {
 
 func Test_MongoReadRowNotFound(t *testing.T) {
 	var id uuid.UUID
-	_, err := rpsMongo.ReadRow(context.Background(), id)
-	require.True(t, errors.Is(err, mongo.ErrNoDocuments))
+	_, err := rpsMongo.ReadRow(testCtx, id)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_MongoReadRowContextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	_, err := rpsMongo.ReadRow(ctx, mongoVladimir.ID)
@@ -75,11 +74,11 @@ func Test_MongoReadRowContextTimeout(t *testing.T) {
 }
 
 func Test_MongoGetAll(t *testing.T) {
-	allPers, err := rpsMongo.GetAll(context.Background())
+	allPers, err := rpsMongo.GetAll(testCtx)
 	require.NoError(t, err)
-	coll := rpsMongo.client.Database("personMongoDB").Collection("persons")
+	coll := rpsMongo.client.Database(tenantDBName(testTenant.ID)).Collection("persons")
 	filter := bson.M{}
-	numberPersons, err := coll.CountDocuments(context.Background(), filter)
+	numberPersons, err := coll.CountDocuments(testCtx, filter)
 	require.NoError(t, err)
 	require.Equal(t, len(allPers), int(numberPersons))
 }
@@ -88,9 +87,9 @@ func Test_MongoUpdate(t *testing.T) {
 	mongoVladimir.Salary = 100
 	mongoVladimir.Married = false
 	mongoVladimir.Profession = "Security"
-	err := rpsMongo.Update(context.Background(), &mongoVladimir)
+	err := rpsMongo.Update(testCtx, &mongoVladimir)
 	require.NoError(t, err)
-	testMongoVladimir, err := rpsMongo.ReadRow(context.Background(), mongoVladimir.ID)
+	testMongoVladimir, err := rpsMongo.ReadRow(testCtx, mongoVladimir.ID)
 	require.NoError(t, err)
 	require.Equal(t, mongoVladimir.ID, testMongoVladimir.ID)
 	require.Equal(t, mongoVladimir.Salary, testMongoVladimir.Salary)
@@ -100,12 +99,12 @@ func Test_MongoUpdate(t *testing.T) {
 
 func Test_MongoUpdateNotFound(t *testing.T) {
 	var emptyEntity model.Person
-	err := rpsMongo.Update(context.Background(), &emptyEntity)
-	require.True(t, errors.Is(err, mongo.ErrNoDocuments))
+	err := rpsMongo.Update(testCtx, &emptyEntity)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_MongoUpdateContextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	err := rpsMongo.Update(ctx, &mongoVladimir)
@@ -113,22 +112,50 @@ func Test_MongoUpdateContextTimeout(t *testing.T) {
 }
 
 func Test_MongoDelete(t *testing.T) {
-	err := rpsMongo.Delete(context.Background(), mongoVladimir.ID)
+	err := rpsMongo.Delete(testCtx, mongoVladimir.ID)
 	require.NoError(t, err)
-	_, err = rpsMongo.ReadRow(context.Background(), mongoVladimir.ID)
-	require.True(t, errors.Is(err, mongo.ErrNoDocuments))
+	_, err = rpsMongo.ReadRow(testCtx, mongoVladimir.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_MongoDeleteNotFound(t *testing.T) {
 	var id uuid.UUID
-	err := rpsMongo.Delete(context.Background(), id)
-	require.True(t, errors.Is(err, mongo.ErrNoDocuments))
+	err := rpsMongo.Delete(testCtx, id)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_MongoDeleteontextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	err := rpsMongo.Delete(ctx, mongoVladimir.ID)
 	require.True(t, errors.Is(err, context.DeadlineExceeded))
 }
+
+func Test_MongoAvatar(t *testing.T) {
+	img := newTestImage(uuid.New())
+	require.NoError(t, rpsMongo.CreateImage(testCtx, &img))
+	imageID := img.ID
+	pers := newTestPerson()
+	require.NoError(t, rpsMongo.Create(testCtx, pers))
+	inUse, err := rpsMongo.AvatarInUse(testCtx, imageID)
+	require.NoError(t, err)
+	require.False(t, inUse)
+
+	pers.AvatarImageID = &imageID
+	require.NoError(t, rpsMongo.Update(testCtx, pers))
+	stored, err := rpsMongo.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, pers, stored)
+	inUse, err = rpsMongo.AvatarInUse(testCtx, imageID)
+	require.NoError(t, err)
+	require.True(t, inUse)
+	persons, err := rpsMongo.GetByAvatarForUpdate(testCtx, imageID)
+	require.NoError(t, err)
+	require.Equal(t, []model.Person{*pers}, persons)
+
+	require.NoError(t, rpsMongo.DeleteImage(testCtx, imageID))
+	stored, err = rpsMongo.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Nil(t, stored.AvatarImageID)
+}
@@ -0,0 +1,102 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mongoRegistryDB is a database that keeps the list of tenants, data of every tenant lives in its own database
+const mongoRegistryDB = "personMongoDB"
+
+// tenantDBName returns name of the database that belongs to the tenant
+func tenantDBName(tenantID uuid.UUID) string {
+	return mongoRegistryDB + "_" + tenantID.String()
+}
+
+// tenantCheckTTL is how long a tenant found in the registry is trusted to exist, so the registry isn't read by every
+// call. A tenant deleted through another replica is rejected by this one after tenantCheckTTL at most
+const tenantCheckTTL = 10 * time.Second
+
+// tenantDB returns database of the tenant taken from ctx. MongoDB creates a database on the first write, so the tenant
+// is checked in the registry first, otherwise a token of a deleted tenant would bring its database back
+func (rpsMongo *Mongo) tenantDB(ctx context.Context) (*mongo.Database, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rpsMongo.tenantsMu.Lock()
+	checked, ok := rpsMongo.tenants[tenantID]
+	rpsMongo.tenantsMu.Unlock()
+	if !ok || time.Since(checked) > tenantCheckTTL {
+		exists, err := rpsMongo.tenantExists(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("tenantExists -> error: %w", err)
+		}
+		if !exists {
+			return nil, ErrTenantNotFound
+		}
+		rpsMongo.tenantsMu.Lock()
+		rpsMongo.tenants[tenantID] = time.Now()
+		rpsMongo.tenantsMu.Unlock()
+	}
+	return rpsMongo.client.Database(tenantDBName(tenantID)), nil
+}
+
+// CreateTenant registers tenant in tenants collection
+func (rpsMongo *Mongo) CreateTenant(ctx context.Context, tenant *model.Tenant) error {
+	if tenant == nil {
+		return ErrNil
+	}
+	coll := rpsMongo.client.Database(mongoRegistryDB).Collection("tenants")
+	_, err := coll.InsertOne(ctx, tenant)
+	if err != nil {
+		return fmt.Errorf("Mongo -> CreateTenant -> InsertOne -> error: %w", err)
+	}
+	err = rpsMongo.ensureTenantIndexes(ctx, tenant.ID)
+	if err != nil {
+		return fmt.Errorf("Mongo -> CreateTenant -> error: %w", err)
+	}
+	return nil
+}
+
+// DeleteTenant drops database of the tenant and removes it from tenants collection
+func (rpsMongo *Mongo) DeleteTenant(ctx context.Context, id uuid.UUID) error {
+	coll := rpsMongo.client.Database(mongoRegistryDB).Collection("tenants")
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("Mongo -> DeleteTenant -> DeleteOne -> error: %w", err)
+	}
+	rpsMongo.tenantsMu.Lock()
+	delete(rpsMongo.tenants, id)
+	rpsMongo.tenantsMu.Unlock()
+	if res.DeletedCount == 0 {
+		return ErrTenantNotFound
+	}
+	err = rpsMongo.client.Database(tenantDBName(id)).Drop(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> DeleteTenant -> Drop -> error: %w", err)
+	}
+	return nil
+}
+
+// tenantExists checks if tenant from ctx is registered in tenants collection
+func (rpsMongo *Mongo) tenantExists(ctx context.Context) (bool, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	coll := rpsMongo.client.Database(mongoRegistryDB).Collection("tenants")
+	numberTenants, err := coll.CountDocuments(ctx, bson.M{"_id": tenantID})
+	if err != nil {
+		return false, err
+	}
+	return numberTenants != 0, nil
+}
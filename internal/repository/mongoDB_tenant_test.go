@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func Test_MongoTenantIsolation(t *testing.T) {
+	otherTenant := model.Tenant{ID: uuid.New(), Name: "otherTenant"}
+	err := rpsMongo.CreateTenant(context.Background(), &otherTenant)
+	require.NoError(t, err)
+	otherCtx := identity.WithTenant(context.Background(), otherTenant.ID)
+
+	pers := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	err = rpsMongo.Create(testCtx, &pers)
+	require.NoError(t, err)
+	_, err = rpsMongo.ReadRow(otherCtx, pers.ID)
+	require.True(t, errors.Is(err, mongo.ErrNoDocuments))
+
+	err = rpsMongo.Delete(testCtx, pers.ID)
+	require.NoError(t, err)
+	err = rpsMongo.DeleteTenant(context.Background(), otherTenant.ID)
+	require.NoError(t, err)
+}
+
+func Test_MongoSignUpUnknownTenant(t *testing.T) {
+	user := model.User{ID: uuid.New(), Username: "nobody", Password: []byte("secret")}
+	err := rpsMongo.SignUp(identity.WithTenant(context.Background(), uuid.New()), &user)
+	require.True(t, errors.Is(err, ErrTenantNotFound))
+}
+
+func Test_MongoDeleteTenantDropsDatabase(t *testing.T) {
+	tenant := model.Tenant{ID: uuid.New(), Name: "goneTenant"}
+	err := rpsMongo.CreateTenant(context.Background(), &tenant)
+	require.NoError(t, err)
+	tenantCtx := identity.WithTenant(context.Background(), tenant.ID)
+	pers := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	err = rpsMongo.Create(tenantCtx, &pers)
+	require.NoError(t, err)
+
+	err = rpsMongo.DeleteTenant(context.Background(), tenant.ID)
+	require.NoError(t, err)
+	names, err := rpsMongo.client.ListDatabaseNames(context.Background(), map[string]string{"name": tenantDBName(tenant.ID)})
+	require.NoError(t, err)
+	require.Empty(t, names)
+	err = rpsMongo.Create(tenantCtx, &model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"})
+	require.True(t, errors.Is(err, ErrTenantNotFound), "a token of the deleted tenant can't bring its database back")
+	names, err = rpsMongo.client.ListDatabaseNames(context.Background(), map[string]string{"name": tenantDBName(tenant.ID)})
+	require.NoError(t, err)
+	require.Empty(t, names)
+	err = rpsMongo.DeleteTenant(context.Background(), tenant.ID)
+	require.True(t, errors.Is(err, ErrTenantNotFound))
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
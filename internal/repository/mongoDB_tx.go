@@ -0,0 +1,36 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WithTx runs fn in a session transaction that is committed if fn returns nil and aborted otherwise.
+// Repository methods called with the ctx given to fn run in that transaction, the driver takes the session from ctx.
+// fn may be retried on transient transaction errors, so it must not have side effects outside of the database.
+// A nested WithTx joins the outer transaction, since mongoDB has no savepoints: nothing is committed until the
+// outermost WithTx returns, and if the nested fn fails the whole transaction is aborted, even if the outer fn handles
+// the error and returns nil, then the outermost WithTx returns ErrTxRollbackOnly.
+// Transactions require mongoDB running as a replica set.
+func (rpsMongo *Mongo) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state, ok := ctx.Value(txStateKey{}).(*txState); ok && mongo.SessionFromContext(ctx) != nil {
+		return nestedTx(ctx, state, fn)
+	}
+	session, err := rpsMongo.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("Mongo -> WithTx -> StartSession -> error: %w", err)
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		// every retry of the transaction starts with a clean state
+		state := &txState{}
+		return nil, outerTxErr(state, fn(context.WithValue(sessCtx, txStateKey{}, state)))
+	})
+	if err != nil {
+		return fmt.Errorf("Mongo -> WithTx -> WithTransaction -> error: %w", err)
+	}
+	return nil
+}
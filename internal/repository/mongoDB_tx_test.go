@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MongoWithTxCommit(t *testing.T) {
+	first, second := newTxPerson(), newTxPerson()
+	err := rpsMongo.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rpsMongo.Create(ctx, &first); err != nil {
+			return err
+		}
+		_, err := rpsMongo.ReadRow(ctx, first.ID)
+		require.NoError(t, err)
+		return rpsMongo.Create(ctx, &second)
+	})
+	require.NoError(t, err)
+	_, err = rpsMongo.ReadRow(testCtx, first.ID)
+	require.NoError(t, err)
+	_, err = rpsMongo.ReadRow(testCtx, second.ID)
+	require.NoError(t, err)
+}
+
+func Test_MongoWithTxRollback(t *testing.T) {
+	first := newTxPerson()
+	err := rpsMongo.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rpsMongo.Create(ctx, &first); err != nil {
+			return err
+		}
+		return errTxTest
+	})
+	require.True(t, errors.Is(err, errTxTest))
+	_, err = rpsMongo.ReadRow(testCtx, first.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_MongoWithTxNestedJoinsOuter(t *testing.T) {
+	inner := newTxPerson()
+	err := rpsMongo.WithTx(testCtx, func(ctx context.Context) error {
+		err := rpsMongo.WithTx(ctx, func(ctx context.Context) error {
+			return rpsMongo.Create(ctx, &inner)
+		})
+		require.NoError(t, err)
+		return errTxTest
+	})
+	require.True(t, errors.Is(err, errTxTest))
+	_, err = rpsMongo.ReadRow(testCtx, inner.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_MongoWithTxNestedFailureRollsBackOuter(t *testing.T) {
+	outer, inner := newTxPerson(), newTxPerson()
+	err := rpsMongo.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rpsMongo.Create(ctx, &outer); err != nil {
+			return err
+		}
+		err := rpsMongo.WithTx(ctx, func(ctx context.Context) error {
+			if err := rpsMongo.Create(ctx, &inner); err != nil {
+				return err
+			}
+			return errTxTest
+		})
+		require.True(t, errors.Is(err, errTxTest))
+		return nil
+	})
+	require.True(t, errors.Is(err, ErrTxRollbackOnly))
+	_, err = rpsMongo.ReadRow(testCtx, outer.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+	_, err = rpsMongo.ReadRow(testCtx, inner.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
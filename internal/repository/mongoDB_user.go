@@ -9,23 +9,23 @@ import (
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// SignUp create new user in users collection
+// SignUp create new user in users collection, uniqueness of the username is guaranteed by the unique index
 func (rpsMongo *Mongo) SignUp(ctx context.Context, user *model.User) error {
 	if user == nil {
 		return ErrNil
 	}
-	coll := rpsMongo.client.Database("personMongoDB").Collection("users")
-	filter := bson.M{"username": user.Username}
-	numberPersons, err := coll.CountDocuments(context.Background(), filter)
+	db, err := rpsMongo.tenantDB(ctx)
 	if err != nil {
-		return fmt.Errorf("Mongo -> SignUp -> CountDocuments -> error: %w", err)
+		return fmt.Errorf("Mongo -> SignUp -> error: %w", err)
 	}
-	if numberPersons != 0 {
+	coll := db.Collection("users")
+	_, err = coll.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
 		return ErrExist
 	}
-	_, err = coll.InsertOne(ctx, user)
 	if err != nil {
 		return fmt.Errorf("Mongo -> SignUp -> InsertOne -> error: %w", err)
 	}
@@ -35,9 +35,14 @@ func (rpsMongo *Mongo) SignUp(ctx context.Context, user *model.User) error {
 // GetPasswordAndIDByUsername returnes id and hash of the password from users table
 func (rpsMongo *Mongo) GetPasswordAndIDByUsername(ctx context.Context, username string) (uuid.UUID, []byte, error) {
 	var user model.User
-	coll := rpsMongo.client.Database("personMongoDB").Collection("users")
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return uuid.UUID{}, nil, fmt.Errorf("Mongo -> GetPasswordAndIDByUserName -> error: %w", err)
+	}
+	coll := db.Collection("users")
 	filter := bson.M{"username": username}
-	err := coll.FindOne(ctx, filter).Decode(&user)
+	opts := options.FindOne().SetCollation(&options.Collation{Locale: "en", Strength: usernameCollationStrength})
+	err = coll.FindOne(ctx, filter, opts).Decode(&user)
 	if err != nil {
 		return uuid.UUID{}, nil, fmt.Errorf("Mongo -> GetPasswordAndIDByUserName -> FindOne -> error: %w", err)
 	}
@@ -46,10 +51,14 @@ func (rpsMongo *Mongo) GetPasswordAndIDByUsername(ctx context.Context, username
 
 // GetRefreshTokenByID returnes refreshToken from users table by id
 func (rpsMongo *Mongo) GetRefreshTokenByID(ctx context.Context, id uuid.UUID) (string, error) {
-	coll := rpsMongo.client.Database("personMongoDB").Collection("users")
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Mongo -> GetRefreshTokenByName -> error: %w", err)
+	}
+	coll := db.Collection("users")
 	filter := bson.M{"_id": id}
 	var user *model.User
-	err := coll.FindOne(ctx, filter).Decode(&user)
+	err = coll.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		return "", fmt.Errorf("Mongo -> GetRefreshTokenByName -> QueryRow -> error: %w", err)
 	}
@@ -58,7 +67,11 @@ func (rpsMongo *Mongo) GetRefreshTokenByID(ctx context.Context, id uuid.UUID) (s
 
 // AddRefreshToken adds refreshToken to users table by id
 func (rpsMongo *Mongo) AddRefreshToken(ctx context.Context, user *model.User) error {
-	coll := rpsMongo.client.Database("personMongoDB").Collection("users")
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> AddRefreshToken -> error: %w", err)
+	}
+	coll := db.Collection("users")
 	filter := bson.M{"_id": user.ID}
 	update := bson.M{"$set": bson.M{"refreshToken": user.RefreshToken}}
 	res, err := coll.UpdateOne(ctx, filter, update)
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func Test_MongoSignUpDuplicate(t *testing.T) {
+	user := model.User{ID: uuid.New(), Username: "duplicate", Password: []byte("secret")}
+	err := rpsMongo.SignUp(testCtx, &user)
+	require.NoError(t, err)
+	user.ID = uuid.New()
+	err = rpsMongo.SignUp(testCtx, &user)
+	require.True(t, errors.Is(err, ErrExist))
+}
+
+func Test_MongoSignUpConcurrent(t *testing.T) {
+	const parallel = 20
+	errs := signUpConcurrently(func(user *model.User) error {
+		return rpsMongo.SignUp(testCtx, user)
+	}, "mongoRacer", parallel)
+	requireOneWinner(t, errs)
+}
+
+func Test_MongoGetPasswordAndIDByUsernameIgnoresCase(t *testing.T) {
+	user := model.User{ID: uuid.New(), Username: "casefree", Password: []byte("secret")}
+	err := rpsMongo.SignUp(testCtx, &user)
+	require.NoError(t, err)
+	id, _, err := rpsMongo.GetPasswordAndIDByUsername(testCtx, "CaseFree")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, id)
+}
+
+func Test_MongoEnsureIndexesLowerCasesUsernames(t *testing.T) {
+	user := model.User{ID: uuid.New(), Username: "MixedCase", Password: []byte("secret")}
+	err := rpsMongo.SignUp(testCtx, &user)
+	require.NoError(t, err)
+	err = rpsMongo.EnsureIndexes(testCtx)
+	require.NoError(t, err)
+	db, err := rpsMongo.tenantDB(testCtx)
+	require.NoError(t, err)
+	var stored model.User
+	err = db.Collection("users").FindOne(testCtx, bson.M{"_id": user.ID}).Decode(&stored)
+	require.NoError(t, err)
+	require.Equal(t, "mixedcase", stored.Username)
+}
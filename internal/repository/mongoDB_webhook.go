@@ -0,0 +1,170 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateWebhook creates a document in webhooks collection of the tenant
+func (rpsMongo *Mongo) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	if webhook == nil {
+		return ErrNil
+	}
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> CreateWebhook -> error: %w", err)
+	}
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	webhook.CreatedAt = time.Now().UTC()
+	_, err = db.Collection("webhooks").InsertOne(ctx, webhook)
+	if err != nil {
+		return fmt.Errorf("Mongo -> CreateWebhook -> InsertOne -> error: %w", err)
+	}
+	return nil
+}
+
+// GetWebhooks reads all webhooks of the tenant
+func (rpsMongo *Mongo) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetWebhooks -> error: %w", err)
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := db.Collection("webhooks").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetWebhooks -> Find -> error: %w", err)
+	}
+	var webhooks []model.Webhook
+	if err = cursor.All(ctx, &webhooks); err != nil {
+		return nil, fmt.Errorf("Mongo -> GetWebhooks -> All -> error: %w", err)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes webhook of the tenant with its delivery log
+func (rpsMongo *Mongo) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> DeleteWebhook -> error: %w", err)
+	}
+	res, err := db.Collection("webhooks").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return fmt.Errorf("Mongo -> DeleteWebhook -> DeleteOne -> error: %w", err)
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = db.Collection("webhookDeliveries").DeleteMany(ctx, bson.M{"webhookId": id})
+	if err != nil {
+		return fmt.Errorf("Mongo -> DeleteWebhook -> DeleteMany -> error: %w", err)
+	}
+	return nil
+}
+
+// EnableWebhook enables webhook and resets its counter of consecutive failures
+func (rpsMongo *Mongo) EnableWebhook(ctx context.Context, id uuid.UUID) error {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> EnableWebhook -> error: %w", err)
+	}
+	res, err := db.Collection("webhooks").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"disabled": false, "failures": 0}})
+	if err != nil {
+		return fmt.Errorf("Mongo -> EnableWebhook -> UpdateOne -> error: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// RecordWebhookResult resets counter of consecutive failures after a success or increments it after a failure,
+// webhook is disabled when the counter reaches disableAfter. It returns whether the webhook is disabled now
+func (rpsMongo *Mongo) RecordWebhookResult(ctx context.Context, id uuid.UUID, success bool, disableAfter int) (bool, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return false, fmt.Errorf("Mongo -> RecordWebhookResult -> error: %w", err)
+	}
+	update := bson.A{bson.M{"$set": bson.M{"failures": 0}}}
+	if !success {
+		update = bson.A{
+			bson.M{"$set": bson.M{"failures": bson.M{"$add": bson.A{"$failures", 1}}}},
+			bson.M{"$set": bson.M{"disabled": bson.M{"$or": bson.A{"$disabled", bson.M{"$gte": bson.A{"$failures", disableAfter}}}}}},
+		}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var webhook model.Webhook
+	err = db.Collection("webhooks").FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&webhook)
+	if err != nil {
+		return false, fmt.Errorf("Mongo -> RecordWebhookResult -> FindOneAndUpdate -> error: %w", err)
+	}
+	return webhook.Disabled, nil
+}
+
+// AddWebhookDelivery writes an attempt of delivery to webhookDeliveries collection of the tenant
+func (rpsMongo *Mongo) AddWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	if delivery == nil {
+		return ErrNil
+	}
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> AddWebhookDelivery -> error: %w", err)
+	}
+	_, err = db.Collection("webhookDeliveries").InsertOne(ctx, delivery)
+	if err != nil {
+		return fmt.Errorf("Mongo -> AddWebhookDelivery -> InsertOne -> error: %w", err)
+	}
+	return nil
+}
+
+// PruneWebhookDeliveries deletes deliveries of the webhook except the latest keep ones
+func (rpsMongo *Mongo) PruneWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, keep int) error {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return fmt.Errorf("Mongo -> PruneWebhookDeliveries -> error: %w", err)
+	}
+	coll := db.Collection("webhookDeliveries")
+	// the newest delivery that isn't kept is the boundary, it and the older ones are deleted
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetSkip(int64(keep))
+	var boundary model.WebhookDelivery
+	err = coll.FindOne(ctx, bson.M{"webhookId": webhookID}, opts).Decode(&boundary)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Mongo -> PruneWebhookDeliveries -> FindOne -> error: %w", err)
+	}
+	_, err = coll.DeleteMany(ctx, bson.M{"webhookId": webhookID, "createdAt": bson.M{"$lte": boundary.CreatedAt}})
+	if err != nil {
+		return fmt.Errorf("Mongo -> PruneWebhookDeliveries -> DeleteMany -> error: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries reads up to limit latest deliveries of the webhook
+func (rpsMongo *Mongo) GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, limit int) ([]model.WebhookDelivery, error) {
+	db, err := rpsMongo.tenantDB(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetWebhookDeliveries -> error: %w", err)
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := db.Collection("webhookDeliveries").Find(ctx, bson.M{"webhookId": webhookID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Mongo -> GetWebhookDeliveries -> Find -> error: %w", err)
+	}
+	var deliveries []model.WebhookDelivery
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("Mongo -> GetWebhookDeliveries -> All -> error: %w", err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func Test_MongoWebhookLifecycle(t *testing.T) {
+	webhook := newTestWebhook()
+	err := rpsMongo.CreateWebhook(testCtx, &webhook)
+	require.NoError(t, err)
+
+	webhooks, err := rpsMongo.GetWebhooks(testCtx)
+	require.NoError(t, err)
+	require.Contains(t, webhookIDs(webhooks), webhook.ID)
+
+	err = rpsMongo.DeleteWebhook(testCtx, webhook.ID)
+	require.NoError(t, err)
+	err = rpsMongo.DeleteWebhook(testCtx, webhook.ID)
+	require.True(t, errors.Is(err, mongo.ErrNoDocuments))
+}
+
+func Test_MongoWebhookAutoDisable(t *testing.T) {
+	webhook := newTestWebhook()
+	err := rpsMongo.CreateWebhook(testCtx, &webhook)
+	require.NoError(t, err)
+
+	disabled, err := rpsMongo.RecordWebhookResult(testCtx, webhook.ID, false, 2)
+	require.NoError(t, err)
+	require.False(t, disabled)
+	disabled, err = rpsMongo.RecordWebhookResult(testCtx, webhook.ID, false, 2)
+	require.NoError(t, err)
+	require.True(t, disabled)
+
+	err = rpsMongo.EnableWebhook(testCtx, webhook.ID)
+	require.NoError(t, err)
+	disabled, err = rpsMongo.RecordWebhookResult(testCtx, webhook.ID, true, 2)
+	require.NoError(t, err)
+	require.False(t, disabled)
+	require.NoError(t, rpsMongo.DeleteWebhook(testCtx, webhook.ID))
+}
+
+func Test_MongoWebhookDeliveries(t *testing.T) {
+	webhook := newTestWebhook()
+	err := rpsMongo.CreateWebhook(testCtx, &webhook)
+	require.NoError(t, err)
+	for attempt := 1; attempt <= 3; attempt++ {
+		delivery := newTestDelivery(webhook.ID, attempt)
+		require.NoError(t, rpsMongo.AddWebhookDelivery(testCtx, &delivery))
+	}
+
+	deliveries, err := rpsMongo.GetWebhookDeliveries(testCtx, webhook.ID, 2)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, 3, deliveries[0].Attempt, "newest delivery goes first")
+	require.NoError(t, rpsMongo.DeleteWebhook(testCtx, webhook.ID))
+}
+
+func Test_MongoPruneWebhookDeliveries(t *testing.T) {
+	webhook := newTestWebhook()
+	require.NoError(t, rpsMongo.CreateWebhook(testCtx, &webhook))
+	for attempt := 1; attempt <= 5; attempt++ {
+		delivery := newTestDelivery(webhook.ID, attempt)
+		require.NoError(t, rpsMongo.AddWebhookDelivery(testCtx, &delivery))
+	}
+
+	err := rpsMongo.PruneWebhookDeliveries(testCtx, webhook.ID, 2)
+	require.NoError(t, err)
+	deliveries, err := rpsMongo.GetWebhookDeliveries(testCtx, webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, 5, deliveries[0].Attempt)
+	require.Equal(t, 4, deliveries[1].Attempt)
+}
@@ -0,0 +1,271 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateImage creates a row in images table
+func (rpsPgx *Pgx) CreateImage(ctx context.Context, img *model.Image) error {
+	if img == nil {
+		return ErrNil
+	}
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> CreateImage -> error: %w", err)
+	}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, `INSERT INTO images(id, tenant_id, owner_id, filename, content_type, size, width, height, sha256, origin)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING created_at`, img.ID, tenantID, img.OwnerID, img.Filename,
+		img.ContentType, img.Size, img.Width, img.Height, img.SHA256, img.Origin).Scan(&img.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Pgx -> CreateImage -> QueryRow -> error: %w", err)
+	}
+	return nil
+}
+
+// GetImage reads metadata of the image of the tenant, it returns ErrImageNotFound if there is no such image
+func (rpsPgx *Pgx) GetImage(ctx context.Context, id uuid.UUID) (*model.Image, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetImage -> error: %w", err)
+	}
+	var img model.Image
+	err = rpsPgx.conn(ctx).QueryRow(ctx, `SELECT id, owner_id, filename, content_type, size, width, height, sha256, origin, created_at
+		FROM images WHERE id = $1 AND tenant_id = $2`, id, tenantID).Scan(&img.ID, &img.OwnerID, &img.Filename,
+		&img.ContentType, &img.Size, &img.Width, &img.Height, &img.SHA256, &img.Origin, &img.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("Pgx -> GetImage -> error: %w", ErrImageNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetImage -> QueryRow -> error: %w", err)
+	}
+	return &img, nil
+}
+
+// GetImages reads a page of images of the owner, newest first
+func (rpsPgx *Pgx) GetImages(ctx context.Context, ownerID uuid.UUID, limit, offset int) ([]model.Image, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetImages -> error: %w", err)
+	}
+	rows, err := rpsPgx.conn(ctx).Query(ctx, `SELECT id, owner_id, filename, content_type, size, width, height, sha256, origin, created_at
+		FROM images WHERE tenant_id = $1 AND owner_id = $2 ORDER BY created_at DESC, id LIMIT $3 OFFSET $4`,
+		tenantID, ownerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetImages -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	images := []model.Image{}
+	for rows.Next() {
+		var img model.Image
+		err = rows.Scan(&img.ID, &img.OwnerID, &img.Filename, &img.ContentType, &img.Size, &img.Width, &img.Height, &img.SHA256,
+			&img.Origin, &img.CreatedAt)
+		if err != nil {
+			return images, fmt.Errorf("Pgx -> GetImages -> Scan -> error: %w", err)
+		}
+		images = append(images, img)
+	}
+	if err = rows.Err(); err != nil {
+		return images, fmt.Errorf("Pgx -> GetImages -> rows.Err -> error: %w", err)
+	}
+	return images, nil
+}
+
+// DeleteImage deletes a row from images table, it returns ErrImageNotFound if there is no such image
+func (rpsPgx *Pgx) DeleteImage(ctx context.Context, id uuid.UUID) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> DeleteImage -> error: %w", err)
+	}
+	res, err := rpsPgx.conn(ctx).Exec(ctx, "DELETE FROM images WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	if err != nil {
+		return fmt.Errorf("Pgx -> DeleteImage -> Exec -> error: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("Pgx -> DeleteImage -> error: %w", ErrImageNotFound)
+	}
+	return nil
+}
+
+// ImageContentKey returns the key of the content with the SHA-256 of the tenant, it returns ErrImageContentNotFound
+// if the tenant has no such content
+func (rpsPgx *Pgx) ImageContentKey(ctx context.Context, sha256 string) (string, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Pgx -> ImageContentKey -> error: %w", err)
+	}
+	var key string
+	err = rpsPgx.conn(ctx).QueryRow(ctx, "SELECT key FROM image_contents WHERE tenant_id = $1 AND sha256 = $2", tenantID, sha256).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("Pgx -> ImageContentKey -> error: %w", ErrImageContentNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Pgx -> ImageContentKey -> QueryRow -> error: %w", err)
+	}
+	return key, nil
+}
+
+// AddImageRef counts one more image that refers to the content with the SHA-256 of the tenant and returns the key
+// of the content. If the tenant has no such content, it is recorded under the key, or ErrImageContentNotFound is
+// returned if the key is empty
+func (rpsPgx *Pgx) AddImageRef(ctx context.Context, sha256, key string) (string, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Pgx -> AddImageRef -> error: %w", err)
+	}
+	var stored string
+	if key == "" {
+		err = rpsPgx.conn(ctx).QueryRow(ctx, `UPDATE image_contents SET refs = refs + 1, unreferenced_at = NULL
+			WHERE tenant_id = $1 AND sha256 = $2 RETURNING key`, tenantID, sha256).Scan(&stored)
+	} else {
+		err = rpsPgx.conn(ctx).QueryRow(ctx, `INSERT INTO image_contents(tenant_id, sha256, key, refs) VALUES($1, $2, $3, 1)
+			ON CONFLICT (tenant_id, sha256) DO UPDATE SET refs = image_contents.refs + 1, unreferenced_at = NULL
+			RETURNING key`, tenantID, sha256, key).Scan(&stored)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("Pgx -> AddImageRef -> error: %w", ErrImageContentNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Pgx -> AddImageRef -> QueryRow -> error: %w", err)
+	}
+	return stored, nil
+}
+
+// ReleaseImageRef counts one image less that refers to the content with the SHA-256 of the tenant. The content that
+// loses its last reference is kept and marked as unreferenced, see UnreferencedImageContents
+func (rpsPgx *Pgx) ReleaseImageRef(ctx context.Context, sha256 string) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> ReleaseImageRef -> error: %w", err)
+	}
+	_, err = rpsPgx.conn(ctx).Exec(ctx, `UPDATE image_contents SET refs = refs - 1,
+		unreferenced_at = CASE WHEN refs = 1 THEN now() END WHERE tenant_id = $1 AND sha256 = $2 AND refs > 0`, tenantID, sha256)
+	if err != nil {
+		return fmt.Errorf("Pgx -> ReleaseImageRef -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// UnreferencedImageContents returns at most limit contents of all tenants that have had no references since before
+func (rpsPgx *Pgx) UnreferencedImageContents(ctx context.Context, before time.Time, limit int) ([]model.ImageContent, error) {
+	rows, err := rpsPgx.conn(ctx).Query(ctx, `SELECT tenant_id, sha256, key, refs, unreferenced_at FROM image_contents
+		WHERE refs = 0 AND unreferenced_at <= $1 ORDER BY unreferenced_at LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> UnreferencedImageContents -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	contents := []model.ImageContent{}
+	for rows.Next() {
+		var content model.ImageContent
+		if err = rows.Scan(&content.TenantID, &content.SHA256, &content.Key, &content.Refs, &content.UnreferencedAt); err != nil {
+			return nil, fmt.Errorf("Pgx -> UnreferencedImageContents -> Scan -> error: %w", err)
+		}
+		contents = append(contents, content)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Pgx -> UnreferencedImageContents -> rows.Err -> error: %w", err)
+	}
+	return contents, nil
+}
+
+// DeleteImageContent deletes the record of the content if it still has no references and the same key,
+// and tells if it was deleted
+func (rpsPgx *Pgx) DeleteImageContent(ctx context.Context, content *model.ImageContent) (bool, error) {
+	res, err := rpsPgx.conn(ctx).Exec(ctx, "DELETE FROM image_contents WHERE tenant_id = $1 AND sha256 = $2 AND key = $3 AND refs = 0",
+		content.TenantID, content.SHA256, content.Key)
+	if err != nil {
+		return false, fmt.Errorf("Pgx -> DeleteImageContent -> Exec -> error: %w", err)
+	}
+	return res.RowsAffected() != 0, nil
+}
+
+// ReserveImageUsage adds size bytes to the usage of the owner unless it would exceed the quota, then it returns
+// model.ErrQuotaExceeded. The check and the increment are one statement, so concurrent uploads can't both pass it.
+// quota 0 means no quota
+func (rpsPgx *Pgx) ReserveImageUsage(ctx context.Context, ownerID uuid.UUID, size, quota int64) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> ReserveImageUsage -> error: %w", err)
+	}
+	res, err := rpsPgx.conn(ctx).Exec(ctx, `INSERT INTO image_usage(tenant_id, owner_id, bytes)
+		SELECT $1::uuid, $2::uuid, $3::bigint WHERE $4::bigint <= 0 OR $3::bigint <= $4::bigint
+		ON CONFLICT (tenant_id, owner_id) DO UPDATE SET bytes = image_usage.bytes + excluded.bytes
+		WHERE $4::bigint <= 0 OR image_usage.bytes + excluded.bytes <= $4::bigint`, tenantID, ownerID, size, quota)
+	if err != nil {
+		return fmt.Errorf("Pgx -> ReserveImageUsage -> Exec -> error: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("Pgx -> ReserveImageUsage -> error: %w", model.ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// ReleaseImageUsage subtracts size bytes from the usage of the owner
+func (rpsPgx *Pgx) ReleaseImageUsage(ctx context.Context, ownerID uuid.UUID, size int64) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> ReleaseImageUsage -> error: %w", err)
+	}
+	_, err = rpsPgx.conn(ctx).Exec(ctx, "UPDATE image_usage SET bytes = GREATEST(bytes - $3, 0) WHERE tenant_id = $1 AND owner_id = $2",
+		tenantID, ownerID, size)
+	if err != nil {
+		return fmt.Errorf("Pgx -> ReleaseImageUsage -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// ImageUsage returns the number and the size of images of the owner
+func (rpsPgx *Pgx) ImageUsage(ctx context.Context, ownerID uuid.UUID) (*model.ImageUsage, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ImageUsage -> error: %w", err)
+	}
+	usage := model.ImageUsage{OwnerID: ownerID}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, "SELECT count(*), COALESCE(SUM(size), 0) FROM images WHERE tenant_id = $1 AND owner_id = $2",
+		tenantID, ownerID).Scan(&usage.Images, &usage.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ImageUsage -> QueryRow -> error: %w", err)
+	}
+	return &usage, nil
+}
+
+// ImageUsageReport returns usage of every owner of images of the tenant, the largest first, and the size of all images
+// and of their distinct contents
+func (rpsPgx *Pgx) ImageUsageReport(ctx context.Context) (*model.ImageUsageReport, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ImageUsageReport -> error: %w", err)
+	}
+	report := model.ImageUsageReport{Users: []model.ImageUsage{}}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, `SELECT COALESCE(SUM(size * refs), 0), COALESCE(SUM(size), 0)
+		FROM (SELECT MAX(size) AS size, count(*) AS refs FROM images WHERE tenant_id = $1 GROUP BY sha256) contents`,
+		tenantID).Scan(&report.Bytes, &report.StoredBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ImageUsageReport -> QueryRow -> error: %w", err)
+	}
+	rows, err := rpsPgx.conn(ctx).Query(ctx, `SELECT owner_id, count(*), SUM(size) FROM images WHERE tenant_id = $1
+		GROUP BY owner_id ORDER BY SUM(size) DESC, owner_id`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ImageUsageReport -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var usage model.ImageUsage
+		if err = rows.Scan(&usage.OwnerID, &usage.Images, &usage.Bytes); err != nil {
+			return nil, fmt.Errorf("Pgx -> ImageUsageReport -> Scan -> error: %w", err)
+		}
+		report.Users = append(report.Users, usage)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Pgx -> ImageUsageReport -> rows.Err -> error: %w", err)
+	}
+	return &report, nil
+}
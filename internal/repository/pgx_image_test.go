@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestImage(ownerID uuid.UUID) model.Image {
+	return model.Image{
+		ID:          uuid.New(),
+		OwnerID:     ownerID,
+		Filename:    "andy.png",
+		ContentType: "image/png",
+		Size:        1024,
+		Width:       32,
+		Height:      16,
+		SHA256:      "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		Origin:      model.ImageOriginAvatar,
+	}
+}
+
+func imageIDs(images []model.Image) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(images))
+	for i := range images {
+		ids = append(ids, images[i].ID)
+	}
+	return ids
+}
+
+func Test_PgxImageLifecycle(t *testing.T) {
+	img := newTestImage(uuid.New())
+	require.NoError(t, rps.CreateImage(testCtx, &img))
+	require.False(t, img.CreatedAt.IsZero())
+
+	stored, err := rps.GetImage(testCtx, img.ID)
+	require.NoError(t, err)
+	require.Equal(t, img.OwnerID, stored.OwnerID)
+	require.Equal(t, img.SHA256, stored.SHA256)
+	require.Equal(t, model.ImageOriginAvatar, stored.Origin)
+	otherCtx := identity.WithTenant(context.Background(), uuid.New())
+	_, err = rps.GetImage(otherCtx, img.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+
+	require.NoError(t, rps.DeleteImage(testCtx, img.ID))
+	err = rps.DeleteImage(testCtx, img.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_PgxGetImagesPages(t *testing.T) {
+	ownerID := uuid.New()
+	var created []uuid.UUID
+	for i := 0; i < 3; i++ {
+		img := newTestImage(ownerID)
+		require.NoError(t, rps.CreateImage(testCtx, &img))
+		created = append(created, img.ID)
+	}
+	other := newTestImage(uuid.New())
+	require.NoError(t, rps.CreateImage(testCtx, &other))
+
+	first, err := rps.GetImages(testCtx, ownerID, 2, 0)
+	require.NoError(t, err)
+	second, err := rps.GetImages(testCtx, ownerID, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	require.Len(t, second, 1)
+	require.ElementsMatch(t, created, append(imageIDs(first), imageIDs(second)...))
+	require.Equal(t, created[2], first[0].ID, "newest image goes first")
+}
+
+func Test_PgxImageUsage(t *testing.T) {
+	ownerID := uuid.New()
+	sha := uuid.NewString()
+	for i := 0; i < 2; i++ {
+		img := newTestImage(ownerID)
+		img.SHA256 = sha
+		require.NoError(t, rps.CreateImage(testCtx, &img))
+	}
+	usage, err := rps.ImageUsage(testCtx, ownerID)
+	require.NoError(t, err)
+	require.Equal(t, model.ImageUsage{OwnerID: ownerID, Images: 2, Bytes: 2048}, *usage)
+	usage, err = rps.ImageUsage(testCtx, uuid.New())
+	require.NoError(t, err)
+	require.Zero(t, usage.Bytes)
+
+	report, err := rps.ImageUsageReport(testCtx)
+	require.NoError(t, err)
+	require.Contains(t, report.Users, model.ImageUsage{OwnerID: ownerID, Images: 2, Bytes: 2048})
+	require.Less(t, report.StoredBytes, report.Bytes)
+}
+
+func Test_PgxImageContentRefs(t *testing.T) {
+	sha := newTestSHA256()
+	_, err := rps.ImageContentKey(testCtx, sha)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+	_, err = rps.AddImageRef(testCtx, sha, "")
+	require.True(t, errors.Is(err, model.ErrNotFound), "a content that isn't recorded isn't created without a key")
+
+	key, err := rps.AddImageRef(testCtx, sha, "first")
+	require.NoError(t, err)
+	require.Equal(t, "first", key)
+	key, err = rps.AddImageRef(testCtx, sha, "second")
+	require.NoError(t, err)
+	require.Equal(t, "first", key, "the content recorded first is shared")
+	key, err = rps.ImageContentKey(testCtx, sha)
+	require.NoError(t, err)
+	require.Equal(t, "first", key)
+
+	require.NoError(t, rps.ReleaseImageRef(testCtx, sha))
+	require.NoError(t, rps.ReleaseImageRef(testCtx, sha))
+	contents, err := rps.UnreferencedImageContents(context.Background(), time.Now().Add(time.Minute), 1000)
+	require.NoError(t, err)
+	var found *model.ImageContent
+	for i := range contents {
+		if contents[i].SHA256 == sha {
+			found = &contents[i]
+		}
+	}
+	require.NotNil(t, found)
+	require.Equal(t, testTenant.ID, found.TenantID)
+	require.Equal(t, "first", found.Key)
+
+	_, err = rps.AddImageRef(testCtx, sha, "")
+	require.NoError(t, err)
+	deleted, err := rps.DeleteImageContent(context.Background(), found)
+	require.NoError(t, err)
+	require.False(t, deleted, "a content referred to again is kept")
+	require.NoError(t, rps.ReleaseImageRef(testCtx, sha))
+	deleted, err = rps.DeleteImageContent(context.Background(), found)
+	require.NoError(t, err)
+	require.True(t, deleted)
+	_, err = rps.ImageContentKey(testCtx, sha)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_PgxReserveImageUsage(t *testing.T) {
+	ownerID := uuid.New()
+	require.NoError(t, rps.ReserveImageUsage(testCtx, ownerID, 600, 1000))
+	err := rps.ReserveImageUsage(testCtx, ownerID, 600, 1000)
+	require.True(t, errors.Is(err, model.ErrQuotaExceeded))
+	require.NoError(t, rps.ReserveImageUsage(testCtx, ownerID, 400, 1000))
+	require.NoError(t, rps.ReleaseImageUsage(testCtx, ownerID, 600))
+	require.NoError(t, rps.ReserveImageUsage(testCtx, ownerID, 600, 1000))
+	require.NoError(t, rps.ReserveImageUsage(testCtx, ownerID, 5000, 0), "0 means no quota")
+	err = rps.ReserveImageUsage(testCtx, uuid.New(), 1001, 1000)
+	require.True(t, errors.Is(err, model.ErrQuotaExceeded))
+}
+
+// newTestSHA256 returns a random SHA-256 in hex
+func newTestSHA256() string {
+	sum := sha256.Sum256([]byte(uuid.NewString()))
+	return hex.EncodeToString(sum[:])
+}
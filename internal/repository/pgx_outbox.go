@@ -0,0 +1,96 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+)
+
+// AddToOutbox writes event to outbox table, called inside of WithTx it becomes a part of the transaction
+func (rpsPgx *Pgx) AddToOutbox(ctx context.Context, event *model.OutboxEvent) error {
+	if event == nil {
+		return ErrNil
+	}
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> AddToOutbox -> error: %w", err)
+	}
+	event.TenantID = tenantID
+	_, err = rpsPgx.conn(ctx).Exec(ctx, "INSERT INTO outbox(id, tenant_id, aggregate_id, event_type, payload) VALUES($1, $2, $3, $4, $5)",
+		event.ID, event.TenantID, event.AggregateID, event.Type, event.Payload)
+	if err != nil {
+		return fmt.Errorf("Pgx -> AddToOutbox -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// ClaimOutbox leases up to limit unpublished events for the given time, so relays of the other replicas skip them
+func (rpsPgx *Pgx) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error) {
+	rows, err := rpsPgx.conn(ctx).Query(ctx, `UPDATE outbox SET claimed_until = now() + $2::interval
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NULL AND dead_at IS NULL AND (claimed_until IS NULL OR claimed_until < now())
+			ORDER BY created_at LIMIT $1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, tenant_id, aggregate_id, event_type, payload, created_at, attempts`, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ClaimOutbox -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var event model.OutboxEvent
+		err = rows.Scan(&event.ID, &event.TenantID, &event.AggregateID, &event.Type, &event.Payload, &event.CreatedAt, &event.Attempts)
+		if err != nil {
+			return events, fmt.Errorf("Pgx -> ClaimOutbox -> Scan -> error: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return events, fmt.Errorf("Pgx -> ClaimOutbox -> rows.Err -> error: %w", err)
+	}
+	return events, nil
+}
+
+// MarkOutboxPublished marks event as published
+func (rpsPgx *Pgx) MarkOutboxPublished(ctx context.Context, id uuid.UUID) error {
+	_, err := rpsPgx.conn(ctx).Exec(ctx, "UPDATE outbox SET published_at = now(), claimed_until = NULL WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("Pgx -> MarkOutboxPublished -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// MarkOutboxFailed records failed attempt of the event and postpones the next one for retryAfter
+func (rpsPgx *Pgx) MarkOutboxFailed(ctx context.Context, id uuid.UUID, reason string, retryAfter time.Duration) error {
+	_, err := rpsPgx.conn(ctx).Exec(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = $2, claimed_until = now() + $3::interval WHERE id = $1",
+		id, reason, retryAfter)
+	if err != nil {
+		return fmt.Errorf("Pgx -> MarkOutboxFailed -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// MarkOutboxDead records the last failed attempt of the event and dead-letters it, so it is never claimed again
+func (rpsPgx *Pgx) MarkOutboxDead(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := rpsPgx.conn(ctx).Exec(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = $2, dead_at = now(), claimed_until = NULL WHERE id = $1",
+		id, reason)
+	if err != nil {
+		return fmt.Errorf("Pgx -> MarkOutboxDead -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// PruneOutbox deletes events published before the given time and returns how many were deleted
+func (rpsPgx *Pgx) PruneOutbox(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	tag, err := rpsPgx.conn(ctx).Exec(ctx, "DELETE FROM outbox WHERE published_at < $1", publishedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("Pgx -> PruneOutbox -> Exec -> error: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
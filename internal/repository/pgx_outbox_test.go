@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newOutboxEvent() model.OutboxEvent {
+	return model.OutboxEvent{ID: uuid.New(), AggregateID: uuid.New(), Type: "PersonCreated", Payload: []byte(`{"salary":100}`)}
+}
+
+// claimAll claims every claimable event of claim and returns their IDs
+func claimAll(t *testing.T, claim func(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error)) map[uuid.UUID]model.OutboxEvent {
+	events, err := claim(context.Background(), 1000, time.Minute)
+	require.NoError(t, err)
+	claimed := make(map[uuid.UUID]model.OutboxEvent)
+	for _, event := range events {
+		claimed[event.ID] = event
+	}
+	return claimed
+}
+
+func Test_PgxOutboxRolledBackWithTx(t *testing.T) {
+	event := newOutboxEvent()
+	err := rps.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rps.AddToOutbox(ctx, &event); err != nil {
+			return err
+		}
+		return errTxTest
+	})
+	require.Error(t, err)
+	_, ok := claimAll(t, rps.ClaimOutbox)[event.ID]
+	require.False(t, ok)
+}
+
+func Test_PgxOutboxClaimAndPublish(t *testing.T) {
+	event := newOutboxEvent()
+	err := rps.AddToOutbox(testCtx, &event)
+	require.NoError(t, err)
+
+	claimed, ok := claimAll(t, rps.ClaimOutbox)[event.ID]
+	require.True(t, ok)
+	require.Equal(t, testTenant.ID, claimed.TenantID)
+	require.JSONEq(t, string(event.Payload), string(claimed.Payload))
+	_, ok = claimAll(t, rps.ClaimOutbox)[event.ID]
+	require.False(t, ok, "claimed event must be hidden until its lease expires")
+
+	err = rps.MarkOutboxPublished(context.Background(), event.ID)
+	require.NoError(t, err)
+}
+
+func Test_PgxOutboxMarkFailed(t *testing.T) {
+	event := newOutboxEvent()
+	err := rps.AddToOutbox(testCtx, &event)
+	require.NoError(t, err)
+	claimAll(t, rps.ClaimOutbox)
+
+	err = rps.MarkOutboxFailed(context.Background(), event.ID, "stream is down", -time.Second)
+	require.NoError(t, err)
+	claimed, ok := claimAll(t, rps.ClaimOutbox)[event.ID]
+	require.True(t, ok, "failed event must be claimed again after its retry delay")
+	require.Equal(t, 1, claimed.Attempts)
+}
+
+func Test_PgxOutboxDeadAndPrune(t *testing.T) {
+	dead, published := newOutboxEvent(), newOutboxEvent()
+	require.NoError(t, rps.AddToOutbox(testCtx, &dead))
+	require.NoError(t, rps.AddToOutbox(testCtx, &published))
+
+	err := rps.MarkOutboxDead(context.Background(), dead.ID, "poison")
+	require.NoError(t, err)
+	_, ok := claimAll(t, rps.ClaimOutbox)[dead.ID]
+	require.False(t, ok, "dead-lettered event must never be claimed")
+
+	err = rps.MarkOutboxPublished(context.Background(), published.ID)
+	require.NoError(t, err)
+	deleted, err := rps.PruneOutbox(context.Background(), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, deleted, int64(1))
+}
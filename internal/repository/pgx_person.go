@@ -3,11 +3,14 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/distuurbia/firstTask/internal/identity"
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -26,7 +29,16 @@ func (rpsPgx *Pgx) Create(ctx context.Context, pers *model.Person) error {
 	if pers == nil {
 		return ErrNil
 	}
-	_, err := rpsPgx.db.Exec(ctx, "INSERT INTO persondb(salary, married, profession, id) VALUES($1, $2, $3, $4)", pers.Salary, pers.Married, pers.Profession, pers.ID)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> Create -> error: %w", err)
+	}
+	_, err = rpsPgx.conn(ctx).Exec(ctx, "INSERT INTO persondb(salary, married, profession, avatar_image_id, id, tenant_id) VALUES($1, $2, $3, $4, $5, $6)",
+		pers.Salary, pers.Married, pers.Profession, pers.AvatarImageID, pers.ID, tenantID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrPersonExist
+	}
 	if err != nil {
 		return fmt.Errorf("Pgx -> Create -> error: %w", err)
 	}
@@ -36,24 +48,83 @@ func (rpsPgx *Pgx) Create(ctx context.Context, pers *model.Person) error {
 // ReadRow reads a row from postgreSQL
 func (rpsPgx *Pgx) ReadRow(ctx context.Context, id uuid.UUID) (*model.Person, error) {
 	var pers model.Person
-	err := rpsPgx.db.QueryRow(ctx, "SELECT id, salary, married, profession FROM persondb WHERE id = $1", id).Scan(&pers.ID, &pers.Salary, &pers.Married, &pers.Profession)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return &pers, fmt.Errorf("Pgx -> ReadRow -> error:  %w", err)
+	}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, "SELECT id, salary, married, profession, avatar_image_id FROM persondb WHERE id = $1 AND tenant_id = $2", id, tenantID).
+		Scan(&pers.ID, &pers.Salary, &pers.Married, &pers.Profession, &pers.AvatarImageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &pers, fmt.Errorf("Pgx -> ReadRow -> error: %w", ErrPersonNotFound)
+	}
 	if err != nil {
 		return &pers, fmt.Errorf("Pgx -> ReadRow -> error:  %w", err)
 	}
 	return &pers, nil
 }
 
+// ReadRowForUpdate reads a row from postgreSQL and locks it until the end of the transaction, it must be called
+// inside of WithTx
+func (rpsPgx *Pgx) ReadRowForUpdate(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	var pers model.Person
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ReadRowForUpdate -> error: %w", err)
+	}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, `SELECT id, salary, married, profession, avatar_image_id FROM persondb
+		WHERE id = $1 AND tenant_id = $2 FOR UPDATE`, id, tenantID).
+		Scan(&pers.ID, &pers.Salary, &pers.Married, &pers.Profession, &pers.AvatarImageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("Pgx -> ReadRowForUpdate -> error: %w", ErrPersonNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> ReadRowForUpdate -> error: %w", err)
+	}
+	return &pers, nil
+}
+
+// GetByAvatarForUpdate reads and locks rows of persons that have the image as avatar, it must be called inside of WithTx
+func (rpsPgx *Pgx) GetByAvatarForUpdate(ctx context.Context, imageID uuid.UUID) ([]model.Person, error) {
+	var persons []model.Person
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetByAvatarForUpdate -> error: %w", err)
+	}
+	rows, err := rpsPgx.conn(ctx).Query(ctx, `SELECT id, salary, married, profession, avatar_image_id FROM persondb
+		WHERE tenant_id = $1 AND avatar_image_id = $2 FOR UPDATE`, tenantID, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetByAvatarForUpdate -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pers model.Person
+		err = rows.Scan(&pers.ID, &pers.Salary, &pers.Married, &pers.Profession, &pers.AvatarImageID)
+		if err != nil {
+			return nil, fmt.Errorf("Pgx -> GetByAvatarForUpdate -> Scan -> error: %w", err)
+		}
+		persons = append(persons, pers)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Pgx -> GetByAvatarForUpdate -> rows.Err -> error: %w", err)
+	}
+	return persons, nil
+}
+
 // GetAll reads an all rows in postgreSQL
 func (rpsPgx *Pgx) GetAll(ctx context.Context) ([]model.Person, error) {
 	var allPers []model.Person
-	rows, err := rpsPgx.db.Query(ctx, "SELECT id, salary, married, profession FROM persondb")
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetAll -> error: %w", err)
+	}
+	rows, err := rpsPgx.conn(ctx).Query(ctx, "SELECT id, salary, married, profession, avatar_image_id FROM persondb WHERE tenant_id = $1", tenantID)
 	if err != nil {
 		return nil, fmt.Errorf("Pgx -> GetAll -> error: %w", err)
 	}
 	defer rows.Close()
-	var pers model.Person
 	for rows.Next() {
-		err = rows.Scan(&pers.ID, &pers.Salary, &pers.Married, &pers.Profession)
+		var pers model.Person
+		err = rows.Scan(&pers.ID, &pers.Salary, &pers.Married, &pers.Profession, &pers.AvatarImageID)
 		if err != nil {
 			return allPers, fmt.Errorf("Pgx -> GetAll -> Scan -> error: %w", err)
 		}
@@ -64,24 +135,48 @@ func (rpsPgx *Pgx) GetAll(ctx context.Context) ([]model.Person, error) {
 
 // Update updates a row in postgreSQL
 func (rpsPgx *Pgx) Update(ctx context.Context, pers *model.Person) error {
-	res, err := rpsPgx.db.Exec(ctx, "UPDATE persondb SET salary = $1, married = $2, profession = $3 WHERE id = $4", pers.Salary, pers.Married, pers.Profession, pers.ID)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> Update -> error: %w", err)
+	}
+	res, err := rpsPgx.conn(ctx).Exec(ctx, "UPDATE persondb SET salary = $1, married = $2, profession = $3, avatar_image_id = $4 WHERE id = $5 AND tenant_id = $6",
+		pers.Salary, pers.Married, pers.Profession, pers.AvatarImageID, pers.ID, tenantID)
 	if err != nil {
 		return fmt.Errorf("Pgx -> Update -> error: %w", err)
 	}
 	if res.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+		return fmt.Errorf("Pgx -> Update -> error: %w", ErrPersonNotFound)
 	}
 	return nil
 }
 
 // Delete deletes a row in postgreSQL
 func (rpsPgx *Pgx) Delete(ctx context.Context, id uuid.UUID) error {
-	res, err := rpsPgx.db.Exec(ctx, "DELETE FROM persondb WHERE id = $1", id)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> Delete -> error: %w", err)
+	}
+	res, err := rpsPgx.conn(ctx).Exec(ctx, "DELETE FROM persondb WHERE id = $1 AND tenant_id = $2", id, tenantID)
 	if err != nil {
 		return fmt.Errorf("Pgx -> Delete -> error: %w", err)
 	}
 	if res.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+		return fmt.Errorf("Pgx -> Delete -> error: %w", ErrPersonNotFound)
 	}
 	return nil
 }
+
+// AvatarInUse tells if any person of the tenant has the image as avatar
+func (rpsPgx *Pgx) AvatarInUse(ctx context.Context, imageID uuid.UUID) (bool, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("Pgx -> AvatarInUse -> error: %w", err)
+	}
+	var inUse bool
+	err = rpsPgx.conn(ctx).QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM persondb WHERE tenant_id = $1 AND avatar_image_id = $2)",
+		tenantID, imageID).Scan(&inUse)
+	if err != nil {
+		return false, fmt.Errorf("Pgx -> AvatarInUse -> error: %w", err)
+	}
+	return inUse, nil
+}
@@ -9,14 +9,13 @@ import (
 
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/require"
 )
 
 func Test_PgxCreate(t *testing.T) {
-	err := rps.Create(context.Background(), &pgxVladimir)
+	err := rps.Create(testCtx, &pgxVladimir)
 	require.NoError(t, err)
-	testVladimir, err := rps.ReadRow(context.Background(), pgxVladimir.ID)
+	testVladimir, err := rps.ReadRow(testCtx, pgxVladimir.ID)
 	require.NoError(t, err)
 	require.Equal(t, testVladimir.ID, pgxVladimir.ID)
 	require.Equal(t, testVladimir.Salary, pgxVladimir.Salary)
@@ -25,24 +24,24 @@ func Test_PgxCreate(t *testing.T) {
 }
 
 func Test_PgxCreateNil(t *testing.T) {
-	err := rps.Create(context.Background(), nil)
+	err := rps.Create(testCtx, nil)
 	require.True(t, errors.Is(err, ErrNil))
 }
 
 func Test_PgxCreateDuplicate(t *testing.T) {
-	err := rps.Create(context.Background(), &pgxVladimir)
+	err := rps.Create(testCtx, &pgxVladimir)
 	require.Error(t, err)
 }
 
 func Test_PgxCreateContextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	err := rps.Create(ctx, &pgxVladimir)
 	require.True(t, errors.Is(err, context.DeadlineExceeded))
 }
 func Test_PgxReadRow(t *testing.T) {
-	testVladimir, err := rps.ReadRow(context.Background(), pgxVladimir.ID)
+	testVladimir, err := rps.ReadRow(testCtx, pgxVladimir.ID)
 	require.NoError(t, err)
 	require.Equal(t, testVladimir.ID, pgxVladimir.ID)
 	require.Equal(t, testVladimir.Salary, pgxVladimir.Salary)
@@ -52,12 +51,12 @@ func Test_PgxReadRow(t *testing.T) {
 
 func Test_PgxReadRowNotFound(t *testing.T) {
 	var id uuid.UUID
-	_, err := rps.ReadRow(context.Background(), id)
-	require.True(t, errors.Is(err, pgx.ErrNoRows))
+	_, err := rps.ReadRow(testCtx, id)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_PgxReadRowContextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	_, err := rps.ReadRow(ctx, pgxVladimir.ID)
@@ -65,10 +64,10 @@ func Test_PgxReadRowContextTimeout(t *testing.T) {
 }
 
 func Test_PgxGetAll(t *testing.T) {
-	allPers, err := rps.GetAll(context.Background())
+	allPers, err := rps.GetAll(testCtx)
 	require.NoError(t, err)
 	var numberPersons int
-	err = rps.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM persondb").Scan(&numberPersons)
+	err = rps.db.QueryRow(testCtx, "SELECT COUNT(*) FROM persondb WHERE tenant_id = $1", testTenant.ID).Scan(&numberPersons)
 	require.NoError(t, err)
 	require.Equal(t, len(allPers), numberPersons)
 }
@@ -77,9 +76,9 @@ func Test_PgxUpdate(t *testing.T) {
 	pgxVladimir.Salary = 700
 	pgxVladimir.Married = false
 	pgxVladimir.Profession = "Lawer"
-	err := rps.Update(context.Background(), &pgxVladimir)
+	err := rps.Update(testCtx, &pgxVladimir)
 	require.NoError(t, err)
-	testVladimir, err := rps.ReadRow(context.Background(), pgxVladimir.ID)
+	testVladimir, err := rps.ReadRow(testCtx, pgxVladimir.ID)
 	require.NoError(t, err)
 	require.Equal(t, testVladimir.ID, pgxVladimir.ID)
 	require.Equal(t, testVladimir.Salary, pgxVladimir.Salary)
@@ -89,12 +88,12 @@ func Test_PgxUpdate(t *testing.T) {
 
 func Test_PgxUpdateNotFound(t *testing.T) {
 	var emptyEntity model.Person
-	err := rps.Update(context.Background(), &emptyEntity)
-	require.True(t, errors.Is(err, pgx.ErrNoRows))
+	err := rps.Update(testCtx, &emptyEntity)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_PgxUpdateContextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	err := rps.Update(ctx, &pgxVladimir)
@@ -102,22 +101,53 @@ func Test_PgxUpdateContextTimeout(t *testing.T) {
 }
 
 func Test_PgxDelete(t *testing.T) {
-	err := rps.Delete(context.Background(), pgxVladimir.ID)
+	err := rps.Delete(testCtx, pgxVladimir.ID)
 	require.NoError(t, err)
-	_, err = rps.ReadRow(context.Background(), pgxVladimir.ID)
-	require.True(t, errors.Is(err, pgx.ErrNoRows))
+	_, err = rps.ReadRow(testCtx, pgxVladimir.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_PgxDeleteNotFound(t *testing.T) {
 	var id uuid.UUID
-	err := rps.Delete(context.Background(), id)
-	require.True(t, errors.Is(err, pgx.ErrNoRows))
+	err := rps.Delete(testCtx, id)
+	require.True(t, errors.Is(err, model.ErrNotFound))
 }
 
 func Test_PgxDeleteContextTimeout(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	ctx, cancel := context.WithTimeout(testCtx, time.Second*1)
 	time.Sleep(1 * time.Second)
 	defer cancel()
 	err := rps.Delete(ctx, pgxVladimir.ID)
 	require.True(t, errors.Is(err, context.DeadlineExceeded))
 }
+
+func Test_PgxAvatar(t *testing.T) {
+	img := newTestImage(uuid.New())
+	require.NoError(t, rps.CreateImage(testCtx, &img))
+	pers := newTestPerson()
+	require.NoError(t, rps.Create(testCtx, pers))
+	inUse, err := rps.AvatarInUse(testCtx, img.ID)
+	require.NoError(t, err)
+	require.False(t, inUse)
+
+	pers.AvatarImageID = &img.ID
+	require.NoError(t, rps.Update(testCtx, pers))
+	stored, err := rps.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, pers, stored)
+	inUse, err = rps.AvatarInUse(testCtx, img.ID)
+	require.NoError(t, err)
+	require.True(t, inUse)
+	err = rps.WithTx(testCtx, func(ctx context.Context) error {
+		persons, err := rps.GetByAvatarForUpdate(ctx, img.ID)
+		require.NoError(t, err)
+		require.Equal(t, []model.Person{*pers}, persons)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, rps.DeleteImage(testCtx, img.ID))
+	stored, err = rps.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Nil(t, stored.AvatarImageID)
+}
@@ -0,0 +1,34 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+)
+
+// CreateTenant creates a row in tenants table
+func (rpsPgx *Pgx) CreateTenant(ctx context.Context, tenant *model.Tenant) error {
+	if tenant == nil {
+		return ErrNil
+	}
+	_, err := rpsPgx.conn(ctx).Exec(ctx, "INSERT INTO tenants(id, name) VALUES($1, $2)", tenant.ID, tenant.Name)
+	if err != nil {
+		return fmt.Errorf("Pgx -> CreateTenant -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// DeleteTenant deletes a row from tenants table, persons and users of the tenant are deleted by cascade
+func (rpsPgx *Pgx) DeleteTenant(ctx context.Context, id uuid.UUID) error {
+	res, err := rpsPgx.conn(ctx).Exec(ctx, "DELETE FROM tenants WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("Pgx -> DeleteTenant -> Exec -> error: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return ErrTenantNotFound
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PgxCreateWithoutTenant(t *testing.T) {
+	pers := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	err := rps.Create(context.Background(), &pers)
+	require.True(t, errors.Is(err, identity.ErrNoTenant))
+}
+
+func Test_PgxTenantIsolation(t *testing.T) {
+	otherTenant := model.Tenant{ID: uuid.New(), Name: "otherTenant"}
+	err := rps.CreateTenant(context.Background(), &otherTenant)
+	require.NoError(t, err)
+	otherCtx := identity.WithTenant(context.Background(), otherTenant.ID)
+
+	pers := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	err = rps.Create(testCtx, &pers)
+	require.NoError(t, err)
+	_, err = rps.ReadRow(otherCtx, pers.ID)
+	require.True(t, errors.Is(err, pgx.ErrNoRows))
+	err = rps.Delete(otherCtx, pers.ID)
+	require.True(t, errors.Is(err, pgx.ErrNoRows))
+	allPers, err := rps.GetAll(otherCtx)
+	require.NoError(t, err)
+	require.Empty(t, allPers)
+
+	err = rps.Delete(testCtx, pers.ID)
+	require.NoError(t, err)
+	err = rps.DeleteTenant(context.Background(), otherTenant.ID)
+	require.NoError(t, err)
+}
+
+func Test_PgxDeleteTenantCascade(t *testing.T) {
+	tenant := model.Tenant{ID: uuid.New(), Name: "goneTenant"}
+	err := rps.CreateTenant(context.Background(), &tenant)
+	require.NoError(t, err)
+	tenantCtx := identity.WithTenant(context.Background(), tenant.ID)
+	pers := model.Person{ID: uuid.New(), Salary: 500, Profession: "baker"}
+	err = rps.Create(tenantCtx, &pers)
+	require.NoError(t, err)
+
+	err = rps.DeleteTenant(context.Background(), tenant.ID)
+	require.NoError(t, err)
+	var numberPersons int
+	err = rps.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM persondb WHERE id = $1", pers.ID).Scan(&numberPersons)
+	require.NoError(t, err)
+	require.Zero(t, numberPersons)
+}
+
+func Test_PgxDeleteTenantNotFound(t *testing.T) {
+	err := rps.DeleteTenant(context.Background(), uuid.New())
+	require.True(t, errors.Is(err, ErrTenantNotFound))
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_PgxSignUpUnknownTenant(t *testing.T) {
+	user := model.User{ID: uuid.New(), Username: "nobody", Password: []byte("secret")}
+	err := rps.SignUp(identity.WithTenant(context.Background(), uuid.New()), &user)
+	require.True(t, errors.Is(err, ErrTenantNotFound))
+}
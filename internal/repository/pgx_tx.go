@@ -0,0 +1,74 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// pgxTxKey is a context key of the pgx.Tx started by WithTx
+type pgxTxKey struct{}
+
+// txStateKey is a context key of the txState of the outermost WithTx
+type txStateKey struct{}
+
+// txState is shared by the outermost WithTx and the nested ones, it remembers the first error of a nested WithTx
+type txState struct {
+	failed error
+}
+
+// nestedTx runs fn of a nested WithTx in the transaction of the outer one and marks the transaction rollback-only
+// if fn fails, so the behaviour doesn't depend on whether the database has savepoints
+func nestedTx(ctx context.Context, state *txState, fn func(ctx context.Context) error) error {
+	err := fn(ctx)
+	if err != nil && state.failed == nil {
+		state.failed = err
+	}
+	return err
+}
+
+// outerTxErr returns the error the outermost WithTx rolls back with: the error of fn or of a failed nested WithTx
+func outerTxErr(state *txState, err error) error {
+	if err == nil && state.failed != nil {
+		return fmt.Errorf("%v: %w", state.failed, ErrTxRollbackOnly)
+	}
+	return err
+}
+
+// querier contains methods that both *pgxpool.Pool and pgx.Tx have
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// conn returns transaction from ctx if the call is made inside of WithTx and the pool otherwise
+func (rpsPgx *Pgx) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(pgxTxKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return rpsPgx.db
+}
+
+// WithTx runs fn in a transaction that is committed if fn returns nil and rolled back otherwise.
+// Repository methods called with the ctx given to fn run in that transaction.
+// A nested WithTx joins the outer transaction like in mongoDB: nothing is committed until the outermost WithTx
+// returns, and if the nested fn fails the whole transaction is rolled back, even if the outer fn handles the error
+// and returns nil, then the outermost WithTx returns ErrTxRollbackOnly.
+func (rpsPgx *Pgx) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if state, ok := ctx.Value(txStateKey{}).(*txState); ok {
+		return nestedTx(ctx, state, fn)
+	}
+	state := &txState{}
+	err := pgx.BeginFunc(ctx, rpsPgx.db, func(tx pgx.Tx) error {
+		txCtx := context.WithValue(context.WithValue(ctx, pgxTxKey{}, tx), txStateKey{}, state)
+		return outerTxErr(state, fn(txCtx))
+	})
+	if err != nil {
+		return fmt.Errorf("Pgx -> WithTx -> error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var errTxTest = fmt.Errorf("tx test error")
+
+func newTxPerson() model.Person {
+	return model.Person{ID: uuid.New(), Salary: 1000, Profession: "cashier"}
+}
+
+func Test_PgxWithTxCommit(t *testing.T) {
+	first, second := newTxPerson(), newTxPerson()
+	err := rps.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rps.Create(ctx, &first); err != nil {
+			return err
+		}
+		_, err := rps.ReadRow(ctx, first.ID)
+		require.NoError(t, err)
+		_, err = rps.ReadRow(testCtx, first.ID)
+		require.True(t, errors.Is(err, model.ErrNotFound), "uncommitted row must not be visible outside of the transaction")
+		return rps.Create(ctx, &second)
+	})
+	require.NoError(t, err)
+	_, err = rps.ReadRow(testCtx, first.ID)
+	require.NoError(t, err)
+	_, err = rps.ReadRow(testCtx, second.ID)
+	require.NoError(t, err)
+}
+
+func Test_PgxWithTxRollback(t *testing.T) {
+	first := newTxPerson()
+	err := rps.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rps.Create(ctx, &first); err != nil {
+			return err
+		}
+		return errTxTest
+	})
+	require.True(t, errors.Is(err, errTxTest))
+	_, err = rps.ReadRow(testCtx, first.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_PgxWithTxNestedFailureRollsBackOuter(t *testing.T) {
+	outer, inner := newTxPerson(), newTxPerson()
+	err := rps.WithTx(testCtx, func(ctx context.Context) error {
+		if err := rps.Create(ctx, &outer); err != nil {
+			return err
+		}
+		err := rps.WithTx(ctx, func(ctx context.Context) error {
+			if err := rps.Create(ctx, &inner); err != nil {
+				return err
+			}
+			return errTxTest
+		})
+		require.True(t, errors.Is(err, errTxTest))
+		return nil
+	})
+	require.True(t, errors.Is(err, ErrTxRollbackOnly))
+	_, err = rps.ReadRow(testCtx, outer.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+	_, err = rps.ReadRow(testCtx, inner.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
+
+func Test_PgxWithTxNestedOuterRollback(t *testing.T) {
+	inner := newTxPerson()
+	err := rps.WithTx(testCtx, func(ctx context.Context) error {
+		err := rps.WithTx(ctx, func(ctx context.Context) error {
+			return rps.Create(ctx, &inner)
+		})
+		require.NoError(t, err)
+		return errTxTest
+	})
+	require.True(t, errors.Is(err, errTxTest))
+	_, err = rps.ReadRow(testCtx, inner.ID)
+	require.True(t, errors.Is(err, model.ErrNotFound))
+}
@@ -3,26 +3,39 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/distuurbia/firstTask/internal/identity"
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-// SignUp creates new user in users table
+// uniqueViolation is a postgreSQL error code of the unique constraint violation
+const uniqueViolation = "23505"
+
+// foreignKeyViolation is a postgreSQL error code of the foreign key constraint violation
+const foreignKeyViolation = "23503"
+
+// SignUp creates new user in users table, uniqueness of the username is guaranteed by the unique index
 func (rpsPgx *Pgx) SignUp(ctx context.Context, user *model.User) error {
 	if user == nil {
 		return ErrNil
 	}
-	var numberUsers int
-	err := rpsPgx.db.QueryRow(context.Background(), "SELECT COUNT(*) FROM users WHERE username = $1", user.Username).Scan(&numberUsers)
+	tenantID, err := identity.TenantFromContext(ctx)
 	if err != nil {
-		return fmt.Errorf("Pgx -> SignUp -> QueryRow -> error: %w", err)
+		return fmt.Errorf("Pgx -> SignUp -> error: %w", err)
 	}
-	if numberUsers != 0 {
+	_, err = rpsPgx.conn(ctx).Exec(ctx, "INSERT INTO users(id, username, password, tenant_id) VALUES($1, $2, $3, $4)", user.ID, user.Username, user.Password, tenantID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
 		return ErrExist
 	}
-	_, err = rpsPgx.db.Exec(ctx, "INSERT INTO users(id, username, password) VALUES($1, $2, $3)", user.ID, user.Username, user.Password)
+	// users reference tenants, so the insert fails for a tenant that isn't registered
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		return ErrTenantNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("Pgx -> SignUp -> Exec -> error: %w", err)
 	}
@@ -33,7 +46,11 @@ func (rpsPgx *Pgx) SignUp(ctx context.Context, user *model.User) error {
 func (rpsPgx *Pgx) GetPasswordAndIDByUsername(ctx context.Context, username string) (uuid.UUID, []byte, error) {
 	var user model.User
 	user.Username = username
-	err := rpsPgx.db.QueryRow(ctx, "SELECT id, password FROM users WHERE username = $1", user.Username).Scan(&user.ID, &user.Password)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return uuid.UUID{}, nil, fmt.Errorf("Pgx -> GetPasswordAndIDByUserName -> error: %w", err)
+	}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, "SELECT id, password FROM users WHERE lower(username) = lower($1) AND tenant_id = $2", user.Username, tenantID).Scan(&user.ID, &user.Password)
 	if err != nil {
 		return uuid.UUID{}, nil, fmt.Errorf("Pgx -> GetPasswordAndIDByUserName -> QueryRow -> error: %w", err)
 	}
@@ -43,7 +60,11 @@ func (rpsPgx *Pgx) GetPasswordAndIDByUsername(ctx context.Context, username stri
 // GetRefreshTokenByID returnes refreshToken from users table by id
 func (rpsPgx *Pgx) GetRefreshTokenByID(ctx context.Context, id uuid.UUID) (string, error) {
 	var hash string
-	err := rpsPgx.db.QueryRow(ctx, "SELECT refreshToken FROM users WHERE id = $1", id).Scan(&hash)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Pgx -> GetRefreshTokenByName -> error: %w", err)
+	}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, "SELECT refreshToken FROM users WHERE id = $1 AND tenant_id = $2", id, tenantID).Scan(&hash)
 	if err != nil {
 		return "", fmt.Errorf("Pgx -> GetRefreshTokenByName -> QueryRow -> error: %w", err)
 	}
@@ -52,7 +73,11 @@ func (rpsPgx *Pgx) GetRefreshTokenByID(ctx context.Context, id uuid.UUID) (strin
 
 // AddRefreshToken adds refreshToken to users table by id
 func (rpsPgx *Pgx) AddRefreshToken(ctx context.Context, user *model.User) error {
-	_, err := rpsPgx.db.Exec(ctx, "UPDATE users SET refreshtoken = $1 WHERE id = $2", user.RefreshToken, user.ID)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> AddRefreshToken -> error: %w", err)
+	}
+	_, err = rpsPgx.conn(ctx).Exec(ctx, "UPDATE users SET refreshtoken = $1 WHERE id = $2 AND tenant_id = $3", user.RefreshToken, user.ID, tenantID)
 	if err != nil {
 		return fmt.Errorf("Pgx -> AddRefreshToken -> Exec -> error: %w", err)
 	}
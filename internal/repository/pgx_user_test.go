@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// signUpConcurrently signs up the same username in different cases from parallel goroutines and returns their errors
+func signUpConcurrently(signUp func(user *model.User) error, username string, parallel int) []error {
+	errs := make([]error, parallel)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := strings.ToLower(username)
+			if i%2 == 0 {
+				name = strings.ToUpper(username)
+			}
+			user := model.User{ID: uuid.New(), Username: name, Password: []byte("secret")}
+			<-start
+			errs[i] = signUp(&user)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+// requireOneWinner checks that exactly one signUp succeeded and all the others got ErrExist
+func requireOneWinner(t *testing.T, errs []error) {
+	var winners int
+	for _, err := range errs {
+		if err == nil {
+			winners++
+			continue
+		}
+		require.True(t, errors.Is(err, ErrExist), err)
+	}
+	require.Equal(t, 1, winners)
+}
+
+func Test_PgxSignUpDuplicate(t *testing.T) {
+	user := model.User{ID: uuid.New(), Username: "duplicate", Password: []byte("secret")}
+	err := rps.SignUp(testCtx, &user)
+	require.NoError(t, err)
+	user.ID = uuid.New()
+	err = rps.SignUp(testCtx, &user)
+	require.True(t, errors.Is(err, ErrExist))
+}
+
+func Test_PgxSignUpConcurrent(t *testing.T) {
+	const parallel = 20
+	errs := signUpConcurrently(func(user *model.User) error {
+		return rps.SignUp(testCtx, user)
+	}, "pgxRacer", parallel)
+	requireOneWinner(t, errs)
+}
+
+func Test_PgxGetPasswordAndIDByUsernameIgnoresCase(t *testing.T) {
+	user := model.User{ID: uuid.New(), Username: "casefree", Password: []byte("secret")}
+	err := rps.SignUp(testCtx, &user)
+	require.NoError(t, err)
+	id, _, err := rps.GetPasswordAndIDByUsername(testCtx, "CaseFree")
+	require.NoError(t, err)
+	require.Equal(t, user.ID, id)
+}
@@ -0,0 +1,163 @@
+// Package repository is a package for work with db methods
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateWebhook creates a row in webhooks table
+func (rpsPgx *Pgx) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	if webhook == nil {
+		return ErrNil
+	}
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> CreateWebhook -> error: %w", err)
+	}
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	err = rpsPgx.conn(ctx).QueryRow(ctx, `INSERT INTO webhooks(id, tenant_id, url, event_types, secret) VALUES($1, $2, $3, $4, $5)
+		RETURNING created_at`, webhook.ID, tenantID, webhook.URL, webhook.EventTypes, webhook.Secret).Scan(&webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Pgx -> CreateWebhook -> QueryRow -> error: %w", err)
+	}
+	return nil
+}
+
+// GetWebhooks reads all webhooks of the tenant
+func (rpsPgx *Pgx) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetWebhooks -> error: %w", err)
+	}
+	rows, err := rpsPgx.conn(ctx).Query(ctx, `SELECT id, url, event_types, secret, disabled, failures, created_at
+		FROM webhooks WHERE tenant_id = $1 ORDER BY created_at`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetWebhooks -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	var webhooks []model.Webhook
+	for rows.Next() {
+		var webhook model.Webhook
+		err = rows.Scan(&webhook.ID, &webhook.URL, &webhook.EventTypes, &webhook.Secret, &webhook.Disabled, &webhook.Failures, &webhook.CreatedAt)
+		if err != nil {
+			return webhooks, fmt.Errorf("Pgx -> GetWebhooks -> Scan -> error: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return webhooks, fmt.Errorf("Pgx -> GetWebhooks -> rows.Err -> error: %w", err)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes a row from webhooks table, its delivery log is deleted by cascade
+func (rpsPgx *Pgx) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> DeleteWebhook -> error: %w", err)
+	}
+	res, err := rpsPgx.conn(ctx).Exec(ctx, "DELETE FROM webhooks WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	if err != nil {
+		return fmt.Errorf("Pgx -> DeleteWebhook -> Exec -> error: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// EnableWebhook enables webhook and resets its counter of consecutive failures
+func (rpsPgx *Pgx) EnableWebhook(ctx context.Context, id uuid.UUID) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Pgx -> EnableWebhook -> error: %w", err)
+	}
+	res, err := rpsPgx.conn(ctx).Exec(ctx, "UPDATE webhooks SET disabled = false, failures = 0 WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	if err != nil {
+		return fmt.Errorf("Pgx -> EnableWebhook -> Exec -> error: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// RecordWebhookResult resets counter of consecutive failures after a success or increments it after a failure,
+// webhook is disabled when the counter reaches disableAfter. It returns whether the webhook is disabled now
+func (rpsPgx *Pgx) RecordWebhookResult(ctx context.Context, id uuid.UUID, success bool, disableAfter int) (bool, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("Pgx -> RecordWebhookResult -> error: %w", err)
+	}
+	var disabled bool
+	err = rpsPgx.conn(ctx).QueryRow(ctx, `UPDATE webhooks SET
+			failures = CASE WHEN $3 THEN 0 ELSE failures + 1 END,
+			disabled = disabled OR (NOT $3 AND failures + 1 >= $4)
+		WHERE id = $1 AND tenant_id = $2 RETURNING disabled`, id, tenantID, success, disableAfter).Scan(&disabled)
+	if err != nil {
+		return false, fmt.Errorf("Pgx -> RecordWebhookResult -> QueryRow -> error: %w", err)
+	}
+	return disabled, nil
+}
+
+// AddWebhookDelivery writes an attempt of delivery to webhook_deliveries table
+func (rpsPgx *Pgx) AddWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	if delivery == nil {
+		return ErrNil
+	}
+	_, err := rpsPgx.conn(ctx).Exec(ctx, `INSERT INTO webhook_deliveries(id, webhook_id, event_id, event_type, attempt, status_code, error, duration_ms, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)`, delivery.ID, delivery.WebhookID, delivery.EventID, delivery.EventType,
+		delivery.Attempt, delivery.StatusCode, delivery.Error, delivery.Duration, delivery.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Pgx -> AddWebhookDelivery -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// PruneWebhookDeliveries deletes deliveries of the webhook except the latest keep ones
+func (rpsPgx *Pgx) PruneWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, keep int) error {
+	_, err := rpsPgx.conn(ctx).Exec(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = $1 AND id NOT IN (
+			SELECT id FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT $2
+		)`, webhookID, keep)
+	if err != nil {
+		return fmt.Errorf("Pgx -> PruneWebhookDeliveries -> Exec -> error: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries reads up to limit latest deliveries of the webhook that belongs to the tenant
+func (rpsPgx *Pgx) GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, limit int) ([]model.WebhookDelivery, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetWebhookDeliveries -> error: %w", err)
+	}
+	rows, err := rpsPgx.conn(ctx).Query(ctx, `SELECT d.id, d.webhook_id, d.event_id, d.event_type, d.attempt, d.status_code, d.error, d.duration_ms, d.created_at
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.webhook_id = $1 AND w.tenant_id = $2 ORDER BY d.created_at DESC LIMIT $3`, webhookID, tenantID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Pgx -> GetWebhookDeliveries -> Query -> error: %w", err)
+	}
+	defer rows.Close()
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType, &delivery.Attempt,
+			&delivery.StatusCode, &delivery.Error, &delivery.Duration, &delivery.CreatedAt)
+		if err != nil {
+			return deliveries, fmt.Errorf("Pgx -> GetWebhookDeliveries -> Scan -> error: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, fmt.Errorf("Pgx -> GetWebhookDeliveries -> rows.Err -> error: %w", err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebhook() model.Webhook {
+	return model.Webhook{ID: uuid.New(), URL: "https://payroll.example.com/hooks", Secret: "0123456789abcdef"}
+}
+
+func newTestDelivery(webhookID uuid.UUID, attempt int) model.WebhookDelivery {
+	return model.WebhookDelivery{
+		ID:        uuid.New(),
+		WebhookID: webhookID,
+		EventID:   uuid.New(),
+		EventType: "PersonCreated",
+		Attempt:   attempt,
+		Error:     "connection refused",
+		CreatedAt: time.Now().UTC().Add(time.Duration(attempt) * time.Millisecond),
+	}
+}
+
+func Test_PgxWebhookLifecycle(t *testing.T) {
+	webhook := newTestWebhook()
+	err := rps.CreateWebhook(testCtx, &webhook)
+	require.NoError(t, err)
+
+	webhooks, err := rps.GetWebhooks(testCtx)
+	require.NoError(t, err)
+	require.Contains(t, webhookIDs(webhooks), webhook.ID)
+	otherCtx := identity.WithTenant(context.Background(), uuid.New())
+	webhooks, err = rps.GetWebhooks(otherCtx)
+	require.NoError(t, err)
+	require.NotContains(t, webhookIDs(webhooks), webhook.ID)
+
+	err = rps.DeleteWebhook(testCtx, webhook.ID)
+	require.NoError(t, err)
+	err = rps.DeleteWebhook(testCtx, webhook.ID)
+	require.True(t, errors.Is(err, pgx.ErrNoRows))
+}
+
+func Test_PgxWebhookAutoDisable(t *testing.T) {
+	webhook := newTestWebhook()
+	err := rps.CreateWebhook(testCtx, &webhook)
+	require.NoError(t, err)
+
+	disabled, err := rps.RecordWebhookResult(testCtx, webhook.ID, false, 2)
+	require.NoError(t, err)
+	require.False(t, disabled)
+	disabled, err = rps.RecordWebhookResult(testCtx, webhook.ID, false, 2)
+	require.NoError(t, err)
+	require.True(t, disabled)
+
+	err = rps.EnableWebhook(testCtx, webhook.ID)
+	require.NoError(t, err)
+	disabled, err = rps.RecordWebhookResult(testCtx, webhook.ID, false, 2)
+	require.NoError(t, err)
+	require.False(t, disabled, "enabling must reset counter of consecutive failures")
+	require.NoError(t, rps.DeleteWebhook(testCtx, webhook.ID))
+}
+
+func Test_PgxWebhookDeliveries(t *testing.T) {
+	webhook := newTestWebhook()
+	err := rps.CreateWebhook(testCtx, &webhook)
+	require.NoError(t, err)
+	for attempt := 1; attempt <= 3; attempt++ {
+		delivery := newTestDelivery(webhook.ID, attempt)
+		require.NoError(t, rps.AddWebhookDelivery(testCtx, &delivery))
+	}
+
+	deliveries, err := rps.GetWebhookDeliveries(testCtx, webhook.ID, 2)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, 3, deliveries[0].Attempt, "newest delivery goes first")
+	otherCtx := identity.WithTenant(context.Background(), uuid.New())
+	deliveries, err = rps.GetWebhookDeliveries(otherCtx, webhook.ID, 2)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+	require.NoError(t, rps.DeleteWebhook(testCtx, webhook.ID))
+}
+
+func webhookIDs(webhooks []model.Webhook) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		ids = append(ids, webhook.ID)
+	}
+	return ids
+}
+
+func Test_PgxPruneWebhookDeliveries(t *testing.T) {
+	webhook := newTestWebhook()
+	require.NoError(t, rps.CreateWebhook(testCtx, &webhook))
+	for attempt := 1; attempt <= 5; attempt++ {
+		delivery := newTestDelivery(webhook.ID, attempt)
+		require.NoError(t, rps.AddWebhookDelivery(testCtx, &delivery))
+	}
+
+	err := rps.PruneWebhookDeliveries(testCtx, webhook.ID, 2)
+	require.NoError(t, err)
+	deliveries, err := rps.GetWebhookDeliveries(testCtx, webhook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	require.Equal(t, 5, deliveries[0].Attempt)
+	require.Equal(t, 4, deliveries[1].Attempt)
+}
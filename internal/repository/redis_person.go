@@ -5,20 +5,118 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/distuurbia/firstTask/internal/identity"
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 )
 
-// Redis contains an object of type *redis.Client
+// personIndex is a sorted set of cached person keys scored by the time they were written, it is used to cap the cache
+const personIndex = "person:index"
+
+// legacyPersonHash is the hash where persons were cached before multi-tenancy, its entries belong to legacyTenantID
+const legacyPersonHash = "person"
+
+// legacyTenantID is the default tenant that got all rows created before multi-tenancy, see V2 migration
+var legacyTenantID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// InvalidationChannel is the pub/sub channel where every write and deletion of cached persons is announced, so replicas
+// drop their local copies, caching a person loaded from the database isn't announced. A message is "<tenant>:<id>" for one person, "<tenant>" for all persons of the tenant and "*" for all persons
+const InvalidationChannel = "person_invalidations"
+
+// flushAll is the invalidation message that drops all persons of all tenants
+const flushAll = "*"
+
+// missingPerson is the value of the entry of a person that doesn't exist
+const missingPerson = "missing"
+
+// drainBatch is how many entries of a legacy hash are moved at once
+const drainBatch = 100
+
+// setPerson writes the entry with its TTL, indexes the entry and evicts the oldest entries above the cap, maxEntries 0
+// disables the cap. Entries that expired by TTL are removed from the index first, so they don't count against the cap.
+// A write (empty ARGV[8]) increases the version of the person and announces the change. A fill passes the version it read
+// before loading the person and is skipped with -1 if the version changed meanwhile, so a load that raced a write can't
+// cache what the write replaced. A fill isn't announced, it caches what the database already has
+var setPerson = redis.NewScript(`
+if ARGV[8] == '' then
+	redis.call('INCR', KEYS[3])
+	redis.call('PEXPIRE', KEYS[3], ARGV[5])
+	redis.call('PUBLISH', ARGV[6], ARGV[7])
+elseif (redis.call('GET', KEYS[3]) or '0') ~= ARGV[8] then
+	return -1
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+local maxEntries = tonumber(ARGV[4])
+if maxEntries == 0 then
+	return 0
+end
+local now = tonumber(ARGV[3])
+redis.call('ZADD', KEYS[2], now, KEYS[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now - tonumber(ARGV[5]))
+local over = redis.call('ZCARD', KEYS[2]) - maxEntries
+if over <= 0 then
+	return 0
+end
+local evicted = redis.call('ZPOPMIN', KEYS[2], over)
+for i = 1, #evicted, 2 do
+	redis.call('DEL', evicted[i])
+end
+return over
+`)
+
+// Redis contains an object of type *redis.Client and limits of the person cache
 type Redis struct {
-	client *redis.Client
+	client     *redis.Client
+	ttl        time.Duration
+	jitter     time.Duration
+	missingTTL time.Duration
+	maxEntries int64
+	hits       atomic.Int64
+	misses     atomic.Int64
+}
+
+// NewRepositoryRedis accepts an object of *redis.Client, TTL of cached persons with its random jitter, TTL of entries of
+// missing persons and maximum number of cached entries and returns an object of type *Redis.
+// Jitter spreads expiration of entries written at the same time
+func NewRepositoryRedis(client *redis.Client, ttl, jitter, missingTTL time.Duration, maxEntries int64) *Redis {
+	return &Redis{client: client, ttl: ttl, jitter: jitter, missingTTL: missingTTL, maxEntries: maxEntries}
+}
+
+// personKey returns key of the cached person of the tenant
+func personKey(tenantID, id uuid.UUID) string {
+	return "person:" + invalidation(tenantID, id)
 }
 
-// NewRepositoryRedis accepts an object of *redis.Client and returns an object of type *Redis
-func NewRepositoryRedis(client *redis.Client) *Redis {
-	return &Redis{client: client}
+// versionKey returns key of the counter of writes of the cached person, it is kept outside of person:* keys
+// so flushes and evictions don't reset it while a load is running
+func versionKey(tenantID, id uuid.UUID) string {
+	return "person_version:" + invalidation(tenantID, id)
+}
+
+// invalidation returns the message that announces the change of the cached person
+func invalidation(tenantID, id uuid.UUID) string {
+	return tenantID.String() + ":" + id.String()
+}
+
+// legacyTenantHash returns name of the hash where persons of the tenant were cached before per-key storage
+func legacyTenantHash(tenantID uuid.UUID) string {
+	return "person:" + tenantID.String()
+}
+
+// entryTTL returns TTL of a new entry with a random jitter added
+func (rds *Redis) entryTTL() time.Duration {
+	if rds.jitter <= 0 {
+		return rds.ttl
+	}
+	//nolint:gosec // jitter doesn't need a cryptographic random
+	return rds.ttl + time.Duration(rand.Int63n(int64(rds.jitter)))
 }
 
 // Set sets cache of person in redis db
@@ -27,103 +125,251 @@ func (rds *Redis) Set(ctx context.Context, pers *model.Person) error {
 	if err != nil {
 		return fmt.Errorf("Redis -> Set -> json.Marshal -> error: %w", err)
 	}
-	rds.client.HSet(ctx, "person", pers.ID.String(), persJSON)
+	if err = rds.set(ctx, pers.ID, persJSON, rds.entryTTL(), ""); err != nil {
+		return fmt.Errorf("Redis -> Set -> error: %w", err)
+	}
+	return nil
+}
+
+// SetMissing caches that person with the id doesn't exist, the entry lives for the short missing TTL
+// so a person created by a writer that bypasses the service shows up soon
+func (rds *Redis) SetMissing(ctx context.Context, id uuid.UUID) error {
+	if err := rds.set(ctx, id, []byte(missingPerson), rds.missingTTL, ""); err != nil {
+		return fmt.Errorf("Redis -> SetMissing -> error: %w", err)
+	}
+	return nil
+}
+
+// Version returns the number of writes of the cached person, it is read before the person is loaded and passed to Fill
+func (rds *Redis) Version(ctx context.Context, id uuid.UUID) (int64, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("Redis -> Version -> error: %w", err)
+	}
+	version, err := rds.client.Get(ctx, versionKey(tenantID, id)).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("Redis -> Version -> client.Get -> error: %w", err)
+	}
+	return version, nil
+}
+
+// Fill caches the person loaded from the database, nil person is cached as missing. The entry is written only if
+// the person wasn't written or deleted since version was read, otherwise the loaded person may be older than the cache
+func (rds *Redis) Fill(ctx context.Context, id uuid.UUID, pers *model.Person, version int64) error {
+	value, ttl := []byte(missingPerson), rds.missingTTL
+	if pers != nil {
+		persJSON, err := json.Marshal(pers)
+		if err != nil {
+			return fmt.Errorf("Redis -> Fill -> json.Marshal -> error: %w", err)
+		}
+		value, ttl = persJSON, rds.entryTTL()
+	}
+	if err := rds.set(ctx, id, value, ttl, strconv.FormatInt(version, 10)); err != nil {
+		return fmt.Errorf("Redis -> Fill -> error: %w", err)
+	}
+	return nil
+}
+
+// maxAge returns the longest TTL of an entry, versions live as long so they outlive every entry and running load
+func (rds *Redis) maxAge() time.Duration {
+	maxAge := rds.ttl + rds.jitter
+	if rds.missingTTL > maxAge {
+		maxAge = rds.missingTTL
+	}
+	return maxAge
+}
+
+// set writes the entry of the person of the tenant from context, version is empty for writes, see setPerson
+func (rds *Redis) set(ctx context.Context, id uuid.UUID, value []byte, ttl time.Duration, version string) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	err = setPerson.Run(ctx, rds.client, []string{personKey(tenantID, id), personIndex, versionKey(tenantID, id)},
+		value, ttl.Milliseconds(), time.Now().UnixMilli(), rds.maxEntries, rds.maxAge().Milliseconds(),
+		InvalidationChannel, invalidation(tenantID, id), version).Err()
+	if err != nil {
+		return fmt.Errorf("setPerson.Run -> error: %w", err)
+	}
 	return nil
 }
 
-// Get gets cache of person from redis db
-func (rds *Redis) Get(ctx context.Context, id uuid.UUID) (*model.Person, error) {
-	persJSON, err := rds.client.HGet(ctx, "person", id.String()).Result()
+// Get gets cache of person from redis db with the time left until the entry expires.
+// It returns redis.Nil if the person isn't cached and nil person if the person is cached as missing
+func (rds *Redis) Get(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Redis -> Get -> error: %w", err)
+	}
+	key := personKey(tenantID, id)
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err = rds.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
 	if err != nil {
 		if err == redis.Nil {
-			return nil, err
+			rds.misses.Add(1)
+			return nil, 0, err
 		}
-		return nil, fmt.Errorf("Redis -> Get -> client.HGet -> error: %w", err)
+		return nil, 0, fmt.Errorf("Redis -> Get -> client.Pipelined -> error: %w", err)
+	}
+	rds.hits.Add(1)
+	persJSON, err := get.Bytes()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Redis -> Get -> Bytes -> error: %w", err)
+	}
+	if string(persJSON) == missingPerson {
+		return nil, pttl.Val(), nil
 	}
 	var pers model.Person
-	err = json.Unmarshal([]byte(persJSON), &pers)
+	err = json.Unmarshal(persJSON, &pers)
 	if err != nil {
-		return nil, fmt.Errorf("Redis -> Get -> json.Unmarshal -> error: %w", err)
+		return nil, 0, fmt.Errorf("Redis -> Get -> json.Unmarshal -> error: %w", err)
 	}
-	return &pers, nil
+	return &pers, pttl.Val(), nil
 }
 
 // Delete deletes cache of person from redis db
 func (rds *Redis) Delete(ctx context.Context, id uuid.UUID) error {
-	_, err := rds.client.HDel(ctx, "person", id.String()).Result()
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Redis -> Delete -> error: %w", err)
+	}
+	key := personKey(tenantID, id)
+	_, err = rds.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.ZRem(ctx, personIndex, key)
+		pipe.Incr(ctx, versionKey(tenantID, id))
+		pipe.PExpire(ctx, versionKey(tenantID, id), rds.maxAge())
+		pipe.Publish(ctx, InvalidationChannel, invalidation(tenantID, id))
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("Redis -> Delete -> client.HDel -> error: %w", err)
+		return fmt.Errorf("Redis -> Delete -> client.TxPipelined -> error: %w", err)
+	}
+	return nil
+}
+
+// Stats returns hit and miss counters of Get
+func (rds *Redis) Stats() model.CacheTierStats {
+	return model.CacheTierStats{Hits: rds.hits.Load(), Misses: rds.misses.Load()}
+}
+
+// DeleteTenant deletes all cached persons of the tenant from redis db
+func (rds *Redis) DeleteTenant(ctx context.Context, tenantID uuid.UUID) error {
+	err := rds.deleteMatching(ctx, legacyTenantHash(tenantID)+":*")
+	if err != nil {
+		return fmt.Errorf("Redis -> DeleteTenant -> error: %w", err)
+	}
+	_, err = rds.client.Del(ctx, legacyTenantHash(tenantID)).Result()
+	if err != nil {
+		return fmt.Errorf("Redis -> DeleteTenant -> client.Del -> error: %w", err)
+	}
+	if err = rds.client.Publish(ctx, InvalidationChannel, tenantID.String()).Err(); err != nil {
+		return fmt.Errorf("Redis -> DeleteTenant -> client.Publish -> error: %w", err)
+	}
+	return nil
+}
+
+// Flush deletes cached persons of all tenants from redis db, keys are scanned so redis isn't blocked
+func (rds *Redis) Flush(ctx context.Context) error {
+	if err := rds.deleteMatching(ctx, "person:*"); err != nil {
+		return fmt.Errorf("Redis -> Flush -> error: %w", err)
+	}
+	if err := rds.client.Del(ctx, legacyPersonHash).Err(); err != nil {
+		return fmt.Errorf("Redis -> Flush -> client.Del -> error: %w", err)
+	}
+	if err := rds.client.Publish(ctx, InvalidationChannel, flushAll).Err(); err != nil {
+		return fmt.Errorf("Redis -> Flush -> client.Publish -> error: %w", err)
 	}
 	return nil
 }
 
-// // AddToStream adds to person_stream person
-// func (rds *Redis) AddToStream(ctx context.Context, msg string) error {
-// 	streamData := redis.XAddArgs{
-// 		Stream: "person_stream",
-// 		Values: map[string]interface{}{
-// 			"data": msg,
-// 		},
-// 	}
-// 	_, err := rds.client.XAdd(ctx, &streamData).Result()
-// 	if err != nil {
-// 		return fmt.Errorf("Redis -> AddToStream -> XAdd -> error: %w", err)
-// 	}
-// 	return nil
-// }
-
-// // GetFromStream gets a person from the Redis Stream by ID
-// func (rds *Redis) GetFromStream(ctx context.Context, id uuid.UUID) (string, error) {
-// 	streamData := redis.XReadArgs{
-// 		Streams: []string{"person_stream", "0"},
-// 		Count:   1,
-// 		Block:   0,
-// 	}
-// 	results, err := rds.client.XRead(ctx, &streamData).Result()
-// 	if err != nil {
-// 		return "", fmt.Errorf("Redis -> GetFromStream -> XRead -> error: %w", err)
-// 	}
-// 	if len(results) == 0 || len(results[0].Messages) == 0 {
-// 		return "", redis.Nil
-// 	}
-// 	var pers model.Person
-// 	var persJSON string
-// 	for _, msg := range results[0].Messages {
-// 		if msg.Values["MyID"] == id.String() {
-// 			persJSON = msg.Values["data"].(string)
-// 			err = json.Unmarshal([]byte(persJSON), &pers)
-// 			if err != nil {
-// 				return nil, fmt.Errorf("RedisStreamRepository -> GetFromStream -> json.Unmarshal -> error: %w", err)
-// 			}
-// 			return &pers, nil
-// 		}
-// 	}
-// 	return nil, redis.Nil
-// }
-
-// // DeleteFromStream deletes a person from the Redis Stream by ID
-// func (rds *Redis) DeleteFromStream(ctx context.Context, id uuid.UUID) error {
-// 	streamData := redis.XReadArgs{
-// 		Streams: []string{"person_stream", "0"},
-// 		Count:   0,
-// 		Block:   0,
-// 	}
-// 	results, err := rds.client.XRead(ctx, &streamData).Result()
-// 	if err != nil {
-// 		return fmt.Errorf("Redis -> GetFromStream -> XRead -> error: %w", err)
-// 	}
-// 	if len(results) == 0 || len(results[0].Messages) == 0 {
-// 		return redis.Nil
-// 	}
-// 	var msgID string
-// 	for _, msg := range results[0].Messages {
-// 		if msg.Values["MyID"] == id.String() {
-// 			msgID = msg.ID
-// 		}
-// 	}
-// 	_, err = rds.client.XDel(ctx, "person_stream", msgID).Result()
-// 	if err != nil {
-// 		return fmt.Errorf("Redis -> DeleteFromStream -> XDel -> error: %w", err)
-// 	}
-// 	return nil
-// }
+// deleteMatching deletes keys that match the pattern and removes them from the index
+func (rds *Redis) deleteMatching(ctx context.Context, pattern string) error {
+	iter := rds.client.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		_, err := rds.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.ZRem(ctx, personIndex, key)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("client.TxPipelined -> error: %w", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("client.Scan -> error: %w", err)
+	}
+	return nil
+}
+
+// DrainLegacyHashes moves persons cached in the hashes of the previous layouts to per-key entries with TTL and deletes
+// the hashes. Moved entries are deleted from the hash batch by batch, so the drain can be stopped and run again.
+// It returns the number of moved entries
+func (rds *Redis) DrainLegacyHashes(ctx context.Context) (int, error) {
+	drained := 0
+	iter := rds.client.ScanType(ctx, 0, legacyPersonHash+"*", 0, "hash").Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		tenantID, ok := legacyHashTenant(key)
+		if !ok {
+			continue
+		}
+		moved, err := rds.drainHash(identity.WithTenant(ctx, tenantID), key)
+		drained += moved
+		if err != nil {
+			return drained, fmt.Errorf("Redis -> DrainLegacyHashes -> %s -> error: %w", key, err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return drained, fmt.Errorf("Redis -> DrainLegacyHashes -> client.ScanType -> error: %w", err)
+	}
+	return drained, nil
+}
+
+// legacyHashTenant returns tenant whose persons are cached in the legacy hash
+func legacyHashTenant(key string) (uuid.UUID, bool) {
+	if key == legacyPersonHash {
+		return legacyTenantID, true
+	}
+	tenant, ok := strings.CutPrefix(key, legacyPersonHash+":")
+	if !ok {
+		return uuid.Nil, false
+	}
+	tenantID, err := uuid.Parse(tenant)
+	return tenantID, err == nil
+}
+
+// drainHash moves entries of one legacy hash, entries that can't be decoded are dropped
+func (rds *Redis) drainHash(ctx context.Context, key string) (int, error) {
+	moved := 0
+	for {
+		fields, _, err := rds.client.HScan(ctx, key, 0, "", drainBatch).Result()
+		if err != nil {
+			return moved, fmt.Errorf("client.HScan -> error: %w", err)
+		}
+		if len(fields) == 0 {
+			return moved, nil
+		}
+		var names []string
+		for i := 0; i+1 < len(fields); i += 2 {
+			names = append(names, fields[i])
+			var pers model.Person
+			if json.Unmarshal([]byte(fields[i+1]), &pers) != nil {
+				continue
+			}
+			if err = rds.Set(ctx, &pers); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+		if err = rds.client.HDel(ctx, key, names...).Err(); err != nil {
+			return moved, fmt.Errorf("client.HDel -> error: %w", err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPerson() *model.Person {
+	return &model.Person{ID: uuid.New(), Salary: 900, Married: true, Profession: "cook"}
+}
+
+func Test_RedisSetGet(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, time.Minute, time.Second, time.Second, 0)
+	pers := newTestPerson()
+	require.NoError(t, rds.Set(testCtx, pers))
+	cached, ttl, err := rds.Get(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, pers, cached)
+	require.True(t, ttl > 0 && ttl <= time.Minute+time.Second)
+}
+
+func Test_RedisGetOtherTenant(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 0)
+	pers := newTestPerson()
+	require.NoError(t, rds.Set(testCtx, pers))
+	_, _, err := rds.Get(identity.WithTenant(context.Background(), uuid.New()), pers.ID)
+	require.Equal(t, redis.Nil, err)
+}
+
+func Test_RedisSetExpires(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, 50*time.Millisecond, 0, time.Second, 0)
+	pers := newTestPerson()
+	require.NoError(t, rds.Set(testCtx, pers))
+	time.Sleep(100 * time.Millisecond)
+	_, _, err := rds.Get(testCtx, pers.ID)
+	require.Equal(t, redis.Nil, err)
+}
+
+func Test_RedisSetMissing(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, time.Minute, 0, 50*time.Millisecond, 0)
+	id := uuid.New()
+	require.NoError(t, rds.SetMissing(testCtx, id))
+	cached, ttl, err := rds.Get(testCtx, id)
+	require.NoError(t, err)
+	require.Nil(t, cached)
+	require.True(t, ttl > 0 && ttl <= 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	_, _, err = rds.Get(testCtx, id)
+	require.Equal(t, redis.Nil, err)
+}
+
+func Test_RedisSetEvictsOldest(t *testing.T) {
+	require.NoError(t, NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 0).Flush(testCtx))
+	rds := NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 2)
+	oldest, older, newest := newTestPerson(), newTestPerson(), newTestPerson()
+	for _, pers := range []*model.Person{oldest, older, newest} {
+		require.NoError(t, rds.Set(testCtx, pers))
+		time.Sleep(5 * time.Millisecond)
+	}
+	_, _, err := rds.Get(testCtx, oldest.ID)
+	require.Equal(t, redis.Nil, err)
+	for _, pers := range []*model.Person{older, newest} {
+		_, _, err = rds.Get(testCtx, pers.ID)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(2), rdsClient.ZCard(testCtx, personIndex).Val())
+}
+
+func Test_RedisDelete(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 10)
+	pers := newTestPerson()
+	require.NoError(t, rds.Set(testCtx, pers))
+	require.NoError(t, rds.Delete(testCtx, pers.ID))
+	_, _, err := rds.Get(testCtx, pers.ID)
+	require.Equal(t, redis.Nil, err)
+	_, err = rdsClient.ZScore(testCtx, personIndex, personKey(testTenant.ID, pers.ID)).Result()
+	require.Equal(t, redis.Nil, err)
+}
+
+func Test_RedisDeleteTenant(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 0)
+	otherCtx := identity.WithTenant(context.Background(), uuid.New())
+	pers, other := newTestPerson(), newTestPerson()
+	require.NoError(t, rds.Set(testCtx, pers))
+	require.NoError(t, rds.Set(otherCtx, other))
+	require.NoError(t, rds.DeleteTenant(testCtx, testTenant.ID))
+	_, _, err := rds.Get(testCtx, pers.ID)
+	require.Equal(t, redis.Nil, err)
+	_, _, err = rds.Get(otherCtx, other.ID)
+	require.NoError(t, err)
+}
+
+func Test_RedisDrainLegacyHashes(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 0)
+	legacy, tenantPers := newTestPerson(), newTestPerson()
+	for key, pers := range map[string]*model.Person{legacyPersonHash: legacy, legacyTenantHash(testTenant.ID): tenantPers} {
+		persJSON, err := json.Marshal(pers)
+		require.NoError(t, err)
+		require.NoError(t, rdsClient.HSet(testCtx, key, pers.ID.String(), persJSON).Err())
+	}
+	drained, err := rds.DrainLegacyHashes(testCtx)
+	require.NoError(t, err)
+	require.Equal(t, 2, drained)
+	cached, _, err := rds.Get(identity.WithTenant(context.Background(), legacyTenantID), legacy.ID)
+	require.NoError(t, err)
+	require.Equal(t, legacy, cached)
+	cached, _, err = rds.Get(testCtx, tenantPers.ID)
+	require.NoError(t, err)
+	require.Equal(t, tenantPers, cached)
+	require.Zero(t, rdsClient.Exists(testCtx, legacyPersonHash, legacyTenantHash(testTenant.ID)).Val())
+}
+
+func Test_RedisFillSkipsAfterWrite(t *testing.T) {
+	rds := NewRepositoryRedis(rdsClient, time.Minute, 0, time.Second, 0)
+	pers := newTestPerson()
+	version, err := rds.Version(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.NoError(t, rds.Delete(testCtx, pers.ID))
+	require.NoError(t, rds.Fill(testCtx, pers.ID, pers, version))
+	_, _, err = rds.Get(testCtx, pers.ID)
+	require.Equal(t, redis.Nil, err, "a fill that raced a write must be skipped")
+
+	version, err = rds.Version(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.NoError(t, rds.Fill(testCtx, pers.ID, pers, version))
+	cached, _, err := rds.Get(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, pers, cached)
+}
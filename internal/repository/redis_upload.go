@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// uploadLockPrefix is the prefix of redis keys of locks of upload sessions
+	uploadLockPrefix = "upload_lock:"
+	// uploadLockLease is how long a lock is held without being renewed, so the lock of a replica that died is taken
+	// over after it
+	uploadLockLease = 30 * time.Second
+	// uploadLockRetry is how often a held lock is tried again
+	uploadLockRetry = 20 * time.Millisecond
+	// uploadUnlockTimeout bounds the release of a lock whose context is already canceled
+	uploadUnlockTimeout = 5 * time.Second
+)
+
+// renewLock extends the lease of the lock if it is still held with the token
+var renewLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLock deletes the lock if it is still held with the token, so a lock taken over after the lease isn't released
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// RedisUploadLocks serializes changes of upload sessions of all replicas with leases in redis
+type RedisUploadLocks struct {
+	client *redis.Client
+}
+
+// NewRedisUploadLocks accepts an object of *redis.Client and returns an object of type *RedisUploadLocks
+func NewRedisUploadLocks(client *redis.Client) *RedisUploadLocks {
+	return &RedisUploadLocks{client: client}
+}
+
+// Lock waits until the lock of the upload session under the key is taken by SET NX PX with a random token or ctx is
+// done and returns the function that releases it. The lease is renewed until the release, so a long Finish keeps it,
+// and a lock that can't be released is taken over after the lease
+func (l *RedisUploadLocks) Lock(ctx context.Context, key string) (unlock func(), err error) {
+	lockKey, token := uploadLockPrefix+key, uuid.NewString()
+	for {
+		ok, err := l.client.SetNX(ctx, lockKey, token, uploadLockLease).Result()
+		if err != nil {
+			return nil, fmt.Errorf("RedisUploadLocks -> Lock -> SetNX -> error: %w", err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("RedisUploadLocks -> Lock -> error: %w", ctx.Err())
+		case <-time.After(uploadLockRetry):
+		}
+	}
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(uploadLockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			_ = renewLock.Run(context.Background(), l.client, []string{lockKey}, token, uploadLockLease.Milliseconds()).Err()
+		}
+	}()
+	return func() {
+		close(done)
+		<-renewed
+		ctx, cancel := context.WithTimeout(context.Background(), uploadUnlockTimeout)
+		defer cancel()
+		_ = releaseLock.Run(ctx, l.client, []string{lockKey}, token).Err()
+	}, nil
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RedisUploadLocks(t *testing.T) {
+	locks := NewRedisUploadLocks(rdsClient)
+	otherReplica := NewRedisUploadLocks(rdsClient)
+	key := "uploads/" + uuid.NewString() + "/" + uuid.NewString() + "/"
+	unlock, err := locks.Lock(context.Background(), key)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = otherReplica.Lock(ctx, key)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	require.True(t, rdsClient.PTTL(context.Background(), uploadLockPrefix+key).Val() > 0, "the lock has a lease")
+
+	unlock()
+	unlockOther, err := otherReplica.Lock(context.Background(), key)
+	require.NoError(t, err)
+	unlockOther()
+	require.Zero(t, rdsClient.Exists(context.Background(), uploadLockPrefix+key).Val())
+}
+
+func Test_RedisUploadLocksTakenOver(t *testing.T) {
+	locks := NewRedisUploadLocks(rdsClient)
+	key := "uploads/" + uuid.NewString() + "/" + uuid.NewString() + "/"
+	unlock, err := locks.Lock(context.Background(), key)
+	require.NoError(t, err)
+	// the lease of a replica that died expires, the other replica takes the lock over
+	require.NoError(t, rdsClient.Del(context.Background(), uploadLockPrefix+key).Err())
+	unlockOther, err := locks.Lock(context.Background(), key)
+	require.NoError(t, err)
+	unlock()
+	require.Equal(t, int64(1), rdsClient.Exists(context.Background(), uploadLockPrefix+key).Val(),
+		"a lock taken over isn't released by the previous holder")
+	unlockOther()
+}
@@ -0,0 +1,189 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrAvatarNotFound means that the person has no avatar
+var ErrAvatarNotFound = fmt.Errorf("person has no avatar")
+
+// AvatarPersons is an interface of the person service that AvatarService keeps avatars of persons through
+type AvatarPersons interface {
+	Create(ctx context.Context, pers *model.Person) error
+	ReadRow(ctx context.Context, id uuid.UUID) (*model.Person, error)
+	GetAll(ctx context.Context) ([]model.Person, error)
+	Update(ctx context.Context, pers *model.Person) error
+	Modify(ctx context.Context, id uuid.UUID, fn func(pers *model.Person) bool) (*model.Person, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// AvatarRepository is an interface that tells if an image is an avatar of any person of the tenant
+type AvatarRepository interface {
+	AvatarInUse(ctx context.Context, imageID uuid.UUID) (bool, error)
+}
+
+// AvatarService links persons to uploaded images. It wraps the person service, so the avatar is set only through
+// SetAvatar and UploadAvatar and is kept by every other change of the person. An image uploaded as an avatar that stops
+// being an avatar of any person, because the person was deleted or got another avatar, is deleted too; an image the user
+// uploaded before is only unlinked. With write-behind strategy the change may be not persisted yet when the image is
+// checked, then the image is kept
+type AvatarService struct {
+	persons   AvatarPersons
+	avatarRps AvatarRepository
+	images    *ImageService
+}
+
+// NewAvatarService accepts the person service, AvatarRepository and ImageService that avatars are kept in
+// and returns an object of type *AvatarService
+func NewAvatarService(persons AvatarPersons, avatarRps AvatarRepository, images *ImageService) *AvatarService {
+	return &AvatarService{persons: persons, avatarRps: avatarRps, images: images}
+}
+
+// Create creates person without avatar, the avatar given by the client is ignored
+func (srv *AvatarService) Create(ctx context.Context, pers *model.Person) error {
+	pers.AvatarImageID, pers.AvatarURL = nil, ""
+	return srv.persons.Create(ctx, pers)
+}
+
+// ReadRow reads person
+func (srv *AvatarService) ReadRow(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	return srv.persons.ReadRow(ctx, id)
+}
+
+// GetAll reads all persons of the tenant
+func (srv *AvatarService) GetAll(ctx context.Context) ([]model.Person, error) {
+	return srv.persons.GetAll(ctx)
+}
+
+// Update updates person keeping its current avatar, the avatar given by the client is ignored. The avatar is read
+// in the transaction of the update, so an avatar set meanwhile is kept
+func (srv *AvatarService) Update(ctx context.Context, pers *model.Person) error {
+	updated, err := srv.persons.Modify(ctx, pers.ID, func(current *model.Person) bool {
+		avatar := current.AvatarImageID
+		*current = *pers.Copy()
+		current.AvatarImageID, current.AvatarURL = avatar, ""
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("AvatarService -> Update -> error: %w", err)
+	}
+	*pers = *updated
+	return nil
+}
+
+// Delete deletes person and its avatar unless another person has the same avatar
+func (srv *AvatarService) Delete(ctx context.Context, id uuid.UUID) error {
+	pers, err := srv.persons.ReadRow(ctx, id)
+	if err != nil && !errors.Is(err, ErrPersonNotFound) {
+		return fmt.Errorf("AvatarService -> Delete -> persons.ReadRow -> error: %w", err)
+	}
+	if err = srv.persons.Delete(ctx, id); err != nil {
+		return fmt.Errorf("AvatarService -> Delete -> error: %w", err)
+	}
+	if pers != nil && pers.AvatarImageID != nil {
+		srv.cleanup(ctx, *pers.AvatarImageID)
+	}
+	return nil
+}
+
+// SetAvatar makes the image of the user from context an avatar of the person and returns the changed person
+func (srv *AvatarService) SetAvatar(ctx context.Context, personID, imageID uuid.UUID) (*model.Person, error) {
+	if _, err := srv.images.Meta(ctx, imageID); err != nil {
+		return nil, fmt.Errorf("AvatarService -> SetAvatar -> error: %w", err)
+	}
+	pers, err := srv.setAvatar(ctx, personID, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("AvatarService -> SetAvatar -> error: %w", err)
+	}
+	return pers, nil
+}
+
+// UploadAvatar uploads the image as the user from context, see ImageService.Upload, makes it an avatar of the person
+// and returns the changed person. The image is deleted if the person can't be changed
+func (srv *AvatarService) UploadAvatar(ctx context.Context, personID uuid.UUID, filename string, src io.Reader) (*model.Person, error) {
+	if _, err := srv.persons.ReadRow(ctx, personID); err != nil {
+		return nil, fmt.Errorf("AvatarService -> UploadAvatar -> persons.ReadRow -> error: %w", err)
+	}
+	img, err := srv.images.upload(ctx, filename, src, model.ImageOriginAvatar)
+	if err != nil {
+		return nil, fmt.Errorf("AvatarService -> UploadAvatar -> error: %w", err)
+	}
+	pers, err := srv.setAvatar(ctx, personID, img.ID)
+	if err != nil {
+		if removeErr := srv.images.remove(ctx, img.ID); removeErr != nil {
+			logrus.WithField("ID", img.ID).Errorf("AvatarService -> UploadAvatar -> images.remove -> error: %v", removeErr)
+		}
+		return nil, fmt.Errorf("AvatarService -> UploadAvatar -> error: %w", err)
+	}
+	return pers, nil
+}
+
+// Avatar opens the avatar of the person or its variant for reading, see ImageService.Open
+func (srv *AvatarService) Avatar(ctx context.Context, personID uuid.UUID, variant model.ImageVariant) (*model.ImageFile, error) {
+	pers, err := srv.persons.ReadRow(ctx, personID)
+	if err != nil {
+		return nil, fmt.Errorf("AvatarService -> Avatar -> persons.ReadRow -> error: %w", err)
+	}
+	if pers.AvatarImageID == nil {
+		return nil, fmt.Errorf("AvatarService -> Avatar -> %s: %w", personID, ErrAvatarNotFound)
+	}
+	img, err := srv.images.Open(ctx, *pers.AvatarImageID, variant)
+	if err != nil {
+		return nil, fmt.Errorf("AvatarService -> Avatar -> error: %w", err)
+	}
+	return img, nil
+}
+
+// setAvatar changes the avatar of the person, cleans up the previous one and returns the changed person
+func (srv *AvatarService) setAvatar(ctx context.Context, personID, imageID uuid.UUID) (*model.Person, error) {
+	var previous *uuid.UUID
+	pers, err := srv.persons.Modify(ctx, personID, func(pers *model.Person) bool {
+		previous = pers.AvatarImageID
+		if previous != nil && *previous == imageID {
+			return false
+		}
+		pers.AvatarImageID = &imageID
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("persons.Modify -> error: %w", err)
+	}
+	if previous != nil && *previous != imageID {
+		srv.cleanup(ctx, *previous)
+	}
+	return pers, nil
+}
+
+// cleanup deletes the image if it was uploaded as an avatar and no person has it as avatar anymore, other images
+// stay with their owners. The person is already changed, so a failure is only logged and the image is left in the store
+func (srv *AvatarService) cleanup(ctx context.Context, imageID uuid.UUID) {
+	img, err := srv.images.imageRps.GetImage(ctx, imageID)
+	if err != nil {
+		if !errors.Is(err, model.ErrNotFound) {
+			logrus.WithField("ID", imageID).Errorf("AvatarService -> cleanup -> imageRps.GetImage -> error: %v", err)
+		}
+		return
+	}
+	if img.Origin != model.ImageOriginAvatar {
+		return
+	}
+	inUse, err := srv.avatarRps.AvatarInUse(ctx, imageID)
+	if err != nil {
+		logrus.WithField("ID", imageID).Errorf("AvatarService -> cleanup -> avatarRps.AvatarInUse -> error: %v", err)
+		return
+	}
+	if inUse {
+		return
+	}
+	if err = srv.images.remove(ctx, imageID); err != nil && !errors.Is(err, ErrImageNotFound) {
+		logrus.WithField("ID", imageID).Errorf("AvatarService -> cleanup -> images.remove -> error: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAvatarRepository finds avatars among persons of the fake person repository
+type fakeAvatarRepository struct {
+	repo *fakePersonRepository
+}
+
+func (r *fakeAvatarRepository) AvatarInUse(_ context.Context, imageID uuid.UUID) (bool, error) {
+	r.repo.mu.Lock()
+	defer r.repo.mu.Unlock()
+	for _, pers := range r.repo.persons {
+		if pers.AvatarImageID != nil && *pers.AvatarImageID == imageID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakePersonRepository) GetByAvatarForUpdate(_ context.Context, imageID uuid.UUID) ([]model.Person, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var persons []model.Person
+	for _, pers := range r.persons {
+		if pers.AvatarImageID != nil && *pers.AvatarImageID == imageID {
+			persons = append(persons, pers)
+		}
+	}
+	return persons, nil
+}
+
+// newTestAvatarService returns AvatarService over one stored person and images kept in a temporary directory
+func newTestAvatarService(t *testing.T) (*AvatarService, *fakePersonRepository, *fakeImageRepository, model.Person) {
+	srv, persRepo, imageRepo, _, pers := newAvatarServiceWithCache(t, newFakePersonCache(), CacheOptions{})
+	return srv, persRepo, imageRepo, pers
+}
+
+// newAvatarServiceWithCache returns AvatarService like newTestAvatarService with persons cached by the strategy
+func newAvatarServiceWithCache(t *testing.T, cache PersonRedisRepository, opts CacheOptions) (*AvatarService,
+	*fakePersonRepository, *fakeImageRepository, *fakeTx, model.Person) {
+	persSrv, persRepo, tx, pers := newStrategyService(cache, opts)
+	imageRepo := newFakeImageRepository()
+	images := NewImageService(imageRepo, imageRepo, persSrv, blob.NewLocal(t.TempDir()), testImageLimits)
+	return NewAvatarService(persSrv, &fakeAvatarRepository{repo: persRepo}, images), persRepo, imageRepo, tx, pers
+}
+
+func TestUploadAvatar(t *testing.T) {
+	srv, persRepo, imageRepo, pers := newTestAvatarService(t)
+	ctx := newUserCtx()
+	changed, err := srv.UploadAvatar(ctx, pers.ID, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.NoError(t, err)
+	require.NotNil(t, changed.AvatarImageID)
+	stored, _ := persRepo.stored(pers.ID)
+	require.Equal(t, changed.AvatarImageID, stored.AvatarImageID)
+	require.Contains(t, imageRepo.images, *changed.AvatarImageID)
+
+	file, err := srv.Avatar(ctx, pers.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+	require.Equal(t, "image/png", file.ContentType)
+
+	_, err = srv.UploadAvatar(ctx, uuid.New(), "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.True(t, errors.Is(err, ErrPersonNotFound))
+	require.Len(t, imageRepo.images, 1)
+}
+
+func TestSetAvatarReplacesAndCleansUp(t *testing.T) {
+	srv, _, imageRepo, pers := newTestAvatarService(t)
+	ctx := newUserCtx()
+	first, err := srv.UploadAvatar(ctx, pers.ID, "first.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.NoError(t, err)
+	img, err := srv.images.Upload(ctx, "second.png", bytes.NewReader(encodeTestImage(t, 4, 4)))
+	require.NoError(t, err)
+
+	changed, err := srv.SetAvatar(ctx, pers.ID, img.ID)
+	require.NoError(t, err)
+	require.Equal(t, img.ID, *changed.AvatarImageID)
+	require.NotContains(t, imageRepo.images, *first.AvatarImageID)
+	require.Contains(t, imageRepo.images, img.ID)
+
+	_, err = srv.SetAvatar(newUserCtx(), pers.ID, img.ID)
+	require.True(t, errors.Is(err, ErrImageForbidden))
+	_, err = srv.SetAvatar(ctx, pers.ID, uuid.New())
+	require.True(t, errors.Is(err, ErrImageNotFound))
+
+	_, err = srv.UploadAvatar(ctx, pers.ID, "third.png", bytes.NewReader(encodeTestImage(t, 5, 5)))
+	require.NoError(t, err)
+	require.Contains(t, imageRepo.images, img.ID, "an image that wasn't uploaded as an avatar is only unlinked")
+}
+
+func TestAvatarKeptByUpdate(t *testing.T) {
+	srv, persRepo, _, pers := newTestAvatarService(t)
+	ctx := newUserCtx()
+	changed, err := srv.UploadAvatar(ctx, pers.ID, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.NoError(t, err)
+
+	other := uuid.New()
+	update := model.Person{ID: pers.ID, Salary: 700, Profession: "baker", AvatarImageID: &other, AvatarURL: "/elsewhere"}
+	require.NoError(t, srv.Update(ctx, &update))
+	stored, _ := persRepo.stored(pers.ID)
+	require.Equal(t, 700, stored.Salary)
+	require.Equal(t, changed.AvatarImageID, stored.AvatarImageID)
+	require.Empty(t, stored.AvatarURL)
+
+	created := model.Person{ID: uuid.New(), Salary: 100, Profession: "driver", AvatarImageID: &other}
+	require.NoError(t, srv.Create(ctx, &created))
+	stored, _ = persRepo.stored(created.ID)
+	require.Nil(t, stored.AvatarImageID)
+	_, err = srv.Avatar(ctx, created.ID, model.ImageVariant{})
+	require.True(t, errors.Is(err, ErrAvatarNotFound))
+}
+
+func TestDeletePersonCleansUpAvatar(t *testing.T) {
+	srv, persRepo, imageRepo, pers := newTestAvatarService(t)
+	ctx := newUserCtx()
+	changed, err := srv.UploadAvatar(ctx, pers.ID, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.NoError(t, err)
+	avatarID := *changed.AvatarImageID
+	shared := model.Person{ID: uuid.New(), Salary: 100, Profession: "driver"}
+	require.NoError(t, srv.Create(ctx, &shared))
+	_, err = srv.SetAvatar(ctx, shared.ID, avatarID)
+	require.NoError(t, err)
+
+	require.NoError(t, srv.Delete(ctx, pers.ID))
+	require.Contains(t, imageRepo.images, avatarID, "the image is still an avatar of another person")
+	require.NoError(t, srv.Delete(ctx, shared.ID))
+	require.NotContains(t, imageRepo.images, avatarID)
+	require.Empty(t, persRepo.persons)
+
+	require.Error(t, srv.Delete(ctx, pers.ID))
+}
+
+func TestDeleteImageUnsetsCachedAvatar(t *testing.T) {
+	for _, strategy := range []string{WriteAround, WriteThrough} {
+		t.Run(strategy, func(t *testing.T) {
+			srv, persRepo, _, tx, pers := newAvatarServiceWithCache(t, newFakePersonCache(), CacheOptions{Strategy: strategy})
+			ctx := newUserCtx()
+			img, err := srv.images.Upload(ctx, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+			require.NoError(t, err)
+			_, err = srv.SetAvatar(ctx, pers.ID, img.ID)
+			require.NoError(t, err)
+			cached, err := srv.ReadRow(ctx, pers.ID)
+			require.NoError(t, err)
+			require.Equal(t, img.ID, *cached.AvatarImageID)
+			events := len(tx.outbox.events)
+
+			require.NoError(t, srv.images.Delete(ctx, img.ID))
+			stored, _ := persRepo.stored(pers.ID)
+			require.Nil(t, stored.AvatarImageID)
+			read, err := srv.ReadRow(ctx, pers.ID)
+			require.NoError(t, err)
+			require.Nil(t, read.AvatarImageID, "the cached person has no avatar anymore")
+			require.Equal(t, []string{"PersonUpdated"}, tx.outbox.events[events:])
+			_, err = srv.Avatar(ctx, pers.ID, model.ImageVariant{})
+			require.True(t, errors.Is(err, ErrAvatarNotFound))
+		})
+	}
+}
@@ -0,0 +1,436 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	// decoders of the allowed image types register themselves in image package
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// sniffLen is how many first bytes of a file are used to detect its type, see http.DetectContentType
+	sniffLen = 512
+	// maxFilenameLen is how many characters of the name given by the uploader are kept
+	maxFilenameLen = 255
+	// contentPrefix is the prefix of keys of image contents in the blob store, see contentKey
+	contentPrefix = "sha256/"
+	// imagePrefix is the prefix of keys of references of images in the blob store, see imageKey
+	imagePrefix = "images/"
+	// maxReferenceLen bounds the size of a reference object, a larger object under an image key is the content itself
+	maxReferenceLen = 256
+)
+
+// allowedImageTypes are MIME types of images that can be uploaded
+var allowedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// ErrImageTooLarge means that the image has more bytes than allowed
+var ErrImageTooLarge = fmt.Errorf("image is too large")
+
+// ErrImageType means that the content of the file isn't an image of an allowed type
+var ErrImageType = fmt.Errorf("image type isn't allowed")
+
+// ErrImageDimensions means that the image is wider, higher or has more pixels than allowed
+var ErrImageDimensions = fmt.Errorf("image dimensions exceed the limit")
+
+// ErrInvalidImage means that the file has a header of an allowed type but can't be decoded
+var ErrInvalidImage = fmt.Errorf("image can't be decoded")
+
+// ErrImageNotFound means that no image is stored under the ID
+var ErrImageNotFound = fmt.Errorf("image not found")
+
+// ErrImageQuota means that the upload would exceed the storage quota of the user
+var ErrImageQuota = fmt.Errorf("storage quota exceeded")
+
+// ErrImageForbidden means that the image belongs to another user
+var ErrImageForbidden = fmt.Errorf("image belongs to another user")
+
+// errNoUser means that the request isn't authenticated, so nobody can own the image
+var errNoUser = fmt.Errorf("user is missing in context")
+
+// ImageLimits contains limits of uploaded images and of their resized variants
+type ImageLimits struct {
+	MaxBytes     int64
+	MaxDimension int
+	MaxPixels    int
+	// VariantSizes are the only widths and heights variants can be requested with, so the number of variants of an image is bounded
+	VariantSizes []int
+	// MaxResizes is how many variants are generated at once, requests for other missing variants wait
+	MaxResizes int
+	// QuotaBytes is how many bytes of images a user may keep, every image counts even if its content is shared.
+	// 0 means no quota
+	QuotaBytes int64
+	// CanonicalFormat is png or jpeg to convert every still upload to, empty keeps the format of the upload
+	CanonicalFormat string
+	// Validators are called on every decoded upload, see ImageValidator
+	Validators []ImageValidator
+}
+
+// BlobStore is an interface of the storage that images are kept in
+type BlobStore interface {
+	Put(ctx context.Context, key string, src io.Reader, size int64) error
+	Get(ctx context.Context, key string) (*model.Blob, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ImageRepository is an interface that contains methods to manage metadata of images of the tenant
+type ImageRepository interface {
+	CreateImage(ctx context.Context, img *model.Image) error
+	GetImage(ctx context.Context, id uuid.UUID) (*model.Image, error)
+	GetImages(ctx context.Context, ownerID uuid.UUID, limit, offset int) ([]model.Image, error)
+	DeleteImage(ctx context.Context, id uuid.UUID) error
+	ImageUsage(ctx context.Context, ownerID uuid.UUID) (*model.ImageUsage, error)
+	ImageUsageReport(ctx context.Context) (*model.ImageUsageReport, error)
+	ImageContentKey(ctx context.Context, sha256 string) (string, error)
+	AddImageRef(ctx context.Context, sha256, key string) (string, error)
+	ReleaseImageRef(ctx context.Context, sha256 string) error
+	UnreferencedImageContents(ctx context.Context, before time.Time, limit int) ([]model.ImageContent, error)
+	DeleteImageContent(ctx context.Context, content *model.ImageContent) (bool, error)
+	ReserveImageUsage(ctx context.Context, ownerID uuid.UUID, size, quota int64) error
+	ReleaseImageUsage(ctx context.Context, ownerID uuid.UUID, size int64) error
+}
+
+// ImageAvatars is an interface of the person service that unsets avatars of persons whose image is deleted.
+// UnsetAvatars is called in the transaction that deletes the image and returns the changed persons, AvatarsUnset
+// is called with them after the commit
+type ImageAvatars interface {
+	UnsetAvatars(ctx context.Context, imageID uuid.UUID) ([]model.Person, error)
+	AvatarsUnset(ctx context.Context, persons []model.Person)
+}
+
+// ImageService validates uploaded images, stores them under generated IDs in one BlobStore and records their metadata.
+// Images are resolved only by ID, so no name given by a client ever becomes a part of a key.
+//
+// The content of an image is stored once per tenant under its SHA-256, see contentKey, and the key of the image
+// keeps a reference to it, so uploads of the same file share storage. References of contents and usage of owners are
+// counted by the repository in the transaction that records or deletes the image, so replicas sharing the store and
+// the database can't race on them. A content that loses its last reference is deleted later by CollectContents
+type ImageService struct {
+	imageRps ImageRepository
+	tx       TxManager
+	avatars  ImageAvatars
+	store    BlobStore
+	limits   ImageLimits
+	resizes  chan struct{}
+	variants singleflight.Group
+}
+
+// NewImageService accepts ImageRepository to record metadata in, TxManager to record it atomically, ImageAvatars to
+// unset avatars of deleted images, nil if persons have no avatars, BlobStore to keep images in and limits of uploaded
+// images and returns an object of type *ImageService
+func NewImageService(imageRps ImageRepository, tx TxManager, avatars ImageAvatars, store BlobStore, limits ImageLimits) *ImageService {
+	if limits.MaxResizes <= 0 {
+		limits.MaxResizes = 1
+	}
+	return &ImageService{imageRps: imageRps, tx: tx, avatars: avatars, store: store, limits: limits,
+		resizes: make(chan struct{}, limits.MaxResizes)}
+}
+
+// Upload checks that src is an image of an allowed type within the limits, stores it re-encoded without metadata,
+// see process, and records it as owned by the user from context. The type is detected from the content, never from
+// the name or the headers given by the client. Size, SHA-256 and the quota are of the re-encoded image.
+// The upload is checked in a local temporary file, so nothing reaches the store before it is accepted
+func (srv *ImageService) Upload(ctx context.Context, filename string, src io.Reader) (*model.Image, error) {
+	return srv.upload(ctx, filename, src, model.ImageOriginUpload)
+}
+
+// upload uploads the image like Upload and records the origin of it
+func (srv *ImageService) upload(ctx context.Context, filename string, src io.Reader, origin string) (*model.Image, error) {
+	ownerID, ok := identity.UserFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("ImageService -> Upload -> error: %w", errNoUser)
+	}
+	tmp, err := os.CreateTemp("", "image-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> os.CreateTemp -> error: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	head := &prefixWriter{limit: sniffLen}
+	size, err := io.Copy(io.MultiWriter(tmp, head), io.LimitReader(src, srv.limits.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> io.Copy -> error: %w", err)
+	}
+	if size > srv.limits.MaxBytes {
+		return nil, fmt.Errorf("ImageService -> Upload -> error: %w", ErrImageTooLarge)
+	}
+	img := &model.Image{ID: uuid.New(), OwnerID: ownerID, Filename: cleanFilename(filename), Origin: origin}
+	img.ContentType = http.DetectContentType(head.Bytes())
+	if !allowedImageTypes[img.ContentType] {
+		return nil, fmt.Errorf("ImageService -> Upload -> %s -> error: %w", img.ContentType, ErrImageType)
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> tmp.Seek -> error: %w", err)
+	}
+	if img.Width, img.Height, err = srv.checkDimensions(tmp); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> error: %w", err)
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> tmp.Seek -> error: %w", err)
+	}
+	processed, err := os.CreateTemp("", "image-processed-*")
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> os.CreateTemp -> error: %w", err)
+	}
+	defer func() {
+		_ = processed.Close()
+		_ = os.Remove(processed.Name())
+	}()
+	hash := sha256.New()
+	if err = srv.process(img, tmp, io.MultiWriter(processed, hash)); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> process -> error: %w", err)
+	}
+	if img.Size, err = processed.Seek(0, io.SeekCurrent); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> processed.Seek -> error: %w", err)
+	}
+	img.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if err = srv.checkQuota(ctx, ownerID, img.Size); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> error: %w", err)
+	}
+	if _, err = processed.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> processed.Seek -> error: %w", err)
+	}
+	if err = srv.save(ctx, img, processed); err != nil {
+		return nil, fmt.Errorf("ImageService -> Upload -> error: %w", err)
+	}
+	return img, nil
+}
+
+// Meta returns metadata of the image of the user from context
+func (srv *ImageService) Meta(ctx context.Context, id uuid.UUID) (*model.Image, error) {
+	img, err := srv.owned(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> Meta -> error: %w", err)
+	}
+	return img, nil
+}
+
+// List returns a page of images of the user from context, newest first
+func (srv *ImageService) List(ctx context.Context, limit, offset int) ([]model.Image, error) {
+	ownerID, ok := identity.UserFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("ImageService -> List -> error: %w", errNoUser)
+	}
+	images, err := srv.imageRps.GetImages(ctx, ownerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> List -> imageRps.GetImages -> error: %w", err)
+	}
+	return images, nil
+}
+
+// Delete deletes the image of the user from context with its variants, see remove. The content is collected later
+// if no other image refers to it
+func (srv *ImageService) Delete(ctx context.Context, id uuid.UUID) error {
+	if _, err := srv.owned(ctx, id); err != nil {
+		return fmt.Errorf("ImageService -> Delete -> error: %w", err)
+	}
+	if err := srv.remove(ctx, id); err != nil {
+		return fmt.Errorf("ImageService -> Delete -> error: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the image of the tenant from context with its variants whoever owns it and releases its reference
+// to the content, see forget. Metadata is deleted last, so a failed deletion can be repeated
+func (srv *ImageService) remove(ctx context.Context, id uuid.UUID) error {
+	img, err := srv.imageRps.GetImage(ctx, id)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			return fmt.Errorf("imageRps.GetImage -> %w: %w", ErrImageNotFound, err)
+		}
+		return fmt.Errorf("imageRps.GetImage -> error: %w", err)
+	}
+	if err = srv.deleteVariants(ctx, id); err != nil {
+		return err
+	}
+	ref, err := imageKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, refKey := range []string{ref, legacyImageKey(id)} {
+		if err = srv.store.Delete(ctx, refKey); err != nil {
+			return fmt.Errorf("store.Delete -> error: %w", err)
+		}
+	}
+	return srv.forget(ctx, img)
+}
+
+// owned returns metadata of the image if it belongs to the user from context
+func (srv *ImageService) owned(ctx context.Context, id uuid.UUID) (*model.Image, error) {
+	userID, ok := identity.UserFromContext(ctx)
+	if !ok {
+		return nil, errNoUser
+	}
+	img, err := srv.imageRps.GetImage(ctx, id)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			return nil, fmt.Errorf("imageRps.GetImage -> %w: %w", ErrImageNotFound, err)
+		}
+		return nil, fmt.Errorf("imageRps.GetImage -> error: %w", err)
+	}
+	if img.OwnerID != userID {
+		return nil, fmt.Errorf("%s: %w", id, ErrImageForbidden)
+	}
+	return img, nil
+}
+
+// cleanFilename returns the last element of the name given by the uploader without control characters,
+// shortened to maxFilenameLen characters. The name is only shown back to the users, it is never used as a path
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxFilenameLen {
+		name = string(runes[:maxFilenameLen])
+	}
+	return name
+}
+
+// Open opens the image stored under the id or its variant for reading. A missing variant is generated and stored,
+// see OpenVariant. The content type is detected from the content, stored images and variants are never changed,
+// so the key is a strong ETag
+func (srv *ImageService) Open(ctx context.Context, id uuid.UUID, variant model.ImageVariant) (*model.ImageFile, error) {
+	if variant != (model.ImageVariant{}) {
+		img, err := srv.openVariant(ctx, id, variant)
+		if err != nil {
+			return nil, fmt.Errorf("ImageService -> Open -> error: %w", err)
+		}
+		return img, nil
+	}
+	ref, err := imageKey(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> Open -> error: %w", err)
+	}
+	key, err := srv.resolve(ctx, id)
+	if err == nil {
+		var img *model.ImageFile
+		if img, err = srv.open(ctx, key); err == nil {
+			img.ETag = `"` + ref + `"`
+			return img, nil
+		}
+	}
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, fmt.Errorf("ImageService -> Open -> %s -> error: %w", id, ErrImageNotFound)
+	}
+	return nil, fmt.Errorf("ImageService -> Open -> error: %w", err)
+}
+
+// open opens the stored object for reading
+func (srv *ImageService) open(ctx context.Context, key string) (*model.ImageFile, error) {
+	stored, err := srv.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("store.Get -> error: %w", err)
+	}
+	contentType, err := sniff(stored.Content)
+	if err != nil {
+		_ = stored.Content.Close()
+		return nil, err
+	}
+	return &model.ImageFile{
+		Content:     stored.Content,
+		ContentType: contentType,
+		Size:        stored.Size,
+		ModTime:     stored.ModTime,
+		ETag:        `"` + key + `"`,
+	}, nil
+}
+
+// sniff detects type of the content and rewinds it
+func sniff(content io.ReadSeeker) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("io.ReadFull -> error: %w", err)
+	}
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("content.Seek -> error: %w", err)
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// imageKey returns the key the reference to the content of the image of the tenant from context is stored under
+func imageKey(ctx context.Context, id uuid.UUID) (string, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return imagePrefix + tenantID.String() + "/" + id.String(), nil
+}
+
+// legacyImageKey returns the key images were stored under before keys had the tenant, images uploaded before
+// contents were shared keep the content itself under the key
+func legacyImageKey(id uuid.UUID) string {
+	return id.String()
+}
+
+// checkDimensions reads dimensions from the image header, so a huge image is rejected before its pixels are decoded
+func (srv *ImageService) checkDimensions(src io.Reader) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return 0, 0, fmt.Errorf("image.DecodeConfig -> %v: %w", err, ErrInvalidImage)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return 0, 0, ErrInvalidImage
+	}
+	if cfg.Width > srv.limits.MaxDimension || cfg.Height > srv.limits.MaxDimension ||
+		cfg.Width*cfg.Height > srv.limits.MaxPixels {
+		return 0, 0, fmt.Errorf("%dx%d: %w", cfg.Width, cfg.Height, ErrImageDimensions)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// prefixWriter keeps the first limit bytes written to it
+type prefixWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+// Write keeps the part of p that fits into the limit and reports p as written, so it never stops io.MultiWriter
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if rest := w.limit - w.buf.Len(); rest > 0 {
+		if len(p) < rest {
+			rest = len(p)
+		}
+		w.buf.Write(p[:rest])
+	}
+	return len(p), nil
+}
+
+// Bytes returns the kept bytes
+func (w *prefixWriter) Bytes() []byte {
+	return w.buf.Bytes()
+}
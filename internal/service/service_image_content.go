@@ -0,0 +1,354 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// collectBatch is how many unreferenced contents CollectContents deletes at most in one call
+const collectBatch = 1000
+
+// errContentCollected means that the content was collected between its lookup and the new reference to it
+var errContentCollected = fmt.Errorf("content was collected meanwhile")
+
+// contentKey returns the key the content with the SHA-256 was stored under for the tenant from context before
+// keys had a generation, see newContentKey. Contents aren't shared between tenants, so references of a content are
+// counted within one tenant
+func contentKey(ctx context.Context, sha256 string) (string, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return tenantContentKey(tenantID, sha256), nil
+}
+
+// tenantContentKey returns the key the content with the SHA-256 was stored under for the tenant before keys had a generation
+func tenantContentKey(tenantID uuid.UUID, sha256 string) string {
+	return contentPrefix + tenantID.String() + "/" + sha256
+}
+
+// newContentKey returns a key for a new content with the SHA-256 of the tenant from context. Every stored content gets
+// a new generation, so a content uploaded again never lands under the key the collector is deleting
+func newContentKey(ctx context.Context, sha256 string) (string, error) {
+	key, err := contentKey(ctx, sha256)
+	if err != nil {
+		return "", err
+	}
+	return key + "." + uuid.NewString(), nil
+}
+
+// recordedContentKey returns the key of the content recorded in the repository, empty key means the key without a generation
+func recordedContentKey(tenantID uuid.UUID, content *model.ImageContent) string {
+	if content.Key == "" {
+		return tenantContentKey(tenantID, content.SHA256)
+	}
+	return content.Key
+}
+
+// save stores the content of the image unless the tenant already has it and records the image, a reference to the
+// content and the usage of the owner in one transaction, so concurrent uploads on any replica can't exceed the quota
+// or lose a reference. If the content was collected between its lookup and the transaction, it is stored again.
+// Nothing is left in the store if the image can't be recorded
+func (srv *ImageService) save(ctx context.Context, img *model.Image, src io.ReadSeeker) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	ref, err := imageKey(ctx, img.ID)
+	if err != nil {
+		return err
+	}
+	var content model.ImageContent
+	for attempt := 0; ; attempt++ {
+		var created bool
+		if created, err = srv.putContent(ctx, img, src, &content); err != nil {
+			return err
+		}
+		var stored string
+		if stored, err = srv.record(ctx, img, &content, created); err == nil {
+			if created && stored != content.Key {
+				// another upload recorded the same content first, the images share its key
+				srv.dropContent(ctx, content.Key, true)
+			}
+			content.Key = stored
+			break
+		}
+		srv.dropContent(ctx, content.Key, created)
+		if !errors.Is(err, errContentCollected) || attempt > 0 {
+			return err
+		}
+		if _, err = src.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("src.Seek -> error: %w", err)
+		}
+	}
+	key := recordedContentKey(tenantID, &content)
+	if err = srv.store.Put(ctx, ref, strings.NewReader(key), int64(len(key))); err != nil {
+		if forgetErr := srv.forget(ctx, img); forgetErr != nil {
+			logrus.WithField("ID", img.ID).Errorf("ImageService -> save -> error: %v", forgetErr)
+		}
+		return fmt.Errorf("store.Put -> error: %w", err)
+	}
+	return nil
+}
+
+// putContent looks the content of the image up in the repository and stores it under a new key if the tenant doesn't
+// have it, then content.Key is the new key and created is true. A recorded content that is missing in the store,
+// like a content of images uploaded before contents were shared, is stored under its recorded key
+func (srv *ImageService) putContent(ctx context.Context, img *model.Image, src io.Reader, content *model.ImageContent) (created bool, err error) {
+	content.SHA256 = img.SHA256
+	content.Key, err = srv.imageRps.ImageContentKey(ctx, img.SHA256)
+	if errors.Is(err, model.ErrNotFound) {
+		if content.Key, err = newContentKey(ctx, img.SHA256); err != nil {
+			return false, err
+		}
+		if err = srv.store.Put(ctx, content.Key, src, img.Size); err != nil {
+			return false, fmt.Errorf("store.Put -> error: %w", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("imageRps.ImageContentKey -> error: %w", err)
+	}
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	key := recordedContentKey(tenantID, content)
+	stored, err := srv.store.Get(ctx, key)
+	if err == nil {
+		if err = stored.Content.Close(); err != nil {
+			logrus.WithField("Key", key).Errorf("ImageService -> putContent -> stored.Content.Close -> error: %v", err)
+		}
+		return false, nil
+	}
+	if !errors.Is(err, blob.ErrNotFound) {
+		return false, fmt.Errorf("store.Get -> error: %w", err)
+	}
+	if err = srv.store.Put(ctx, key, src, img.Size); err != nil {
+		return false, fmt.Errorf("store.Put -> error: %w", err)
+	}
+	return false, nil
+}
+
+// record records the image, a reference to the content and the usage of the owner in one transaction and returns
+// the recorded key of the content. A content stored now is recorded under its new key unless another upload recorded
+// it first, a content looked up before must still be recorded, otherwise errContentCollected is returned
+func (srv *ImageService) record(ctx context.Context, img *model.Image, content *model.ImageContent, created bool) (string, error) {
+	newKey := ""
+	if created {
+		newKey = content.Key
+	}
+	var stored string
+	err := srv.tx.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		if stored, err = srv.imageRps.AddImageRef(ctx, img.SHA256, newKey); err != nil {
+			if errors.Is(err, model.ErrNotFound) {
+				return fmt.Errorf("imageRps.AddImageRef -> %w: %w", errContentCollected, err)
+			}
+			return fmt.Errorf("imageRps.AddImageRef -> error: %w", err)
+		}
+		if err = srv.imageRps.ReserveImageUsage(ctx, img.OwnerID, img.Size, srv.limits.QuotaBytes); err != nil {
+			if errors.Is(err, model.ErrQuotaExceeded) {
+				return fmt.Errorf("imageRps.ReserveImageUsage -> %w: %w", ErrImageQuota, err)
+			}
+			return fmt.Errorf("imageRps.ReserveImageUsage -> error: %w", err)
+		}
+		if err = srv.imageRps.CreateImage(ctx, img); err != nil {
+			return fmt.Errorf("imageRps.CreateImage -> error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("tx.WithTx -> error: %w", err)
+	}
+	return stored, nil
+}
+
+// forget deletes metadata of the image, unsets it as avatar of persons and releases its reference to the content and
+// the usage of the owner in one transaction. The content is kept, CollectContents deletes it later if no image refers
+// to it anymore
+func (srv *ImageService) forget(ctx context.Context, img *model.Image) error {
+	var unset []model.Person
+	err := srv.tx.WithTx(ctx, func(ctx context.Context) error {
+		if srv.avatars != nil {
+			var err error
+			if unset, err = srv.avatars.UnsetAvatars(ctx, img.ID); err != nil {
+				return fmt.Errorf("avatars.UnsetAvatars -> error: %w", err)
+			}
+		}
+		if err := srv.imageRps.DeleteImage(ctx, img.ID); err != nil {
+			if errors.Is(err, model.ErrNotFound) {
+				return fmt.Errorf("imageRps.DeleteImage -> %w: %w", ErrImageNotFound, err)
+			}
+			return fmt.Errorf("imageRps.DeleteImage -> error: %w", err)
+		}
+		if err := srv.imageRps.ReleaseImageRef(ctx, img.SHA256); err != nil {
+			return fmt.Errorf("imageRps.ReleaseImageRef -> error: %w", err)
+		}
+		if err := srv.imageRps.ReleaseImageUsage(ctx, img.OwnerID, img.Size); err != nil {
+			return fmt.Errorf("imageRps.ReleaseImageUsage -> error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("tx.WithTx -> error: %w", err)
+	}
+	if len(unset) != 0 {
+		srv.avatars.AvatarsUnset(ctx, unset)
+	}
+	return nil
+}
+
+// dropContent deletes the content that was stored by the failed upload, a content that was already stored is kept
+func (srv *ImageService) dropContent(ctx context.Context, key string, created bool) {
+	if !created {
+		return
+	}
+	if err := srv.store.Delete(ctx, key); err != nil {
+		logrus.WithField("Key", key).Errorf("ImageService -> dropContent -> store.Delete -> error: %v", err)
+	}
+}
+
+// CollectContents deletes contents of all tenants that have had no references since before and returns how many were
+// deleted. The record of a content is deleted first and only if it still has no references, so an upload that refers
+// to the content meanwhile keeps it, and an upload after that stores the content under a new key
+func (srv *ImageService) CollectContents(ctx context.Context, before time.Time) (int, error) {
+	contents, err := srv.imageRps.UnreferencedImageContents(ctx, before, collectBatch)
+	if err != nil {
+		return 0, fmt.Errorf("ImageService -> CollectContents -> imageRps.UnreferencedImageContents -> error: %w", err)
+	}
+	deleted := 0
+	for i := range contents {
+		content := &contents[i]
+		ok, err := srv.imageRps.DeleteImageContent(ctx, content)
+		if err != nil {
+			logrus.WithField("SHA256", content.SHA256).Errorf("ImageService -> CollectContents -> imageRps.DeleteImageContent -> error: %v", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		key := recordedContentKey(content.TenantID, content)
+		if err = srv.store.Delete(ctx, key); err != nil {
+			logrus.WithField("Key", key).Errorf("ImageService -> CollectContents -> store.Delete -> error: %v", err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Run calls CollectContents every interval until ctx is canceled, contents are kept for grace after their last
+// reference is deleted, so downloads that resolved the content before can finish
+func (srv *ImageService) Run(ctx context.Context, interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := srv.CollectContents(ctx, time.Now().Add(-grace))
+		if err != nil {
+			logrus.Errorf("ImageService -> Run -> CollectContents -> error: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			logrus.Infof("ImageService -> Run -> %d unreferenced contents are deleted", deleted)
+		}
+	}
+}
+
+// resolve returns the key the content of the image of the tenant from context is stored under. Legacy keys
+// without the tenant are read only if the tenant has recorded the image, so an ID never reaches another tenant
+func (srv *ImageService) resolve(ctx context.Context, id uuid.UUID) (string, error) {
+	refKey, err := imageKey(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	ref, err := srv.store.Get(ctx, refKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		if _, err = srv.imageRps.GetImage(ctx, id); err != nil {
+			if errors.Is(err, model.ErrNotFound) {
+				return "", fmt.Errorf("imageRps.GetImage -> %w: %w", blob.ErrNotFound, err)
+			}
+			return "", fmt.Errorf("imageRps.GetImage -> error: %w", err)
+		}
+		refKey = legacyImageKey(id)
+		ref, err = srv.store.Get(ctx, refKey)
+	}
+	if err != nil {
+		return "", fmt.Errorf("store.Get -> error: %w", err)
+	}
+	defer func() {
+		if err = ref.Content.Close(); err != nil {
+			logrus.WithField("ID", id).Errorf("ImageService -> resolve -> ref.Content.Close -> error: %v", err)
+		}
+	}()
+	if ref.Size > maxReferenceLen {
+		return refKey, nil
+	}
+	data, err := io.ReadAll(ref.Content)
+	if err != nil {
+		return "", fmt.Errorf("io.ReadAll -> error: %w", err)
+	}
+	if key := string(data); strings.HasPrefix(key, contentPrefix) {
+		return key, nil
+	}
+	return refKey, nil
+}
+
+// checkQuota returns ErrImageQuota if size more bytes don't fit into the quota of the user. It only rejects an upload
+// early, before it is stored; the quota is enforced when the image is recorded, see record
+func (srv *ImageService) checkQuota(ctx context.Context, ownerID uuid.UUID, size int64) error {
+	if srv.limits.QuotaBytes <= 0 {
+		return nil
+	}
+	usage, err := srv.imageRps.ImageUsage(ctx, ownerID)
+	if err != nil {
+		return fmt.Errorf("imageRps.ImageUsage -> error: %w", err)
+	}
+	if usage.Bytes+size > srv.limits.QuotaBytes {
+		return fmt.Errorf("%d of %d bytes are used, %d more don't fit: %w", usage.Bytes, srv.limits.QuotaBytes, size, ErrImageQuota)
+	}
+	return nil
+}
+
+// Usage returns storage used by images of the user from context and the quota of the user
+func (srv *ImageService) Usage(ctx context.Context) (*model.ImageUsage, error) {
+	ownerID, ok := identity.UserFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("ImageService -> Usage -> error: %w", errNoUser)
+	}
+	usage, err := srv.imageRps.ImageUsage(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> Usage -> imageRps.ImageUsage -> error: %w", err)
+	}
+	usage.QuotaBytes = srv.limits.QuotaBytes
+	return usage, nil
+}
+
+// UsageReport returns storage used by images of every user of the tenant from context
+func (srv *ImageService) UsageReport(ctx context.Context) (*model.ImageUsageReport, error) {
+	report, err := srv.imageRps.ImageUsageReport(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ImageService -> UsageReport -> imageRps.ImageUsageReport -> error: %w", err)
+	}
+	report.QuotaBytes = srv.limits.QuotaBytes
+	for i := range report.Users {
+		report.Users[i].QuotaBytes = srv.limits.QuotaBytes
+	}
+	return report, nil
+}
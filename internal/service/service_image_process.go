@@ -0,0 +1,321 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/distuurbia/firstTask/internal/model"
+)
+
+// uploadJPEGQuality is quality of re-encoded JPEG uploads, it is higher than of variants because the upload is the original
+const uploadJPEGQuality = 90
+
+// exifOrientation is the EXIF tag that tells how the camera was rotated
+const exifOrientation = 0x0112
+
+// ImageValidator checks the decoded upload, format is png, jpeg or gif. An error rejects the upload with ErrInvalidImage
+type ImageValidator func(img image.Image, format string) error
+
+// CheckImageFormat returns an error if the format can't be the canonical format of uploads, empty format keeps formats
+func CheckImageFormat(format string) error {
+	switch format {
+	case "", FormatPNG, FormatJPEG:
+		return nil
+	default:
+		return fmt.Errorf("CheckImageFormat -> error: canonical image format %q isn't png or jpeg", format)
+	}
+}
+
+// process decodes the upload read from src, runs validators on it and writes it re-encoded to dst, so no metadata
+// of the uploaded file such as EXIF with GPS coordinates is stored. JPEG is rotated as its EXIF orientation tells.
+// Still images are converted to the canonical format if it is set. Animated GIFs keep their format and frames, only
+// their extensions are stripped. Every frame of a GIF is decoded, so the pixels of all frames together are bounded by
+// MaxPixels before decoding, see decodeGIF.
+// Width, height and content type of the image are set to the ones of the written image
+func (srv *ImageService) process(img *model.Image, src io.Reader, dst io.Writer) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("io.ReadAll -> error: %w", err)
+	}
+	var (
+		decoded  image.Image
+		format   = "gif"
+		stripped bytes.Buffer
+		frames   int
+	)
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		decoded, frames, err = srv.decodeGIF(data, &stripped)
+		if err != nil {
+			return err
+		}
+	} else if decoded, format, err = image.Decode(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("image.Decode -> %v: %w", err, ErrInvalidImage)
+	}
+	for _, validate := range srv.limits.Validators {
+		if err = validate(decoded, format); err != nil {
+			return fmt.Errorf("%v: %w", err, ErrInvalidImage)
+		}
+	}
+	if format == "gif" && (frames > 1 || srv.limits.CanonicalFormat == "") {
+		if _, err = stripped.WriteTo(dst); err != nil {
+			return fmt.Errorf("stripped.WriteTo -> error: %w", err)
+		}
+		return nil
+	}
+	if format == FormatJPEG {
+		decoded = orient(decoded, jpegOrientation(data))
+	}
+	output := srv.limits.CanonicalFormat
+	if output == "" {
+		output = FormatPNG
+		if format == FormatJPEG {
+			output = FormatJPEG
+		}
+	}
+	switch output {
+	case FormatJPEG:
+		if format != FormatJPEG {
+			decoded = flatten(toRGBA(decoded))
+		}
+		err = jpeg.Encode(dst, decoded, &jpeg.Options{Quality: uploadJPEGQuality})
+		img.ContentType = "image/jpeg"
+	default:
+		err = png.Encode(dst, decoded)
+		img.ContentType = "image/png"
+	}
+	if err != nil {
+		return fmt.Errorf("encode -> error: %w", err)
+	}
+	img.Width, img.Height = decoded.Bounds().Dx(), decoded.Bounds().Dy()
+	return nil
+}
+
+// toRGBA returns the image as *image.RGBA
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+	return dst
+}
+
+// decodeGIF writes the GIF stripped of extensions to dst, decodes all its frames and returns the first one and the
+// number of frames. A GIF whose frames have more than MaxPixels pixels together is rejected before decoding, a frame
+// takes a byte per pixel, so an animation takes no more memory than a still image of MaxPixels pixels
+func (srv *ImageService) decodeGIF(data []byte, dst *bytes.Buffer) (first image.Image, frames int, err error) {
+	frames, pixels, err := stripGIF(data, dst)
+	if err != nil {
+		return nil, 0, err
+	}
+	if pixels > srv.limits.MaxPixels {
+		return nil, 0, fmt.Errorf("%d frames of %d pixels: %w", frames, pixels, ErrImageDimensions)
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("gif.DecodeAll -> %v: %w", err, ErrInvalidImage)
+	}
+	if len(anim.Image) == 0 {
+		return nil, 0, fmt.Errorf("gif.DecodeAll -> no frames: %w", ErrInvalidImage)
+	}
+	return anim.Image[0], frames, nil
+}
+
+// orient returns the image turned upright as the EXIF orientation from 1 to 8 tells, 1 and unknown values keep it
+func orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// the source pixel (x, y) goes to origin + x*stepX + y*stepY of the destination
+	var origin, stepX, stepY image.Point
+	switch orientation {
+	case 2: // mirrored
+		origin, stepX, stepY = image.Pt(w-1, 0), image.Pt(-1, 0), image.Pt(0, 1)
+	case 3: // upside down
+		origin, stepX, stepY = image.Pt(w-1, h-1), image.Pt(-1, 0), image.Pt(0, -1)
+	case 4: // upside down and mirrored
+		origin, stepX, stepY = image.Pt(0, h-1), image.Pt(1, 0), image.Pt(0, -1)
+	case 5: // transposed
+		origin, stepX, stepY = image.Pt(0, 0), image.Pt(0, 1), image.Pt(1, 0)
+	case 6: // rotated by 90 degrees counterclockwise, so it is turned clockwise
+		origin, stepX, stepY = image.Pt(h-1, 0), image.Pt(0, 1), image.Pt(-1, 0)
+	case 7: // transversed
+		origin, stepX, stepY = image.Pt(h-1, w-1), image.Pt(0, -1), image.Pt(-1, 0)
+	case 8: // rotated by 90 degrees clockwise, so it is turned counterclockwise
+		origin, stepX, stepY = image.Pt(0, w-1), image.Pt(0, -1), image.Pt(1, 0)
+	}
+	srcRGBA := toRGBA(src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	offsetX := stepX.Y*dst.Stride + stepX.X*4
+	offsetY := stepY.Y*dst.Stride + stepY.X*4
+	for y := 0; y < h; y++ {
+		row := srcRGBA.Pix[srcRGBA.PixOffset(srcRGBA.Rect.Min.X, srcRGBA.Rect.Min.Y+y):]
+		pos := origin.Y*dst.Stride + origin.X*4 + y*offsetY
+		for x := 0; x < w; x++ {
+			copy(dst.Pix[pos:pos+4], row[x*4:x*4+4])
+			pos += offsetX
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of the JPEG or 1 if it has none
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		// EXIF is in APP1 before the image data, SOS starts the data
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation returns the orientation from the first IFD of the TIFF structure of EXIF or 1 if it has none
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// the value of a SHORT tag is in the first two bytes of the value field
+		if order.Uint16(tiff[entry:]) == exifOrientation && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
+
+// stripGIF writes the GIF without comment, plain text and application extensions except the loop count and returns
+// the number of frames and their pixels together. Image data is copied without decoding
+func stripGIF(data []byte, dst *bytes.Buffer) (frames, pixels int, err error) {
+	invalid := fmt.Errorf("stripGIF -> truncated or malformed GIF: %w", ErrInvalidImage)
+	if len(data) < 13 {
+		return 0, 0, invalid
+	}
+	pos := 13 + colorTableLen(data[10])
+	if pos > len(data) {
+		return 0, 0, invalid
+	}
+	dst.Write(data[:pos])
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x3B: // trailer
+			dst.WriteByte(0x3B)
+			return frames, pixels, nil
+		case 0x21: // extension
+			if pos+2 > len(data) {
+				return 0, 0, invalid
+			}
+			end, ok := skipSubBlocks(data, pos+2)
+			if !ok {
+				return 0, 0, invalid
+			}
+			if keepGIFExtension(data[pos+1], data[pos+2:end]) {
+				dst.Write(data[pos:end])
+			}
+			pos = end
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return 0, 0, invalid
+			}
+			// the descriptor is followed by the local color table and the minimum LZW code size
+			start := pos
+			pos += 10 + colorTableLen(data[pos+9]) + 1
+			end, ok := skipSubBlocks(data, pos)
+			if !ok {
+				return 0, 0, invalid
+			}
+			dst.Write(data[start:end])
+			pos = end
+			frames++
+			pixels += int(binary.LittleEndian.Uint16(data[start+5:])) * int(binary.LittleEndian.Uint16(data[start+7:]))
+		default:
+			return 0, 0, invalid
+		}
+	}
+	// some encoders omit the trailer, decoders accept it
+	dst.WriteByte(0x3B)
+	return frames, pixels, nil
+}
+
+// colorTableLen returns the length of the color table that the flags of the screen or image descriptor tell about
+func colorTableLen(flags byte) int {
+	if flags&0x80 == 0 {
+		return 0
+	}
+	return 3 << ((flags & 0x07) + 1)
+}
+
+// skipSubBlocks returns the position after the sub-blocks starting at pos and the zero-length block that ends them
+func skipSubBlocks(data []byte, pos int) (int, bool) {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos, true
+		}
+		pos += size
+	}
+	return 0, false
+}
+
+// keepGIFExtension tells if the extension with the label and sub-blocks affects how the GIF is shown:
+// graphic controls and the loop count of animations
+func keepGIFExtension(label byte, blocks []byte) bool {
+	switch label {
+	case 0xF9:
+		return true
+	case 0xFF:
+		return len(blocks) >= 12 && blocks[0] == 11 &&
+			(string(blocks[1:12]) == "NETSCAPE2.0" || string(blocks[1:12]) == "ANIMEXTS1.0")
+	default:
+		return false
+	}
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+// secretMetadata is a value of metadata that must never reach the store
+const secretMetadata = "GPS 53.9045N 27.5615E serial 0042"
+
+// exifJPEG returns a JPEG of the given size with red top left corner and EXIF with the orientation and secretMetadata
+func exifJPEG(t *testing.T, width, height int, orientation uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	// little endian TIFF with one IFD entry: the orientation
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry, exifOrientation)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], orientation)
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+	payload := append(append([]byte("Exif\x00\x00"), tiff...), secretMetadata...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	jpg := buf.Bytes()
+	return append(append(append([]byte{}, jpg[:2]...), append(segment, payload...)...), jpg[2:]...)
+}
+
+// textPNG returns a PNG with a text chunk keeping secretMetadata
+func textPNG(t *testing.T) []byte {
+	content := encodeTestImage(t, 3, 3)
+	data := []byte("Comment\x00" + secretMetadata)
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	// the signature and IHDR take 33 bytes
+	return append(append(append([]byte{}, content[:33]...), chunk...), content[33:]...)
+}
+
+// commentedGIF returns an animated GIF of two frames with a comment extension keeping secretMetadata
+func commentedGIF(t *testing.T) []byte {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{LoopCount: 3, Delay: []int{10, 10}}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, anim))
+	content := buf.Bytes()
+	comment := append([]byte{0x21, 0xFE, byte(len(secretMetadata))}, append([]byte(secretMetadata), 0)...)
+	// the comment is put before the trailer
+	return append(append(append([]byte{}, content[:len(content)-1]...), comment...), 0x3B)
+}
+
+// uploadStored uploads the content and returns the image and its stored content
+func uploadStored(t *testing.T, srv *ImageService, content []byte) (*model.Image, []byte) {
+	ctx := newUserCtx()
+	img, err := srv.Upload(ctx, "photo", bytes.NewReader(content))
+	require.NoError(t, err)
+	file, err := srv.Open(ctx, img.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	stored, err := io.ReadAll(file.Content)
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+	require.Equal(t, img.Size, int64(len(stored)))
+	require.NotContains(t, string(stored), secretMetadata)
+	return img, stored
+}
+
+func TestImageUploadStripsMetadata(t *testing.T) {
+	srv, _ := newTestImageService(t.TempDir())
+
+	img, stored := uploadStored(t, srv, exifJPEG(t, 8, 4, 1))
+	require.Equal(t, "image/jpeg", img.ContentType)
+	require.Equal(t, 1, jpegOrientation(stored), "EXIF is stripped")
+
+	img, stored = uploadStored(t, srv, textPNG(t))
+	require.Equal(t, "image/png", img.ContentType)
+	_, err := png.Decode(bytes.NewReader(stored))
+	require.NoError(t, err)
+
+	img, stored = uploadStored(t, srv, commentedGIF(t))
+	require.Equal(t, "image/gif", img.ContentType)
+	anim, err := gif.DecodeAll(bytes.NewReader(stored))
+	require.NoError(t, err)
+	require.Len(t, anim.Image, 2, "frames are kept")
+	require.Equal(t, 3, anim.LoopCount)
+}
+
+func TestImageUploadNormalizesOrientation(t *testing.T) {
+	srv, _ := newTestImageService(t.TempDir())
+	// orientation 6 means that the camera was turned clockwise, the top left corner of the shot is its top right corner
+	content := exifJPEG(t, 8, 4, 6)
+	require.Equal(t, 6, jpegOrientation(content))
+	img, stored := uploadStored(t, srv, content)
+	require.Equal(t, 4, img.Width)
+	require.Equal(t, 8, img.Height)
+	decoded, err := jpeg.Decode(bytes.NewReader(stored))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 4, 8), decoded.Bounds())
+	r, g, _, _ := decoded.At(3, 0).RGBA()
+	require.Greater(t, r, g, "the red corner is at the top right")
+}
+
+func TestOrient(t *testing.T) {
+	// the source is 3x2 with a marked top left corner, the expected position is where the corner must be shown
+	// the source is a sub-image, so its bounds don't start at zero
+	tests := map[int]image.Point{1: {0, 0}, 2: {2, 0}, 3: {2, 1}, 4: {0, 1}, 5: {0, 0}, 6: {1, 0}, 7: {1, 2}, 8: {0, 2}}
+	for orientation, corner := range tests {
+		canvas := image.NewRGBA(image.Rect(0, 0, 5, 4))
+		src := canvas.SubImage(image.Rect(1, 1, 4, 3)).(*image.RGBA)
+		src.Set(1, 1, color.RGBA{R: 255, A: 255})
+		dst := orient(src, orientation)
+		if orientation == 1 {
+			corner = corner.Add(src.Bounds().Min)
+		} else if orientation >= 5 {
+			require.Equal(t, image.Rect(0, 0, 2, 3), dst.Bounds(), orientation)
+		}
+		red := 0
+		for y := dst.Bounds().Min.Y; y < dst.Bounds().Max.Y; y++ {
+			for x := dst.Bounds().Min.X; x < dst.Bounds().Max.X; x++ {
+				if r, _, _, _ := dst.At(x, y).RGBA(); r > 0 {
+					red++
+				}
+			}
+		}
+		require.Equal(t, 1, red, orientation)
+		require.Equal(t, color.RGBAModel.Convert(color.RGBA{R: 255, A: 255}), color.RGBAModel.Convert(dst.At(corner.X, corner.Y)), orientation)
+	}
+}
+
+func TestImageUploadCanonicalFormat(t *testing.T) {
+	limits := testImageLimits
+	limits.CanonicalFormat = FormatJPEG
+	srv := newFakeImageService(blob.NewLocal(t.TempDir()), limits)
+
+	img, stored := uploadStored(t, srv, textPNG(t))
+	require.Equal(t, "image/jpeg", img.ContentType)
+	_, err := jpeg.Decode(bytes.NewReader(stored))
+	require.NoError(t, err)
+	img, _ = uploadStored(t, srv, commentedGIF(t))
+	require.Equal(t, "image/gif", img.ContentType, "animations keep their format")
+
+	require.NoError(t, CheckImageFormat(""))
+	require.NoError(t, CheckImageFormat(FormatPNG))
+	require.Error(t, CheckImageFormat("webp"))
+}
+
+func TestImageUploadValidators(t *testing.T) {
+	limits := testImageLimits
+	limits.Validators = []ImageValidator{func(img image.Image, format string) error {
+		if format == "gif" {
+			return errors.New("GIFs aren't allowed")
+		}
+		return nil
+	}}
+	dir := t.TempDir()
+	srv := newFakeImageService(blob.NewLocal(dir), limits)
+	_, err := srv.Upload(newUserCtx(), "anim.gif", bytes.NewReader(commentedGIF(t)))
+	require.True(t, errors.Is(err, ErrInvalidImage), err)
+	require.ErrorContains(t, err, "GIFs aren't allowed")
+	require.Empty(t, storedFiles(t, dir))
+	uploadStored(t, srv, textPNG(t))
+}
+
+func TestImageUploadGIFFrames(t *testing.T) {
+	// every frame of commentedGIF is 4x4, so the frames have 32 pixels together
+	limits := testImageLimits
+	limits.MaxPixels = 20
+	srv := newFakeImageService(blob.NewLocal(t.TempDir()), limits)
+	_, err := srv.Upload(newUserCtx(), "anim.gif", bytes.NewReader(commentedGIF(t)))
+	require.True(t, errors.Is(err, ErrImageDimensions), err)
+
+	// the LZW code size of the second frame is invalid, decoding of the first frame alone doesn't notice it
+	content := commentedGIF(t)
+	second := bytes.LastIndex(content, []byte{0x2C, 0, 0, 0, 0, 4, 0, 4, 0})
+	require.Greater(t, second, bytes.Index(content, []byte{0x2C, 0, 0, 0, 0, 4, 0, 4, 0}))
+	content[second+10+colorTableLen(content[second+9])] = 12
+	_, _, err = image.Decode(bytes.NewReader(content))
+	require.NoError(t, err)
+	srv, _ = newTestImageService(t.TempDir())
+	_, err = srv.Upload(newUserCtx(), "anim.gif", bytes.NewReader(content))
+	require.True(t, errors.Is(err, ErrInvalidImage), err)
+}
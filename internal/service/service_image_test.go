@@ -0,0 +1,624 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var testImageLimits = ImageLimits{MaxBytes: 1 << 20, MaxDimension: 200, MaxPixels: 20000, VariantSizes: []int{4, 16, 64, 256}, MaxResizes: 2}
+
+// fakeImageRepository keeps metadata of images, references of contents and usage of owners in memory
+type fakeImageRepository struct {
+	mu       sync.Mutex
+	images   map[uuid.UUID]model.Image
+	contents map[string]model.ImageContent
+	usage    map[uuid.UUID]int64
+	err      error
+}
+
+func newFakeImageRepository() *fakeImageRepository {
+	return &fakeImageRepository{images: make(map[uuid.UUID]model.Image), contents: make(map[string]model.ImageContent),
+		usage: make(map[uuid.UUID]int64)}
+}
+
+// newFakeImageService returns ImageService that records images in a new fake repository
+func newFakeImageService(store BlobStore, limits ImageLimits) *ImageService {
+	repo := newFakeImageRepository()
+	return NewImageService(repo, repo, nil, store, limits)
+}
+
+// WithTx restores the records if fn fails, like a rolled back transaction
+func (r *fakeImageRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	r.mu.Lock()
+	images, contents, usage := copyMap(r.images), copyMap(r.contents), copyMap(r.usage)
+	r.mu.Unlock()
+	err := fn(ctx)
+	if err != nil {
+		r.mu.Lock()
+		r.images, r.contents, r.usage = images, contents, usage
+		r.mu.Unlock()
+	}
+	return err
+}
+
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	copied := make(map[K]V, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func (r *fakeImageRepository) CreateImage(_ context.Context, img *model.Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	img.CreatedAt = time.Now().UTC()
+	r.images[img.ID] = *img
+	return nil
+}
+
+func (r *fakeImageRepository) GetImage(_ context.Context, id uuid.UUID) (*model.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok {
+		return nil, model.ErrNotFound
+	}
+	return &img, nil
+}
+
+func (r *fakeImageRepository) GetImages(_ context.Context, ownerID uuid.UUID, limit, offset int) ([]model.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	images := []model.Image{}
+	for _, img := range r.images {
+		if img.OwnerID == ownerID {
+			images = append(images, img)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].CreatedAt.After(images[j].CreatedAt) })
+	if offset > len(images) {
+		offset = len(images)
+	}
+	images = images[offset:]
+	if limit < len(images) {
+		images = images[:limit]
+	}
+	return images, nil
+}
+
+func (r *fakeImageRepository) DeleteImage(_ context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.images[id]; !ok {
+		return model.ErrNotFound
+	}
+	delete(r.images, id)
+	return nil
+}
+
+func (r *fakeImageRepository) ImageContentKey(_ context.Context, sha256 string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	content, ok := r.contents[sha256]
+	if !ok {
+		return "", model.ErrNotFound
+	}
+	return content.Key, nil
+}
+
+func (r *fakeImageRepository) AddImageRef(ctx context.Context, sha256, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	content, ok := r.contents[sha256]
+	if !ok {
+		if key == "" {
+			return "", model.ErrNotFound
+		}
+		tenantID, err := identity.TenantFromContext(ctx)
+		if err != nil {
+			return "", err
+		}
+		content = model.ImageContent{TenantID: tenantID, SHA256: sha256, Key: key}
+	}
+	content.Refs++
+	content.UnreferencedAt = nil
+	r.contents[sha256] = content
+	return content.Key, nil
+}
+
+func (r *fakeImageRepository) ReleaseImageRef(_ context.Context, sha256 string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	content, ok := r.contents[sha256]
+	if !ok || content.Refs == 0 {
+		return nil
+	}
+	content.Refs--
+	if content.Refs == 0 {
+		now := time.Now()
+		content.UnreferencedAt = &now
+	}
+	r.contents[sha256] = content
+	return nil
+}
+
+func (r *fakeImageRepository) UnreferencedImageContents(_ context.Context, before time.Time, limit int) ([]model.ImageContent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	contents := []model.ImageContent{}
+	for _, content := range r.contents {
+		if content.Refs == 0 && !content.UnreferencedAt.After(before) && len(contents) < limit {
+			contents = append(contents, content)
+		}
+	}
+	return contents, nil
+}
+
+func (r *fakeImageRepository) DeleteImageContent(_ context.Context, content *model.ImageContent) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.contents[content.SHA256]
+	if !ok || stored.Refs != 0 || stored.Key != content.Key {
+		return false, nil
+	}
+	delete(r.contents, content.SHA256)
+	return true, nil
+}
+
+func (r *fakeImageRepository) ReserveImageUsage(_ context.Context, ownerID uuid.UUID, size, quota int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if quota > 0 && r.usage[ownerID]+size > quota {
+		return model.ErrQuotaExceeded
+	}
+	r.usage[ownerID] += size
+	return nil
+}
+
+func (r *fakeImageRepository) ReleaseImageUsage(_ context.Context, ownerID uuid.UUID, size int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.usage[ownerID] -= size
+	return nil
+}
+
+func (r *fakeImageRepository) ImageUsage(_ context.Context, ownerID uuid.UUID) (*model.ImageUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage := model.ImageUsage{OwnerID: ownerID}
+	for _, img := range r.images {
+		if img.OwnerID == ownerID {
+			usage.Images++
+			usage.Bytes += img.Size
+		}
+	}
+	return &usage, nil
+}
+
+func (r *fakeImageRepository) ImageUsageReport(_ context.Context) (*model.ImageUsageReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := model.ImageUsageReport{}
+	users := make(map[uuid.UUID]*model.ImageUsage)
+	contents := make(map[string]bool)
+	for _, img := range r.images {
+		usage, ok := users[img.OwnerID]
+		if !ok {
+			usage = &model.ImageUsage{OwnerID: img.OwnerID}
+			users[img.OwnerID] = usage
+		}
+		usage.Images++
+		usage.Bytes += img.Size
+		report.Bytes += img.Size
+		if !contents[img.SHA256] {
+			contents[img.SHA256] = true
+			report.StoredBytes += img.Size
+		}
+	}
+	for _, usage := range users {
+		report.Users = append(report.Users, *usage)
+	}
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].Bytes > report.Users[j].Bytes })
+	return &report, nil
+}
+
+// newUserCtx returns context of a new user of the test tenant
+func newUserCtx() context.Context {
+	return identity.WithUser(testCtx, uuid.New())
+}
+
+// newTestImageService returns ImageService that keeps images in the directory
+func newTestImageService(dir string) (*ImageService, *fakeImageRepository) {
+	repo := newFakeImageRepository()
+	return NewImageService(repo, repo, nil, blob.NewLocal(dir), testImageLimits), repo
+}
+
+// storedFiles returns keys of all files kept in the directory of the local store
+func storedFiles(t *testing.T, dir string) []string {
+	var keys []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		key, err := filepath.Rel(dir, path)
+		keys = append(keys, filepath.ToSlash(key))
+		return err
+	})
+	require.NoError(t, err)
+	return keys
+}
+
+// encodeTestImage returns a PNG of the given size
+func encodeTestImage(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestImageUpload(t *testing.T) {
+	dir := t.TempDir()
+	srv, repo := newTestImageService(dir)
+	content := encodeTestImage(t, 120, 80)
+	ctx := newUserCtx()
+
+	img, err := srv.Upload(ctx, "../../photos/andy\x00.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	userID, _ := identity.UserFromContext(ctx)
+	require.Equal(t, userID, img.OwnerID)
+	require.Equal(t, "andy.png", img.Filename)
+	require.Equal(t, *img, repo.images[img.ID])
+	require.Equal(t, "image/png", img.ContentType)
+	require.Equal(t, int64(len(content)), img.Size)
+	require.Equal(t, 120, img.Width)
+	require.Equal(t, 80, img.Height)
+	sum := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(sum[:]), img.SHA256)
+
+	prefix, err := contentKey(ctx, img.SHA256)
+	require.NoError(t, err)
+	key := repo.contents[img.SHA256].Key
+	require.True(t, strings.HasPrefix(key, prefix+"."), key)
+	require.Equal(t, 1, repo.contents[img.SHA256].Refs)
+	refKey, err := imageKey(ctx, img.ID)
+	require.NoError(t, err)
+	ref, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(refKey)))
+	require.NoError(t, err)
+	require.Equal(t, key, string(ref))
+	stored, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(key)))
+	require.NoError(t, err)
+	require.Equal(t, content, stored)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestImageUploadJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 10, 20)), nil))
+	srv, _ := newTestImageService(t.TempDir())
+	img, err := srv.Upload(newUserCtx(), "andy.jpg", &buf)
+	require.NoError(t, err)
+	require.Equal(t, "image/jpeg", img.ContentType)
+	require.Equal(t, 10, img.Width)
+}
+
+func TestImageUploadRejected(t *testing.T) {
+	pngHeader := encodeTestImage(t, 1, 1)[:16]
+	tests := map[string]struct {
+		content []byte
+		err     error
+	}{
+		"tooLarge":    {content: append(encodeTestImage(t, 10, 10), make([]byte, testImageLimits.MaxBytes)...), err: ErrImageTooLarge},
+		"notImage":    {content: []byte("<html><script>alert(1)</script></html>"), err: ErrImageType},
+		"tooWide":     {content: encodeTestImage(t, 201, 1), err: ErrImageDimensions},
+		"tooManyPixs": {content: encodeTestImage(t, 150, 150), err: ErrImageDimensions},
+		"corrupted":   {content: append(pngHeader, []byte("garbage")...), err: ErrInvalidImage},
+		"truncated":   {content: encodeTestImage(t, 10, 10)[:60], err: ErrInvalidImage},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			srv, repo := newTestImageService(dir)
+			_, err := srv.Upload(newUserCtx(), "andy.png", bytes.NewReader(test.content))
+			require.True(t, errors.Is(err, test.err), err)
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			require.Empty(t, entries)
+			require.Empty(t, repo.images)
+		})
+	}
+}
+
+func TestImageOpen(t *testing.T) {
+	srv, _ := newTestImageService(t.TempDir())
+	content := encodeTestImage(t, 3, 3)
+	img, err := srv.Upload(newUserCtx(), "andy.png", bytes.NewReader(content))
+	require.NoError(t, err)
+
+	file, err := srv.Open(testCtx, img.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, file.Content.Close()) }()
+	require.Equal(t, "image/png", file.ContentType)
+	require.Equal(t, int64(len(content)), file.Size)
+	refKey, err := imageKey(testCtx, img.ID)
+	require.NoError(t, err)
+	require.Equal(t, `"`+refKey+`"`, file.ETag)
+	require.False(t, file.ModTime.IsZero())
+	stored, err := io.ReadAll(file.Content)
+	require.NoError(t, err)
+	require.Equal(t, content, stored)
+
+	_, err = srv.Open(testCtx, uuid.New(), model.ImageVariant{})
+	require.True(t, errors.Is(err, ErrImageNotFound))
+}
+
+func TestImageUploadUnrecorded(t *testing.T) {
+	dir := t.TempDir()
+	srv, repo := newTestImageService(dir)
+	repo.err = fmt.Errorf("connection refused")
+	_, err := srv.Upload(newUserCtx(), "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.ErrorContains(t, err, "connection refused")
+	require.Empty(t, storedFiles(t, dir), "content without metadata is deleted")
+
+	_, err = srv.Upload(testCtx, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.True(t, errors.Is(err, errNoUser))
+}
+
+func TestImageOwnership(t *testing.T) {
+	srv, _ := newTestImageService(t.TempDir())
+	ownerCtx, otherCtx := newUserCtx(), newUserCtx()
+	img, err := srv.Upload(ownerCtx, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.NoError(t, err)
+
+	meta, err := srv.Meta(ownerCtx, img.ID)
+	require.NoError(t, err)
+	require.Equal(t, img, meta)
+	_, err = srv.Meta(otherCtx, img.ID)
+	require.True(t, errors.Is(err, ErrImageForbidden))
+	require.True(t, errors.Is(srv.Delete(otherCtx, img.ID), ErrImageForbidden))
+	images, err := srv.List(otherCtx, 10, 0)
+	require.NoError(t, err)
+	require.Empty(t, images)
+
+	require.NoError(t, srv.Delete(ownerCtx, img.ID))
+	_, err = srv.Open(ownerCtx, img.ID, model.ImageVariant{})
+	require.True(t, errors.Is(err, ErrImageNotFound))
+	_, err = srv.Meta(ownerCtx, img.ID)
+	require.True(t, errors.Is(err, ErrImageNotFound))
+	require.True(t, errors.Is(srv.Delete(ownerCtx, img.ID), ErrImageNotFound))
+}
+
+func TestImageList(t *testing.T) {
+	srv, _ := newTestImageService(t.TempDir())
+	ctx := newUserCtx()
+	var uploaded []uuid.UUID
+	for i := 0; i < 3; i++ {
+		img, err := srv.Upload(ctx, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+		require.NoError(t, err)
+		uploaded = append(uploaded, img.ID)
+	}
+	first, err := srv.List(ctx, 2, 0)
+	require.NoError(t, err)
+	second, err := srv.List(ctx, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	require.Len(t, second, 1)
+	listed := []uuid.UUID{first[0].ID, first[1].ID, second[0].ID}
+	require.ElementsMatch(t, uploaded, listed)
+}
+
+func TestCleanFilename(t *testing.T) {
+	tests := map[string]string{
+		"andy.png":                "andy.png",
+		"../../etc/passwd":        "passwd",
+		"C:\\Users\\andy\\me.png": "me.png",
+		"bad\x07\nname.gif":       "badname.gif",
+		"/":                       "",
+		"":                        "",
+		strings.Repeat("é", 300):  strings.Repeat("é", maxFilenameLen),
+	}
+	for name, want := range tests {
+		require.Equal(t, want, cleanFilename(name), name)
+	}
+}
+
+func TestImageDeduplication(t *testing.T) {
+	dir := t.TempDir()
+	srv, repo := newTestImageService(dir)
+	content := encodeTestImage(t, 3, 3)
+	ctx, otherCtx := newUserCtx(), newUserCtx()
+	first, err := srv.Upload(ctx, "logo.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	second, err := srv.Upload(otherCtx, "logo.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, second.ID)
+	key := repo.contents[first.SHA256].Key
+	firstRef, err := imageKey(ctx, first.ID)
+	require.NoError(t, err)
+	secondRef, err := imageKey(ctx, second.ID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{firstRef, secondRef, key}, storedFiles(t, dir))
+
+	report, err := srv.UsageReport(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2*first.Size, report.Bytes)
+	require.Equal(t, first.Size, report.StoredBytes)
+	require.Len(t, report.Users, 2)
+
+	require.NoError(t, srv.Delete(ctx, first.ID))
+	require.ElementsMatch(t, []string{secondRef, key}, storedFiles(t, dir))
+	file, err := srv.Open(otherCtx, second.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	stored, err := io.ReadAll(file.Content)
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+	require.Equal(t, content, stored)
+
+	require.NoError(t, srv.Delete(otherCtx, second.ID))
+	require.ElementsMatch(t, []string{key}, storedFiles(t, dir), "the content is collected later")
+	deleted, err := srv.CollectContents(testCtx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.Empty(t, storedFiles(t, dir))
+	require.Empty(t, repo.images)
+	require.Empty(t, repo.contents)
+}
+
+func TestImageCollectContents(t *testing.T) {
+	dir := t.TempDir()
+	srv, repo := newTestImageService(dir)
+	ctx := newUserCtx()
+	content := encodeTestImage(t, 3, 3)
+	img, err := srv.Upload(ctx, "logo.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	key := repo.contents[img.SHA256].Key
+	require.NoError(t, srv.Delete(ctx, img.ID))
+	deleted, err := srv.CollectContents(testCtx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, deleted, "the content is kept for the grace period")
+
+	again, err := srv.Upload(ctx, "logo.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, key, repo.contents[img.SHA256].Key, "the unreferenced content is referred to again")
+	deleted, err = srv.CollectContents(testCtx, time.Now())
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	file, err := srv.Open(ctx, again.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+
+	require.NoError(t, srv.Delete(ctx, again.ID))
+	deleted, err = srv.CollectContents(testCtx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.Empty(t, storedFiles(t, dir))
+
+	third, err := srv.Upload(ctx, "logo.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	require.NotEqual(t, key, repo.contents[third.SHA256].Key, "a collected content is stored under a new key")
+}
+
+func TestImageQuota(t *testing.T) {
+	content := encodeTestImage(t, 3, 3)
+	limits := testImageLimits
+	limits.QuotaBytes = int64(len(content))*2 + 1
+	srv := newFakeImageService(blob.NewLocal(t.TempDir()), limits)
+	ctx := newUserCtx()
+	for i := 0; i < 2; i++ {
+		_, err := srv.Upload(ctx, "logo.png", bytes.NewReader(content))
+		require.NoError(t, err)
+	}
+	_, err := srv.Upload(ctx, "logo.png", bytes.NewReader(content))
+	require.True(t, errors.Is(err, ErrImageQuota), err)
+
+	usage, err := srv.Usage(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, usage.Images)
+	require.Equal(t, int64(len(content))*2, usage.Bytes)
+	require.Equal(t, limits.QuotaBytes, usage.QuotaBytes)
+
+	_, err = srv.Upload(newUserCtx(), "logo.png", bytes.NewReader(content))
+	require.NoError(t, err, "the quota is per user")
+	_, err = srv.Usage(testCtx)
+	require.True(t, errors.Is(err, errNoUser))
+}
+
+func TestImageQuotaReserved(t *testing.T) {
+	content := encodeTestImage(t, 3, 3)
+	limits := testImageLimits
+	limits.QuotaBytes = int64(len(content)) * 2
+	repo := newFakeImageRepository()
+	dir := t.TempDir()
+	srv := NewImageService(repo, repo, nil, blob.NewLocal(dir), limits)
+	ctx := newUserCtx()
+	ownerID, _ := identity.UserFromContext(ctx)
+	// another replica reserved the quota for an upload that isn't recorded yet
+	repo.usage[ownerID] = int64(len(content)) + 1
+	_, err := srv.Upload(ctx, "logo.png", bytes.NewReader(content))
+	require.True(t, errors.Is(err, ErrImageQuota), err)
+	require.Empty(t, repo.images)
+	require.Empty(t, repo.contents)
+	require.Empty(t, storedFiles(t, dir))
+	require.Equal(t, int64(len(content))+1, repo.usage[ownerID])
+}
+
+func TestImageLegacyContent(t *testing.T) {
+	dir := t.TempDir()
+	srv, repo := newTestImageService(dir)
+	ctx := newUserCtx()
+	content := encodeTestImage(t, 3, 3)
+	img, err := srv.Upload(ctx, "andy.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	key := repo.contents[img.SHA256].Key
+	refKey, err := imageKey(ctx, img.ID)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(filepath.Join(dir, filepath.FromSlash(refKey))))
+	require.NoError(t, os.Rename(filepath.Join(dir, filepath.FromSlash(key)), filepath.Join(dir, legacyImageKey(img.ID))))
+
+	file, err := srv.Open(ctx, img.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	stored, err := io.ReadAll(file.Content)
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+	require.Equal(t, content, stored)
+
+	require.NoError(t, srv.Delete(ctx, img.ID))
+	_, err = srv.CollectContents(testCtx, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, storedFiles(t, dir))
+	require.Empty(t, repo.images)
+}
+
+func TestImageRecordedContentMissing(t *testing.T) {
+	dir := t.TempDir()
+	srv, repo := newTestImageService(dir)
+	ctx := newUserCtx()
+	content := encodeTestImage(t, 3, 3)
+	sum := sha256.Sum256(content)
+	sha := hex.EncodeToString(sum[:])
+	tenantID, err := identity.TenantFromContext(ctx)
+	require.NoError(t, err)
+	// contents counted by the migration have no key, images uploaded before contents were shared didn't store them
+	repo.contents[sha] = model.ImageContent{TenantID: tenantID, SHA256: sha, Refs: 1}
+
+	img, err := srv.Upload(ctx, "andy.png", bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, 2, repo.contents[sha].Refs)
+	key, err := contentKey(ctx, sha)
+	require.NoError(t, err)
+	stored, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(key)))
+	require.NoError(t, err)
+	require.Equal(t, content, stored)
+	file, err := srv.Open(ctx, img.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+}
@@ -0,0 +1,122 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+)
+
+// defaultURLTTL is how long a signed URL is valid if the client doesn't ask for another TTL
+const defaultURLTTL = 15 * time.Minute
+
+// imageURLKeyLabel is the message the key of signed URLs is derived with from another secret, see DeriveImageURLKey
+const imageURLKeyLabel = "firstTask image URL signing key v1"
+
+// allowedDispositions are values of Content-Disposition a signed URL can be minted with, empty means attachment
+var allowedDispositions = map[string]bool{
+	"":           true,
+	"inline":     true,
+	"attachment": true,
+}
+
+// ErrImageURLInvalid means that the signature of the URL doesn't match or the URL has expired
+var ErrImageURLInvalid = fmt.Errorf("signed URL is invalid or expired")
+
+// ErrImageURLOptions means that the TTL or the disposition of the requested URL isn't allowed
+var ErrImageURLOptions = fmt.Errorf("signed URL options aren't allowed")
+
+// ImageURLService mints download URLs of images signed with HMAC-SHA256, so an image can be downloaded without access
+// token, e.g. by an <img> tag, until the URL expires. The signature covers the tenant, the image ID, the expiry and the disposition;
+// variant params may be added to a signed URL, because the sizes of variants are bounded anyway
+type ImageURLService struct {
+	images *ImageService
+	key    []byte
+	maxTTL time.Duration
+	now    func() time.Time
+}
+
+// DeriveImageURLKey returns the key of signed URLs derived from the secret as HMAC-SHA256 of a fixed label, so a signed
+// URL never reveals anything about the secret, e.g. the key access tokens are signed with, and signatures of URLs can't
+// be used as signatures of anything else
+func DeriveImageURLKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(imageURLKeyLabel))
+	return mac.Sum(nil)
+}
+
+// NewImageURLService accepts ImageService that checks owners of images, the key URLs are signed with and the longest
+// TTL of a URL and returns an object of type *ImageURLService
+func NewImageURLService(images *ImageService, key []byte, maxTTL time.Duration) *ImageURLService {
+	if maxTTL <= 0 {
+		maxTTL = defaultURLTTL
+	}
+	return &ImageURLService{images: images, key: key, maxTTL: maxTTL, now: time.Now}
+}
+
+// Sign returns a URL of the image of the user from context that is valid for ttl, the default TTL is used if ttl is 0.
+// disposition is inline or attachment, the downloaded file is an attachment by default
+func (srv *ImageURLService) Sign(ctx context.Context, id uuid.UUID, ttl time.Duration, disposition string) (*model.SignedURL, error) {
+	if len(srv.key) == 0 {
+		return nil, fmt.Errorf("ImageURLService -> Sign -> error: signing key is empty")
+	}
+	if ttl == 0 {
+		ttl = defaultURLTTL
+		if ttl > srv.maxTTL {
+			ttl = srv.maxTTL
+		}
+	}
+	if ttl < 0 || ttl > srv.maxTTL {
+		return nil, fmt.Errorf("ImageURLService -> Sign -> ttl %s isn't within %s: %w", ttl, srv.maxTTL, ErrImageURLOptions)
+	}
+	if !allowedDispositions[disposition] {
+		return nil, fmt.Errorf("ImageURLService -> Sign -> disposition %q: %w", disposition, ErrImageURLOptions)
+	}
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ImageURLService -> Sign -> error: %w", err)
+	}
+	if _, err = srv.images.Meta(ctx, id); err != nil {
+		return nil, fmt.Errorf("ImageURLService -> Sign -> error: %w", err)
+	}
+	expiresAt := srv.now().Add(ttl).Truncate(time.Second).UTC()
+	query := url.Values{}
+	query.Set("tenant", tenantID.String())
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	if disposition != "" {
+		query.Set("disposition", disposition)
+	}
+	query.Set("signature", srv.signature(tenantID, id, expiresAt.Unix(), disposition))
+	return &model.SignedURL{URL: "/images/" + id.String() + "?" + query.Encode(), ExpiresAt: expiresAt}, nil
+}
+
+// Verify checks that the signature was made by Sign for the image of the tenant, the expiry and the disposition
+// and that the URL hasn't expired
+func (srv *ImageURLService) Verify(tenantID, id uuid.UUID, expires int64, disposition, signature string) error {
+	if len(srv.key) == 0 {
+		return fmt.Errorf("ImageURLService -> Verify -> signing key is empty: %w", ErrImageURLInvalid)
+	}
+	if !hmac.Equal([]byte(signature), []byte(srv.signature(tenantID, id, expires, disposition))) {
+		return fmt.Errorf("ImageURLService -> Verify -> signature doesn't match: %w", ErrImageURLInvalid)
+	}
+	if srv.now().Unix() > expires {
+		return fmt.Errorf("ImageURLService -> Verify -> expired at %d: %w", expires, ErrImageURLInvalid)
+	}
+	return nil
+}
+
+// signature returns hex encoded HMAC-SHA256 of "tenant.id.expires.disposition"
+func (srv *ImageURLService) signature(tenantID, id uuid.UUID, expires int64, disposition string) string {
+	mac := hmac.New(sha256.New, srv.key)
+	mac.Write([]byte(tenantID.String() + "." + id.String() + "." + strconv.FormatInt(expires, 10) + "." + disposition))
+	return hex.EncodeToString(mac.Sum(nil))
+}
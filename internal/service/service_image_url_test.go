@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageURLSign(t *testing.T) {
+	images, _ := newTestImageService(t.TempDir())
+	srv := NewImageURLService(images, []byte("url key"), time.Hour)
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+	ctx := newUserCtx()
+	img, err := images.Upload(ctx, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.NoError(t, err)
+
+	signed, err := srv.Sign(ctx, img.ID, 0, "inline")
+	require.NoError(t, err)
+	require.Equal(t, now.Add(defaultURLTTL).Unix(), signed.ExpiresAt.Unix())
+	path, rawQuery, _ := strings.Cut(signed.URL, "?")
+	require.Equal(t, "/images/"+img.ID.String(), path)
+	query, err := url.ParseQuery(rawQuery)
+	require.NoError(t, err)
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	require.NoError(t, err)
+	require.Equal(t, "inline", query.Get("disposition"))
+	tenantID, err := identity.TenantFromContext(ctx)
+	require.NoError(t, err)
+	require.Equal(t, tenantID.String(), query.Get("tenant"))
+	require.NoError(t, srv.Verify(tenantID, img.ID, expires, "inline", query.Get("signature")))
+
+	tests := map[string]error{
+		"otherTenant":      srv.Verify(uuid.New(), img.ID, expires, "inline", query.Get("signature")),
+		"otherImage":       srv.Verify(tenantID, uuid.New(), expires, "inline", query.Get("signature")),
+		"laterExpiry":      srv.Verify(tenantID, img.ID, expires+3600, "inline", query.Get("signature")),
+		"otherDisposition": srv.Verify(tenantID, img.ID, expires, "attachment", query.Get("signature")),
+		"otherKey":         NewImageURLService(images, []byte("other key"), time.Hour).Verify(tenantID, img.ID, expires, "inline", query.Get("signature")),
+	}
+	for name, err := range tests {
+		require.True(t, errors.Is(err, ErrImageURLInvalid), name)
+	}
+	now = now.Add(defaultURLTTL + time.Second)
+	require.True(t, errors.Is(srv.Verify(tenantID, img.ID, expires, "inline", query.Get("signature")), ErrImageURLInvalid), "URL has expired")
+}
+
+func TestImageURLSignRejected(t *testing.T) {
+	images, _ := newTestImageService(t.TempDir())
+	srv := NewImageURLService(images, []byte("url key"), time.Hour)
+	ctx := newUserCtx()
+	img, err := images.Upload(ctx, "andy.png", bytes.NewReader(encodeTestImage(t, 3, 3)))
+	require.NoError(t, err)
+
+	_, err = srv.Sign(ctx, img.ID, 2*time.Hour, "")
+	require.True(t, errors.Is(err, ErrImageURLOptions))
+	_, err = srv.Sign(ctx, img.ID, time.Minute, "form-data")
+	require.True(t, errors.Is(err, ErrImageURLOptions))
+	_, err = srv.Sign(newUserCtx(), img.ID, time.Minute, "")
+	require.True(t, errors.Is(err, ErrImageForbidden))
+	_, err = NewImageURLService(images, nil, time.Hour).Sign(ctx, img.ID, time.Minute, "")
+	require.Error(t, err)
+}
+
+func TestDeriveImageURLKey(t *testing.T) {
+	key := DeriveImageURLKey([]byte("secret"))
+	require.Len(t, key, sha256.Size)
+	require.NotContains(t, string(key), "secret")
+	require.Equal(t, key, DeriveImageURLKey([]byte("secret")))
+	require.NotEqual(t, key, DeriveImageURLKey([]byte("other secret")))
+}
@@ -0,0 +1,318 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Fit modes of image variants
+const (
+	// FitContain scales the image down to fit into the box keeping its proportions
+	FitContain = "contain"
+	// FitCover scales the image down to cover the box keeping its proportions and crops the center of it
+	FitCover = "cover"
+	// FitFill scales the image to the box ignoring its proportions
+	FitFill = "fill"
+)
+
+// Formats of image variants, WebP isn't supported because the standard library can't encode it
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+)
+
+const (
+	// variantPrefix is the prefix of keys of variants in the blob store, variants of an image share the prefix with
+	// the tenant and its ID
+	variantPrefix = "variants/"
+	// variantTimeout bounds generation of a variant, it isn't bound to the request because other requests may wait for it
+	variantTimeout = 30 * time.Second
+	// variantJPEGQuality is quality of JPEG variants
+	variantJPEGQuality = 85
+)
+
+// ErrImageVariant means that the variant is requested with a size, fit or format that isn't allowed
+var ErrImageVariant = fmt.Errorf("image variant isn't allowed")
+
+// checkVariant returns the variant with default fit or ErrImageVariant if it isn't allowed
+func (srv *ImageService) checkVariant(variant model.ImageVariant) (model.ImageVariant, error) {
+	if variant.Width == 0 && variant.Height == 0 {
+		return variant, fmt.Errorf("width or height is required: %w", ErrImageVariant)
+	}
+	for _, size := range []int{variant.Width, variant.Height} {
+		if size != 0 && !srv.allowedSize(size) {
+			return variant, fmt.Errorf("size %d, allowed sizes are %v: %w", size, srv.limits.VariantSizes, ErrImageVariant)
+		}
+	}
+	switch variant.Fit {
+	case "":
+		variant.Fit = FitContain
+	case FitContain:
+	case FitCover, FitFill:
+		if variant.Width == 0 || variant.Height == 0 {
+			return variant, fmt.Errorf("fit %s needs both width and height: %w", variant.Fit, ErrImageVariant)
+		}
+	default:
+		return variant, fmt.Errorf("fit %q, use %s, %s or %s: %w", variant.Fit, FitContain, FitCover, FitFill, ErrImageVariant)
+	}
+	switch variant.Format {
+	case "", FormatPNG, FormatJPEG:
+	default:
+		return variant, fmt.Errorf("format %q, use %s or %s: %w", variant.Format, FormatPNG, FormatJPEG, ErrImageVariant)
+	}
+	return variant, nil
+}
+
+// allowedSize checks if variants can be requested with the size
+func (srv *ImageService) allowedSize(size int) bool {
+	for _, allowed := range srv.limits.VariantSizes {
+		if size == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// variantsKey returns the prefix of keys of variants of the image of the tenant
+func variantsKey(tenantID, id uuid.UUID) string {
+	return variantPrefix + tenantID.String() + "/" + id.String() + "/"
+}
+
+// variantKey returns the key the variant of the image of the tenant is stored under, empty format is named auto
+func variantKey(tenantID, id uuid.UUID, variant model.ImageVariant) string {
+	format := variant.Format
+	if format == "" {
+		format = "auto"
+	}
+	return fmt.Sprintf("%s%dx%d-%s.%s", variantsKey(tenantID, id), variant.Width, variant.Height, variant.Fit, format)
+}
+
+// openVariant opens the stored variant, a missing one is generated first. Concurrent requests for the same missing variant
+// share one generation, and at most MaxResizes variants are generated at once
+func (srv *ImageService) openVariant(ctx context.Context, id uuid.UUID, variant model.ImageVariant) (*model.ImageFile, error) {
+	variant, err := srv.checkVariant(variant)
+	if err != nil {
+		return nil, err
+	}
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := variantKey(tenantID, id, variant)
+	img, err := srv.open(ctx, key)
+	if err == nil {
+		return img, nil
+	}
+	if !errors.Is(err, blob.ErrNotFound) {
+		return nil, err
+	}
+	res := srv.variants.DoChan(key, func() (interface{}, error) {
+		genCtx, cancel := context.WithTimeout(identity.WithTenant(context.Background(), tenantID), variantTimeout)
+		defer cancel()
+		return nil, srv.generate(genCtx, id, key, variant)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-res:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+	}
+	img, err = srv.open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// generate resizes the original image and stores the variant under the key
+func (srv *ImageService) generate(ctx context.Context, id uuid.UUID, key string, variant model.ImageVariant) error {
+	select {
+	case srv.resizes <- struct{}{}:
+		defer func() { <-srv.resizes }()
+	case <-ctx.Done():
+		return fmt.Errorf("generate -> error: %w", ctx.Err())
+	}
+	originalKey, err := srv.resolve(ctx, id)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			return fmt.Errorf("generate -> %s -> error: %w", id, ErrImageNotFound)
+		}
+		return fmt.Errorf("generate -> error: %w", err)
+	}
+	original, err := srv.store.Get(ctx, originalKey)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			return fmt.Errorf("generate -> %s -> error: %w", id, ErrImageNotFound)
+		}
+		return fmt.Errorf("generate -> store.Get -> error: %w", err)
+	}
+	defer func() {
+		if err = original.Content.Close(); err != nil {
+			logrus.WithField("ID", id).Errorf("ImageService -> generate -> original.Content.Close -> error: %v", err)
+		}
+	}()
+	// the original was checked on upload, it is checked again so decoding never allocates more than the limits allow
+	if _, _, err = srv.checkDimensions(original.Content); err != nil {
+		return fmt.Errorf("generate -> error: %w", err)
+	}
+	if _, err = original.Content.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("generate -> original.Content.Seek -> error: %w", err)
+	}
+	src, format, err := image.Decode(original.Content)
+	if err != nil {
+		return fmt.Errorf("generate -> image.Decode -> %v: %w", err, ErrInvalidImage)
+	}
+	dst := resize(src, variant)
+	if variant.Format == "" {
+		variant.Format = FormatPNG
+		if format == FormatJPEG {
+			variant.Format = FormatJPEG
+		}
+	}
+	var buf bytes.Buffer
+	if variant.Format == FormatJPEG {
+		err = jpeg.Encode(&buf, flatten(dst), &jpeg.Options{Quality: variantJPEGQuality})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return fmt.Errorf("generate -> encode -> error: %w", err)
+	}
+	if err = srv.store.Put(ctx, key, &buf, int64(buf.Len())); err != nil {
+		return fmt.Errorf("generate -> store.Put -> error: %w", err)
+	}
+	return nil
+}
+
+// deleteVariants deletes all stored variants of the image of the tenant from context, including the ones stored
+// before keys had the tenant
+func (srv *ImageService) deleteVariants(ctx context.Context, id uuid.UUID) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("deleteVariants -> error: %w", err)
+	}
+	for _, prefix := range []string{variantsKey(tenantID, id), variantPrefix + id.String() + "/"} {
+		keys, err := srv.store.List(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("deleteVariants -> store.List -> error: %w", err)
+		}
+		for _, key := range keys {
+			if err = srv.store.Delete(ctx, key); err != nil {
+				return fmt.Errorf("deleteVariants -> store.Delete -> error: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// resize returns the image scaled to the variant, images are only scaled down, never up
+func resize(src image.Image, variant model.ImageVariant) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	crop := bounds
+	var dstW, dstH int
+	switch variant.Fit {
+	case FitFill:
+		dstW, dstH = minInt(variant.Width, srcW), minInt(variant.Height, srcH)
+	case FitCover:
+		scale := math.Min(1, math.Max(float64(variant.Width)/float64(srcW), float64(variant.Height)/float64(srcH)))
+		dstW, dstH = minInt(variant.Width, srcW), minInt(variant.Height, srcH)
+		cropW := minInt(srcW, int(math.Round(float64(dstW)/scale)))
+		cropH := minInt(srcH, int(math.Round(float64(dstH)/scale)))
+		crop.Min = crop.Min.Add(image.Pt((srcW-cropW)/2, (srcH-cropH)/2))
+		crop.Max = crop.Min.Add(image.Pt(cropW, cropH))
+	default:
+		scale := 1.0
+		if variant.Width > 0 {
+			scale = math.Min(scale, float64(variant.Width)/float64(srcW))
+		}
+		if variant.Height > 0 {
+			scale = math.Min(scale, float64(variant.Height)/float64(srcH))
+		}
+		dstW = maxInt(1, int(math.Round(float64(srcW)*scale)))
+		dstH = maxInt(1, int(math.Round(float64(srcH)*scale)))
+	}
+	return boxResize(src, crop, dstW, dstH)
+}
+
+// boxResize scales the rectangle of the image down to width x height, every pixel is the average of the source pixels it covers.
+// Pixels are averaged premultiplied by alpha, so transparent pixels don't darken the edges
+func boxResize(src image.Image, rect image.Rectangle, width, height int) *image.RGBA {
+	srcRGBA := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(srcRGBA, srcRGBA.Bounds(), src, rect.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xs := spans(rect.Dx(), width)
+	ys := spans(rect.Dy(), height)
+	for dy := 0; dy < height; dy++ {
+		for dx := 0; dx < width; dx++ {
+			var sum [4]uint64
+			for y := ys[dy]; y < ys[dy+1]; y++ {
+				row := srcRGBA.Pix[y*srcRGBA.Stride:]
+				for x := xs[dx]; x < xs[dx+1]; x++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += uint64(row[x*4+c])
+					}
+				}
+			}
+			n := uint64((xs[dx+1] - xs[dx]) * (ys[dy+1] - ys[dy]))
+			pixel := dst.Pix[dy*dst.Stride+dx*4:]
+			for c := 0; c < 4; c++ {
+				pixel[c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// spans splits srcLen source pixels into dstLen runs that are as equal as possible, run i is [spans[i], spans[i+1])
+func spans(srcLen, dstLen int) []int {
+	bounds := make([]int, dstLen+1)
+	for i := range bounds {
+		bounds[i] = i * srcLen / dstLen
+		if i > 0 && bounds[i] <= bounds[i-1] {
+			bounds[i] = bounds[i-1] + 1
+		}
+	}
+	bounds[dstLen] = srcLen
+	return bounds
+}
+
+// flatten draws the image over white background, JPEG has no transparency
+func flatten(img *image.RGBA) *image.RGBA {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts objects put into the wrapped store
+type countingStore struct {
+	BlobStore
+	puts atomic.Int64
+}
+
+func (s *countingStore) Put(ctx context.Context, key string, src io.Reader, size int64) error {
+	s.puts.Add(1)
+	return s.BlobStore.Put(ctx, key, src, size)
+}
+
+// newVariantService returns ImageService with an uploaded 200x100 PNG, its left half is red and the right half is blue
+func newVariantService(t *testing.T) (*ImageService, *countingStore, *model.Image) {
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(src, image.Rect(0, 0, 100, 100), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(100, 0, 200, 100), image.NewUniform(color.RGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	store := &countingStore{BlobStore: blob.NewLocal(t.TempDir())}
+	srv := newFakeImageService(store, testImageLimits)
+	img, err := srv.Upload(newUserCtx(), "flag.png", &buf)
+	require.NoError(t, err)
+	return srv, store, img
+}
+
+// openDecoded opens the variant and decodes it
+func openDecoded(t *testing.T, srv *ImageService, id uuid.UUID, variant model.ImageVariant) (image.Image, *model.ImageFile) {
+	file, err := srv.Open(testCtx, id, variant)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, file.Content.Close()) }()
+	img, _, err := image.Decode(file.Content)
+	require.NoError(t, err)
+	return img, file
+}
+
+func TestImageVariantFits(t *testing.T) {
+	srv, _, img := newVariantService(t)
+	tests := map[string]struct {
+		variant       model.ImageVariant
+		width, height int
+		left, right   color.Color
+	}{
+		"containWidth":  {variant: model.ImageVariant{Width: 64}, width: 64, height: 32},
+		"containHeight": {variant: model.ImageVariant{Height: 16}, width: 32, height: 16},
+		"containBox":    {variant: model.ImageVariant{Width: 64, Height: 64, Fit: FitContain}, width: 64, height: 32},
+		"neverUpscaled": {variant: model.ImageVariant{Width: 256}, width: 200, height: 100},
+		"cover":         {variant: model.ImageVariant{Width: 64, Height: 64, Fit: FitCover}, width: 64, height: 64},
+		"fill":          {variant: model.ImageVariant{Width: 16, Height: 64, Fit: FitFill}, width: 16, height: 64},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			resized, file := openDecoded(t, srv, img.ID, test.variant)
+			require.Equal(t, "image/png", file.ContentType)
+			require.Equal(t, image.Rect(0, 0, test.width, test.height), resized.Bounds())
+			requireColor(t, color.RGBA{R: 255, A: 255}, resized.At(0, test.height/2))
+			requireColor(t, color.RGBA{B: 255, A: 255}, resized.At(test.width-1, test.height/2))
+		})
+	}
+}
+
+// requireColor checks the color of a pixel
+func requireColor(t *testing.T, want, got color.Color) {
+	wr, wg, wb, wa := want.RGBA()
+	r, g, b, a := got.RGBA()
+	require.Equal(t, [4]uint32{wr >> 8, wg >> 8, wb >> 8, wa >> 8}, [4]uint32{r >> 8, g >> 8, b >> 8, a >> 8})
+}
+
+func TestImageVariantFormat(t *testing.T) {
+	srv, _, img := newVariantService(t)
+	resized, file := openDecoded(t, srv, img.ID, model.ImageVariant{Width: 64, Format: FormatJPEG})
+	require.Equal(t, "image/jpeg", file.ContentType)
+	require.Equal(t, 64, resized.Bounds().Dx())
+	require.Contains(t, file.ETag, "64x0-contain.jpeg")
+}
+
+func TestImageVariantStoredOnce(t *testing.T) {
+	srv, store, img := newVariantService(t)
+	uploads := store.puts.Load()
+	variant := model.ImageVariant{Width: 16, Height: 16, Fit: FitCover}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file, err := srv.Open(testCtx, img.ID, variant)
+			if err == nil {
+				err = file.Content.Close()
+			}
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, uploads+1, store.puts.Load())
+	keys, err := store.List(testCtx, variantPrefix)
+	require.NoError(t, err)
+	tenantID, err := identity.TenantFromContext(testCtx)
+	require.NoError(t, err)
+	require.Equal(t, []string{variantKey(tenantID, img.ID, variant)}, keys)
+}
+
+func TestImageVariantRejected(t *testing.T) {
+	srv, store, img := newVariantService(t)
+	for _, variant := range []model.ImageVariant{
+		{Fit: FitCover},
+		{Width: 100},
+		{Width: 64, Height: 5000},
+		{Width: 64, Fit: FitCover},
+		{Width: 64, Fit: "stretch"},
+		{Width: 64, Format: "webp"},
+	} {
+		_, err := srv.Open(testCtx, img.ID, variant)
+		require.True(t, errors.Is(err, ErrImageVariant), variant)
+	}
+	_, err := srv.Open(testCtx, uuid.New(), model.ImageVariant{Width: 64})
+	require.True(t, errors.Is(err, ErrImageNotFound))
+	keys, err := store.List(testCtx, variantPrefix)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestImageDeleteDeletesVariants(t *testing.T) {
+	store := blob.NewLocal(t.TempDir())
+	srv := newFakeImageService(store, testImageLimits)
+	ctx := newUserCtx()
+	img, err := srv.Upload(ctx, "andy.png", bytes.NewReader(encodeTestImage(t, 100, 100)))
+	require.NoError(t, err)
+	for _, size := range []int{4, 16} {
+		file, err := srv.Open(ctx, img.ID, model.ImageVariant{Width: size})
+		require.NoError(t, err)
+		require.NoError(t, file.Content.Close())
+	}
+	require.NoError(t, srv.Delete(ctx, img.ID))
+	_, err = srv.CollectContents(testCtx, time.Now())
+	require.NoError(t, err)
+	keys, err := store.List(testCtx, "")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestBoxResize(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 4, 2))
+	copy(src.Pix, []uint8{0, 255, 10, 10, 255, 0, 30, 30})
+	dst := boxResize(src, src.Bounds(), 2, 1)
+	requireColor(t, color.Gray{Y: 128}, dst.At(0, 0))
+	requireColor(t, color.Gray{Y: 20}, dst.At(1, 0))
+	require.Equal(t, []int{0, 2, 4, 7}, spans(7, 3))
+}
@@ -3,98 +3,353 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"time"
 
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/go-redis/redis"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
+// ErrPersonNotFound means that person with the given id doesn't exist
+var ErrPersonNotFound = fmt.Errorf("person not found")
+
+// loadTimeout bounds loading of a person from the database, the load is shared by all concurrent readers of the person
+// so it doesn't depend on the context of any of them
+const loadTimeout = 10 * time.Second
+
 // PersonRepository is an interface that contains CRUD methods and GetAll
 type PersonRepository interface {
 	Create(ctx context.Context, pers *model.Person) error
 	ReadRow(ctx context.Context, id uuid.UUID) (*model.Person, error)
+	ReadRowForUpdate(ctx context.Context, id uuid.UUID) (*model.Person, error)
+	GetByAvatarForUpdate(ctx context.Context, imageID uuid.UUID) ([]model.Person, error)
 	GetAll(ctx context.Context) ([]model.Person, error)
 	Update(ctx context.Context, pers *model.Person) error
 	Delete(ctx context.Context, id uuid.UUID) error
 }
 
-// PersonRedisRepository is an interface that contains redis methods
+// PersonRedisRepository is an interface that contains redis methods. Get returns redis.Nil if the person isn't cached
+// and nil person if the person is cached as missing. Fill caches a loaded person, nil for missing, only if the person
+// wasn't written or deleted since Version was read
 type PersonRedisRepository interface {
 	Set(ctx context.Context, user *model.Person) error
-	Get(ctx context.Context, id uuid.UUID) (*model.Person, error)
+	SetMissing(ctx context.Context, id uuid.UUID) error
+	Version(ctx context.Context, id uuid.UUID) (int64, error)
+	Fill(ctx context.Context, id uuid.UUID, pers *model.Person, version int64) error
+	Get(ctx context.Context, id uuid.UUID) (*model.Person, time.Duration, error)
 	Delete(ctx context.Context, id uuid.UUID) error
 }
 
+// OutboxRepository is an interface that writes events to the outbox
+type OutboxRepository interface {
+	AddToOutbox(ctx context.Context, event *model.OutboxEvent) error
+}
+
 // PersonService contains Repository interface
 type PersonService struct {
 	persRps    PersonRepository
 	persRdsRps PersonRedisRepository
+	tx         TxManager
+	outboxRps  OutboxRepository
+	loads      singleflight.Group
+	cacheOpts  CacheOptions
+	behind     *writeBehind
 }
 
-// NewPersonService accepts Repository object and returnes an object of type *PersonService
-func NewPersonService(persRps PersonRepository, persRdsRps PersonRedisRepository) *PersonService {
-	return &PersonService{persRps: persRps, persRdsRps: persRdsRps}
+// NewPersonService accepts Repository, RedisRepository, TxManager and OutboxRepository objects and options of the cache
+// and returnes an object of type *PersonService
+func NewPersonService(persRps PersonRepository, persRdsRps PersonRedisRepository, tx TxManager, outboxRps OutboxRepository,
+	cacheOpts CacheOptions) *PersonService {
+	srv := &PersonService{persRps: persRps, persRdsRps: persRdsRps, tx: tx, outboxRps: outboxRps, cacheOpts: cacheOpts}
+	if cacheOpts.Strategy == WriteBehind {
+		srv.behind = newWriteBehind()
+	}
+	return srv
 }
 
-// Create is a method of PersonService that calls Create method of Repository
-func (srv *PersonService) Create(ctx context.Context, pers *model.Person) error {
-	err := srv.persRps.Create(ctx, pers)
+// addToOutbox writes domain event about the person to the outbox, it must be called inside of tx.WithTx
+func (srv *PersonService) addToOutbox(ctx context.Context, eventType string, id uuid.UUID, payload interface{}) error {
+	event, err := events.New(ctx, eventType, id, payload)
 	if err != nil {
-		return fmt.Errorf("PersonService -> Create -> persRps.Create -> error: %w", err)
+		return fmt.Errorf("events.New -> error: %w", err)
 	}
-	err = srv.persRdsRps.Set(ctx, pers)
+	eventJSON, err := events.Encode(event)
 	if err != nil {
-		return fmt.Errorf("PersonService -> Create -> persRdsRps.Set -> error: %w", err)
+		return fmt.Errorf("events.Encode -> error: %w", err)
+	}
+	return srv.outboxRps.AddToOutbox(ctx, &model.OutboxEvent{
+		ID:          event.ID,
+		AggregateID: id,
+		Type:        eventType,
+		Payload:     eventJSON,
+	})
+}
+
+// Create is a method of PersonService that creates person and PersonCreated event in one transaction,
+// with write-behind strategy the person is only queued, see CacheOptions
+func (srv *PersonService) Create(ctx context.Context, pers *model.Person) error {
+	if srv.behind != nil {
+		if err := srv.enqueue(ctx, events.PersonCreated, pers.ID, pers); err != nil {
+			return fmt.Errorf("PersonService -> Create -> error: %w", err)
+		}
+		return nil
+	}
+	err := srv.tx.WithTx(ctx, func(ctx context.Context) error {
+		return srv.create(ctx, pers)
+	})
+	if err != nil {
+		return fmt.Errorf("PersonService -> Create -> tx.WithTx -> error: %w", err)
+	}
+	srv.cacheWritten(ctx, pers.ID, pers)
+	return nil
+}
+
+// create creates person and PersonCreated event, it must be called inside of tx.WithTx
+func (srv *PersonService) create(ctx context.Context, pers *model.Person) error {
+	if err := srv.persRps.Create(ctx, pers); err != nil {
+		return fmt.Errorf("persRps.Create -> error: %w", err)
+	}
+	if err := srv.addToOutbox(ctx, events.PersonCreated, pers.ID, pers); err != nil {
+		return fmt.Errorf("addToOutbox -> error: %w", err)
 	}
 	return nil
 }
 
-// ReadRow is a method of PersonService that calls ReadRow method of Repository
+// ReadRow is a method of PersonService that reads person from the cache and loads it from Repository on a miss.
+// Concurrent misses of the same person share one load, and missing persons are cached for a short time as well
 func (srv *PersonService) ReadRow(ctx context.Context, id uuid.UUID) (*model.Person, error) {
-	pers, err := srv.persRdsRps.Get(ctx, id)
+	if srv.behind != nil {
+		pers, pending, err := srv.behind.pending(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("PersonService -> ReadRow -> error: %w", err)
+		}
+		if pending {
+			if pers == nil {
+				return nil, fmt.Errorf("PersonService -> ReadRow -> error: %w", ErrPersonNotFound)
+			}
+			return pers, nil
+		}
+	}
+	pers, ttl, err := srv.persRdsRps.Get(ctx, id)
 	if err != nil && err.Error() != redis.Nil.Error() {
 		return nil, fmt.Errorf("PersonService -> ReadRow -> persRdsRps.Get -> error: %w", err)
 	}
-	if pers == nil {
-		pers, err = srv.persRps.ReadRow(ctx, id)
-		if err != nil {
-			return nil, fmt.Errorf("PersonService -> ReadRow -> persRps.ReadRow -> error: %w", err)
+	if err == nil {
+		if srv.refreshEarly(ttl) {
+			if _, err = srv.load(ctx, id); err != nil {
+				logrus.WithField("ID", id).Errorf("PersonService -> ReadRow -> load -> error: %v", err)
+			}
+		}
+		if pers == nil {
+			return nil, fmt.Errorf("PersonService -> ReadRow -> error: %w", ErrPersonNotFound)
+		}
+		return pers, nil
+	}
+	result, err := srv.load(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("PersonService -> ReadRow -> load -> error: %w", err)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("PersonService -> ReadRow -> error: %w", ctx.Err())
+	case res := <-result:
+		if res.Err != nil {
+			return nil, fmt.Errorf("PersonService -> ReadRow -> error: %w", res.Err)
 		}
-		err = srv.persRdsRps.Set(ctx, pers)
-		if err != nil && err != redis.Nil {
-			return nil, fmt.Errorf("PersonService -> ReadRow -> persRdsRps.Set -> error: %w", err)
+		loaded := *res.Val.(*model.Person)
+		return &loaded, nil
+	}
+}
+
+// refreshEarly tells if the cached entry that expires in ttl should be reloaded now. The probability is 1 - e^(-EarlyRefresh/ttl),
+// so of many readers close to expiration only a few reload the entry and the rest keep reading it from the cache
+func (srv *PersonService) refreshEarly(ttl time.Duration) bool {
+	if srv.cacheOpts.EarlyRefresh <= 0 || ttl <= 0 {
+		return false
+	}
+	//nolint:gosec // refresh decision doesn't need a cryptographic random
+	return float64(ttl) <= -float64(srv.cacheOpts.EarlyRefresh)*math.Log(rand.Float64())
+}
+
+// load starts loading of the person into the cache or joins the load that is already running and returns the channel of its result
+func (srv *PersonService) load(ctx context.Context, id uuid.UUID) (<-chan singleflight.Result, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return srv.loads.DoChan(tenantID.String()+":"+id.String(), func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(identity.WithTenant(context.Background(), tenantID), loadTimeout)
+		defer cancel()
+		return srv.loadPerson(loadCtx, id)
+	}), nil
+}
+
+// loadPerson reads person from Repository and caches it, a person that doesn't exist is cached as missing.
+// The version is read before the person, so a write that commits while the person is loaded keeps the load from
+// caching the replaced person. Cache failures are only logged, the person is returned anyway
+func (srv *PersonService) loadPerson(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	version, versionErr := srv.persRdsRps.Version(ctx, id)
+	if versionErr != nil {
+		logrus.WithField("ID", id).Errorf("PersonService -> loadPerson -> persRdsRps.Version -> error: %v", versionErr)
+	}
+	pers, err := srv.persRps.ReadRow(ctx, id)
+	if srv.behind != nil {
+		// a queued change is newer than the database, so what was read must not replace it in the cache
+		if queued, pending, _ := srv.behind.pending(ctx, id); pending {
+			if queued == nil {
+				return nil, ErrPersonNotFound
+			}
+			return queued, nil
 		}
 	}
+	notFound := errors.Is(err, model.ErrNotFound)
+	if err != nil && !notFound {
+		return nil, fmt.Errorf("persRps.ReadRow -> error: %w", err)
+	}
+	if notFound {
+		pers = nil
+	}
+	if versionErr == nil {
+		if cacheErr := srv.persRdsRps.Fill(ctx, id, pers, version); cacheErr != nil {
+			logrus.WithField("ID", id).Errorf("PersonService -> loadPerson -> persRdsRps.Fill -> error: %v", cacheErr)
+		}
+	}
+	if notFound {
+		return nil, fmt.Errorf("persRps.ReadRow -> %w: %w", ErrPersonNotFound, err)
+	}
 	return pers, nil
 }
 
-// Update is a method of PersonService that calls Update method of Repository
+// Update is a method of PersonService that updates person and creates PersonUpdated event in one transaction,
+// with write-behind strategy the change is only queued, see CacheOptions
 func (srv *PersonService) Update(ctx context.Context, pers *model.Person) error {
-	err := srv.persRps.Update(ctx, pers)
+	if srv.behind != nil {
+		if err := srv.enqueue(ctx, events.PersonUpdated, pers.ID, pers); err != nil {
+			return fmt.Errorf("PersonService -> Update -> error: %w", err)
+		}
+		return nil
+	}
+	err := srv.tx.WithTx(ctx, func(ctx context.Context) error {
+		return srv.update(ctx, pers)
+	})
 	if err != nil {
-		return fmt.Errorf("PersonService -> Update -> persRps -> Update -> error: %w", err)
+		return fmt.Errorf("PersonService -> Update -> tx.WithTx -> error: %w", err)
 	}
-	err = srv.persRdsRps.Delete(ctx, pers.ID)
-	if err != nil && err.Error() != redis.Nil.Error() {
-		return fmt.Errorf("PersonService -> Update -> persRdsRps.Delete -> error: %w", err)
+	srv.cacheWritten(ctx, pers.ID, pers)
+	return nil
+}
+
+// update updates person and creates PersonUpdated event, it must be called inside of tx.WithTx
+func (srv *PersonService) update(ctx context.Context, pers *model.Person) error {
+	if err := srv.persRps.Update(ctx, pers); err != nil {
+		return fmt.Errorf("persRps.Update -> error: %w", err)
 	}
-	err = srv.persRdsRps.Set(ctx, pers)
-	if err != nil {
-		return fmt.Errorf("PersonService -> Update -> persRdsRps.Set -> error: %w", err)
+	if err := srv.addToOutbox(ctx, events.PersonUpdated, pers.ID, pers); err != nil {
+		return fmt.Errorf("addToOutbox -> error: %w", err)
 	}
 	return nil
 }
 
-// Delete is a method of PersonService that calls Delete method of Repository
-func (srv PersonService) Delete(ctx context.Context, id uuid.UUID) error {
-	err := srv.persRps.Delete(ctx, id)
+// UnsetAvatars unsets the image as avatar of the persons that have it and writes PersonUpdated events about them,
+// it must be called inside of tx.WithTx that deletes the image. It returns the changed persons to pass to AvatarsUnset
+func (srv *PersonService) UnsetAvatars(ctx context.Context, imageID uuid.UUID) ([]model.Person, error) {
+	persons, err := srv.persRps.GetByAvatarForUpdate(ctx, imageID)
 	if err != nil {
-		return fmt.Errorf("PersonService -> Delete -> persRps.Delete -> error: %w", err)
+		return nil, fmt.Errorf("PersonService -> UnsetAvatars -> persRps.GetByAvatarForUpdate -> error: %w", err)
 	}
-	_ = srv.persRdsRps.Delete(ctx, id)
-	if err != nil && err.Error() != redis.Nil.Error() {
-		return fmt.Errorf("PersonService -> Delete -> persRdsRps.Delete -> error: %w", err)
+	for i := range persons {
+		persons[i].AvatarImageID = nil
+		if err = srv.update(ctx, &persons[i]); err != nil {
+			return nil, fmt.Errorf("PersonService -> UnsetAvatars -> error: %w", err)
+		}
+	}
+	return persons, nil
+}
+
+// AvatarsUnset updates the cache of the persons changed by UnsetAvatars after the transaction is committed
+func (srv *PersonService) AvatarsUnset(ctx context.Context, persons []model.Person) {
+	for i := range persons {
+		srv.cacheWritten(ctx, persons[i].ID, &persons[i])
+	}
+}
+
+// Modify is a method of PersonService that changes the person by fn and updates it, fn tells if it changed the person,
+// an unchanged person isn't written. The person is read and locked in the transaction of the update, so a change made
+// meanwhile can't be lost; fn may be called again if the transaction is retried. With write-behind strategy the person
+// is read like ReadRow does and the change is only queued, queued changes of a person are last-writer-wins
+func (srv *PersonService) Modify(ctx context.Context, id uuid.UUID, fn func(pers *model.Person) bool) (*model.Person, error) {
+	if srv.behind != nil {
+		pers, err := srv.ReadRow(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("PersonService -> Modify -> error: %w", err)
+		}
+		if !fn(pers) {
+			return pers, nil
+		}
+		if err = srv.enqueue(ctx, events.PersonUpdated, id, pers); err != nil {
+			return nil, fmt.Errorf("PersonService -> Modify -> error: %w", err)
+		}
+		return pers, nil
+	}
+	var pers *model.Person
+	var changed bool
+	err := srv.tx.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		pers, err = srv.persRps.ReadRowForUpdate(ctx, id)
+		if err != nil {
+			if errors.Is(err, model.ErrNotFound) {
+				return fmt.Errorf("persRps.ReadRowForUpdate -> %w: %w", ErrPersonNotFound, err)
+			}
+			return fmt.Errorf("persRps.ReadRowForUpdate -> error: %w", err)
+		}
+		if changed = fn(pers); !changed {
+			return nil
+		}
+		return srv.update(ctx, pers)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PersonService -> Modify -> tx.WithTx -> error: %w", err)
+	}
+	if changed {
+		srv.cacheWritten(ctx, id, pers)
+	}
+	return pers, nil
+}
+
+// Delete is a method of PersonService that deletes person and creates PersonDeleted event in one transaction,
+// with write-behind strategy the deletion is only queued, see CacheOptions
+func (srv *PersonService) Delete(ctx context.Context, id uuid.UUID) error {
+	if srv.behind != nil {
+		if err := srv.enqueue(ctx, events.PersonDeleted, id, nil); err != nil {
+			return fmt.Errorf("PersonService -> Delete -> error: %w", err)
+		}
+		return nil
+	}
+	err := srv.tx.WithTx(ctx, func(ctx context.Context) error {
+		return srv.delete(ctx, id)
+	})
+	if err != nil {
+		return fmt.Errorf("PersonService -> Delete -> tx.WithTx -> error: %w", err)
+	}
+	srv.cacheWritten(ctx, id, nil)
+	return nil
+}
+
+// delete deletes person and creates PersonDeleted event, it must be called inside of tx.WithTx
+func (srv *PersonService) delete(ctx context.Context, id uuid.UUID) error {
+	if err := srv.persRps.Delete(ctx, id); err != nil {
+		return fmt.Errorf("persRps.Delete -> error: %w", err)
+	}
+	if err := srv.addToOutbox(ctx, events.PersonDeleted, id, events.PersonDeletedPayload{ID: id}); err != nil {
+		return fmt.Errorf("addToOutbox -> error: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,360 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Cache strategies of PersonService, see CacheOptions
+const (
+	WriteThrough = "write-through"
+	WriteAround  = "write-around"
+	WriteBehind  = "write-behind"
+)
+
+// Defaults of write-behind strategy
+const (
+	defaultFlushInterval = time.Second
+	defaultFlushBatch    = 100
+	defaultMaxQueued     = 10000
+	// maxFlushBackoff bounds the pause after flushes that failed one after another
+	maxFlushBackoff = time.Minute
+)
+
+// CacheOptions configures how PersonService uses the person cache.
+//
+// Strategy tells what a write does with the cache:
+//   - write-around (default) commits the change and deletes the cached person, the next read loads it from the database.
+//     Reads never see a person older than the last commit made by this replica.
+//   - write-through commits the change and caches the written person (or caches a deleted person as missing), so the next read
+//     is a hit. Two replicas writing the same person at once may leave the cache with the older of the two values until
+//     the outbox relay invalidates the entry.
+//   - write-behind caches the change and queues it, the call returns before the database is written. Queued changes are
+//     persisted in background in batches of FlushBatch every FlushInterval and on Flush. Reads of this replica see queued
+//     changes at once, other replicas see them through the cache, GetAll sees them only after they are persisted.
+//     A change the database rejects for good (duplicate or missing person) is logged as a dead letter and dropped with
+//     its cache entry. Other failures, like a lost connection, keep the change queued and flushes are retried after
+//     a pause that doubles up to a minute. At most MaxQueued changes wait in the queue, a write waits for room while
+//     it is full. Changes still queued when the process is killed are lost.
+//
+// A cached person is reloaded in background with a probability that grows as its entry gets closer than EarlyRefresh
+// to expiration, 0 turns early refresh off
+type CacheOptions struct {
+	Strategy      string
+	EarlyRefresh  time.Duration
+	FlushInterval time.Duration
+	FlushBatch    int
+	MaxQueued     int
+}
+
+// CheckCacheStrategy returns error if the strategy is unknown, empty strategy means write-around
+func CheckCacheStrategy(strategy string) error {
+	switch strategy {
+	case "", WriteAround, WriteThrough, WriteBehind:
+		return nil
+	}
+	return fmt.Errorf("unknown cache strategy %q, use %s, %s or %s", strategy, WriteAround, WriteThrough, WriteBehind)
+}
+
+// cacheWritten updates cached person after the change is committed or queued, pers is nil if the person was deleted.
+// A failure is only logged, the entry is deleted instead and the relay invalidates it later anyway
+func (srv *PersonService) cacheWritten(ctx context.Context, id uuid.UUID, pers *model.Person) {
+	if srv.cacheOpts.Strategy != WriteThrough && srv.cacheOpts.Strategy != WriteBehind {
+		srv.invalidate(ctx, id)
+		return
+	}
+	var err error
+	if pers == nil {
+		err = srv.persRdsRps.SetMissing(ctx, id)
+	} else {
+		err = srv.persRdsRps.Set(ctx, pers)
+	}
+	if err != nil {
+		logrus.WithField("ID", id).Errorf("PersonService -> cacheWritten -> error: %v", err)
+		srv.invalidate(ctx, id)
+	}
+}
+
+// invalidate deletes cached person right after the commit, so reads don't wait for the outbox relay.
+// The change is already committed, so a failure is only logged and the relay invalidates the entry later
+func (srv *PersonService) invalidate(ctx context.Context, id uuid.UUID) {
+	err := srv.persRdsRps.Delete(ctx, id)
+	if err != nil && err.Error() != redis.Nil.Error() {
+		logrus.WithField("ID", id).Errorf("PersonService -> invalidate -> persRdsRps.Delete -> error: %v", err)
+	}
+}
+
+// pendingWrite is a change queued by write-behind strategy, pers is nil for deletion
+type pendingWrite struct {
+	seq       uint64
+	tenantID  uuid.UUID
+	eventType string
+	id        uuid.UUID
+	pers      *model.Person
+}
+
+// writeBehind keeps changes that aren't persisted yet in the order they were made
+type writeBehind struct {
+	mu     sync.Mutex
+	queue  []*pendingWrite
+	latest map[string]*pendingWrite
+	seq    uint64
+	full   chan struct{}
+	// drained is closed and replaced when changes are taken from the queue, writers wait on it while the queue is full
+	drained chan struct{}
+	flushMu sync.Mutex
+}
+
+func newWriteBehind() *writeBehind {
+	return &writeBehind{latest: make(map[string]*pendingWrite), full: make(chan struct{}, 1), drained: make(chan struct{})}
+}
+
+// pending returns the latest queued change of the person, ok is false if the person has no queued changes
+func (wb *writeBehind) pending(ctx context.Context, id uuid.UUID) (pers *model.Person, ok bool, err error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	write, ok := wb.latest[tenantID.String()+":"+id.String()]
+	if !ok {
+		return nil, false, nil
+	}
+	return write.pers.Copy(), true, nil
+}
+
+// take removes up to limit oldest changes from the queue
+func (wb *writeBehind) take(limit int) []*pendingWrite {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if limit > len(wb.queue) {
+		limit = len(wb.queue)
+	}
+	batch := wb.queue[:limit:limit]
+	wb.queue = wb.queue[limit:]
+	if limit > 0 {
+		close(wb.drained)
+		wb.drained = make(chan struct{})
+	}
+	return batch
+}
+
+// requeue puts the batch that wasn't persisted back to the head of the queue
+func (wb *writeBehind) requeue(batch []*pendingWrite) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.queue = append(batch, wb.queue...)
+}
+
+// done forgets the persisted change unless the person was changed again since
+func (wb *writeBehind) done(write *pendingWrite) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	key := write.tenantID.String() + ":" + write.id.String()
+	if latest, ok := wb.latest[key]; ok && latest.seq == write.seq {
+		delete(wb.latest, key)
+	}
+}
+
+// enqueue caches the change and queues it to be persisted, while the queue is full it waits for a flush or until ctx is done
+func (srv *PersonService) enqueue(ctx context.Context, eventType string, id uuid.UUID, pers *model.Person) error {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	wb := srv.behind
+	wb.mu.Lock()
+	for len(wb.queue) >= srv.maxQueued() {
+		drained := wb.drained
+		wb.mu.Unlock()
+		srv.signalFull()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			return fmt.Errorf("write-behind queue is full: %w", ctx.Err())
+		}
+		wb.mu.Lock()
+	}
+	wb.seq++
+	write := &pendingWrite{seq: wb.seq, tenantID: tenantID, eventType: eventType, id: id, pers: pers.Copy()}
+	wb.queue = append(wb.queue, write)
+	wb.latest[tenantID.String()+":"+id.String()] = write
+	queued := len(wb.queue)
+	wb.mu.Unlock()
+	srv.cacheWritten(ctx, id, pers)
+	if queued >= srv.flushBatch() {
+		srv.signalFull()
+	}
+	return nil
+}
+
+// signalFull wakes Run up to flush a full batch at once
+func (srv *PersonService) signalFull() {
+	select {
+	case srv.behind.full <- struct{}{}:
+	default:
+	}
+}
+
+// Run persists changes queued by write-behind strategy every FlushInterval or as soon as a batch is full until ctx is canceled,
+// it returns at once with other strategies. After a failed flush it pauses, doubling the pause while flushes keep failing.
+// Changes left in the queue are persisted by Flush
+func (srv *PersonService) Run(ctx context.Context) {
+	if srv.behind == nil {
+		return
+	}
+	interval := srv.cacheOpts.FlushInterval
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var backoff time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-srv.behind.full:
+		}
+		err := srv.Flush(ctx)
+		if err == nil {
+			backoff = 0
+			continue
+		}
+		backoff = nextFlushBackoff(backoff, interval)
+		logrus.Errorf("PersonService -> Run -> Flush -> error: %v, retrying in %v", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// nextFlushBackoff returns the pause after one more failed flush, it starts at the flush interval
+func nextFlushBackoff(backoff, interval time.Duration) time.Duration {
+	if backoff <= 0 {
+		return interval
+	}
+	if backoff *= 2; backoff > maxFlushBackoff {
+		return maxFlushBackoff
+	}
+	return backoff
+}
+
+// Flush persists all changes queued by write-behind strategy, it must be called on shutdown so no change is lost
+func (srv *PersonService) Flush(ctx context.Context) error {
+	if srv.behind == nil {
+		return nil
+	}
+	srv.behind.flushMu.Lock()
+	defer srv.behind.flushMu.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("PersonService -> Flush -> error: %w", err)
+		}
+		batch := srv.behind.take(srv.flushBatch())
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := srv.persist(ctx, batch); err != nil {
+			return fmt.Errorf("PersonService -> Flush -> error: %w", err)
+		}
+	}
+}
+
+// maxQueued returns how many changes may wait in the queue
+func (srv *PersonService) maxQueued() int {
+	if srv.cacheOpts.MaxQueued <= 0 {
+		return defaultMaxQueued
+	}
+	return srv.cacheOpts.MaxQueued
+}
+
+// flushBatch returns how many queued changes are persisted in one transaction
+func (srv *PersonService) flushBatch() int {
+	if srv.cacheOpts.FlushBatch <= 0 {
+		return defaultFlushBatch
+	}
+	return srv.cacheOpts.FlushBatch
+}
+
+// persist writes the batch in one transaction. If the transaction fails, changes are written one by one, so only
+// the ones the database rejects for good are dropped. On any other failure the rest of the batch is queued again
+// in its order and the error is returned, the change is retried by a later flush
+func (srv *PersonService) persist(ctx context.Context, batch []*pendingWrite) error {
+	err := srv.tx.WithTx(ctx, func(ctx context.Context) error {
+		for _, write := range batch {
+			if err := srv.apply(ctx, write); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		for _, write := range batch {
+			srv.behind.done(write)
+		}
+		return nil
+	}
+	if ctx.Err() != nil {
+		srv.behind.requeue(batch)
+		return ctx.Err()
+	}
+	for i, write := range batch {
+		err = srv.tx.WithTx(ctx, func(ctx context.Context) error {
+			return srv.apply(ctx, write)
+		})
+		if err != nil && (ctx.Err() != nil || !rejected(err)) {
+			srv.behind.requeue(batch[i:])
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		srv.behind.done(write)
+		if err != nil {
+			srv.deadLetter(ctx, write, err)
+		}
+	}
+	return nil
+}
+
+// rejected tells if the database rejected the change for good, so writing it again can't succeed
+func rejected(err error) bool {
+	return errors.Is(err, model.ErrExist) || errors.Is(err, model.ErrNotFound)
+}
+
+// deadLetter logs the dropped change with everything needed to apply it by hand and deletes its cache entry,
+// which shows the change that was never persisted
+func (srv *PersonService) deadLetter(ctx context.Context, write *pendingWrite, err error) {
+	logrus.WithFields(logrus.Fields{"ID": write.id, "TenantID": write.tenantID, "Type": write.eventType, "Person": write.pers}).
+		Errorf("PersonService -> persist -> write-behind dead letter: %v", err)
+	srv.invalidate(identity.WithTenant(ctx, write.tenantID), write.id)
+}
+
+// apply writes the queued change with the tenant it was made by, it must be called inside of tx.WithTx
+func (srv *PersonService) apply(ctx context.Context, write *pendingWrite) error {
+	ctx = identity.WithTenant(ctx, write.tenantID)
+	switch write.eventType {
+	case events.PersonCreated:
+		return srv.create(ctx, write.pers.Copy())
+	case events.PersonUpdated:
+		return srv.update(ctx, write.pers.Copy())
+	default:
+		return srv.delete(ctx, write.id)
+	}
+}
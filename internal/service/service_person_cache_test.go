@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/repository"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/ory/dockertest"
+	"github.com/stretchr/testify/require"
+)
+
+func (r *fakePersonRepository) Create(_ context.Context, pers *model.Person) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failWrites != nil {
+		return r.failWrites
+	}
+	if _, ok := r.persons[pers.ID]; ok {
+		return fmt.Errorf("duplicate key: %w", model.ErrExist)
+	}
+	r.persons[pers.ID] = *pers
+	return nil
+}
+
+func (r *fakePersonRepository) Update(_ context.Context, pers *model.Person) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failWrites != nil {
+		return r.failWrites
+	}
+	if _, ok := r.persons[pers.ID]; !ok {
+		return model.ErrNotFound
+	}
+	r.persons[pers.ID] = *pers
+	return nil
+}
+
+func (r *fakePersonRepository) Delete(_ context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failWrites != nil {
+		return r.failWrites
+	}
+	if _, ok := r.persons[id]; !ok {
+		return model.ErrNotFound
+	}
+	delete(r.persons, id)
+	return nil
+}
+
+func (r *fakePersonRepository) stored(id uuid.UUID) (model.Person, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pers, ok := r.persons[id]
+	return pers, ok
+}
+
+// fakeTx restores persons and the outbox of the fakes if fn fails, like a rolled back transaction
+type fakeTx struct {
+	repo   *fakePersonRepository
+	outbox *fakeOutbox
+	txs    int
+}
+
+func (tx *fakeTx) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx.txs++
+	tx.repo.mu.Lock()
+	persons := make(map[uuid.UUID]model.Person, len(tx.repo.persons))
+	for id, pers := range tx.repo.persons {
+		persons[id] = pers
+	}
+	tx.repo.mu.Unlock()
+	outboxLen := len(tx.outbox.events)
+	if err := fn(ctx); err != nil {
+		tx.repo.mu.Lock()
+		tx.repo.persons = persons
+		tx.repo.mu.Unlock()
+		tx.outbox.events = tx.outbox.events[:outboxLen]
+		return err
+	}
+	return nil
+}
+
+// fakeOutbox records types of the written events
+type fakeOutbox struct {
+	events []string
+}
+
+func (o *fakeOutbox) AddToOutbox(_ context.Context, event *model.OutboxEvent) error {
+	o.events = append(o.events, event.Type)
+	return nil
+}
+
+var (
+	redisOnce    sync.Once
+	redisClient  *redis.Client
+	redisCleanup func()
+	errNoRedis   error
+)
+
+// testRedis starts redis for the tests of the package once, tests that need it are skipped where docker isn't available
+func testRedis(t *testing.T) *redis.Client {
+	redisOnce.Do(func() {
+		pool, err := dockertest.NewPool("")
+		if err == nil {
+			err = pool.Client.Ping()
+		}
+		if err != nil {
+			errNoRedis = fmt.Errorf("docker isn't available: %w", err)
+			return
+		}
+		resource, err := pool.Run("redis", "latest", nil)
+		if err != nil {
+			errNoRedis = fmt.Errorf("could not start redis: %w", err)
+			return
+		}
+		redisCleanup = func() { _ = pool.Purge(resource) }
+		redisClient = redis.NewClient(&redis.Options{Addr: "localhost:" + resource.GetPort("6379/tcp")})
+		if err = pool.Retry(func() error { return redisClient.Ping(context.Background()).Err() }); err != nil {
+			errNoRedis = fmt.Errorf("redis isn't ready: %w", err)
+		}
+	})
+	if errNoRedis != nil {
+		t.Skip(errNoRedis)
+	}
+	return redisClient
+}
+
+func TestMain(m *testing.M) {
+	exitVal := m.Run()
+	if redisCleanup != nil {
+		redisCleanup()
+	}
+	os.Exit(exitVal)
+}
+
+// testCaches returns constructors of the caches every strategy is tested against
+func testCaches() map[string]func(t *testing.T) PersonRedisRepository {
+	return map[string]func(t *testing.T) PersonRedisRepository{
+		"inProcess": func(t *testing.T) PersonRedisRepository {
+			return repository.NewLRU(newFakePersonCache(), nil, 100, time.Minute)
+		},
+		"redis": func(t *testing.T) PersonRedisRepository {
+			return repository.NewRepositoryRedis(testRedis(t), time.Minute, 0, time.Minute, 0)
+		},
+	}
+}
+
+// newStrategyService returns PersonService with the strategy over the cache and one stored person
+func newStrategyService(cache PersonRedisRepository, opts CacheOptions) (*PersonService, *fakePersonRepository, *fakeTx, model.Person) {
+	pers := model.Person{ID: uuid.New(), Salary: 300, Married: true, Profession: "baker"}
+	repo := &fakePersonRepository{persons: map[uuid.UUID]model.Person{pers.ID: pers}}
+	tx := &fakeTx{repo: repo, outbox: &fakeOutbox{}}
+	return NewPersonService(repo, cache, tx, tx.outbox, opts), repo, tx, pers
+}
+
+// requireCached checks what the cache keeps for the person, nil want means the person is cached as missing
+func requireCached(t *testing.T, cache PersonRedisRepository, id uuid.UUID, want *model.Person) {
+	cached, _, err := cache.Get(testCtx, id)
+	require.NoError(t, err)
+	require.Equal(t, want, cached)
+}
+
+func TestWriteAround(t *testing.T) {
+	for name, newCache := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(t)
+			srv, repo, _, pers := newStrategyService(cache, CacheOptions{Strategy: WriteAround})
+			_, err := srv.ReadRow(testCtx, pers.ID)
+			require.NoError(t, err)
+
+			pers.Salary = 500
+			require.NoError(t, srv.Update(testCtx, &pers))
+			_, _, err = cache.Get(testCtx, pers.ID)
+			require.True(t, errors.Is(err, redis.Nil))
+			readPers, err := srv.ReadRow(testCtx, pers.ID)
+			require.NoError(t, err)
+			require.Equal(t, pers, *readPers)
+			require.Equal(t, int64(2), repo.reads.Load())
+
+			require.NoError(t, srv.Delete(testCtx, pers.ID))
+			_, err = srv.ReadRow(testCtx, pers.ID)
+			require.True(t, errors.Is(err, ErrPersonNotFound))
+		})
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	for name, newCache := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(t)
+			srv, repo, _, pers := newStrategyService(cache, CacheOptions{Strategy: WriteThrough})
+			created := model.Person{ID: uuid.New(), Salary: 100, Profession: "driver"}
+			require.NoError(t, srv.Create(testCtx, &created))
+			requireCached(t, cache, created.ID, &created)
+
+			pers.Salary = 500
+			require.NoError(t, srv.Update(testCtx, &pers))
+			requireCached(t, cache, pers.ID, &pers)
+			readPers, err := srv.ReadRow(testCtx, pers.ID)
+			require.NoError(t, err)
+			require.Equal(t, pers, *readPers)
+
+			require.NoError(t, srv.Delete(testCtx, pers.ID))
+			requireCached(t, cache, pers.ID, nil)
+			_, err = srv.ReadRow(testCtx, pers.ID)
+			require.True(t, errors.Is(err, ErrPersonNotFound))
+			require.Zero(t, repo.reads.Load())
+		})
+	}
+}
+
+func TestWriteThroughFailedWriteKeepsCache(t *testing.T) {
+	for name, newCache := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(t)
+			srv, _, _, pers := newStrategyService(cache, CacheOptions{Strategy: WriteThrough})
+			_, err := srv.ReadRow(testCtx, pers.ID)
+			require.NoError(t, err)
+			missing := model.Person{ID: uuid.New()}
+			require.Error(t, srv.Update(testCtx, &missing))
+			_, _, err = cache.Get(testCtx, missing.ID)
+			require.True(t, errors.Is(err, redis.Nil))
+			requireCached(t, cache, pers.ID, &pers)
+		})
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	for name, newCache := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(t)
+			srv, repo, tx, pers := newStrategyService(cache, CacheOptions{Strategy: WriteBehind, FlushBatch: 10})
+			created := model.Person{ID: uuid.New(), Salary: 100, Profession: "driver"}
+			require.NoError(t, srv.Create(testCtx, &created))
+			pers.Salary = 500
+			require.NoError(t, srv.Update(testCtx, &pers))
+			require.NoError(t, srv.Delete(testCtx, created.ID))
+
+			stored, _ := repo.stored(pers.ID)
+			require.Equal(t, 300, int(stored.Salary))
+			readPers, err := srv.ReadRow(testCtx, pers.ID)
+			require.NoError(t, err)
+			require.Equal(t, pers, *readPers)
+			_, err = srv.ReadRow(testCtx, created.ID)
+			require.True(t, errors.Is(err, ErrPersonNotFound))
+			require.Zero(t, tx.txs)
+
+			require.NoError(t, srv.Flush(testCtx))
+			require.Equal(t, 1, tx.txs)
+			stored, _ = repo.stored(pers.ID)
+			require.Equal(t, pers, stored)
+			_, ok := repo.stored(created.ID)
+			require.False(t, ok)
+			require.Equal(t, []string{"PersonCreated", "PersonUpdated", "PersonDeleted"}, tx.outbox.events)
+			requireCached(t, cache, pers.ID, &pers)
+			require.Zero(t, repo.reads.Load())
+		})
+	}
+}
+
+func TestWriteBehindDropsRejectedChange(t *testing.T) {
+	for name, newCache := range testCaches() {
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(t)
+			srv, repo, tx, pers := newStrategyService(cache, CacheOptions{Strategy: WriteBehind})
+			missing := model.Person{ID: uuid.New(), Salary: 1}
+			require.NoError(t, srv.Update(testCtx, &missing))
+			pers.Salary = 500
+			require.NoError(t, srv.Update(testCtx, &pers))
+
+			require.NoError(t, srv.Flush(testCtx))
+			stored, _ := repo.stored(pers.ID)
+			require.Equal(t, pers, stored)
+			require.Equal(t, []string{"PersonUpdated"}, tx.outbox.events)
+			_, _, err := cache.Get(testCtx, missing.ID)
+			require.True(t, errors.Is(err, redis.Nil))
+			_, err = srv.ReadRow(testCtx, missing.ID)
+			require.True(t, errors.Is(err, ErrPersonNotFound))
+		})
+	}
+}
+
+func TestWriteBehindRunFlushesFullBatch(t *testing.T) {
+	srv, repo, _, _ := newStrategyService(newFakePersonCache(), CacheOptions{Strategy: WriteBehind, FlushBatch: 2, FlushInterval: time.Hour})
+	ctx, cancel := context.WithCancel(testCtx)
+	defer cancel()
+	go srv.Run(ctx)
+	first, second := model.Person{ID: uuid.New()}, model.Person{ID: uuid.New()}
+	require.NoError(t, srv.Create(testCtx, &first))
+	require.NoError(t, srv.Create(testCtx, &second))
+	require.Eventually(t, func() bool {
+		_, ok := repo.stored(second.ID)
+		return ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestWriteBehindFlushCanceledKeepsQueue(t *testing.T) {
+	srv, repo, _, _ := newStrategyService(newFakePersonCache(), CacheOptions{Strategy: WriteBehind})
+	created := model.Person{ID: uuid.New()}
+	require.NoError(t, srv.Create(testCtx, &created))
+	ctx, cancel := context.WithCancel(testCtx)
+	cancel()
+	require.True(t, errors.Is(srv.Flush(ctx), context.Canceled))
+	require.NoError(t, srv.Flush(testCtx))
+	_, ok := repo.stored(created.ID)
+	require.True(t, ok)
+}
+
+func TestWriteBehindKeepsChangesWhenDatabaseIsDown(t *testing.T) {
+	srv, repo, _, pers := newStrategyService(newFakePersonCache(), CacheOptions{Strategy: WriteBehind})
+	created := model.Person{ID: uuid.New(), Salary: 100}
+	require.NoError(t, srv.Create(testCtx, &created))
+	pers.Salary = 500
+	require.NoError(t, srv.Update(testCtx, &pers))
+	repo.mu.Lock()
+	repo.failWrites = fmt.Errorf("connection refused")
+	repo.mu.Unlock()
+	require.Error(t, srv.Flush(testCtx))
+	readPers, err := srv.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, pers, *readPers, "a change that failed for a while must stay queued")
+
+	repo.mu.Lock()
+	repo.failWrites = nil
+	repo.mu.Unlock()
+	require.NoError(t, srv.Flush(testCtx))
+	_, ok := repo.stored(created.ID)
+	require.True(t, ok)
+	stored, _ := repo.stored(pers.ID)
+	require.Equal(t, pers, stored)
+}
+
+func TestWriteBehindFullQueueWaits(t *testing.T) {
+	srv, repo, _, _ := newStrategyService(newFakePersonCache(), CacheOptions{Strategy: WriteBehind, MaxQueued: 1})
+	first, second := model.Person{ID: uuid.New()}, model.Person{ID: uuid.New()}
+	require.NoError(t, srv.Create(testCtx, &first))
+	ctx, cancel := context.WithTimeout(testCtx, 10*time.Millisecond)
+	defer cancel()
+	require.True(t, errors.Is(srv.Create(ctx, &second), context.DeadlineExceeded))
+
+	created := make(chan error)
+	go func() { created <- srv.Create(testCtx, &second) }()
+	require.NoError(t, srv.Flush(testCtx))
+	require.NoError(t, <-created)
+	require.NoError(t, srv.Flush(testCtx))
+	_, ok := repo.stored(second.ID)
+	require.True(t, ok)
+}
+
+func TestNextFlushBackoff(t *testing.T) {
+	require.Equal(t, time.Second, nextFlushBackoff(0, time.Second))
+	require.Equal(t, 4*time.Second, nextFlushBackoff(2*time.Second, time.Second))
+	require.Equal(t, maxFlushBackoff, nextFlushBackoff(maxFlushBackoff, time.Second))
+}
+
+func TestCheckCacheStrategy(t *testing.T) {
+	for _, strategy := range []string{"", WriteAround, WriteThrough, WriteBehind} {
+		require.NoError(t, CheckCacheStrategy(strategy))
+	}
+	require.Error(t, CheckCacheStrategy("write-sideways"))
+}
+
+func TestModify(t *testing.T) {
+	cache := newFakePersonCache()
+	srv, repo, tx, pers := newStrategyService(cache, CacheOptions{Strategy: WriteThrough})
+	changed, err := srv.Modify(testCtx, pers.ID, func(pers *model.Person) bool {
+		pers.Salary = 900
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 900, changed.Salary)
+	stored, _ := repo.stored(pers.ID)
+	require.Equal(t, *changed, stored)
+	requireCached(t, cache, pers.ID, changed)
+
+	_, err = srv.Modify(testCtx, pers.ID, func(*model.Person) bool { return false })
+	require.NoError(t, err)
+	require.Equal(t, []string{"PersonUpdated"}, tx.outbox.events, "an unchanged person isn't written")
+	_, err = srv.Modify(testCtx, uuid.New(), func(*model.Person) bool { return true })
+	require.True(t, errors.Is(err, ErrPersonNotFound))
+}
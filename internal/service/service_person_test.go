@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+var testCtx = identity.WithTenant(context.Background(), uuid.New())
+
+// fakePersonRepository keeps persons in memory, counts reads and makes every read take delay like a database would
+type fakePersonRepository struct {
+	PersonRepository
+	mu      sync.Mutex
+	persons map[uuid.UUID]model.Person
+	delay   time.Duration
+	reads   atomic.Int64
+	// failWrites is returned by writes while it is set, like by a database that is down
+	failWrites error
+}
+
+func (r *fakePersonRepository) ReadRow(_ context.Context, id uuid.UUID) (*model.Person, error) {
+	r.reads.Add(1)
+	time.Sleep(r.delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pers, ok := r.persons[id]
+	if !ok {
+		return nil, fmt.Errorf("Pgx -> ReadRow -> error: %w", model.ErrNotFound)
+	}
+	return &pers, nil
+}
+
+func (r *fakePersonRepository) ReadRowForUpdate(_ context.Context, id uuid.UUID) (*model.Person, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pers, ok := r.persons[id]
+	if !ok {
+		return nil, fmt.Errorf("Pgx -> ReadRowForUpdate -> error: %w", model.ErrNotFound)
+	}
+	return &pers, nil
+}
+
+// fakeCacheEntry is a cached person, nil person means that the person is cached as missing
+type fakeCacheEntry struct {
+	pers      *model.Person
+	expiresAt time.Time
+}
+
+// fakePersonCache keeps cache entries in memory, entries don't expire unless ttl is set
+type fakePersonCache struct {
+	mu       sync.Mutex
+	entries  map[uuid.UUID]fakeCacheEntry
+	versions map[uuid.UUID]int64
+	ttl      time.Duration
+	failSet  bool
+}
+
+func newFakePersonCache() *fakePersonCache {
+	return &fakePersonCache{entries: map[uuid.UUID]fakeCacheEntry{}, versions: map[uuid.UUID]int64{}, ttl: time.Hour}
+}
+
+func (c *fakePersonCache) Set(_ context.Context, pers *model.Person) error {
+	if c.failSet {
+		return fmt.Errorf("redis is down")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[pers.ID]++
+	c.entries[pers.ID] = fakeCacheEntry{pers: pers, expiresAt: time.Now().Add(c.ttl)}
+	return nil
+}
+
+func (c *fakePersonCache) SetMissing(_ context.Context, id uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[id]++
+	c.entries[id] = fakeCacheEntry{expiresAt: time.Now().Add(c.ttl)}
+	return nil
+}
+
+func (c *fakePersonCache) Version(_ context.Context, id uuid.UUID) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.versions[id], nil
+}
+
+func (c *fakePersonCache) Fill(_ context.Context, id uuid.UUID, pers *model.Person, version int64) error {
+	if c.failSet {
+		return fmt.Errorf("redis is down")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.versions[id] != version {
+		return nil
+	}
+	c.entries[id] = fakeCacheEntry{pers: pers, expiresAt: time.Now().Add(c.ttl)}
+	return nil
+}
+
+func (c *fakePersonCache) Get(_ context.Context, id uuid.UUID) (*model.Person, time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, 0, redis.Nil
+	}
+	return entry.pers, time.Until(entry.expiresAt), nil
+}
+
+func (c *fakePersonCache) Delete(_ context.Context, id uuid.UUID) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[id]++
+	delete(c.entries, id)
+	return nil
+}
+
+func newTestPersonService(earlyRefresh time.Duration) (*PersonService, *fakePersonRepository, *fakePersonCache, model.Person) {
+	pers := model.Person{ID: uuid.New(), Salary: 300, Married: true, Profession: "baker"}
+	repo := &fakePersonRepository{persons: map[uuid.UUID]model.Person{pers.ID: pers}, delay: 5 * time.Millisecond}
+	cache := newFakePersonCache()
+	return NewPersonService(repo, cache, nil, nil, CacheOptions{EarlyRefresh: earlyRefresh}), repo, cache, pers
+}
+
+// readConcurrently calls read from the given number of goroutines at once and returns the first error
+func readConcurrently(readers int, read func() error) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	start := make(chan struct{})
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- read()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestReadRowCoalescesLoads(t *testing.T) {
+	srv, repo, _, pers := newTestPersonService(0)
+	err := readConcurrently(50, func() error {
+		readPers, err := srv.ReadRow(testCtx, pers.ID)
+		if err == nil && *readPers != pers {
+			return fmt.Errorf("read %v instead of %v", *readPers, pers)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), repo.reads.Load())
+	_, err = srv.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), repo.reads.Load())
+}
+
+func TestReadRowCachesMissing(t *testing.T) {
+	srv, repo, _, _ := newTestPersonService(0)
+	id := uuid.New()
+	for i := 0; i < 3; i++ {
+		_, err := srv.ReadRow(testCtx, id)
+		require.True(t, errors.Is(err, ErrPersonNotFound))
+	}
+	require.Equal(t, int64(1), repo.reads.Load())
+}
+
+func TestReadRowReturnsPersonWhenCacheFails(t *testing.T) {
+	srv, _, cache, pers := newTestPersonService(0)
+	cache.failSet = true
+	readPers, err := srv.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, pers, *readPers)
+}
+
+// racingRepository commits a write right after the person is read, before the read person is cached
+type racingRepository struct {
+	*fakePersonRepository
+	write func()
+}
+
+func (r *racingRepository) ReadRow(ctx context.Context, id uuid.UUID) (*model.Person, error) {
+	pers, err := r.fakePersonRepository.ReadRow(ctx, id)
+	r.write()
+	return pers, err
+}
+
+func TestReadRowLoadDoesntCacheReplacedPerson(t *testing.T) {
+	_, repo, cache, pers := newTestPersonService(0)
+	updated := pers
+	updated.Salary = 900
+	racing := &racingRepository{fakePersonRepository: repo, write: func() {
+		repo.mu.Lock()
+		repo.persons[pers.ID] = updated
+		repo.mu.Unlock()
+		require.NoError(t, cache.Delete(testCtx, pers.ID))
+	}}
+	srv := NewPersonService(racing, cache, nil, nil, CacheOptions{})
+	readPers, err := srv.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	require.Equal(t, pers, *readPers)
+	_, _, err = cache.Get(testCtx, pers.ID)
+	require.Equal(t, redis.Nil, err, "the load that raced the write must not cache the replaced person")
+}
+
+func TestReadRowCanceledReaderDoesntCancelLoad(t *testing.T) {
+	srv, repo, cache, pers := newTestPersonService(0)
+	ctx, cancel := context.WithTimeout(testCtx, time.Millisecond)
+	defer cancel()
+	_, err := srv.ReadRow(ctx, pers.ID)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Eventually(t, func() bool {
+		_, _, err := cache.Get(testCtx, pers.ID)
+		return err == nil
+	}, time.Second, time.Millisecond)
+	require.Equal(t, int64(1), repo.reads.Load())
+}
+
+func TestReadRowRefreshesEarly(t *testing.T) {
+	srv, repo, cache, pers := newTestPersonService(time.Hour)
+	cache.ttl = time.Millisecond * 100
+	_, err := srv.ReadRow(testCtx, pers.ID)
+	require.NoError(t, err)
+	// with the early refresh window much longer than TTL the entry is almost surely refreshed by one of the reads
+	for i := 0; i < 10; i++ {
+		_, err = srv.ReadRow(testCtx, pers.ID)
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return repo.reads.Load() > 1 }, time.Second, time.Millisecond)
+}
+
+func TestRefreshEarly(t *testing.T) {
+	require.False(t, NewPersonService(nil, nil, nil, nil, CacheOptions{}).refreshEarly(time.Millisecond))
+	srv := NewPersonService(nil, nil, nil, nil, CacheOptions{EarlyRefresh: time.Second})
+	require.False(t, srv.refreshEarly(0))
+	// -ln of a float64 from [0, 1) doesn't exceed 37 unless it is 0, so entries further than that from expiration are never refreshed
+	for i := 0; i < 1000; i++ {
+		require.False(t, srv.refreshEarly(time.Minute))
+		require.True(t, srv.refreshEarly(time.Nanosecond))
+	}
+}
+
+// readRowWithoutCoalescing is how ReadRow worked before request coalescing and negative caching, benchmarks use it as the baseline
+func readRowWithoutCoalescing(ctx context.Context, srv *PersonService, id uuid.UUID) (*model.Person, error) {
+	pers, _, err := srv.persRdsRps.Get(ctx, id)
+	if err == nil {
+		return pers, nil
+	}
+	pers, err = srv.persRps.ReadRow(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return pers, srv.persRdsRps.Set(ctx, pers)
+}
+
+// BenchmarkReadRowHotKeyExpired measures database reads when 100 readers ask for a person whose entry has just expired
+func BenchmarkReadRowHotKeyExpired(b *testing.B) {
+	benchmarks := map[string]func(srv *PersonService, id uuid.UUID) error{
+		"baseline": func(srv *PersonService, id uuid.UUID) error {
+			_, err := readRowWithoutCoalescing(testCtx, srv, id)
+			return err
+		},
+		"coalesced": func(srv *PersonService, id uuid.UUID) error {
+			_, err := srv.ReadRow(testCtx, id)
+			return err
+		},
+	}
+	for name, read := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			srv, repo, cache, pers := newTestPersonService(0)
+			repo.delay = time.Millisecond
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, cache.Delete(testCtx, pers.ID))
+				require.NoError(b, readConcurrently(100, func() error { return read(srv, pers.ID) }))
+			}
+			b.ReportMetric(float64(repo.reads.Load())/float64(b.N), "db-reads/op")
+		})
+	}
+}
+
+// BenchmarkReadRowMissing measures database reads when 100 readers in a row ask for a person that doesn't exist
+func BenchmarkReadRowMissing(b *testing.B) {
+	benchmarks := map[string]func(srv *PersonService, id uuid.UUID){
+		"baseline": func(srv *PersonService, id uuid.UUID) {
+			_, _ = readRowWithoutCoalescing(testCtx, srv, id)
+		},
+		"negativeCache": func(srv *PersonService, id uuid.UUID) {
+			_, _ = srv.ReadRow(testCtx, id)
+		},
+	}
+	for name, read := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			srv, repo, _, _ := newTestPersonService(0)
+			repo.delay = 0
+			for i := 0; i < b.N; i++ {
+				id := uuid.New()
+				for j := 0; j < 100; j++ {
+					read(srv, id)
+				}
+			}
+			b.ReportMetric(float64(repo.reads.Load())/float64(b.N), "db-reads/op")
+		})
+	}
+}
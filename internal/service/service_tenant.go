@@ -0,0 +1,89 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+)
+
+// ErrTenantNotFound means that no tenant is registered with the given id
+var ErrTenantNotFound = fmt.Errorf("tenant not found")
+
+// TenantRepository is an interface that contains methods for provisioning and deletion of tenants
+type TenantRepository interface {
+	CreateTenant(ctx context.Context, tenant *model.Tenant) error
+	DeleteTenant(ctx context.Context, id uuid.UUID) error
+}
+
+// TenantRedisRepository is an interface that contains redis methods for tenants
+type TenantRedisRepository interface {
+	DeleteTenant(ctx context.Context, tenantID uuid.UUID) error
+}
+
+// TenantService contains TenantRepository and TenantRedisRepository interfaces and BlobStore with files of tenants
+type TenantService struct {
+	tenantRps    TenantRepository
+	tenantRdsRps TenantRedisRepository
+	store        BlobStore
+}
+
+// NewTenantService accepts TenantRepository and TenantRedisRepository objects and BlobStore that keeps images and
+// uploads of tenants and returnes an object of type *TenantService
+func NewTenantService(tenantRps TenantRepository, tenantRdsRps TenantRedisRepository, store BlobStore) *TenantService {
+	return &TenantService{tenantRps: tenantRps, tenantRdsRps: tenantRdsRps, store: store}
+}
+
+// Create is a method of TenantService that calls CreateTenant method of Repository
+func (srv *TenantService) Create(ctx context.Context, tenant *model.Tenant) error {
+	err := srv.tenantRps.CreateTenant(ctx, tenant)
+	if err != nil {
+		return fmt.Errorf("TenantService -> Create -> tenantRps.CreateTenant -> error: %w", err)
+	}
+	return nil
+}
+
+// Delete is a method of TenantService that deletes tenant with all its data, cache and files. The files are deleted
+// after the tenant, so no new file of the tenant can be stored meanwhile. Files are deleted also when the tenant is
+// already gone, so Delete retried after a failure removes the files left behind
+func (srv *TenantService) Delete(ctx context.Context, id uuid.UUID) error {
+	err := srv.tenantRps.DeleteTenant(ctx, id)
+	if errors.Is(err, model.ErrNotFound) {
+		if err := srv.deleteBlobs(ctx, id); err != nil {
+			return fmt.Errorf("TenantService -> Delete -> error: %w", err)
+		}
+		return fmt.Errorf("TenantService -> Delete -> tenantRps.DeleteTenant -> %w: %w", ErrTenantNotFound, err)
+	}
+	if err != nil {
+		return fmt.Errorf("TenantService -> Delete -> tenantRps.DeleteTenant -> error: %w", err)
+	}
+	err = srv.tenantRdsRps.DeleteTenant(ctx, id)
+	if err != nil {
+		return fmt.Errorf("TenantService -> Delete -> tenantRdsRps.DeleteTenant -> error: %w", err)
+	}
+	if err = srv.deleteBlobs(ctx, id); err != nil {
+		return fmt.Errorf("TenantService -> Delete -> error: %w", err)
+	}
+	return nil
+}
+
+// deleteBlobs deletes images, their contents and variants and uploads of the tenant from the store. Their metadata is
+// deleted with the tenant, so nothing would ever collect them
+func (srv *TenantService) deleteBlobs(ctx context.Context, id uuid.UUID) error {
+	tenant := id.String() + "/"
+	for _, prefix := range []string{imagePrefix + tenant, contentPrefix + tenant, variantPrefix + tenant, uploadPrefix + tenant} {
+		keys, err := srv.store.List(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("deleteBlobs -> store.List -> error: %w", err)
+		}
+		for _, key := range keys {
+			if err = srv.store.Delete(ctx, key); err != nil {
+				return fmt.Errorf("deleteBlobs -> store.Delete -> error: %w", err)
+			}
+		}
+	}
+	return nil
+}
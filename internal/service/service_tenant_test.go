@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTenantRepository keeps IDs of tenants in memory
+type fakeTenantRepository struct {
+	mu      sync.Mutex
+	tenants map[uuid.UUID]bool
+}
+
+func (r *fakeTenantRepository) CreateTenant(_ context.Context, tenant *model.Tenant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tenants[tenant.ID] = true
+	return nil
+}
+
+func (r *fakeTenantRepository) DeleteTenant(_ context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.tenants[id] {
+		return model.ErrNotFound
+	}
+	delete(r.tenants, id)
+	return nil
+}
+
+// fakeTenantCache has no cache of tenants to delete
+type fakeTenantCache struct{}
+
+func (fakeTenantCache) DeleteTenant(context.Context, uuid.UUID) error {
+	return nil
+}
+
+// storeTenantFiles uploads an image of the tenant, reads its variant and starts an upload, so the tenant has files
+// under every prefix
+func storeTenantFiles(t *testing.T, uploads *UploadService, tenantID uuid.UUID) {
+	ctx := identity.WithUser(identity.WithTenant(context.Background(), tenantID), uuid.New())
+	img, err := uploads.images.Upload(ctx, "andy.png", bytes.NewReader(encodeTestImage(t, 20, 20)))
+	require.NoError(t, err)
+	file, err := uploads.images.Open(ctx, img.ID, model.ImageVariant{Width: 4})
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+	_, err = uploads.Create(ctx, "andy.png", 100)
+	require.NoError(t, err)
+}
+
+// tenantFiles returns keys of the files of the tenant kept in the directory of the local store
+func tenantFiles(t *testing.T, dir string, tenantID uuid.UUID) []string {
+	var keys []string
+	for _, key := range storedFiles(t, dir) {
+		if strings.Contains(key, tenantID.String()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestDeleteTenantDeletesFiles(t *testing.T) {
+	uploads, _, dir := newTestUploadService(t)
+	deleted, kept := uuid.New(), uuid.New()
+	tenantRps := &fakeTenantRepository{tenants: map[uuid.UUID]bool{deleted: true, kept: true}}
+	srv := NewTenantService(tenantRps, fakeTenantCache{}, uploads.store)
+	storeTenantFiles(t, uploads, deleted)
+	storeTenantFiles(t, uploads, kept)
+	files := tenantFiles(t, dir, deleted)
+	for _, prefix := range []string{imagePrefix, contentPrefix, variantPrefix, uploadPrefix} {
+		require.Contains(t, strings.Join(files, " "), prefix+deleted.String()+"/")
+	}
+	keptFiles := tenantFiles(t, dir, kept)
+
+	require.NoError(t, srv.Delete(context.Background(), deleted))
+	require.Empty(t, tenantFiles(t, dir, deleted))
+	require.Equal(t, keptFiles, tenantFiles(t, dir, kept))
+
+	// a file left by an interrupted deletion is deleted when it is retried
+	require.NoError(t, uploads.store.Put(context.Background(), imagePrefix+deleted.String()+"/left", strings.NewReader("x"), 1))
+	err := srv.Delete(context.Background(), deleted)
+	require.True(t, errors.Is(err, ErrTenantNotFound), err)
+	require.Empty(t, tenantFiles(t, dir, deleted))
+}
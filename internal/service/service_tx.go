@@ -0,0 +1,11 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import "context"
+
+// TxManager is an interface that groups several repository calls into one atomic unit of work:
+// repository methods called with the ctx given to fn run in one transaction. A nested WithTx joins the outer
+// transaction on every database, and if the nested fn fails the whole transaction is rolled back
+type TxManager interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,478 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// uploadPrefix is the prefix of keys of upload sessions in the blob store, see uploadKey
+const uploadPrefix = "uploads/"
+
+// sessionLocks is the number of locks that changes of upload sessions are serialized with
+const sessionLocks = 64
+
+// defaultUploadTTL is how long an upload session is kept after its last chunk if no TTL is configured
+const defaultUploadTTL = 24 * time.Hour
+
+// defaultUploadMinChunk is the minimal size of a chunk but the last one if no minimal size is configured
+const defaultUploadMinChunk = 1 << 20
+
+// ErrUploadNotFound means that there is no upload session with the ID or it has expired
+var ErrUploadNotFound = fmt.Errorf("upload not found")
+
+// ErrUploadForbidden means that the upload session belongs to another user
+var ErrUploadForbidden = fmt.Errorf("upload belongs to another user")
+
+// ErrUploadLength means that the length of the upload isn't positive
+var ErrUploadLength = fmt.Errorf("upload length must be positive")
+
+// ErrUploadOffset means that the chunk doesn't start where the received content ends
+var ErrUploadOffset = fmt.Errorf("chunk offset doesn't match the upload offset")
+
+// ErrUploadChunkSize means that the chunk is smaller than the minimal size and isn't the last one
+var ErrUploadChunkSize = fmt.Errorf("chunk is too small")
+
+// ErrUploadIncomplete means that the upload is finished before all its content is received
+var ErrUploadIncomplete = fmt.Errorf("upload is incomplete")
+
+// UploadLocks is an interface of locks of upload sessions shared by all replicas. Lock waits until the lock of
+// the session under the key is taken or ctx is done and returns the function that releases it
+type UploadLocks interface {
+	Lock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// UploadService receives images in chunks, so an upload interrupted by a dropped connection is resumed from the last
+// received byte. Sessions and chunks are kept in the BlobStore, so any replica sharing the store can continue the upload.
+// The session is a JSON object and every chunk is an object named by its offset; the finished upload is checked
+// and stored by ImageService.Upload. A session expires TTL after its last change and is deleted by Cleanup.
+// Changes of the same session are serialized by UploadLocks, so replicas don't store chunks at the same offset together
+type UploadService struct {
+	images   *ImageService
+	store    BlobStore
+	sessions UploadLocks
+	ttl      time.Duration
+	minChunk int64
+	now      func() time.Time
+}
+
+// NewUploadService accepts ImageService that finished uploads are stored by, BlobStore to keep sessions in,
+// UploadLocks shared by the replicas, nil serializes changes inside of the process only, the TTL of a session and
+// the minimal size of a chunk but the last one, which bounds the number of chunks of an upload, and returns an object
+// of type *UploadService
+func NewUploadService(images *ImageService, store BlobStore, sessions UploadLocks, ttl time.Duration, minChunk int64) *UploadService {
+	if sessions == nil {
+		sessions = &keyLocks{}
+	}
+	if ttl <= 0 {
+		ttl = defaultUploadTTL
+	}
+	if minChunk <= 0 {
+		minChunk = defaultUploadMinChunk
+	}
+	return &UploadService{images: images, store: store, sessions: sessions, ttl: ttl, minChunk: minChunk, now: time.Now}
+}
+
+// keyLocks serializes changes of objects of the blob store inside of the process, keys share a fixed number of locks
+type keyLocks [sessionLocks]sync.Mutex
+
+// Lock locks changes of the object under the key and returns the function that unlocks them
+func (l *keyLocks) Lock(_ context.Context, key string) (unlock func(), err error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	mu := &l[h.Sum32()%sessionLocks]
+	mu.Lock()
+	return mu.Unlock, nil
+}
+
+// Create starts an upload of length bytes of an image as the user from context. The length is checked against
+// the size limit and the quota of the user at once, so the client doesn't send a file that can't be accepted
+func (srv *UploadService) Create(ctx context.Context, filename string, length int64) (*model.Upload, error) {
+	ownerID, ok := identity.UserFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("UploadService -> Create -> error: %w", errNoUser)
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("UploadService -> Create -> error: %w", ErrUploadLength)
+	}
+	if length > srv.images.limits.MaxBytes {
+		return nil, fmt.Errorf("UploadService -> Create -> error: %w", ErrImageTooLarge)
+	}
+	if err := srv.images.checkQuota(ctx, ownerID, length); err != nil {
+		return nil, fmt.Errorf("UploadService -> Create -> error: %w", err)
+	}
+	now := srv.now().UTC()
+	upload := &model.Upload{ID: uuid.New(), OwnerID: ownerID, Filename: cleanFilename(filename), Length: length,
+		CreatedAt: now, ExpiresAt: now.Add(srv.ttl)}
+	if err := srv.save(ctx, upload); err != nil {
+		return nil, fmt.Errorf("UploadService -> Create -> error: %w", err)
+	}
+	return upload, nil
+}
+
+// Progress returns the upload session of the user from context, Offset is how many bytes are received
+func (srv *UploadService) Progress(ctx context.Context, id uuid.UUID) (*model.Upload, error) {
+	upload, err := srv.owned(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Progress -> error: %w", err)
+	}
+	return upload, nil
+}
+
+// Append stores the chunk read from src at the offset of the upload of the user from context and returns the changed
+// session. The offset must be the current offset of the upload, and every chunk but the last one must have at least
+// the minimal size. The chunk is received into a temporary file before the session is locked, so a slow client doesn't
+// hold the lock; another chunk stored meanwhile makes the offset stale. If reading src fails, the bytes received
+// before are kept if they make a chunk of the minimal size, so the client resumes from the offset returned by Progress
+func (srv *UploadService) Append(ctx context.Context, id uuid.UUID, offset int64, src io.Reader) (*model.Upload, error) {
+	key, err := uploadKey(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Append -> error: %w", err)
+	}
+	upload, err := srv.owned(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Append -> error: %w", err)
+	}
+	if offset != upload.Offset {
+		return nil, fmt.Errorf("UploadService -> Append -> %d instead of %d: %w", offset, upload.Offset, ErrUploadOffset)
+	}
+	tmp, err := os.CreateTemp("", "image-chunk-*")
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Append -> os.CreateTemp -> error: %w", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	remaining := upload.Length - upload.Offset
+	size, readErr := io.Copy(tmp, io.LimitReader(src, remaining+1))
+	if size > remaining {
+		return nil, fmt.Errorf("UploadService -> Append -> error: %w", ErrImageTooLarge)
+	}
+	if size < srv.minChunk && size < remaining {
+		if readErr != nil {
+			return upload, fmt.Errorf("UploadService -> Append -> io.Copy -> error: %w", readErr)
+		}
+		return nil, fmt.Errorf("UploadService -> Append -> %d of at least %d bytes: %w", size, srv.minChunk, ErrUploadChunkSize)
+	}
+	if size > 0 {
+		if upload, err = srv.commit(ctx, id, key, offset, tmp, size); err != nil {
+			return nil, fmt.Errorf("UploadService -> Append -> error: %w", err)
+		}
+	}
+	if readErr != nil {
+		return upload, fmt.Errorf("UploadService -> Append -> io.Copy -> error: %w", readErr)
+	}
+	return upload, nil
+}
+
+// commit stores the received chunk at the offset and moves the offset of the session past it. The session is locked
+// and read again, so the chunk is stored only if no other chunk was stored at the offset meanwhile
+func (srv *UploadService) commit(ctx context.Context, id uuid.UUID, key string, offset int64, chunk io.ReadSeeker, size int64) (*model.Upload, error) {
+	unlock, err := srv.sessions.Lock(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("sessions.Lock -> error: %w", err)
+	}
+	defer unlock()
+	upload, err := srv.owned(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return nil, fmt.Errorf("%d instead of %d: %w", offset, upload.Offset, ErrUploadOffset)
+	}
+	if _, err = chunk.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("chunk.Seek -> error: %w", err)
+	}
+	if err = srv.store.Put(ctx, chunkKey(key, offset), chunk, size); err != nil {
+		return nil, fmt.Errorf("store.Put -> error: %w", err)
+	}
+	upload.Offset += size
+	upload.ExpiresAt = srv.now().UTC().Add(srv.ttl)
+	if err = srv.save(ctx, upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Finish stores the completely received upload of the user from context as an image, see ImageService.Upload,
+// and deletes the session. The session is kept if the image can't be stored
+func (srv *UploadService) Finish(ctx context.Context, id uuid.UUID) (*model.Image, error) {
+	key, err := uploadKey(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Finish -> error: %w", err)
+	}
+	unlock, err := srv.sessions.Lock(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Finish -> sessions.Lock -> error: %w", err)
+	}
+	defer unlock()
+	upload, err := srv.owned(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Finish -> error: %w", err)
+	}
+	if upload.Offset != upload.Length {
+		return nil, fmt.Errorf("UploadService -> Finish -> %d of %d bytes: %w", upload.Offset, upload.Length, ErrUploadIncomplete)
+	}
+	chunks := &chunkReader{ctx: ctx, store: srv.store, key: key, length: upload.Length}
+	defer chunks.Close()
+	img, err := srv.images.Upload(ctx, upload.Filename, chunks)
+	if err != nil {
+		return nil, fmt.Errorf("UploadService -> Finish -> error: %w", err)
+	}
+	if err = srv.delete(ctx, key); err != nil {
+		logrus.WithField("ID", id).Errorf("UploadService -> Finish -> error: %v", err)
+	}
+	return img, nil
+}
+
+// Cancel deletes the upload session of the user from context with its chunks
+func (srv *UploadService) Cancel(ctx context.Context, id uuid.UUID) error {
+	key, err := uploadKey(ctx, id)
+	if err != nil {
+		return fmt.Errorf("UploadService -> Cancel -> error: %w", err)
+	}
+	unlock, err := srv.sessions.Lock(ctx, key)
+	if err != nil {
+		return fmt.Errorf("UploadService -> Cancel -> sessions.Lock -> error: %w", err)
+	}
+	defer unlock()
+	if _, err = srv.owned(ctx, id); err != nil {
+		return fmt.Errorf("UploadService -> Cancel -> error: %w", err)
+	}
+	if err = srv.delete(ctx, key); err != nil {
+		return fmt.Errorf("UploadService -> Cancel -> error: %w", err)
+	}
+	return nil
+}
+
+// Cleanup deletes expired sessions of all tenants and chunks left without a session and returns how many sessions
+// were deleted
+func (srv *UploadService) Cleanup(ctx context.Context) (int, error) {
+	keys, err := srv.store.List(ctx, uploadPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("UploadService -> Cleanup -> store.List -> error: %w", err)
+	}
+	sessions := make(map[string]bool)
+	for _, key := range keys {
+		// keys are uploads/<tenant>/<upload>/..., the session is named by the first three parts
+		if parts := strings.SplitN(key, "/", 4); len(parts) == 4 {
+			sessions[strings.Join(parts[:3], "/")+"/"] = true
+		}
+	}
+	deleted := 0
+	for key := range sessions {
+		ok, err := srv.deleteExpired(ctx, key)
+		if err != nil {
+			logrus.WithField("Key", key).Errorf("UploadService -> Cleanup -> error: %v", err)
+			continue
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// deleteExpired deletes the session under the key with its chunks if it has expired and tells if it was deleted.
+// The session is locked, so a chunk being stored or an upload being finished isn't deleted under it
+func (srv *UploadService) deleteExpired(ctx context.Context, key string) (bool, error) {
+	unlock, err := srv.sessions.Lock(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("sessions.Lock -> error: %w", err)
+	}
+	defer unlock()
+	expired, err := srv.expired(ctx, key)
+	if err != nil || !expired {
+		return false, err
+	}
+	if err = srv.delete(ctx, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run calls Cleanup every interval until ctx is canceled
+func (srv *UploadService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := srv.Cleanup(ctx)
+		if err != nil {
+			logrus.Errorf("UploadService -> Run -> Cleanup -> error: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			logrus.Infof("UploadService -> Run -> %d expired uploads are deleted", deleted)
+		}
+	}
+}
+
+// expired tells if the session under the key has expired or is missing, so its chunks are left over
+func (srv *UploadService) expired(ctx context.Context, key string) (bool, error) {
+	upload, err := srv.load(ctx, key)
+	if errors.Is(err, ErrUploadNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return srv.now().After(upload.ExpiresAt), nil
+}
+
+// owned returns the session of the upload if it isn't expired and belongs to the user from context
+func (srv *UploadService) owned(ctx context.Context, id uuid.UUID) (*model.Upload, error) {
+	ownerID, ok := identity.UserFromContext(ctx)
+	if !ok {
+		return nil, errNoUser
+	}
+	key, err := uploadKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	upload, err := srv.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if srv.now().After(upload.ExpiresAt) {
+		return nil, fmt.Errorf("%s expired at %s: %w", id, upload.ExpiresAt, ErrUploadNotFound)
+	}
+	if upload.OwnerID != ownerID {
+		return nil, fmt.Errorf("%s: %w", id, ErrUploadForbidden)
+	}
+	return upload, nil
+}
+
+// load reads the session stored under the key of the upload
+func (srv *UploadService) load(ctx context.Context, key string) (*model.Upload, error) {
+	stored, err := srv.store.Get(ctx, key+"session")
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			return nil, fmt.Errorf("store.Get -> %w: %w", ErrUploadNotFound, err)
+		}
+		return nil, fmt.Errorf("store.Get -> error: %w", err)
+	}
+	defer func() {
+		if err = stored.Content.Close(); err != nil {
+			logrus.WithField("Key", key).Errorf("UploadService -> load -> stored.Content.Close -> error: %v", err)
+		}
+	}()
+	var upload model.Upload
+	if err = json.NewDecoder(stored.Content).Decode(&upload); err != nil {
+		return nil, fmt.Errorf("json.Decode -> error: %w", err)
+	}
+	return &upload, nil
+}
+
+// save stores the session of the upload
+func (srv *UploadService) save(ctx context.Context, upload *model.Upload) error {
+	key, err := uploadKey(ctx, upload.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("json.Marshal -> error: %w", err)
+	}
+	if err = srv.store.Put(ctx, key+"session", bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("store.Put -> error: %w", err)
+	}
+	return nil
+}
+
+// delete deletes chunks of the upload under the key and then its session, so a failed deletion leaves the session
+// for Cleanup to retry
+func (srv *UploadService) delete(ctx context.Context, key string) error {
+	chunks, err := srv.store.List(ctx, key+"chunks/")
+	if err != nil {
+		return fmt.Errorf("store.List -> error: %w", err)
+	}
+	for _, chunk := range chunks {
+		if err = srv.store.Delete(ctx, chunk); err != nil {
+			return fmt.Errorf("store.Delete -> error: %w", err)
+		}
+	}
+	if err = srv.store.Delete(ctx, key+"session"); err != nil {
+		return fmt.Errorf("store.Delete -> error: %w", err)
+	}
+	return nil
+}
+
+// uploadKey returns the prefix of keys of the session and chunks of the upload of the tenant from context
+func uploadKey(ctx context.Context, id uuid.UUID) (string, error) {
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return uploadPrefix + tenantID.String() + "/" + id.String() + "/", nil
+}
+
+// chunkKey returns the key of the chunk of the upload that starts at the offset, zero padding keeps chunks listed in order
+func chunkKey(key string, offset int64) string {
+	return fmt.Sprintf("%schunks/%020d", key, offset)
+}
+
+// chunkReader reads the content of the upload under the key chunk after chunk. A chunk is found by the offset
+// where the previous one ends, so a chunk left by an interrupted Append and overwritten later is never read
+type chunkReader struct {
+	ctx    context.Context
+	store  BlobStore
+	key    string
+	length int64
+	offset int64
+	chunk  io.ReadCloser
+}
+
+// Read reads the content from the chunk that contains the current offset
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.chunk == nil {
+			if r.offset >= r.length {
+				return 0, io.EOF
+			}
+			stored, err := r.store.Get(r.ctx, chunkKey(r.key, r.offset))
+			if err != nil {
+				return 0, fmt.Errorf("chunkReader -> Read -> store.Get -> error: %w", err)
+			}
+			r.chunk = stored.Content
+		}
+		n, err := r.chunk.Read(p)
+		r.offset += int64(n)
+		if errors.Is(err, io.EOF) {
+			_ = r.chunk.Close()
+			r.chunk = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// Close closes the chunk that is being read
+func (r *chunkReader) Close() {
+	if r.chunk != nil {
+		_ = r.chunk.Close()
+		r.chunk = nil
+	}
+}
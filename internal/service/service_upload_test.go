@@ -0,0 +1,233 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// droppedReader returns the content and then fails like a dropped connection
+type droppedReader struct {
+	content []byte
+}
+
+func (r *droppedReader) Read(p []byte) (int, error) {
+	if len(r.content) == 0 {
+		return 0, fmt.Errorf("connection reset by peer")
+	}
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+	return n, nil
+}
+
+// testUploadMinChunk is the minimal size of a chunk of uploads of the tests
+const testUploadMinChunk = 8
+
+// newTestUploadService returns UploadService whose sessions and images are kept in a temporary directory
+func newTestUploadService(t *testing.T) (*UploadService, *fakeImageRepository, string) {
+	dir := t.TempDir()
+	images, repo := newTestImageService(dir)
+	return NewUploadService(images, blob.NewLocal(dir), nil, time.Hour, testUploadMinChunk), repo, dir
+}
+
+func TestUploadResumed(t *testing.T) {
+	srv, repo, dir := newTestUploadService(t)
+	ctx := newUserCtx()
+	content := encodeTestImage(t, 20, 20)
+	upload, err := srv.Create(ctx, "../andy.png", int64(len(content)))
+	require.NoError(t, err)
+	require.Equal(t, "andy.png", upload.Filename)
+
+	upload, err = srv.Append(ctx, upload.ID, 0, &droppedReader{content: content[:10]})
+	require.ErrorContains(t, err, "connection reset by peer")
+	require.Equal(t, int64(10), upload.Offset, "bytes received before the connection dropped are kept")
+	_, err = srv.Append(ctx, upload.ID, 0, bytes.NewReader(content))
+	require.True(t, errors.Is(err, ErrUploadOffset), err)
+	_, err = srv.Finish(ctx, upload.ID)
+	require.True(t, errors.Is(err, ErrUploadIncomplete), err)
+
+	progress, err := srv.Progress(ctx, upload.ID)
+	require.NoError(t, err)
+	upload, err = srv.Append(ctx, upload.ID, progress.Offset, bytes.NewReader(content[progress.Offset:]))
+	require.NoError(t, err)
+	require.Equal(t, upload.Length, upload.Offset)
+
+	img, err := srv.Finish(ctx, upload.ID)
+	require.NoError(t, err)
+	require.Equal(t, "andy.png", img.Filename)
+	require.Equal(t, int64(len(content)), img.Size)
+	require.Contains(t, repo.images, img.ID)
+	file, err := srv.images.Open(ctx, img.ID, model.ImageVariant{})
+	require.NoError(t, err)
+	stored, err := io.ReadAll(file.Content)
+	require.NoError(t, err)
+	require.NoError(t, file.Content.Close())
+	require.Equal(t, content, stored)
+
+	_, err = srv.Progress(ctx, upload.ID)
+	require.True(t, errors.Is(err, ErrUploadNotFound), err)
+	for _, key := range storedFiles(t, dir) {
+		require.NotContains(t, key, uploadPrefix)
+	}
+}
+
+func TestUploadRejected(t *testing.T) {
+	srv, _, _ := newTestUploadService(t)
+	ctx := newUserCtx()
+	_, err := srv.Create(ctx, "andy.png", 0)
+	require.True(t, errors.Is(err, ErrUploadLength))
+	_, err = srv.Create(ctx, "andy.png", testImageLimits.MaxBytes+1)
+	require.True(t, errors.Is(err, ErrImageTooLarge))
+	_, err = srv.Create(testCtx, "andy.png", 10)
+	require.True(t, errors.Is(err, errNoUser))
+
+	upload, err := srv.Create(ctx, "andy.png", 4)
+	require.NoError(t, err)
+	_, err = srv.Append(ctx, upload.ID, 0, bytes.NewReader([]byte("12345")))
+	require.True(t, errors.Is(err, ErrImageTooLarge), err)
+	_, err = srv.Append(newUserCtx(), upload.ID, 0, bytes.NewReader([]byte("1234")))
+	require.True(t, errors.Is(err, ErrUploadForbidden), err)
+	require.True(t, errors.Is(srv.Cancel(newUserCtx(), upload.ID), ErrUploadForbidden))
+	_, err = srv.Progress(ctx, uuid.New())
+	require.True(t, errors.Is(err, ErrUploadNotFound), err)
+
+	upload, err = srv.Append(ctx, upload.ID, 0, bytes.NewReader([]byte("1234")))
+	require.NoError(t, err)
+	_, err = srv.Finish(ctx, upload.ID)
+	require.True(t, errors.Is(err, ErrImageType), err)
+	require.NoError(t, srv.Cancel(ctx, upload.ID))
+	_, err = srv.Progress(ctx, upload.ID)
+	require.True(t, errors.Is(err, ErrUploadNotFound), err)
+}
+
+func TestUploadCleanup(t *testing.T) {
+	srv, _, dir := newTestUploadService(t)
+	now := time.Now()
+	srv.now = func() time.Time { return now }
+	ctx := newUserCtx()
+	abandoned, err := srv.Create(ctx, "andy.png", 100)
+	require.NoError(t, err)
+	_, err = srv.Append(ctx, abandoned.ID, 0, bytes.NewReader(make([]byte, 10)))
+	require.NoError(t, err)
+	now = now.Add(40 * time.Minute)
+	active, err := srv.Create(ctx, "andy.png", 100)
+	require.NoError(t, err)
+
+	now = now.Add(30 * time.Minute)
+	_, err = srv.Progress(ctx, abandoned.ID)
+	require.True(t, errors.Is(err, ErrUploadNotFound), "an expired upload can't be resumed")
+	deleted, err := srv.Cleanup(testCtx)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	_, err = srv.Progress(ctx, active.ID)
+	require.NoError(t, err)
+	activeKey, err := uploadKey(ctx, active.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{activeKey + "session"}, storedFiles(t, dir))
+}
+
+func TestUploadChunkSize(t *testing.T) {
+	srv, _, _ := newTestUploadService(t)
+	ctx := newUserCtx()
+	upload, err := srv.Create(ctx, "andy.png", 20)
+	require.NoError(t, err)
+	_, err = srv.Append(ctx, upload.ID, 0, bytes.NewReader(make([]byte, testUploadMinChunk-1)))
+	require.True(t, errors.Is(err, ErrUploadChunkSize), err)
+	upload, err = srv.Append(ctx, upload.ID, 0, &droppedReader{content: make([]byte, testUploadMinChunk-1)})
+	require.ErrorContains(t, err, "connection reset by peer")
+	require.Zero(t, upload.Offset, "bytes of a dropped chunk smaller than the minimal size aren't kept")
+
+	upload, err = srv.Append(ctx, upload.ID, 0, bytes.NewReader(make([]byte, 17)))
+	require.NoError(t, err)
+	upload, err = srv.Append(ctx, upload.ID, upload.Offset, bytes.NewReader(make([]byte, 3)))
+	require.NoError(t, err, "the last chunk may be smaller")
+	require.Equal(t, upload.Length, upload.Offset)
+}
+
+func TestUploadConcurrentChunks(t *testing.T) {
+	dir := t.TempDir()
+	images, _ := newTestImageService(dir)
+	// two replicas share the store and the locks, like the locks in redis
+	locks := &keyLocks{}
+	replicas := []*UploadService{
+		NewUploadService(images, blob.NewLocal(dir), locks, time.Hour, testUploadMinChunk),
+		NewUploadService(images, blob.NewLocal(dir), locks, time.Hour, testUploadMinChunk),
+	}
+	srv := replicas[0]
+	ctx := newUserCtx()
+	upload, err := srv.Create(ctx, "andy.png", 20)
+	require.NoError(t, err)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = replicas[i].Append(ctx, upload.ID, 0, &blockingReader{content: make([]byte, 10), release: release})
+		}(i)
+	}
+	close(release)
+	wg.Wait()
+	require.True(t, (errs[0] == nil) != (errs[1] == nil), "one of the chunks at the same offset is stored: %v", errs)
+	for _, err := range errs {
+		if err != nil {
+			require.True(t, errors.Is(err, ErrUploadOffset), err)
+		}
+	}
+	progress, err := srv.Progress(ctx, upload.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), progress.Offset)
+}
+
+func TestUploadLockedByOtherReplica(t *testing.T) {
+	dir := t.TempDir()
+	images, _ := newTestImageService(dir)
+	locks := &keyLocks{}
+	srv := NewUploadService(images, blob.NewLocal(dir), locks, time.Hour, testUploadMinChunk)
+	ctx := newUserCtx()
+	upload, err := srv.Create(ctx, "andy.png", 20)
+	require.NoError(t, err)
+	key, err := uploadKey(ctx, upload.ID)
+	require.NoError(t, err)
+	// another replica holds the lock of the session
+	unlock, err := locks.Lock(ctx, key)
+	require.NoError(t, err)
+	appended := make(chan error, 1)
+	go func() {
+		_, err := srv.Append(ctx, upload.ID, 0, bytes.NewReader(make([]byte, 10)))
+		appended <- err
+	}()
+	select {
+	case err = <-appended:
+		t.Fatalf("the chunk is stored while the session is locked: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	require.NoError(t, <-appended)
+}
+
+// blockingReader returns the content once release is closed, like a slow client
+type blockingReader struct {
+	content []byte
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.release
+	if len(r.content) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+	return n, nil
+}
@@ -4,11 +4,14 @@ package service
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
+	"strings"
 	"time"
 
 	"fmt"
 
 	"github.com/distuurbia/firstTask/internal/config"
+	"github.com/distuurbia/firstTask/internal/identity"
 	"github.com/distuurbia/firstTask/internal/middleware"
 	"github.com/distuurbia/firstTask/internal/model"
 	"github.com/golang-jwt/jwt/v5"
@@ -16,6 +19,9 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrUsernameExist means that the username is taken by another user of the tenant, case isn't taken into account
+var ErrUsernameExist = fmt.Errorf("such username already exist")
+
 // UserRepository is an interface that contains CRUD methods and GetAll
 type UserRepository interface {
 	SignUp(ctx context.Context, user *model.User) error
@@ -48,14 +54,23 @@ type TokenPair struct {
 	RefreshToken string
 }
 
+// NormalizeUsername makes usernames case-insensitive, every username is stored and looked up lower-cased
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
 // SignUp is a method of UserService that calls  method of Repository
 func (srvUser *UserService) SignUp(ctx context.Context, user *model.User) error {
 	var err error
+	user.Username = NormalizeUsername(user.Username)
 	user.Password, err = srvUser.HashPassword(user.Password)
 	if err != nil {
 		return fmt.Errorf("ServiceUser -> HashPassword -> error: %w", err)
 	}
 	err = srvUser.rpsUser.SignUp(ctx, user)
+	if errors.Is(err, model.ErrExist) {
+		return fmt.Errorf("ServiceUser -> UserRepository -> SignUp -> %v: %w", err, ErrUsernameExist)
+	}
 	if err != nil {
 		return fmt.Errorf("ServiceUser -> UserRepository -> SignIn -> error: %w", err)
 	}
@@ -64,6 +79,7 @@ func (srvUser *UserService) SignUp(ctx context.Context, user *model.User) error
 
 // Login is a method of UserService that calls method of Repository
 func (srvUser *UserService) Login(ctx context.Context, user *model.User) (TokenPair, error) {
+	user.Username = NormalizeUsername(user.Username)
 	id, hash, err := srvUser.rpsUser.GetPasswordAndIDByUsername(ctx, user.Username)
 	user.ID = id
 	if err != nil {
@@ -73,7 +89,11 @@ func (srvUser *UserService) Login(ctx context.Context, user *model.User) (TokenP
 	if err != nil || !verified {
 		return TokenPair{}, fmt.Errorf("ServiceUser ->  Login -> CheckPasswordHash -> error: %w", err)
 	}
-	tokenPair, err := srvUser.GenerateTokenPair(user.ID)
+	tenantID, err := identity.TenantFromContext(ctx)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("ServiceUser ->  Login -> identity.TenantFromContext -> error: %w", err)
+	}
+	tokenPair, err := srvUser.GenerateTokenPair(user.ID, tenantID)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUser ->  Login -> GenerateTokenPair -> error: %w", err)
 	}
@@ -83,7 +103,7 @@ func (srvUser *UserService) Login(ctx context.Context, user *model.User) (TokenP
 		return TokenPair{}, fmt.Errorf("ServiceUser -> Login -> HashPassword -> error: %w", err)
 	}
 	user.RefreshToken = string(hashedRefreshToken)
-	err = srvUser.rpsUser.AddRefreshToken(context.Background(), user)
+	err = srvUser.rpsUser.AddRefreshToken(ctx, user)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUsere ->  Login -> RepositoryUser -> AddRefreshToken -> error: %w", err)
 	}
@@ -92,10 +112,11 @@ func (srvUser *UserService) Login(ctx context.Context, user *model.User) (TokenP
 
 // Refresh is a method of ServiceUser that refeshes access token and refresh token
 func (srvUser *UserService) Refresh(ctx context.Context, tokenPair TokenPair) (TokenPair, error) {
-	id, err := srvUser.TokensIDCompare(tokenPair)
+	id, tenantID, err := srvUser.TokensIDCompare(tokenPair)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUser -> Refresh -> TokensIDCompare -> error: %w", err)
 	}
+	ctx = identity.WithTenant(ctx, tenantID)
 	hash, err := srvUser.rpsUser.GetRefreshTokenByID(ctx, id)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUser ->  Refresh -> RepositoryUser -> GetPasswordByUsernsame -> error: %w", err)
@@ -105,7 +126,7 @@ func (srvUser *UserService) Refresh(ctx context.Context, tokenPair TokenPair) (T
 	if err != nil || !verified {
 		return TokenPair{}, fmt.Errorf("ServiceUser ->  Refresh -> CheckPasswordHash -> error: refreshToken invalid")
 	}
-	tokenPair, err = srvUser.GenerateTokenPair(id)
+	tokenPair, err = srvUser.GenerateTokenPair(id, tenantID)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUser ->  Refresh -> GenerateTokenPair -> error: %w", err)
 	}
@@ -117,48 +138,47 @@ func (srvUser *UserService) Refresh(ctx context.Context, tokenPair TokenPair) (T
 	var user model.User
 	user.RefreshToken = string(hashedRefreshToken)
 	user.ID = id
-	err = srvUser.rpsUser.AddRefreshToken(context.Background(), &user)
+	err = srvUser.rpsUser.AddRefreshToken(ctx, &user)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUsere ->  Refresh -> RepositoryUser -> AddRefreshToken -> error: %w", err)
 	}
 	return tokenPair, nil
 }
 
-// TokensIDCompare compares IDs from refresh and access token for being equal
-func (srvUser *UserService) TokensIDCompare(tokenPair TokenPair) (uuid.UUID, error) {
+// TokensIDCompare compares IDs and tenants from refresh and access token for being equal
+func (srvUser *UserService) TokensIDCompare(tokenPair TokenPair) (id, tenantID uuid.UUID, err error) {
 	accessToken, err := middleware.ValidateToken(tokenPair.AccessToken, srvUser.cfg.SecretKey)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> accessToken -> middleware -> ValidateToken -> error: %w", err)
+		return uuid.Nil, uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> accessToken -> middleware -> ValidateToken -> error: %w", err)
 	}
-	var accessID uuid.UUID
-	var uuidID uuid.UUID
+	var accessID, accessTenantID uuid.UUID
 	if claims, ok := accessToken.Claims.(jwt.MapClaims); ok && accessToken.Valid {
-		uuidID, err = uuid.Parse(claims["id"].(string))
+		accessID, accessTenantID, err = middleware.ParseIdentityClaims(claims)
 		if err != nil {
-			return uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> accessToken -> uuid.Parse -> error: %w", err)
+			return uuid.Nil, uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> accessToken -> middleware.ParseIdentityClaims -> error: %w", err)
 		}
-		accessID = uuidID
 	}
 	refreshToken, err := middleware.ValidateToken(tokenPair.RefreshToken, srvUser.cfg.SecretKey)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> refreshToken -> middleware -> ValidateToken -> error: %w", err)
+		return uuid.Nil, uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> refreshToken -> middleware -> ValidateToken -> error: %w", err)
 	}
-	var refreshID uuid.UUID
+	var refreshID, refreshTenantID uuid.UUID
 	if claims, ok := refreshToken.Claims.(jwt.MapClaims); ok && refreshToken.Valid {
-		exp := claims["exp"].(float64)
-		uuidID, err = uuid.Parse(claims["id"].(string))
+		refreshID, refreshTenantID, err = middleware.ParseIdentityClaims(claims)
 		if err != nil {
-			return uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> accessToken  -> uuid.Parse -> error: %w", err)
+			return uuid.Nil, uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> refreshToken -> middleware.ParseIdentityClaims -> error: %w", err)
 		}
-		refreshID = uuidID
-		if exp < float64(time.Now().Unix()) {
-			return uuid.Nil, fmt.Errorf("ServiceUser ->  TokensIDCompare -> middleware -> ValidateToken -> error: %w", err)
+		if err = middleware.CheckExpiration(claims); err != nil {
+			return uuid.Nil, uuid.Nil, fmt.Errorf("ServiceUser -> TokensIDCompare -> refreshToken -> middleware.CheckExpiration -> error: %w", err)
 		}
 	}
 	if accessID != refreshID {
-		return uuid.Nil, fmt.Errorf("user ID in acess token doesn't equal user ID in refresh token")
+		return uuid.Nil, uuid.Nil, fmt.Errorf("user ID in acess token doesn't equal user ID in refresh token")
+	}
+	if accessTenantID != refreshTenantID {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("tenant in acess token doesn't equal tenant in refresh token")
 	}
-	return accessID, nil
+	return accessID, accessTenantID, nil
 }
 
 // HashPassword is a method of ServiceUser that makes from bytes hashed value
@@ -180,12 +200,12 @@ func (srvUser *UserService) CheckPasswordHash(hash, password []byte) (bool, erro
 }
 
 // GenerateTokenPair generates pair of access and refresh tokens
-func (srvUser *UserService) GenerateTokenPair(id uuid.UUID) (TokenPair, error) {
-	accessToken, err := srvUser.GenerateJWTToken(accessTokenExpiration, id)
+func (srvUser *UserService) GenerateTokenPair(id, tenantID uuid.UUID) (TokenPair, error) {
+	accessToken, err := srvUser.GenerateJWTToken(accessTokenExpiration, id, tenantID)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUser -> GenerateTokenPair -> accessToken -> GenerateJWTToken -> error: %w", err)
 	}
-	refreshToken, err := srvUser.GenerateJWTToken(refreshTokenExpiration, id)
+	refreshToken, err := srvUser.GenerateJWTToken(refreshTokenExpiration, id, tenantID)
 	if err != nil {
 		return TokenPair{}, fmt.Errorf("ServiceUser -> GenerateTokenPair -> refreshToken -> GenerateJWTToken -> error: %w", err)
 	}
@@ -195,11 +215,12 @@ func (srvUser *UserService) GenerateTokenPair(id uuid.UUID) (TokenPair, error) {
 	}, nil
 }
 
-// GenerateJWTToken is a method of ServiceUser that generate JWT token with given expiration with user id
-func (srvUser *UserService) GenerateJWTToken(expiration time.Duration, id uuid.UUID) (string, error) {
+// GenerateJWTToken is a method of ServiceUser that generate JWT token with given expiration with user id and tenant
+func (srvUser *UserService) GenerateJWTToken(expiration time.Duration, id, tenantID uuid.UUID) (string, error) {
 	claims := &jwt.MapClaims{
-		"exp": time.Now().Add(expiration).Unix(),
-		"id":  id,
+		"exp":    time.Now().Add(expiration).Unix(),
+		"id":     id,
+		"tenant": tenantID,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(srvUser.cfg.SecretKey))
@@ -0,0 +1,97 @@
+// Package service realize bisnes-logic of the microservice
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/distuurbia/firstTask/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// webhookSecretBytes is length of the generated secret of a webhook
+const webhookSecretBytes = 32
+
+// ErrWebhookURL means that the URL of the webhook isn't http(s) or points to an internal address
+var ErrWebhookURL = fmt.Errorf("webhook URL must be a public http or https URL")
+
+// WebhookRepository is an interface that contains methods to manage webhooks of the tenant
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, webhook *model.Webhook) error
+	GetWebhooks(ctx context.Context) ([]model.Webhook, error)
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	EnableWebhook(ctx context.Context, id uuid.UUID) error
+	GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, limit int) ([]model.WebhookDelivery, error)
+}
+
+// WebhookService contains WebhookRepository interface
+type WebhookService struct {
+	webhookRps WebhookRepository
+}
+
+// NewWebhookService accepts WebhookRepository object and returnes an object of type *WebhookService
+func NewWebhookService(webhookRps WebhookRepository) *WebhookService {
+	return &WebhookService{webhookRps: webhookRps}
+}
+
+// Create registers the webhook, its URL must be a public http(s) URL. A random secret is generated when the tenant
+// didn't choose one. The secret is returned only here, so the tenant has to keep it to verify signatures
+func (srv *WebhookService) Create(ctx context.Context, created *model.Webhook) error {
+	if err := webhook.ValidateURL(created.URL); err != nil {
+		return fmt.Errorf("WebhookService -> Create -> %v: %w", err, ErrWebhookURL)
+	}
+	if created.Secret == "" {
+		secret := make([]byte, webhookSecretBytes)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("WebhookService -> Create -> rand.Read -> error: %w", err)
+		}
+		created.Secret = hex.EncodeToString(secret)
+	}
+	err := srv.webhookRps.CreateWebhook(ctx, created)
+	if err != nil {
+		return fmt.Errorf("WebhookService -> Create -> webhookRps.CreateWebhook -> error: %w", err)
+	}
+	return nil
+}
+
+// GetAll returns webhooks of the tenant without their secrets
+func (srv *WebhookService) GetAll(ctx context.Context) ([]model.Webhook, error) {
+	webhooks, err := srv.webhookRps.GetWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("WebhookService -> GetAll -> webhookRps.GetWebhooks -> error: %w", err)
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// Delete is a method of WebhookService that calls DeleteWebhook method of Repository
+func (srv *WebhookService) Delete(ctx context.Context, id uuid.UUID) error {
+	err := srv.webhookRps.DeleteWebhook(ctx, id)
+	if err != nil {
+		return fmt.Errorf("WebhookService -> Delete -> webhookRps.DeleteWebhook -> error: %w", err)
+	}
+	return nil
+}
+
+// Enable turns on the webhook that was disabled after too many failed deliveries
+func (srv *WebhookService) Enable(ctx context.Context, id uuid.UUID) error {
+	err := srv.webhookRps.EnableWebhook(ctx, id)
+	if err != nil {
+		return fmt.Errorf("WebhookService -> Enable -> webhookRps.EnableWebhook -> error: %w", err)
+	}
+	return nil
+}
+
+// Deliveries returns up to limit latest delivery attempts of the webhook
+func (srv *WebhookService) Deliveries(ctx context.Context, id uuid.UUID, limit int) ([]model.WebhookDelivery, error) {
+	deliveries, err := srv.webhookRps.GetWebhookDeliveries(ctx, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("WebhookService -> Deliveries -> webhookRps.GetWebhookDeliveries -> error: %w", err)
+	}
+	return deliveries, nil
+}
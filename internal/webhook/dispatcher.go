@@ -0,0 +1,279 @@
+// Package webhook delivers person events to the endpoints that tenants subscribed to them
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/identity"
+	"github.com/distuurbia/firstTask/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers of the delivery request
+const (
+	HeaderEventID   = "X-Webhook-Event-ID"
+	HeaderEventType = "X-Webhook-Event-Type"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	// retryBase and retryMax bound exponential backoff between attempts of one delivery
+	retryBase = time.Second
+	retryMax  = time.Minute
+	// retryLease is how long a claimed retry is hidden from the other replicas, it is longer than one attempt
+	retryLease = time.Minute
+	// retryBatch is how many due retries are claimed at once
+	retryBatch = 100
+	// maxErrorLength limits error text kept in the delivery log
+	maxErrorLength = 512
+)
+
+// Store is an interface that contains webhook methods of the repository
+type Store interface {
+	GetWebhooks(ctx context.Context) ([]model.Webhook, error)
+	AddWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+	PruneWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, keep int) error
+	RecordWebhookResult(ctx context.Context, id uuid.UUID, success bool, disableAfter int) (bool, error)
+}
+
+// Dispatcher is an events.Handler that posts every event to the matching webhooks of its tenant.
+// Handle makes only the first attempt, so the event is acknowledged long before the consumer group hands it
+// to another consumer. Failed deliveries are retried by Run from the retry queue with exponential backoff,
+// every attempt is written to the delivery log that keeps the latest deliveriesKept attempts of a webhook,
+// and a webhook that failed disableAfter events in a row is disabled
+type Dispatcher struct {
+	store          Store
+	client         *http.Client
+	retries        RetryQueue
+	maxAttempts    int
+	disableAfter   int
+	deliveriesKept int
+	retryBase      time.Duration
+}
+
+// NewDispatcher accepts Store, an object of *http.Client and RetryQueue, number of attempts per event, number of
+// consecutive failed events after which webhook is disabled and number of deliveries kept in the log of a webhook
+// and returns an object of type *Dispatcher. The client should be made by NewClient, so internal addresses can't be reached
+func NewDispatcher(store Store, client *http.Client, retries RetryQueue, maxAttempts, disableAfter, deliveriesKept int) *Dispatcher {
+	return &Dispatcher{
+		store:          store,
+		client:         client,
+		retries:        retries,
+		maxAttempts:    maxAttempts,
+		disableAfter:   disableAfter,
+		deliveriesKept: deliveriesKept,
+		retryBase:      retryBase,
+	}
+}
+
+// Handle makes the first attempt to deliver event to all enabled webhooks of its tenant that subscribed to its type,
+// webhooks are served concurrently. Failure of a webhook doesn't fail the event, it is scheduled for a retry
+func (d *Dispatcher) Handle(ctx context.Context, event *events.Event) error {
+	ctx = identity.WithTenant(ctx, event.TenantID)
+	webhooks, err := d.store.GetWebhooks(ctx)
+	if err != nil {
+		return fmt.Errorf("Dispatcher -> Handle -> store.GetWebhooks -> error: %w", err)
+	}
+	body, err := events.Encode(event)
+	if err != nil {
+		return fmt.Errorf("Dispatcher -> Handle -> error: %w", err)
+	}
+	var wg sync.WaitGroup
+	for i := range webhooks {
+		webhook := &webhooks[i]
+		if webhook.Disabled || !Subscribed(webhook, event.Type) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliver(ctx, webhook, event, body, 1)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Run delivers due retries every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := d.ProcessRetries(ctx); err != nil {
+			logrus.Errorf("Dispatcher -> Run -> ProcessRetries -> error: %v", err)
+		}
+	}
+}
+
+// ProcessRetries claims due retries and makes their attempts concurrently. A retry of a webhook that was deleted
+// or disabled meanwhile is dropped
+func (d *Dispatcher) ProcessRetries(ctx context.Context) error {
+	retries, err := d.retries.Claim(ctx, time.Now(), retryLease, retryBatch)
+	if err != nil {
+		return fmt.Errorf("Dispatcher -> ProcessRetries -> retries.Claim -> error: %w", err)
+	}
+	var wg sync.WaitGroup
+	for i := range retries {
+		retry := &retries[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.retry(ctx, retry)
+			if err := d.retries.Done(ctx, retry); err != nil {
+				logrus.WithField("WebhookID", retry.WebhookID).Errorf("Dispatcher -> ProcessRetries -> retries.Done -> error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// retry makes the attempt of the claimed retry
+func (d *Dispatcher) retry(ctx context.Context, retry *Retry) {
+	log := logrus.WithFields(logrus.Fields{"WebhookID": retry.WebhookID, "Attempt": retry.Attempt})
+	event, err := retry.decodeEvent()
+	if err != nil {
+		log.Errorf("Dispatcher -> retry -> decodeEvent -> error: %v", err)
+		return
+	}
+	ctx = identity.WithTenant(ctx, event.TenantID)
+	webhooks, err := d.store.GetWebhooks(ctx)
+	if err != nil {
+		log.Errorf("Dispatcher -> retry -> store.GetWebhooks -> error: %v", err)
+		return
+	}
+	for i := range webhooks {
+		if webhooks[i].ID == retry.WebhookID && !webhooks[i].Disabled {
+			d.deliver(ctx, &webhooks[i], event, retry.Event, retry.Attempt)
+			return
+		}
+	}
+}
+
+// deliver makes one attempt to post the event to the webhook and records it. A failed attempt is scheduled again
+// unless attempts run out, the result of the event is recorded when it succeeds or fails for the last time
+func (d *Dispatcher) deliver(ctx context.Context, webhook *model.Webhook, event *events.Event, body []byte, attempt int) {
+	log := logrus.WithFields(logrus.Fields{"WebhookID": webhook.ID, "EventID": event.ID, "Type": event.Type, "Attempt": attempt})
+	delivery := d.post(ctx, webhook, event, body)
+	delivery.Attempt = attempt
+	if err := d.store.AddWebhookDelivery(ctx, delivery); err != nil {
+		log.Errorf("Dispatcher -> deliver -> store.AddWebhookDelivery -> error: %v", err)
+	}
+	if err := d.store.PruneWebhookDeliveries(ctx, webhook.ID, d.deliveriesKept); err != nil {
+		log.Errorf("Dispatcher -> deliver -> store.PruneWebhookDeliveries -> error: %v", err)
+	}
+	success := delivery.Succeeded()
+	if !success && attempt < d.maxAttempts {
+		next := &Retry{WebhookID: webhook.ID, Attempt: attempt + 1, Event: body}
+		err := d.retries.Schedule(ctx, next, time.Now().Add(d.backoff(attempt)))
+		if err == nil {
+			return
+		}
+		log.Errorf("Dispatcher -> deliver -> retries.Schedule -> error: %v", err)
+	}
+	disabled, err := d.store.RecordWebhookResult(ctx, webhook.ID, success, d.disableAfter)
+	if err != nil {
+		log.Errorf("Dispatcher -> deliver -> store.RecordWebhookResult -> error: %v", err)
+		return
+	}
+	if disabled {
+		log.Warn("webhook is disabled after too many failed deliveries")
+	}
+}
+
+// post sends one signed request to the webhook and returns the result as a delivery
+func (d *Dispatcher) post(ctx context.Context, webhook *model.Webhook, event *events.Event, body []byte) *model.WebhookDelivery {
+	delivery := &model.WebhookDelivery{
+		ID:        uuid.New(),
+		WebhookID: webhook.ID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		CreatedAt: time.Now().UTC(),
+	}
+	defer func() {
+		delivery.Duration = time.Since(delivery.CreatedAt).Milliseconds()
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = truncate(err.Error())
+		return delivery
+	}
+	timestamp := strconv.FormatInt(delivery.CreatedAt.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, event.ID.String())
+	req.Header.Set(HeaderEventType, event.Type)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		delivery.Error = truncate(err.Error())
+		return delivery
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	delivery.StatusCode = resp.StatusCode
+	return delivery
+}
+
+// backoff returns delay before the next attempt after the given number of failed ones
+func (d *Dispatcher) backoff(failed int) time.Duration {
+	delay := d.retryBase
+	for i := 1; i < failed && delay < retryMax; i++ {
+		delay *= 2
+	}
+	if delay > retryMax {
+		return retryMax
+	}
+	return delay
+}
+
+// Sign returns value of the signature header, it is HMAC-SHA256 of "timestamp.body" keyed with the secret of the webhook.
+// Receivers compute the same value and compare it in constant time, the timestamp lets them reject replayed requests
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Subscribed checks if the webhook listens to events of the given type, webhook without types listens to all person events
+func Subscribed(webhook *model.Webhook, eventType string) bool {
+	if len(webhook.EventTypes) == 0 {
+		return events.IsPersonEvent(eventType)
+	}
+	for _, t := range webhook.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate cuts the error text so one bad endpoint can't bloat the delivery log
+func truncate(s string) string {
+	if len(s) > maxErrorLength {
+		return s[:maxErrorLength]
+	}
+	return s
+}
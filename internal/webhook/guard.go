@@ -0,0 +1,90 @@
+// Package webhook delivers person events to the endpoints that tenants subscribed to them
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// dialTimeout bounds connecting to a webhook, the whole request is bounded by the timeout of the client
+	dialTimeout = 5 * time.Second
+	// maxRedirects is how many redirects a delivery follows, every one is dialed through the guarded dialer
+	maxRedirects = 3
+)
+
+// ErrForbiddenURL means that the webhook URL isn't http(s) or points to an address of the service network
+var ErrForbiddenURL = fmt.Errorf("webhook URL must be a public http or https URL")
+
+// cgnat is the shared address space of carrier-grade NAT, it isn't covered by net.IP.IsPrivate
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// ValidateURL checks the URL of a webhook when it is registered: the scheme must be http or https and the host must
+// not be localhost or an internal IP literal. Hostnames are checked again after DNS resolution by the client of NewClient
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("ValidateURL -> url.Parse -> %v: %w", err, ErrForbiddenURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("ValidateURL -> scheme %q: %w", u.Scheme, ErrForbiddenURL)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" || u.User != nil {
+		return fmt.Errorf("ValidateURL -> host is empty or has credentials: %w", ErrForbiddenURL)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("ValidateURL -> host %q: %w", host, ErrForbiddenURL)
+	}
+	if ip := net.ParseIP(host); ip != nil && forbiddenIP(ip) {
+		return fmt.Errorf("ValidateURL -> address %s: %w", ip, ErrForbiddenURL)
+	}
+	return nil
+}
+
+// NewClient returns a client for deliveries that refuses to connect to internal addresses after DNS resolution,
+// so a hostname that resolves or is rebound to the service network can't be reached either
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: dialTimeout, Control: guardControl}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   dialTimeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConnsPerHost:   2,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return ValidateURL(req.URL.String())
+		},
+	}
+}
+
+// guardControl is net.Dialer.Control that rejects connections to internal addresses, address is already resolved
+func guardControl(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("guardControl -> net.SplitHostPort -> %v: %w", err, ErrForbiddenURL)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || forbiddenIP(ip) {
+		return fmt.Errorf("guardControl -> address %s: %w", host, ErrForbiddenURL)
+	}
+	return nil
+}
+
+// forbiddenIP tells if the address belongs to the host or the internal network: loopback, private, link-local
+// including cloud metadata, carrier-grade NAT, unspecified and multicast addresses
+func forbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
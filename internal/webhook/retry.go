@@ -0,0 +1,105 @@
+// Package webhook delivers person events to the endpoints that tenants subscribed to them
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// RetryKey is a redis sorted set of scheduled retries, the score is the unix time in milliseconds when a retry is due
+const RetryKey = "webhook_retries"
+
+// Retry is the next attempt to deliver the event to the webhook
+type Retry struct {
+	WebhookID uuid.UUID       `json:"webhookId"`
+	Attempt   int             `json:"attempt"`
+	Event     json.RawMessage `json:"event"`
+	// member is the encoded retry as it is stored in the queue, it is set by Claim and used by Done
+	member string
+}
+
+// RetryQueue is an interface of the queue of scheduled retries shared by all replicas. Claim leases due retries,
+// so a retry claimed by a replica that dies before Done is claimed again after the lease
+type RetryQueue interface {
+	Schedule(ctx context.Context, retry *Retry, at time.Time) error
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int64) ([]Retry, error)
+	Done(ctx context.Context, retry *Retry) error
+}
+
+// claimScript moves due retries forward by the lease and returns them, so other replicas don't claim them meanwhile
+var claimScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+for _, member in ipairs(due) do
+	redis.call("ZADD", KEYS[1], "XX", ARGV[2], member)
+end
+return due
+`)
+
+// RedisRetryQueue keeps scheduled retries in a redis sorted set
+type RedisRetryQueue struct {
+	client *redis.Client
+}
+
+// NewRedisRetryQueue accepts an object of *redis.Client and returns an object of type *RedisRetryQueue
+func NewRedisRetryQueue(client *redis.Client) *RedisRetryQueue {
+	return &RedisRetryQueue{client: client}
+}
+
+// Schedule adds the retry that is due at the given time
+func (q *RedisRetryQueue) Schedule(ctx context.Context, retry *Retry, at time.Time) error {
+	member, err := json.Marshal(retry)
+	if err != nil {
+		return fmt.Errorf("RedisRetryQueue -> Schedule -> json.Marshal -> error: %w", err)
+	}
+	err = q.client.ZAdd(ctx, RetryKey, &redis.Z{Score: float64(at.UnixMilli()), Member: string(member)}).Err()
+	if err != nil {
+		return fmt.Errorf("RedisRetryQueue -> Schedule -> ZAdd -> error: %w", err)
+	}
+	return nil
+}
+
+// Claim leases up to limit retries that are due at now
+func (q *RedisRetryQueue) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int64) ([]Retry, error) {
+	members, err := claimScript.Run(ctx, q.client, []string{RetryKey},
+		strconv.FormatInt(now.UnixMilli(), 10), strconv.FormatInt(now.Add(lease).UnixMilli(), 10), limit).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("RedisRetryQueue -> Claim -> claimScript.Run -> error: %w", err)
+	}
+	return decodeRetries(members), nil
+}
+
+// Done deletes the retry, the next one, if any, is scheduled as a new retry
+func (q *RedisRetryQueue) Done(ctx context.Context, retry *Retry) error {
+	if err := q.client.ZRem(ctx, RetryKey, retry.member).Err(); err != nil {
+		return fmt.Errorf("RedisRetryQueue -> Done -> ZRem -> error: %w", err)
+	}
+	return nil
+}
+
+// decodeRetries decodes claimed members, a member that can't be decoded is kept as a retry without an event,
+// so it is dropped by Done instead of being claimed forever
+func decodeRetries(members []string) []Retry {
+	retries := make([]Retry, 0, len(members))
+	for _, member := range members {
+		var retry Retry
+		_ = json.Unmarshal([]byte(member), &retry)
+		retry.member = member
+		retries = append(retries, retry)
+	}
+	return retries
+}
+
+// decodeEvent returns the event of the retry
+func (r *Retry) decodeEvent() (*events.Event, error) {
+	if len(r.Event) == 0 {
+		return nil, fmt.Errorf("retry has no event")
+	}
+	return events.Decode(r.Event)
+}
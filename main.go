@@ -3,28 +3,48 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "github.com/distuurbia/firstTask/docs"
 
 	"github.com/caarlos0/env/v8"
+	"github.com/distuurbia/firstTask/internal/blob"
+	"github.com/distuurbia/firstTask/internal/cdc"
 	"github.com/distuurbia/firstTask/internal/config"
+	"github.com/distuurbia/firstTask/internal/events"
 	"github.com/distuurbia/firstTask/internal/handler"
 	customMidleware "github.com/distuurbia/firstTask/internal/middleware"
+	"github.com/distuurbia/firstTask/internal/migrate"
+	"github.com/distuurbia/firstTask/internal/outbox"
 	"github.com/distuurbia/firstTask/internal/repository"
 	"github.com/distuurbia/firstTask/internal/service"
+	"github.com/distuurbia/firstTask/internal/webhook"
+	"github.com/distuurbia/firstTask/migrations"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-redis/redis/v8"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/sirupsen/logrus"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// accessLogFormat is the default format of the echo logger with the path in place of the URI, because the query
+// carries access tokens of the change feed and signatures of image URLs that must not end up in logs
+const accessLogFormat = `{"time":"${time_rfc3339_nano}","id":"${id}","remote_ip":"${remote_ip}",` +
+	`"host":"${host}","method":"${method}","path":"${path}","user_agent":"${user_agent}",` +
+	`"status":${status},"error":"${error}","latency":${latency},"latency_human":"${latency_human}"` +
+	`,"bytes_in":${bytes_in},"bytes_out":${bytes_out}}` + "\n"
+
 // ConnectPgx connects to the pgxpool
 func ConnectPgx(cfg *config.Config) (*pgxpool.Pool, error) {
 	cfgPgx, err := pgxpool.ParseConfig(cfg.PgxConnectionString)
@@ -60,43 +80,38 @@ func ConnectRedis(cfg *config.Config) *redis.Client {
 	return client
 }
 
-// producer writes some message to redis stream
-func producer(rdsClient *redis.Client) {
-	const delay = 5
-	streamData := redis.XAddArgs{
-		Stream: "person_stream",
-		Values: map[string]interface{}{
-			"data": "wassab body",
-		},
-	}
-	for {
-		_, err := rdsClient.XAdd(context.Background(), &streamData).Result()
-		if err != nil {
-			log.Fatal("producer -> XAdd -> error: ", err)
-		}
-		time.Sleep(time.Second * delay)
-	}
+// NewPersonCache returns redis cache of persons with TTL and size limits from config
+func NewPersonCache(cfg *config.Config, client *redis.Client) *repository.Redis {
+	return repository.NewRepositoryRedis(client, cfg.CacheTTL, cfg.CacheTTLJitter, cfg.CacheMissingTTL, cfg.CacheMaxEntries)
 }
 
-// consumer reads some message from redis stream and deletes it
-func consumer(rdsClient *redis.Client) {
-	const delay = 5
-	streamData := redis.XReadArgs{
-		Streams: []string{"person_stream", "0"},
-		Count:   1,
-		Block:   0,
-	}
-	for {
-		results, err := rdsClient.XRead(context.Background(), &streamData).Result()
-		if err != nil {
-			log.Fatal("consumer -> XRead -> error: ", err)
-		}
-		for _, msg := range results[0].Messages {
-			fmt.Println("Message received: ", msg.Values["data"])
-			rdsClient.XDel(context.Background(), "person_stream", msg.ID)
-		}
-		time.Sleep(time.Second * delay)
+// NewBlobStore returns the store of images chosen by config, local directory IMAGE_DIR or a bucket of an S3-compatible storage
+func NewBlobStore(cfg *config.Config) (service.BlobStore, error) {
+	switch cfg.BlobStore {
+	case "", "local":
+		return blob.NewLocal(cfg.ImageDir), nil
+	case "s3":
+		return blob.NewS3(blob.S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		}, blob.NewHTTPClient())
 	}
+	return nil, fmt.Errorf("unknown blob store %q, use local or s3", cfg.BlobStore)
+}
+
+// logEvent is a handler of person events that writes them to the log
+func logEvent(_ context.Context, event *events.Event) error {
+	logrus.WithFields(logrus.Fields{
+		"ID":          event.ID,
+		"Type":        event.Type,
+		"TenantID":    event.TenantID,
+		"AggregateID": event.AggregateID,
+	}).Info("person event received")
+	return nil
 }
 
 // @title FirstTask API
@@ -114,15 +129,87 @@ func main() {
 		//nolint:gocritic
 		log.Fatal("could not parse config: ", err)
 	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(&cfg, os.Args[2:]); err != nil {
+				log.Fatal("could not migrate: ", err)
+			}
+			return
+		case "replay":
+			if err := runReplay(&cfg, os.Args[2:]); err != nil {
+				log.Fatal("could not replay: ", err)
+			}
+			return
+		case "rebuild-projection":
+			if err := runRebuildProjection(&cfg, os.Args[2:]); err != nil {
+				log.Fatal("could not rebuild projection: ", err)
+			}
+			return
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	validate := validator.New()
 	var handl *handler.EntityHandler
+	var tenantHandl *handler.TenantHandler
+	var webhookHandl *handler.WebhookHandler
+	var webhookStore webhook.Store
+	var persService *service.PersonService
+	if err := service.CheckCacheStrategy(cfg.CacheStrategy); err != nil {
+		log.Fatal(err)
+	}
+	if err := service.CheckImageFormat(cfg.ImageCanonicalFormat); err != nil {
+		log.Fatal(err)
+	}
+	cacheOpts := service.CacheOptions{
+		Strategy:      cfg.CacheStrategy,
+		EarlyRefresh:  cfg.CacheEarlyRefresh,
+		FlushInterval: cfg.CacheFlushInterval,
+		FlushBatch:    cfg.CacheFlushBatch,
+		MaxQueued:     cfg.CacheMaxQueued,
+	}
 	rdsClient := ConnectRedis(&cfg)
-	rds := repository.NewRepositoryRedis(rdsClient)
-	go producer(rdsClient)
-	go consumer(rdsClient)
+	rds := NewPersonCache(&cfg, rdsClient)
+	if cfg.AutoMigrate {
+		if _, err := rds.DrainLegacyHashes(context.Background()); err != nil {
+			log.Fatal("could not drain legacy person cache: ", err)
+		}
+	}
+	var personCache service.PersonRedisRepository = rds
+	cacheTiers := map[string]handler.CacheTier{"redis": rds}
+	if cfg.CacheLocalSize > 0 {
+		lru := repository.NewLRU(rds, rdsClient, cfg.CacheLocalSize, cfg.CacheLocalTTL)
+		go lru.Run(ctx)
+		personCache = lru
+		cacheTiers["local"] = lru
+	}
+	cacheHandl := handler.NewCacheHandler(cacheTiers)
+	blobStore, err := NewBlobStore(&cfg)
+	if err != nil {
+		log.Fatal("could not create blob store: ", err)
+	}
+	var imageRps service.ImageRepository
+	var imageTx service.TxManager
+	var avatarRps service.AvatarRepository
+	var userService handler.UserService
+	publisher := events.NewRedisPublisher(rdsClient, cfg.EventsStreamMaxLen)
+	if cfg.EventsConsumer == "" {
+		cfg.EventsConsumer, _ = os.Hostname()
+	}
+	subscriber := events.NewRedisSubscriber(rdsClient, cfg.EventsGroup, cfg.EventsConsumer, cfg.EventsMinIdle, cfg.EventsMaxDeliveries)
+	go func() {
+		if err := subscriber.Subscribe(ctx, logEvent); err != nil {
+			logrus.Errorf("events subscriber stopped: %v", err)
+		}
+	}()
+	feed := events.NewRedisFeed(rdsClient)
+	go feed.Run(ctx)
+	feedHandl := handler.NewFeedHandler(feed, cfg.FeedAllowedOrigins)
+	deadLetterHandl := handler.NewDeadLetterHandler(events.NewRedisDeadLetters(rdsClient))
 	fmt.Println("What db do u wanna use?\n 1.PostgreSQL\n 2.MongoDB")
 	var dbChoose int
-	_, err := fmt.Scan(&dbChoose)
+	_, err = fmt.Scan(&dbChoose)
 	if err != nil {
 		fmt.Println("failed to scan")
 	}
@@ -136,10 +223,35 @@ func main() {
 			log.Fatal("could not construct the pool: ", err)
 		}
 		defer dbpool.Close()
+		if cfg.AutoMigrate {
+			migrator, err := migrate.NewPostgres(dbpool, migrations.FS)
+			if err != nil {
+				log.Fatal("could not load migrations: ", err)
+			}
+			if _, err = migrator.Up(context.Background()); err != nil {
+				log.Fatal("could not migrate: ", err)
+			}
+		}
 		persPgx := repository.NewRepositoryPgx(dbpool)
-		persSrv := service.NewPersonService(persPgx, rds)
+		persSrv := service.NewPersonService(persPgx, personCache, persPgx, persPgx, cacheOpts)
 		userSrv := service.NewUserService(persPgx, &cfg)
-		handl = handler.NewHandler(persSrv, userSrv, validate)
+		tenantSrv := service.NewTenantService(persPgx, rds, blobStore)
+		go outbox.NewRelay(persPgx, publisher, rds, cfg.OutboxBatchSize, cfg.OutboxMaxAttempts, cfg.OutboxPollInterval, cfg.OutboxRetention).Run(ctx)
+		if cfg.CDCEnabled {
+			reader, err := cdc.NewReader(cfg.PgxConnectionString, cfg.CDCSlot, publisher, rds)
+			if err != nil {
+				log.Fatal("could not construct change data capture: ", err)
+			}
+			go reader.Run(ctx)
+		}
+		userService = userSrv
+		persService = persSrv
+		tenantHandl = handler.NewTenantHandler(tenantSrv, validate)
+		webhookHandl = handler.NewWebhookHandler(service.NewWebhookService(persPgx), validate)
+		webhookStore = persPgx
+		imageRps = persPgx
+		imageTx = persPgx
+		avatarRps = persPgx
 	case MongoDB:
 		client, err := ConnectMongo(&cfg)
 		if err != nil {
@@ -147,9 +259,23 @@ func main() {
 			log.Fatal("could not construct the client: ", err)
 		}
 		rpsMongo := repository.NewRepositoryMongo(client)
-		srvPers := service.NewPersonService(rpsMongo, rds)
+		if cfg.AutoMigrate {
+			if err = rpsMongo.EnsureIndexes(context.Background()); err != nil {
+				log.Fatal("could not ensure indexes: ", err)
+			}
+		}
+		srvPers := service.NewPersonService(rpsMongo, personCache, rpsMongo, rpsMongo, cacheOpts)
 		srvUser := service.NewUserService(rpsMongo, &cfg)
-		handl = handler.NewHandler(srvPers, srvUser, validate)
+		srvTenant := service.NewTenantService(rpsMongo, rds, blobStore)
+		go outbox.NewRelay(rpsMongo, publisher, rds, cfg.OutboxBatchSize, cfg.OutboxMaxAttempts, cfg.OutboxPollInterval, cfg.OutboxRetention).Run(ctx)
+		userService = srvUser
+		persService = srvPers
+		tenantHandl = handler.NewTenantHandler(srvTenant, validate)
+		webhookHandl = handler.NewWebhookHandler(service.NewWebhookService(rpsMongo), validate)
+		webhookStore = rpsMongo
+		imageRps = rpsMongo
+		imageTx = rpsMongo
+		avatarRps = rpsMongo
 		defer func() {
 			if err = client.Disconnect(context.Background()); err != nil {
 				//nolint:gocritic
@@ -161,23 +287,104 @@ func main() {
 		log.Fatal("The wrong number!")
 	}
 
+	go persService.Run(ctx)
+	imageService := service.NewImageService(imageRps, imageTx, persService, blobStore, service.ImageLimits{
+		MaxBytes:        cfg.ImageMaxBytes,
+		MaxDimension:    cfg.ImageMaxDimension,
+		MaxPixels:       cfg.ImageMaxPixels,
+		VariantSizes:    cfg.ImageVariantSizes,
+		MaxResizes:      cfg.ImageMaxResizes,
+		QuotaBytes:      cfg.ImageQuotaBytes,
+		CanonicalFormat: cfg.ImageCanonicalFormat,
+	})
+	go imageService.Run(ctx, cfg.ImageCollectInterval, cfg.ImageCollectGrace)
+	imageURLKey := []byte(cfg.ImageURLKey)
+	if len(imageURLKey) == 0 {
+		imageURLKey = service.DeriveImageURLKey([]byte(cfg.SecretKey))
+	}
+	imageURLService := service.NewImageURLService(imageService, imageURLKey, cfg.ImageURLMaxTTL)
+	imageHandl := handler.NewImageHandler(imageService, imageURLService, cfg.ImageMaxBytes)
+	uploadService := service.NewUploadService(imageService, blobStore, repository.NewRedisUploadLocks(rdsClient), cfg.UploadTTL, cfg.UploadMinChunk)
+	go uploadService.Run(ctx, cfg.UploadCleanupInterval)
+	uploadHandl := handler.NewUploadHandler(uploadService)
+	avatarService := service.NewAvatarService(persService, avatarRps, imageService)
+	handl = handler.NewHandler(avatarService, userService, validate)
+	avatarHandl := handler.NewAvatarHandler(avatarService, cfg.ImageMaxBytes)
+
+	dispatcher := webhook.NewDispatcher(webhookStore, webhook.NewClient(cfg.WebhookTimeout), webhook.NewRedisRetryQueue(rdsClient),
+		cfg.WebhookMaxAttempts, cfg.WebhookDisableAfter, cfg.WebhookDeliveriesKept)
+	go dispatcher.Run(ctx, cfg.WebhookRetryInterval)
+	webhookSubscriber := events.NewRedisSubscriber(rdsClient, cfg.EventsGroup+"-webhooks", cfg.EventsConsumer, cfg.EventsMinIdle, cfg.EventsMaxDeliveries)
+	go func() {
+		if err := webhookSubscriber.Subscribe(ctx, dispatcher.Handle); err != nil {
+			logrus.Errorf("webhook subscriber stopped: %v", err)
+		}
+	}()
+
 	e := echo.New()
-	e.Use(middleware.Logger())
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{Format: accessLogFormat}))
 	e.Use(middleware.Recover())
 
 	e.POST("/persons", handl.Create, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/persons/events", feedHandl.Events, customMidleware.QueryTokenMiddleware(), customMidleware.JWTMiddleware(&cfg))
+	e.GET("/persons/ws", feedHandl.WebSocket, customMidleware.QueryTokenMiddleware(), customMidleware.JWTMiddleware(&cfg))
 	e.GET("/persons/:id", handl.ReadRow, customMidleware.JWTMiddleware(&cfg))
 	e.GET("/persons", handl.GetAll, customMidleware.JWTMiddleware(&cfg))
 	e.PUT("/persons/:id", handl.Update, customMidleware.JWTMiddleware(&cfg))
 	e.DELETE("/persons/:id", handl.Delete, customMidleware.JWTMiddleware(&cfg))
+	e.PUT("/persons/:id/avatar", avatarHandl.SetAvatar, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/persons/:id/avatar", avatarHandl.Avatar, customMidleware.JWTMiddleware(&cfg))
+
+	e.POST("/tenants", tenantHandl.Create, customMidleware.AdminMiddleware(&cfg))
+	e.DELETE("/tenants/:id", tenantHandl.Delete, customMidleware.AdminMiddleware(&cfg))
 
-	e.POST("/signUp", handl.SignUp)
-	e.POST("/login", handl.Login)
+	e.POST("/webhooks", webhookHandl.Create, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/webhooks", webhookHandl.GetAll, customMidleware.JWTMiddleware(&cfg))
+	e.DELETE("/webhooks/:id", webhookHandl.Delete, customMidleware.JWTMiddleware(&cfg))
+	e.POST("/webhooks/:id/enable", webhookHandl.Enable, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/webhooks/:id/deliveries", webhookHandl.Deliveries, customMidleware.JWTMiddleware(&cfg))
+
+	e.GET("/admin/deadletters", deadLetterHandl.List, customMidleware.AdminMiddleware(&cfg))
+	e.POST("/admin/deadletters/:id/replay", deadLetterHandl.Replay, customMidleware.AdminMiddleware(&cfg))
+	e.DELETE("/admin/deadletters/:id", deadLetterHandl.Discard, customMidleware.AdminMiddleware(&cfg))
+	e.GET("/admin/cache/stats", cacheHandl.Stats, customMidleware.AdminMiddleware(&cfg))
+	e.GET("/admin/images/usage", imageHandl.UsageReport, customMidleware.AdminMiddleware(&cfg), customMidleware.TenantMiddleware())
+
+	e.POST("/signUp", handl.SignUp, customMidleware.TenantMiddleware())
+	e.POST("/login", handl.Login, customMidleware.TenantMiddleware())
 	e.POST("/refresh", handl.Refresh)
-	e.GET("/downloadImage/:imageName", handl.DownloadImage)
-	e.POST("/uploadImage", handl.UploadImage)
+	e.GET("/images/:id", imageHandl.Download, customMidleware.SignedURLMiddleware(&cfg))
+	e.GET("/downloadImage/:id", imageHandl.Download, customMidleware.SignedURLMiddleware(&cfg))
+	e.POST("/images", imageHandl.Upload, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/images", imageHandl.List, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/images/usage", imageHandl.Usage, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/images/:id/meta", imageHandl.Meta, customMidleware.JWTMiddleware(&cfg))
+	e.POST("/images/:id/url", imageHandl.SignURL, customMidleware.JWTMiddleware(&cfg))
+	e.DELETE("/images/:id", imageHandl.Delete, customMidleware.JWTMiddleware(&cfg))
+	e.POST("/uploadImage", imageHandl.Upload, customMidleware.JWTMiddleware(&cfg))
+	e.POST("/uploads", uploadHandl.Create, customMidleware.JWTMiddleware(&cfg))
+	e.HEAD("/uploads/:id", uploadHandl.Progress, customMidleware.JWTMiddleware(&cfg))
+	e.GET("/uploads/:id", uploadHandl.Progress, customMidleware.JWTMiddleware(&cfg))
+	e.PATCH("/uploads/:id", uploadHandl.Append, customMidleware.JWTMiddleware(&cfg))
+	e.POST("/uploads/:id/finish", uploadHandl.Finish, customMidleware.JWTMiddleware(&cfg))
+	e.DELETE("/uploads/:id", uploadHandl.Cancel, customMidleware.JWTMiddleware(&cfg))
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
-	e.Logger.Fatal(e.Start(":8080"))
+	go func() {
+		if err := e.Start(":8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+	stop, stopCancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopCancel()
+	<-stop.Done()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer shutdownCancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("could not shut down the server: %v", err)
+	}
+	if err := persService.Flush(shutdownCtx); err != nil {
+		logrus.Errorf("could not persist queued person changes: %v", err)
+	}
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distuurbia/firstTask/internal/config"
+	"github.com/distuurbia/firstTask/internal/migrate"
+	"github.com/distuurbia/firstTask/internal/repository"
+	"github.com/distuurbia/firstTask/migrations"
+)
+
+// runMigrate executes migrate subcommand: migrate up, migrate down or migrate status
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+	dbpool, err := ConnectPgx(cfg)
+	if err != nil {
+		return fmt.Errorf("could not construct the pool: %w", err)
+	}
+	defer dbpool.Close()
+	migrator, err := migrate.NewPostgres(dbpool, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, version := range applied {
+			fmt.Println("applied version", version)
+		}
+		if err != nil {
+			return err
+		}
+		if cfg.RedisAddress != "" {
+			if err = drainPersonCache(cfg); err != nil {
+				return err
+			}
+		}
+		if cfg.MongoConnectionString != "" {
+			return ensureMongoIndexes(cfg)
+		}
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("reverted version", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			if status.Applied {
+				fmt.Printf("V%d %s applied at %s\n", status.Version, status.Description, status.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("V%d %s pending\n", status.Version, status.Description)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+	}
+	return nil
+}
+
+// ensureMongoIndexes creates indexes of every tenant database of mongoDB
+func ensureMongoIndexes(cfg *config.Config) error {
+	client, err := ConnectMongo(cfg)
+	if err != nil {
+		return fmt.Errorf("could not construct the client: %w", err)
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+	return repository.NewRepositoryMongo(client).EnsureIndexes(context.Background())
+}
+
+// drainPersonCache moves persons cached in the legacy redis hashes to per-person keys
+func drainPersonCache(cfg *config.Config) error {
+	client := ConnectRedis(cfg)
+	defer client.Close()
+	drained, err := NewPersonCache(cfg, client).DrainLegacyHashes(context.Background())
+	fmt.Println("drained cached persons", drained)
+	return err
+}
@@ -0,0 +1,2 @@
+-- Dropping index of references of image contents
+drop index images_sha256_idx;
@@ -0,0 +1,5 @@
+-- Dropping dead letters of the outbox
+drop index outbox_published_idx;
+drop index outbox_unpublished_idx;
+create index outbox_unpublished_idx on outbox (created_at) where published_at is null;
+alter table outbox drop column dead_at;
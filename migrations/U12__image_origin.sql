@@ -0,0 +1,2 @@
+-- Dropping origin of images
+alter table images drop column origin;
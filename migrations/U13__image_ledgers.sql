@@ -0,0 +1,3 @@
+-- Dropping reference and usage counters of images
+drop table image_usage;
+drop table image_contents;
@@ -0,0 +1,5 @@
+-- Dropping users table
+drop table users;
+
+-- Dropping persondb table
+drop table persondb;
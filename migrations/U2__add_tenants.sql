@@ -0,0 +1,10 @@
+-- Unbinding users from tenants
+drop index users_tenant_id_idx;
+alter table users drop column tenant_id;
+
+-- Unbinding persons from tenants
+drop index persondb_tenant_id_idx;
+alter table persondb drop column tenant_id;
+
+-- Dropping tenants table
+drop table tenants;
@@ -0,0 +1,2 @@
+-- Dropping unique index of usernames
+drop index users_tenant_id_username_idx;
@@ -0,0 +1,3 @@
+-- Returning case-sensitive unique index of usernames
+drop index users_tenant_id_lower_username_idx;
+create unique index users_tenant_id_username_idx on users (tenant_id, username);
@@ -0,0 +1,2 @@
+-- Dropping outbox table
+drop table outbox;
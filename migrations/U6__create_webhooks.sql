@@ -0,0 +1,3 @@
+-- Dropping webhook tables
+drop table webhook_deliveries;
+drop table webhooks;
@@ -0,0 +1,4 @@
+-- Dropping change data capture publication
+drop publication person_cdc;
+alter table users replica identity default;
+alter table persondb replica identity default;
@@ -0,0 +1,2 @@
+-- Dropping images table
+drop table images;
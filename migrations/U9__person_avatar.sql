@@ -0,0 +1,2 @@
+-- Dropping avatar of person
+alter table persondb drop column avatar_image_id;
@@ -0,0 +1,2 @@
+-- Images with the same content share it in the blob store, the index counts references of a content
+create index images_sha256_idx on images (tenant_id, sha256);
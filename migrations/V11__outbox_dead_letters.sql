@@ -0,0 +1,7 @@
+-- Events that failed too many times or can't be relayed are dead-lettered and never claimed again
+alter table outbox add column dead_at TIMESTAMPTZ;
+drop index outbox_unpublished_idx;
+create index outbox_unpublished_idx on outbox (created_at) where published_at is null and dead_at is null;
+
+-- Published events older than the retention are pruned
+create index outbox_published_idx on outbox (published_at) where published_at is not null;
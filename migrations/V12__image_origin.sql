@@ -0,0 +1,2 @@
+-- Recording how an image was uploaded, an image uploaded as an avatar is deleted when no person has it as avatar anymore
+alter table images add column origin VARCHAR(20) not null default 'upload';
@@ -0,0 +1,26 @@
+-- Counting references of contents and usage of owners in tables that uploads and deletions change in their transaction,
+-- so replicas can't race on them. Contents that lost their last reference are collected later, see unreferenced_at
+create table image_contents (
+	tenant_id uuid not null references tenants (id) on delete cascade,
+	sha256 CHAR(64) not null,
+	key VARCHAR(255) not null default '',
+	refs INTEGER not null,
+	unreferenced_at TIMESTAMPTZ,
+	primary key (tenant_id, sha256)
+);
+
+create index image_contents_unreferenced_idx on image_contents (unreferenced_at) where refs = 0;
+
+create table image_usage (
+	tenant_id uuid not null references tenants (id) on delete cascade,
+	owner_id uuid not null,
+	bytes BIGINT not null,
+	primary key (tenant_id, owner_id)
+);
+
+-- contents uploaded before have no generation in their key, empty key means that key
+insert into image_contents (tenant_id, sha256, refs)
+	select tenant_id, sha256, count(*) from images group by tenant_id, sha256;
+
+insert into image_usage (tenant_id, owner_id, bytes)
+	select tenant_id, owner_id, sum(size) from images group by tenant_id, owner_id;
@@ -0,0 +1,21 @@
+-- Creating tenants table
+create table tenants (
+	id uuid,
+	name VARCHAR(30),
+	primary key (id)
+);
+
+-- Rows created before multi-tenancy belong to the default tenant
+insert into tenants (id, name) values ('00000000-0000-0000-0000-000000000001', 'default');
+
+-- Binding persons to tenants
+alter table persondb add column tenant_id uuid references tenants (id) on delete cascade;
+update persondb set tenant_id = '00000000-0000-0000-0000-000000000001';
+alter table persondb alter column tenant_id set not null;
+create index persondb_tenant_id_idx on persondb (tenant_id);
+
+-- Binding users to tenants
+alter table users add column tenant_id uuid references tenants (id) on delete cascade;
+update users set tenant_id = '00000000-0000-0000-0000-000000000001';
+alter table users alter column tenant_id set not null;
+create index users_tenant_id_idx on users (tenant_id);
@@ -0,0 +1,2 @@
+-- Username is unique inside of the tenant
+create unique index users_tenant_id_username_idx on users (tenant_id, username);
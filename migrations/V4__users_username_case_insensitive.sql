@@ -0,0 +1,23 @@
+-- Usernames that differ only by case can't be lower-cased, they are reported and must be renamed or deleted before
+-- the migration is applied again
+do $$
+declare
+    duplicates text;
+begin
+    select string_agg(format('tenant %s: %s', tenant_id, usernames), '; ')
+    into duplicates
+    from (
+        select tenant_id, string_agg(username, ', ' order by username) as usernames
+        from users
+        group by tenant_id, lower(username)
+        having count(*) > 1
+    ) as groups;
+    if duplicates is not null then
+        raise exception 'usernames differ only by case, rename or delete them: %', duplicates;
+    end if;
+end $$;
+
+-- Usernames are case-insensitive, so they are stored lower-cased and compared by lower(username)
+drop index users_tenant_id_username_idx;
+update users set username = lower(username);
+create unique index users_tenant_id_lower_username_idx on users (tenant_id, lower(username));
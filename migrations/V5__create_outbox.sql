@@ -0,0 +1,17 @@
+-- Creating outbox table, events are written in the same transaction as the change they describe
+create table outbox (
+	id uuid,
+	tenant_id uuid not null references tenants (id) on delete cascade,
+	aggregate_id uuid not null,
+	event_type VARCHAR(50) not null,
+	payload jsonb not null,
+	created_at TIMESTAMPTZ not null default now(),
+	attempts INTEGER not null default 0,
+	last_error VARCHAR,
+	claimed_until TIMESTAMPTZ,
+	published_at TIMESTAMPTZ,
+	primary key (id)
+);
+
+-- Relay looks only for unpublished events
+create index outbox_unpublished_idx on outbox (created_at) where published_at is null;
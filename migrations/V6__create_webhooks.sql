@@ -0,0 +1,30 @@
+-- Creating webhooks table, a tenant subscribes its endpoints to person events
+create table webhooks (
+	id uuid,
+	tenant_id uuid not null references tenants (id) on delete cascade,
+	url VARCHAR(2048) not null,
+	event_types VARCHAR(50)[] not null default '{}',
+	secret VARCHAR(128) not null,
+	disabled BOOLEAN not null default false,
+	failures INTEGER not null default 0,
+	created_at TIMESTAMPTZ not null default now(),
+	primary key (id)
+);
+
+create index webhooks_tenant_idx on webhooks (tenant_id);
+
+-- Creating webhook_deliveries table, every attempt to deliver an event is logged
+create table webhook_deliveries (
+	id uuid,
+	webhook_id uuid not null references webhooks (id) on delete cascade,
+	event_id uuid not null,
+	event_type VARCHAR(50) not null,
+	attempt INTEGER not null,
+	status_code INTEGER not null default 0,
+	error VARCHAR not null default '',
+	duration_ms BIGINT not null default 0,
+	created_at TIMESTAMPTZ not null default now(),
+	primary key (id)
+);
+
+create index webhook_deliveries_webhook_idx on webhook_deliveries (webhook_id, created_at desc);
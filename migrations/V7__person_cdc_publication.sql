@@ -0,0 +1,6 @@
+-- Deleted rows of the logical replication carry all columns, so change data capture knows tenant of the row
+alter table persondb replica identity full;
+alter table users replica identity full;
+
+-- Publication read by change data capture, outbox is included to recognise transactions made by the service
+create publication person_cdc for table persondb, users, outbox;
@@ -0,0 +1,16 @@
+-- Creating images table, it records metadata of every uploaded image, the content is kept in the blob store
+create table images (
+	id uuid,
+	tenant_id uuid not null references tenants (id) on delete cascade,
+	owner_id uuid not null,
+	filename VARCHAR(255) not null default '',
+	content_type VARCHAR(50) not null,
+	size BIGINT not null,
+	width INTEGER not null,
+	height INTEGER not null,
+	sha256 CHAR(64) not null,
+	created_at TIMESTAMPTZ not null default now(),
+	primary key (id)
+);
+
+create index images_owner_idx on images (tenant_id, owner_id, created_at desc);
@@ -0,0 +1,4 @@
+-- Adding optional avatar of person, the avatar is unset when its image is deleted
+alter table persondb add column avatar_image_id uuid references images (id) on delete set null;
+
+create index persondb_avatar_image_id_idx on persondb (tenant_id, avatar_image_id) where avatar_image_id is not null;
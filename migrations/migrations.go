@@ -0,0 +1,9 @@
+// Package migrations contains versioned SQL migrations of postgreSQL embedded into the binary
+package migrations
+
+import "embed"
+
+// FS contains V<version>__<description>.sql files that migrate schema up and U<version>__<description>.sql files that undo them
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/distuurbia/firstTask/internal/config"
+	"github.com/distuurbia/firstTask/internal/events"
+	"github.com/distuurbia/firstTask/internal/replay"
+)
+
+// newReplayService returns replay service with all projections of the microservice registered
+func newReplayService(cfg *config.Config) *replay.Service {
+	rdsClient := ConnectRedis(cfg)
+	return replay.NewService(events.NewRedisReader(rdsClient),
+		replay.NewCacheProjection(NewPersonCache(cfg, rdsClient)),
+	)
+}
+
+// runReplay executes replay subcommand: replay [-from position] [-to position] <projection>,
+// positions are stream IDs or RFC3339 timestamps and the whole stream is replayed by default
+func runReplay(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	from := flags.String("from", replay.First, "stream ID or RFC3339 time of the first event")
+	to := flags.String("to", replay.Last, "stream ID or RFC3339 time of the last event")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	srv := newReplayService(cfg)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: replay [-from position] [-to position] <projection>, projections: %v", srv.Projections())
+	}
+	start, err := replay.ParsePosition(*from, false)
+	if err != nil {
+		return err
+	}
+	end, err := replay.ParsePosition(*to, true)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := srv.Replay(ctx, flags.Arg(0), start, end)
+	fmt.Printf("applied %d events, last applied %q\n", result.Applied, result.LastID)
+	return err
+}
+
+// runRebuildProjection executes rebuild-projection subcommand: it resets the projection and replays the whole stream to it
+func runRebuildProjection(cfg *config.Config, args []string) error {
+	srv := newReplayService(cfg)
+	if len(args) != 1 {
+		return fmt.Errorf("usage: rebuild-projection <projection>, projections: %v", srv.Projections())
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := srv.Rebuild(ctx, args[0])
+	fmt.Printf("applied %d events, last applied %q\n", result.Applied, result.LastID)
+	return err
+}